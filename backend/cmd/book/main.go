@@ -0,0 +1,145 @@
+// Command book builds a Polyglot opening book from PGN files, from the
+// finished standard games in our archive, or from both.
+//
+//	go run ./cmd/book -out book.bin games1.pgn games2.pgn
+//	go run ./cmd/book -out book.bin -archive
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/config"
+	"arcane-chess/internal/database"
+	"arcane-chess/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	outPath := flag.String("out", "book.bin", "path of the book to write")
+	maxPly := flag.Int("plies", 20, "number of half-moves of each game to include")
+	fromArchive := flag.Bool("archive", false, "include the finished games stored in the database")
+	batchSize := flag.Int("batch", 200, "number of archived games loaded per query")
+	flag.Parse()
+
+	if flag.NArg() == 0 && !*fromArchive {
+		fmt.Fprintln(os.Stderr, "usage: book [-out book.bin] [-plies n] [-archive] [file.pgn ...]")
+		os.Exit(2)
+	}
+
+	builder := chess.NewBookBuilder(*maxPly)
+	games := 0
+
+	for _, path := range flag.Args() {
+		added, err := addPGNFile(builder, path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		games += added
+	}
+
+	if *fromArchive {
+		added, err := addArchive(builder, *batchSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		games += added
+	}
+
+	out, err := os.Create(*outPath)
+	if err != nil {
+		log.Fatal("Failed to create book:", err)
+	}
+	writer := bufio.NewWriter(out)
+	written, err := builder.WriteTo(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatal("Failed to write book:", err)
+	}
+
+	fmt.Printf("wrote %d entries from %d games to %s\n", written/16, games, *outPath)
+	if truncated := builder.Truncated(); truncated > 0 {
+		fmt.Printf("%d PGN games were cut short at a move the engine can't play, such as castling or promotion\n", truncated)
+	}
+}
+
+func addPGNFile(builder *chess.BookBuilder, path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	games, err := chess.ParsePGN(bufio.NewReader(file))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, game := range games {
+		if err := builder.AddPGN(game); err != nil {
+			fmt.Printf("%s: skipping game %d: %v\n", path, i+1, err)
+		}
+	}
+	return len(games), nil
+}
+
+func addArchive(builder *chess.BookBuilder, batchSize int) (int, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	db, err := database.Initialize(cfg.Database)
+	if err != nil {
+		return 0, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	db = db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+
+	count := 0
+	var games []models.Game
+	result := db.Where("status = ? AND variant = ?", models.GameStatusFinished, models.GameVariantStandard).
+		Preload("Moves", func(tx *gorm.DB) *gorm.DB { return tx.Order("move_number ASC") }).
+		Order("created_at ASC").
+		FindInBatches(&games, batchSize, func(tx *gorm.DB, batch int) error {
+			for _, game := range games {
+				moves := make([]string, 0, len(game.Moves))
+				for _, move := range game.Moves {
+					moves = append(moves, move.FromSquare+move.ToSquare)
+				}
+				if err := builder.AddGame(chess.StandardStartFEN, moves, pgnResult(game.Result)); err != nil {
+					fmt.Printf("%s: skipping game: %v\n", game.ID, err)
+				}
+				count++
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return count, fmt.Errorf("failed to load games: %w", result.Error)
+	}
+	return count, nil
+}
+
+func pgnResult(result *models.GameResult) string {
+	if result == nil {
+		return "*"
+	}
+	switch *result {
+	case models.GameResultWhiteWins:
+		return "1-0"
+	case models.GameResultBlackWins:
+		return "0-1"
+	case models.GameResultDraw:
+		return "1/2-1/2"
+	default:
+		return "*"
+	}
+}
@@ -0,0 +1,83 @@
+// Command consistency replays the event log of every stored game and reports
+// games whose row no longer matches what the log says.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"arcane-chess/internal/config"
+	"arcane-chess/internal/database"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/services"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	gameFlag := flag.String("game", "", "check only the game with this ID")
+	batchSize := flag.Int("batch", 200, "number of games loaded per query")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Failed to load configuration:", err)
+	}
+
+	db, err := database.Initialize(cfg.Database)
+	if err != nil {
+		log.Fatal("Failed to initialize database:", err)
+	}
+	db = db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+
+	query := db.Model(&models.Game{}).Order("created_at ASC")
+	if *gameFlag != "" {
+		gameID, err := uuid.Parse(*gameFlag)
+		if err != nil {
+			log.Fatal("Invalid game ID:", err)
+		}
+		query = query.Where("id = ?", gameID)
+	}
+
+	store := services.NewEventStore(db)
+	var checked, mismatched, missing, failed int
+
+	var games []models.Game
+	result := query.FindInBatches(&games, *batchSize, func(tx *gorm.DB, batch int) error {
+		for i := range games {
+			game := &games[i]
+			checked++
+
+			diffs, err := store.Verify(game)
+			switch {
+			case errors.Is(err, services.ErrNoEventLog):
+				missing++
+				fmt.Printf("%s: no event log\n", game.ID)
+			case err != nil:
+				failed++
+				fmt.Printf("%s: replay failed: %v\n", game.ID, err)
+			case len(diffs) > 0:
+				mismatched++
+				for _, diff := range diffs {
+					fmt.Printf("%s: %s\n", game.ID, diff)
+				}
+			}
+		}
+		return nil
+	})
+	if result.Error != nil {
+		log.Fatal("Failed to load games:", result.Error)
+	}
+
+	fmt.Printf("checked %d games: %d mismatched, %d failed to replay, %d without event log\n",
+		checked, mismatched, failed, missing)
+
+	if mismatched > 0 || failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,62 @@
+// Command positions adds the finished games played before position search
+// existed to its index. Games already indexed are left as they are, so it's
+// safe to run again.
+//
+//	go run ./cmd/positions [-batch n]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"arcane-chess/internal/config"
+	"arcane-chess/internal/database"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/services"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	batchSize := flag.Int("batch", 100, "number of games loaded per query")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Failed to load configuration:", err)
+	}
+	db, err := database.Initialize(cfg.Database)
+	if err != nil {
+		log.Fatal("Failed to initialize database:", err)
+	}
+	db = db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+
+	index := services.NewPositionSearchService(db)
+	var indexed, failed int
+
+	var games []models.Game
+	result := db.Where("status = ? AND variant = ?", models.GameStatusFinished, models.GameVariantStandard).
+		Order("created_at ASC").
+		FindInBatches(&games, *batchSize, func(tx *gorm.DB, batch int) error {
+			for _, game := range games {
+				if err := index.IndexGame(game); err != nil {
+					failed++
+					fmt.Printf("%s: %v\n", game.ID, err)
+					continue
+				}
+				indexed++
+			}
+			return nil
+		})
+	if result.Error != nil {
+		log.Fatal("Failed to load games:", result.Error)
+	}
+
+	fmt.Printf("indexed %d games (%d failed)\n", indexed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,114 @@
+// Command puzzles manages the puzzle pool: it imports CSV puzzle dumps and
+// mines new puzzles from the blunders in our finished games.
+//
+//	go run ./cmd/puzzles import puzzles.csv
+//	go run ./cmd/puzzles mine
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"arcane-chess/internal/config"
+	"arcane-chess/internal/database"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/services"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: puzzles import file.csv ...")
+	fmt.Fprintln(os.Stderr, "       puzzles mine [-batch n]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "import":
+		if len(os.Args) < 3 {
+			usage()
+		}
+		importPuzzles(openDatabase(), os.Args[2:])
+	case "mine":
+		flags := flag.NewFlagSet("mine", flag.ExitOnError)
+		batchSize := flags.Int("batch", 50, "number of games loaded per query")
+		flags.Parse(os.Args[2:])
+		minePuzzles(openDatabase(), *batchSize)
+	default:
+		usage()
+	}
+}
+
+func openDatabase() *gorm.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Failed to load configuration:", err)
+	}
+
+	db, err := database.Initialize(cfg.Database)
+	if err != nil {
+		log.Fatal("Failed to initialize database:", err)
+	}
+	return db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+}
+
+func importPuzzles(db *gorm.DB, paths []string) {
+	service := services.NewPuzzleService(db)
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatal("Failed to open puzzle file:", err)
+		}
+
+		stats, err := service.ImportCSV(bufio.NewReader(file))
+		file.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		fmt.Printf("%s: imported %d puzzles, skipped %d malformed and %d that castle or promote\n", path, stats.Imported, stats.Skipped, stats.Unsupported)
+	}
+}
+
+// minePuzzles runs the miner over every finished standard game. Puzzles
+// already mined from a game are skipped, so it's safe to run again.
+func minePuzzles(db *gorm.DB, batchSize int) {
+	miner := services.NewPuzzleMiner(db)
+	var checked, mined, failed int
+
+	var games []models.Game
+	result := db.Where("status = ? AND variant = ?", models.GameStatusFinished, models.GameVariantStandard).
+		Order("created_at ASC").
+		FindInBatches(&games, batchSize, func(tx *gorm.DB, batch int) error {
+			for _, game := range games {
+				checked++
+				found, err := miner.MineGame(game)
+				if err != nil {
+					failed++
+					fmt.Printf("%s: %v\n", game.ID, err)
+					continue
+				}
+				if found > 0 {
+					fmt.Printf("%s: %d new puzzles\n", game.ID, found)
+				}
+				mined += found
+			}
+			return nil
+		})
+	if result.Error != nil {
+		log.Fatal("Failed to load games:", result.Error)
+	}
+
+	fmt.Printf("mined %d new puzzles from %d games (%d failed)\n", mined, checked, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
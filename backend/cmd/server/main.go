@@ -9,6 +9,7 @@ import (
 	"syscall"
 	"time"
 
+	"arcane-chess/internal/chess"
 	"arcane-chess/internal/config"
 	"arcane-chess/internal/database"
 	"arcane-chess/internal/handlers"
@@ -40,9 +41,62 @@ func main() {
 	gameService := services.NewGameService(db, redis)
 	userService := services.NewUserService(db)
 	avatarService := services.NewAvatarService(db, redis)
+	arenaService := services.NewArenaService(db)
+	explorerService := services.NewExplorerService(db)
+	analysisService := services.NewAnalysisService(db, redis)
+	puzzleService := services.NewPuzzleService(db)
+	renderService := services.NewRenderService(db, redis)
+	analysisRoomService := services.NewAnalysisRoomService(db)
+	studyService := services.NewStudyService(db)
+	positionSearch := services.NewPositionSearchService(db)
+	simulService := services.NewSimulService(db, gameService)
+
+	// Keep the opening explorer's counts and the position index up to date as
+	// games finish, and review every finished game in the background
+	gameService.OnGameFinished(explorerService.RecordFinishedGame)
+	gameService.OnGameFinished(positionSearch.IndexFinishedGame)
+	gameService.OnGameFinished(analysisService.AnalyzeFinishedGame)
+
+	// End games that reach an endgame the tablebases cover, if configured
+	if cfg.Syzygy.Path != "" {
+		tablebase, err := chess.OpenTablebase(cfg.Syzygy.Path)
+		if err != nil {
+			log.Fatal("Failed to open Syzygy tablebases:", err)
+		}
+		defer tablebase.Close()
+		wdl, dtz := tablebase.Tables()
+		log.Printf("Found %d WDL and %d DTZ Syzygy tables (up to %d pieces)", wdl, dtz, tablebase.MaxPieces())
+		gameService.AdjudicateWith(tablebase)
+	}
+
+	// Tell simul hosts about the boards waiting for them and their arenas
+	// about results
+	gameService.OnMove(simulService.GameMoved)
+	gameService.OnGameFinished(simulService.GameFinished)
 
 	// Initialize handlers
-	handler := handlers.NewHandler(gameService, userService, avatarService, cfg.JWT.Secret)
+	handler := handlers.NewHandler(gameService, userService, avatarService, arenaService, explorerService, analysisService, puzzleService, renderService, analysisRoomService, studyService, positionSearch, simulService, cfg.JWT.Secret)
+
+	// Fan WebSocket traffic out to the other backend instances
+	hubBridge := services.NewHubBridge(handler.WebSocketHub(), redis)
+	if err := hubBridge.Start(context.Background()); err != nil {
+		log.Fatal("Failed to start WebSocket hub bridge:", err)
+	}
+	simulService.PublishTo(handler.WebSocketHub())
+	gameService.PublishTo(handler.WebSocketHub())
+
+	// Warn players who turned the coach on of threats after every move
+	gameService.OnMove(gameService.CoachMoved)
+
+	// Show spectators a delayed evaluation of the games they watch
+	spectatorEvals := services.NewSpectatorEvalService(handler.WebSocketHub(), gameService, cfg.Spectator.Delay, cfg.Spectator.EvalSearches)
+	gameService.OnMove(spectatorEvals.GameMoved)
+	gameService.OnGameFinished(spectatorEvals.GameFinished)
+
+	// Forfeit overdue correspondence games and remind players of deadlines
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	correspondence := services.NewCorrespondenceScheduler(gameService, handler.WebSocketHub(), cfg.Correspondence.CheckInterval)
+	go correspondence.Run(schedulerCtx)
 
 	// Setup Gin
 	if cfg.Server.Environment == "production" {
@@ -76,6 +130,7 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	stopScheduler()
 
 	// Graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -85,5 +140,9 @@ func main() {
 		log.Fatal("Server forced to shutdown:", err)
 	}
 
+	if err := hubBridge.Close(); err != nil {
+		log.Println("Error closing WebSocket hub bridge:", err)
+	}
+
 	log.Println("Server exited")
 }
@@ -6,6 +6,12 @@ import (
 	"strings"
 )
 
+// Starting positions of the supported variants.
+const (
+	StandardStartFEN   = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+	CrazyhouseStartFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR[] w KQkq - 0 1"
+)
+
 type Board struct {
 	squares     [8][8]string
 	currentTurn string
@@ -13,6 +19,10 @@ type Board struct {
 	enPassant   string
 	halfmove    int
 	fullmove    int
+
+	// Crazyhouse pieces in hand, keyed by the piece as it would be dropped
+	// ("N" is a knight white can drop). Nil for every other variant.
+	pockets map[string]int
 }
 
 type Position struct {
@@ -35,11 +45,25 @@ func (b *Board) loadFromFEN(fen string) {
 		parts = strings.Split("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", " ")
 	}
 
+	// Crazyhouse appends the pieces in hand in brackets: "...RNBQKBNR[Nn]"
+	placement := parts[0]
+	if i := strings.IndexByte(placement, '['); i >= 0 {
+		b.pockets = make(map[string]int)
+		for _, char := range strings.TrimSuffix(placement[i+1:], "]") {
+			b.pockets[string(char)]++
+		}
+		placement = placement[:i]
+	}
+
 	// Parse position
-	ranks := strings.Split(parts[0], "/")
-	for rank := 0; rank < 8; rank++ {
+	ranks := strings.Split(placement, "/")
+	for rank := 0; rank < 8 && rank < len(ranks); rank++ {
 		file := 0
 		for _, char := range ranks[rank] {
+			if char == '~' {
+				// Promoted-piece marker; promotions aren't tracked
+				continue
+			}
 			if char >= '1' && char <= '8' {
 				// Empty squares
 				emptyCount := int(char - '0')
@@ -56,7 +80,10 @@ func (b *Board) loadFromFEN(fen string) {
 	}
 
 	// Parse turn
-	b.currentTurn = parts[1]
+	b.currentTurn = "white"
+	if parts[1] == "b" {
+		b.currentTurn = "black"
+	}
 
 	// Parse castling rights
 	castling := parts[2]
@@ -104,6 +131,42 @@ func (b *Board) MovePiece(fromRank, fromFile, toRank, toFile int) {
 	b.squares[fromRank][fromFile] = ""
 }
 
+// castlingSquares lists the castling rights lost once a piece moves from, or
+// is captured on, each king and rook home square.
+var castlingSquares = map[string]string{
+	"e1": "KQ", "h1": "K", "a1": "Q",
+	"e8": "kq", "h8": "k", "a8": "q",
+}
+
+// finishMove hands the turn to the other side and updates the castling
+// rights, en passant square and move counters for a move of piece from one
+// square to another.
+func (b *Board) finishMove(piece string, from, to Position, isCapture bool) {
+	for _, square := range []Position{from, to} {
+		for _, right := range castlingSquares[squareName(square)] {
+			delete(b.castling, string(right))
+		}
+	}
+
+	b.enPassant = "-"
+	if strings.ToLower(piece) == "p" && abs(to.rank-from.rank) == 2 {
+		b.enPassant = squareName(Position{rank: (from.rank + to.rank) / 2, file: from.file})
+	}
+
+	if strings.ToLower(piece) == "p" || isCapture {
+		b.halfmove = 0
+	} else {
+		b.halfmove++
+	}
+
+	if b.currentTurn == "black" {
+		b.fullmove++
+		b.currentTurn = "white"
+	} else {
+		b.currentTurn = "black"
+	}
+}
+
 func (b *Board) ToFEN() string {
 	var fen strings.Builder
 
@@ -130,8 +193,16 @@ func (b *Board) ToFEN() string {
 		}
 	}
 
+	if b.pockets != nil {
+		fen.WriteString("[" + b.pocketString() + "]")
+	}
+
 	// Turn
-	fen.WriteString(" " + b.currentTurn)
+	if b.currentTurn == "black" {
+		fen.WriteString(" b")
+	} else {
+		fen.WriteString(" w")
+	}
 
 	// Castling
 	fen.WriteString(" ")
@@ -162,6 +233,17 @@ func (b *Board) ToFEN() string {
 	return fen.String()
 }
 
+// pocketString lists the pieces in hand, white's first, strongest first.
+func (b *Board) pocketString() string {
+	var pocket strings.Builder
+	for _, pieces := range []string{"QRBNP", "qrbnp"} {
+		for _, piece := range pieces {
+			pocket.WriteString(strings.Repeat(string(piece), b.pockets[string(piece)]))
+		}
+	}
+	return pocket.String()
+}
+
 func parseSquare(square string) (Position, error) {
 	if len(square) != 2 {
 		return Position{}, fmt.Errorf("invalid square: %s", square)
@@ -176,3 +258,7 @@ func parseSquare(square string) (Position, error) {
 
 	return Position{rank: rank, file: file}, nil
 }
+
+func squareName(pos Position) string {
+	return string(rune('a'+pos.file)) + strconv.Itoa(8-pos.rank)
+}
@@ -0,0 +1,58 @@
+package chess
+
+import "math/rand"
+
+// Where a bot's move came from.
+const (
+	MoveSourceBook      = "book"
+	MoveSourceSearch    = "search"
+	MoveSourceTablebase = "tablebase"
+)
+
+// Bot chooses moves for a computer player: from its opening book while the
+// position is in the book, from its tablebase once the position is in that,
+// and by searching otherwise.
+type Bot struct {
+	Book      *Book      // nil to always search
+	Tablebase *Tablebase // nil to search endgames too
+	Depth     int        // search depth in plies
+	rng       *rand.Rand
+}
+
+// NewBot returns a bot that plays from book, if any, and otherwise searches
+// depth plies. rng varies the book moves it picks.
+func NewBot(book *Book, depth int, rng *rand.Rand) *Bot {
+	return &Bot{Book: book, Depth: depth, rng: rng}
+}
+
+// BotMove is a move a bot chose.
+type BotMove struct {
+	Move   string `json:"move"`   // from and to squares run together ("e2e4")
+	Source string `json:"source"` // MoveSourceBook, MoveSourceTablebase or MoveSourceSearch
+	// Score and Mate are the search's, from white's point of view; zero for
+	// book and tablebase moves.
+	Score int `json:"score"`
+	Mate  int `json:"mate,omitempty"`
+}
+
+// ChooseMove picks the bot's move in fen. It reports false when the side to
+// move has no legal moves.
+func (b *Bot) ChooseMove(fen string) (BotMove, bool) {
+	if b.Book != nil {
+		if move, ok := b.Book.PickMove(fen, b.rng); ok {
+			return BotMove{Move: move.UCI(), Source: MoveSourceBook}, true
+		}
+	}
+
+	if b.Tablebase != nil {
+		if move, err := b.Tablebase.BestMove(fen); err == nil {
+			return BotMove{Move: move.Move, Source: MoveSourceTablebase}, true
+		}
+	}
+
+	result := NewEngine(fen).Search(b.Depth)
+	if result.BestMove == "" {
+		return BotMove{}, false
+	}
+	return BotMove{Move: result.BestMove, Source: MoveSourceSearch, Score: result.Score, Mate: result.Mate}, true
+}
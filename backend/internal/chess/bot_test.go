@@ -0,0 +1,68 @@
+package chess
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBot_ChooseMove(t *testing.T) {
+	builder := NewBookBuilder(2)
+	require.NoError(t, builder.AddGame(startFEN, []string{"d2d4", "d7d5"}, "1/2-1/2"))
+	var buf bytes.Buffer
+	_, err := builder.WriteTo(&buf)
+	require.NoError(t, err)
+	book, err := ReadBook(&buf)
+	require.NoError(t, err)
+
+	bot := NewBot(book, 2, rand.New(rand.NewSource(1)))
+
+	// In the book the bot plays the book move, however the search rates it
+	move, ok := bot.ChooseMove(startFEN)
+	require.True(t, ok)
+	assert.Equal(t, BotMove{Move: "d2d4", Source: MoveSourceBook}, move)
+
+	// Out of the book it searches, and finds the back-rank mate
+	move, ok = bot.ChooseMove("6k1/5ppp/8/8/8/8/5PPP/4R1K1 w - - 0 1")
+	require.True(t, ok)
+	assert.Equal(t, "e1e8", move.Move)
+	assert.Equal(t, MoveSourceSearch, move.Source)
+	assert.Equal(t, 1, move.Mate)
+
+	// Mated, there is nothing to play
+	_, ok = bot.ChooseMove("4R1k1/5ppp/8/8/8/8/5PPP/6K1 b - - 0 1")
+	assert.False(t, ok)
+}
+
+func TestBot_ChooseMoveWithoutBook(t *testing.T) {
+	move, ok := NewBot(nil, 1, nil).ChooseMove(startFEN)
+
+	require.True(t, ok)
+	assert.Equal(t, MoveSourceSearch, move.Source)
+}
+
+func TestBot_ChooseMoveFromTablebase(t *testing.T) {
+	tb, err := OpenTablebase(syzygyTestdata)
+	require.NoError(t, err)
+	defer tb.Close()
+	bot := NewBot(nil, 1, nil)
+	bot.Tablebase = tb
+
+	// The tablebase queens the pawn rather than leave it to the search
+	move, ok := bot.ChooseMove("8/4P3/5K2/8/8/8/8/k7 w - - 0 1")
+	require.True(t, ok)
+	assert.Equal(t, BotMove{Move: "e7e8q", Source: MoveSourceTablebase}, move)
+
+	// and finds the mate the search is too shallow to see
+	move, ok = bot.ChooseMove("6k1/8/5K2/8/8/8/8/7Q w - - 0 1")
+	require.True(t, ok)
+	assert.Equal(t, BotMove{Move: "h1h2", Source: MoveSourceTablebase}, move)
+
+	// Positions it doesn't cover are searched
+	move, ok = bot.ChooseMove(startFEN)
+	require.True(t, ok)
+	assert.Equal(t, MoveSourceSearch, move.Source)
+}
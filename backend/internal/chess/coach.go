@@ -0,0 +1,117 @@
+package chess
+
+// Attack maps and threat detection for coaching. Like the search they follow
+// the Engine's rules, and they look at what pieces attack, not at pins.
+
+// threatMargin is how much, in centipawns, a move has to win to count as a
+// threat: most of a pawn.
+const threatMargin = 75
+
+// HangingPiece is a piece the opponent can win by taking it.
+type HangingPiece struct {
+	Square string `json:"square"`
+	Piece  string `json:"piece"` // FEN letter
+}
+
+// HangingPieces returns color's pieces, other than the king, that the
+// opponent attacks and that are either undefended or attacked by a cheaper
+// piece.
+func HangingPieces(fen, color string) []HangingPiece {
+	pos := newSearchPosition(NewBoardFromFEN(fen))
+	white := color == "white"
+
+	var hanging []HangingPiece
+	for square, piece := range pos.squares {
+		if piece == 0 || isWhitePiece(piece) != white || piece|0x20 == 'k' {
+			continue
+		}
+		attacker, ok := pos.cheapestAttacker(square, !white)
+		if !ok {
+			continue
+		}
+		defended := pos.attacked(square, white)
+		if attacker|0x20 == 'k' && defended {
+			continue // the king can't take a defended piece
+		}
+		if !defended || pieceValues[attacker] < pieceValues[piece] {
+			hanging = append(hanging, HangingPiece{
+				Square: squareName(Position{rank: square / 8, file: square % 8}),
+				Piece:  string(piece),
+			})
+		}
+	}
+	return hanging
+}
+
+// cheapestAttacker returns the least valuable piece of the given side that
+// attacks the piece on square, a king counting as the most valuable.
+func (p *searchPosition) cheapestAttacker(square int, byWhite bool) (byte, bool) {
+	saved := p.white
+	p.white = byWhite
+	moves := p.pseudoMoves(nil)
+	p.white = saved
+
+	value := func(piece byte) int {
+		if piece|0x20 == 'k' {
+			return mateScore
+		}
+		return pieceValues[piece]
+	}
+	var cheapest byte
+	for _, move := range moves {
+		if move.to == square && (cheapest == 0 || value(move.piece) < value(cheapest)) {
+			cheapest = move.piece
+		}
+	}
+	return cheapest, cheapest != 0
+}
+
+// Threat is the best a side could do if it were its move.
+type Threat struct {
+	Move     string `json:"move"` // from and to squares run together ("d1h5")
+	Notation string `json:"notation"`
+	Captured string `json:"captured,omitempty"` // FEN letter of the piece the move takes
+	Gain     int    `json:"gain"`               // centipawns won, after the best defence
+	Mate     int    `json:"mate,omitempty"`     // moves to mate, when the threat is mate
+}
+
+// FindThreat searches fen depth plies deep as if color were to move, and
+// returns the move color threatens if it mates or wins material. There is no
+// threat while color's opponent is in check, as color could take the king.
+func FindThreat(fen, color string, depth int) (Threat, bool) {
+	board := NewBoardFromFEN(fen)
+	board.currentTurn = color
+	board.enPassant = "-"
+	pos := newSearchPosition(board)
+	if pos.inCheck(!pos.white) {
+		return Threat{}, false
+	}
+
+	static := pos.evaluate()
+	flipped := board.ToFEN()
+	result := NewEngine(flipped).Search(depth)
+	if result.BestMove == "" {
+		return Threat{}, false
+	}
+	score, mate := result.Score, result.Mate
+	if !pos.white {
+		score, mate = -score, -mate
+	}
+	if mate <= 0 && score-static < threatMargin {
+		return Threat{}, false
+	}
+
+	from, to := result.BestMove[:2], result.BestMove[2:]
+	move, err := NewEngine(flipped).ValidateMove(from, to)
+	if err != nil {
+		return Threat{}, false
+	}
+	threat := Threat{Move: result.BestMove, Notation: move.Notation, Gain: score - static}
+	if mate > 0 {
+		threat.Mate = mate
+	}
+	if move.CapturedPiece != nil {
+		threat.Captured = *move.CapturedPiece
+	}
+	return threat, true
+}
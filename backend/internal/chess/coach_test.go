@@ -0,0 +1,42 @@
+package chess
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHangingPieces(t *testing.T) {
+	// The knight on e6 is undefended, the one on c4 is defended by the queen
+	// but attacked by a pawn
+	fen := "4k3/4r3/4N3/3p4/2N5/8/8/2Q1K3 b - - 0 1"
+	assert.ElementsMatch(t, []HangingPiece{{Square: "e6", Piece: "N"}, {Square: "c4", Piece: "N"}}, HangingPieces(fen, "white"))
+	assert.Empty(t, HangingPieces(fen, "black"))
+
+	// A defended rook attacked by the king alone is safe
+	assert.Empty(t, HangingPieces("8/8/8/8/8/2k5/2R5/2K5 w - - 0 1", "white"))
+	assert.Empty(t, HangingPieces(StandardStartFEN, "white"))
+}
+
+func TestFindThreat(t *testing.T) {
+	// Black to move, but white threatens mate on the back rank
+	threat, ok := FindThreat("6k1/5ppp/8/8/8/8/5PPP/4R1K1 b - - 0 1", "white", 2)
+	require.True(t, ok)
+	assert.Equal(t, "e1e8", threat.Move)
+	assert.Equal(t, 1, threat.Mate)
+
+	// White to move; black's knight would take the undefended rook
+	threat, ok = FindThreat("4k3/8/8/8/8/2n5/8/1R2K3 w - - 0 1", "black", 2)
+	require.True(t, ok)
+	assert.Equal(t, "c3b1", threat.Move)
+	assert.Equal(t, "R", threat.Captured)
+	assert.Greater(t, threat.Gain, 400)
+
+	_, ok = FindThreat(StandardStartFEN, "black", 2)
+	assert.False(t, ok)
+
+	// White is in check, which is more than a threat
+	_, ok = FindThreat("4k3/8/8/8/8/8/8/r3K3 w - - 0 1", "black", 2)
+	assert.False(t, ok)
+}
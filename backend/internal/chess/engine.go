@@ -1,10 +1,15 @@
 package chess
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
 
+// ErrUnsupportedMove is returned for legal chess moves the engine doesn't
+// play yet: castling and promotion.
+var ErrUnsupportedMove = errors.New("not supported")
+
 type Engine struct {
 	board *Board
 }
@@ -47,29 +52,40 @@ func (e *Engine) ValidateMove(from, to string) (*Move, error) {
 		return nil, fmt.Errorf("not your piece")
 	}
 
+	// The king stepping two files is castling, which the engine can't play
+	if isCastling(piece, fromPos, toPos) {
+		return nil, fmt.Errorf("castling is %w", ErrUnsupportedMove)
+	}
+
 	// Validate move is legal for this piece type
 	if !e.isMoveLegal(fromPos, toPos, piece) {
 		return nil, fmt.Errorf("illegal move for %s", piece)
 	}
 
+	// Without promotion a pawn reaching the last rank would stay a pawn there
+	if isPromotion(piece, toPos) {
+		return nil, fmt.Errorf("promotion is %w", ErrUnsupportedMove)
+	}
+
 	// Execute move and check for checks/checkmate
 	capturedPiece := e.board.GetPiece(toPos.rank, toPos.file)
 	e.board.MovePiece(fromPos.rank, fromPos.file, toPos.rank, toPos.file)
 
-	// Check for check/checkmate/stalemate
-	isCheck := e.isInCheck(e.getOpponentColor())
-	isCheckmate := isCheck && e.isCheckmate(e.getOpponentColor())
-	isStalemate := !isCheck && e.isStalemate(e.getOpponentColor())
+	// A move may not leave the mover's own king in check
+	if e.isInCheck(e.board.currentTurn) {
+		e.board.SetPiece(fromPos.rank, fromPos.file, piece)
+		e.board.SetPiece(toPos.rank, toPos.file, capturedPiece)
+		return nil, fmt.Errorf("move leaves king in check")
+	}
 
-	move := &Move{
-		Piece:       piece,
-		IsCheck:     isCheck,
-		IsCheckmate: isCheckmate,
-		IsStalemate: isStalemate,
-		Notation:    e.generateNotation(from, to, piece, capturedPiece != ""),
-		FENAfter:    e.board.ToFEN(),
+	// In crazyhouse the capturer gets the piece in hand
+	if capturedPiece != "" && e.board.pockets != nil {
+		e.board.pockets[swapCase(capturedPiece)]++
 	}
 
+	e.board.finishMove(piece, fromPos, toPos, capturedPiece != "")
+
+	move := e.completeMove(piece, e.generateNotation(from, to, piece, capturedPiece != ""))
 	if capturedPiece != "" {
 		move.CapturedPiece = &capturedPiece
 	}
@@ -77,6 +93,72 @@ func (e *Engine) ValidateMove(from, to string) (*Move, error) {
 	return move, nil
 }
 
+// ValidateDrop puts a piece from the hand of the side to move on an empty
+// square, as in crazyhouse. pieceType is one of P, N, B, R or Q.
+func (e *Engine) ValidateDrop(pieceType, to string) (*Move, error) {
+	if e.board.pockets == nil {
+		return nil, fmt.Errorf("drops are only allowed in crazyhouse")
+	}
+
+	pieceType = strings.ToUpper(pieceType)
+	if len(pieceType) != 1 || !strings.Contains("PNBRQ", pieceType) {
+		return nil, fmt.Errorf("invalid drop piece: %s", pieceType)
+	}
+
+	toPos, err := parseSquare(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to square: %w", err)
+	}
+
+	piece := pieceOfColor(pieceType, e.board.currentTurn)
+	if e.board.pockets[piece] == 0 {
+		return nil, fmt.Errorf("no %s in hand", pieceType)
+	}
+	if e.board.GetPiece(toPos.rank, toPos.file) != "" {
+		return nil, fmt.Errorf("square %s is occupied", to)
+	}
+	if pieceType == "P" && (toPos.rank == 0 || toPos.rank == 7) {
+		return nil, fmt.Errorf("pawns cannot be dropped on the first or last rank")
+	}
+
+	e.board.SetPiece(toPos.rank, toPos.file, piece)
+	if e.isInCheck(e.board.currentTurn) {
+		e.board.SetPiece(toPos.rank, toPos.file, "")
+		return nil, fmt.Errorf("move leaves king in check")
+	}
+	e.board.pockets[piece]--
+
+	e.board.finishMove(piece, toPos, toPos, false)
+
+	return e.completeMove(piece, pieceType+"@"+to), nil
+}
+
+// completeMove describes a move that has just been played, looking at the
+// position from the side now to move.
+func (e *Engine) completeMove(piece, notation string) *Move {
+	isCheck := e.isInCheck(e.board.currentTurn)
+
+	return &Move{
+		Piece:       piece,
+		IsCheck:     isCheck,
+		IsCheckmate: isCheck && e.isCheckmate(e.board.currentTurn),
+		IsStalemate: !isCheck && e.isStalemate(e.board.currentTurn),
+		Notation:    notation,
+		FENAfter:    e.board.ToFEN(),
+	}
+}
+
+// isPromotion reports whether piece moving to to is a pawn reaching the last
+// rank. The engine doesn't promote, so ValidateMove refuses these moves.
+func isPromotion(piece string, to Position) bool {
+	return strings.ToLower(piece) == "p" && (to.rank == 0 || to.rank == 7)
+}
+
+// isCastling reports whether piece moving from to to is a king castling.
+func isCastling(piece string, from, to Position) bool {
+	return strings.ToLower(piece) == "k" && from.rank == to.rank && abs(to.file-from.file) == 2
+}
+
 func (e *Engine) isPieceColorValid(piece string) bool {
 	isWhitePiece := strings.ToUpper(piece) == piece
 	return (e.board.currentTurn == "white" && isWhitePiece) ||
@@ -84,6 +166,15 @@ func (e *Engine) isPieceColorValid(piece string) bool {
 }
 
 func (e *Engine) isMoveLegal(from, to Position, piece string) bool {
+	if from == to {
+		return false
+	}
+
+	// Pieces never land on a square held by their own side
+	if target := e.board.GetPiece(to.rank, to.file); target != "" && e.isPieceColor(target, colorOf(piece)) {
+		return false
+	}
+
 	pieceType := strings.ToLower(piece)
 
 	switch pieceType {
@@ -121,9 +212,9 @@ func (e *Engine) isPawnMoveLegal(from, to Position, piece string) bool {
 		}
 		// Double step from starting position
 		if rankDiff == 2*direction {
-			startingRank := 6
+			startingRank := 1
 			if direction == -1 {
-				startingRank = 1
+				startingRank = 6
 			}
 			return from.rank == startingRank &&
 				e.board.GetPiece(to.rank, to.file) == "" &&
@@ -201,7 +292,7 @@ func (e *Engine) isInCheck(color string) bool {
 	for rank := 0; rank < 8; rank++ {
 		for file := 0; file < 8; file++ {
 			piece := e.board.GetPiece(rank, file)
-			if piece != "" && e.isPieceColor(piece, e.getOpponentColor()) {
+			if piece != "" && e.isPieceColor(piece, oppositeColor(color)) {
 				if e.isMoveLegal(Position{rank, file}, *kingPos, piece) {
 					return true
 				}
@@ -250,8 +341,29 @@ func (e *Engine) isPieceColor(piece, color string) bool {
 	return strings.ToLower(piece) == piece
 }
 
-func (e *Engine) getOpponentColor() string {
-	if e.board.currentTurn == "white" {
+func colorOf(piece string) string {
+	if strings.ToUpper(piece) == piece {
+		return "white"
+	}
+	return "black"
+}
+
+func pieceOfColor(pieceType, color string) string {
+	if color == "white" {
+		return strings.ToUpper(pieceType)
+	}
+	return strings.ToLower(pieceType)
+}
+
+func swapCase(piece string) string {
+	if strings.ToUpper(piece) == piece {
+		return strings.ToLower(piece)
+	}
+	return strings.ToUpper(piece)
+}
+
+func oppositeColor(color string) string {
+	if color == "white" {
 		return "black"
 	}
 	return "white"
@@ -298,9 +410,83 @@ func (e *Engine) hasLegalMoves(color string) bool {
 			}
 		}
 	}
+	return e.hasLegalDrop(color)
+}
+
+// hasLegalDrop reports whether color can drop a piece in hand anywhere
+// without leaving its king in check, which includes blocking a check.
+func (e *Engine) hasLegalDrop(color string) bool {
+	if e.board.pockets == nil {
+		return false
+	}
+
+	for _, pieceType := range []string{"P", "N", "B", "R", "Q"} {
+		piece := pieceOfColor(pieceType, color)
+		if e.board.pockets[piece] == 0 {
+			continue
+		}
+
+		for rank := 0; rank < 8; rank++ {
+			if pieceType == "P" && (rank == 0 || rank == 7) {
+				continue
+			}
+			for file := 0; file < 8; file++ {
+				if e.board.GetPiece(rank, file) != "" {
+					continue
+				}
+
+				e.board.SetPiece(rank, file, piece)
+				inCheck := e.isInCheck(color)
+				e.board.SetPiece(rank, file, "")
+
+				if !inCheck {
+					return true
+				}
+			}
+		}
+	}
 	return false
 }
 
+// LegalMoves lists the moves the side to move can make, as from and to
+// squares run together ("e2e4"). Drops and promotions are not included.
+func (e *Engine) LegalMoves() []string {
+	color := e.board.currentTurn
+	var moves []string
+
+	for rank := 0; rank < 8; rank++ {
+		for file := 0; file < 8; file++ {
+			piece := e.board.GetPiece(rank, file)
+			if piece == "" || !e.isPieceColor(piece, color) {
+				continue
+			}
+			from := Position{rank, file}
+
+			for toRank := 0; toRank < 8; toRank++ {
+				for toFile := 0; toFile < 8; toFile++ {
+					to := Position{toRank, toFile}
+					if !e.isMoveLegal(from, to, piece) || isPromotion(piece, to) {
+						continue
+					}
+
+					captured := e.board.GetPiece(to.rank, to.file)
+					e.board.SetPiece(to.rank, to.file, piece)
+					e.board.SetPiece(from.rank, from.file, "")
+					inCheck := e.isInCheck(color)
+					e.board.SetPiece(from.rank, from.file, piece)
+					e.board.SetPiece(to.rank, to.file, captured)
+
+					if !inCheck {
+						moves = append(moves, squareName(from)+squareName(to))
+					}
+				}
+			}
+		}
+	}
+
+	return moves
+}
+
 func (e *Engine) generateNotation(from, to, piece string, isCapture bool) string {
 	// Simplified notation generation
 	notation := ""
@@ -0,0 +1,167 @@
+package chess
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const startFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+func TestValidateMove_PawnDoubleStep(t *testing.T) {
+	engine := NewEngine(startFEN)
+
+	move, err := engine.ValidateMove("e2", "e4")
+
+	require.NoError(t, err)
+	assert.Equal(t, "P", move.Piece)
+	assert.Equal(t, "e4", move.Notation)
+	assert.Equal(t, "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", move.FENAfter)
+}
+
+func TestValidateMove_RejectsWrongSide(t *testing.T) {
+	engine := NewEngine(startFEN)
+
+	_, err := engine.ValidateMove("e7", "e5")
+
+	assert.EqualError(t, err, "not your piece")
+}
+
+func TestValidateMove_RejectsOwnPieceCapture(t *testing.T) {
+	engine := NewEngine(startFEN)
+
+	_, err := engine.ValidateMove("d1", "d2")
+
+	assert.Error(t, err)
+}
+
+func TestValidateMove_RejectsSelfCheck(t *testing.T) {
+	// The e2 knight is pinned against the white king by the e8 rook
+	engine := NewEngine("4r2k/8/8/8/8/8/4N3/4K3 w - - 0 1")
+
+	_, err := engine.ValidateMove("e2", "c3")
+
+	assert.EqualError(t, err, "move leaves king in check")
+}
+
+func TestValidateMove_Checkmate(t *testing.T) {
+	// Fool's mate
+	engine := NewEngine("rnbqkbnr/pppp1ppp/8/4p3/6P1/5P2/PPPPP2P/RNBQKBNR b KQkq g3 0 2")
+
+	move, err := engine.ValidateMove("d8", "h4")
+
+	require.NoError(t, err)
+	assert.True(t, move.IsCheck)
+	assert.True(t, move.IsCheckmate)
+	assert.Contains(t, move.FENAfter, " w KQkq - 1 3")
+}
+
+func TestValidateMove_CrazyhouseCaptureGoesToPocket(t *testing.T) {
+	engine := NewEngine("rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR[] w KQkq d6 0 2")
+
+	move, err := engine.ValidateMove("e4", "d5")
+
+	require.NoError(t, err)
+	assert.Equal(t, "rnbqkbnr/ppp1pppp/8/3P4/8/8/PPPP1PPP/RNBQKBNR[P] b KQkq - 0 2", move.FENAfter)
+}
+
+func TestValidateDrop(t *testing.T) {
+	engine := NewEngine("rnbqkb1r/pppppppp/8/8/8/8/PPPPPPPP/RNBQKB1R[Nn] w KQkq - 0 3")
+
+	move, err := engine.ValidateDrop("N", "f3")
+
+	require.NoError(t, err)
+	assert.Equal(t, "N", move.Piece)
+	assert.Equal(t, "N@f3", move.Notation)
+	assert.Equal(t, "rnbqkb1r/pppppppp/8/8/8/5N2/PPPPPPPP/RNBQKB1R[n] b KQkq - 1 3", move.FENAfter)
+}
+
+func TestValidateDrop_Rejected(t *testing.T) {
+	tests := []struct {
+		name      string
+		fen       string
+		pieceType string
+		to        string
+		err       string
+	}{
+		{"standard game", startFEN, "N", "f3", "drops are only allowed in crazyhouse"},
+		{"empty hand", "4k3/8/8/8/8/8/8/4K3[n] w - - 0 1", "N", "f3", "no N in hand"},
+		{"occupied square", "4k3/8/8/8/8/8/8/4K3[N] w - - 0 1", "N", "e1", "square e1 is occupied"},
+		{"pawn on last rank", "4k3/8/8/8/8/8/8/4K3[P] w - - 0 1", "P", "a8", "pawns cannot be dropped on the first or last rank"},
+		{"pawn on first rank", "4k3/8/8/8/8/8/8/4K3[P] w - - 0 1", "P", "a1", "pawns cannot be dropped on the first or last rank"},
+		{"ignores check", "4k3/8/8/8/8/8/8/r3K3[N] w - - 0 1", "N", "h5", "move leaves king in check"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewEngine(tt.fen).ValidateDrop(tt.pieceType, tt.to)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestValidateMove_CrazyhouseDropCanBlockMate(t *testing.T) {
+	// Ra8 is a back-rank mate unless black can drop a piece in between
+	standard := NewEngine("7k/6pp/8/8/8/8/8/R3K3 w - - 0 1")
+	move, err := standard.ValidateMove("a1", "a8")
+	require.NoError(t, err)
+	assert.True(t, move.IsCheckmate)
+
+	crazyhouse := NewEngine("7k/6pp/8/8/8/8/8/R3K3[n] w - - 0 1")
+	move, err = crazyhouse.ValidateMove("a1", "a8")
+	require.NoError(t, err)
+	assert.True(t, move.IsCheck)
+	assert.False(t, move.IsCheckmate)
+
+	block, err := crazyhouse.ValidateDrop("N", "g8")
+	require.NoError(t, err)
+	assert.Equal(t, "n", block.Piece)
+	assert.False(t, block.IsCheck)
+}
+
+func TestValidateMove_CastlingRights(t *testing.T) {
+	for _, tc := range []struct {
+		name, fen string
+		moves     []string
+		rights    string
+	}{
+		{"king leaves home", startFEN, []string{"e2e4", "e7e5", "e1e2"}, "kq"},
+		{"king's rook leaves home", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", []string{"h1h2"}, "Qkq"},
+		{"queen's rook leaves home", "r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", []string{"a8a7"}, "KQk"},
+		{"rook captured at home", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", []string{"a1a8"}, "Kk"},
+		{"other moves keep them", startFEN, []string{"g1f3", "b8c6"}, "KQkq"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := NewEngine(tc.fen)
+			var move *Move
+			for _, uci := range tc.moves {
+				var err error
+				move, err = engine.ValidateMove(uci[:2], uci[2:])
+				require.NoError(t, err, uci)
+			}
+			assert.Equal(t, tc.rights, strings.Fields(move.FENAfter)[2])
+		})
+	}
+}
+
+func TestValidateMove_RejectsPromotion(t *testing.T) {
+	engine := NewEngine("8/4P3/8/8/8/8/8/k3K3 w - - 0 1")
+
+	_, err := engine.ValidateMove("e7", "e8")
+
+	assert.EqualError(t, err, "promotion is not supported")
+	assert.NotContains(t, engine.LegalMoves(), "e7e8")
+	assert.Equal(t, "8/4P3/8/8/8/8/8/k3K3 w - - 0 1", engine.board.ToFEN())
+}
+
+func TestValidateMove_RejectsCastling(t *testing.T) {
+	engine := NewEngine("r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1")
+
+	_, err := engine.ValidateMove("e1", "g1")
+
+	assert.ErrorIs(t, err, ErrUnsupportedMove)
+	assert.EqualError(t, err, "castling is not supported")
+	assert.Equal(t, "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", engine.board.ToFEN())
+}
@@ -0,0 +1,140 @@
+package chess
+
+// Tactical motifs, used to tag puzzles. Each takes the position right after
+// a move and looks at what the moved piece, on square, now does.
+
+// IsFork reports whether the piece on square, safe from being taken for
+// nothing, attacks two or more enemy pieces that it can win: the king,
+// anything worth more than itself, or anything undefended.
+func IsFork(fen, square string) bool {
+	pos, from, ok := motifPosition(fen, square)
+	if !ok {
+		return false
+	}
+	piece := pos.squares[from]
+	white := isWhitePiece(piece)
+	if pos.attacked(from, !white) && !pos.attacked(from, white) {
+		return false // it would just be taken
+	}
+
+	targets := 0
+	for _, move := range pos.pseudoMoves(nil) {
+		if move.from != from || move.captured == 0 {
+			continue
+		}
+		target := move.captured
+		if target|0x20 == 'p' {
+			continue
+		}
+		if target|0x20 == 'k' || pieceValues[target] > pieceValues[piece] ||
+			!pos.attacked(move.to, isWhitePiece(target)) {
+			targets++
+		}
+	}
+	return targets >= 2
+}
+
+// IsPin reports whether the piece on square pins an enemy piece, other than
+// a pawn, to its king or to a more valuable piece behind it.
+func IsPin(fen, square string) bool {
+	pos, from, ok := motifPosition(fen, square)
+	if !ok {
+		return false
+	}
+
+	var rays [][2]int
+	switch pos.squares[from] | 0x20 {
+	case 'r':
+		rays = rookRays
+	case 'b':
+		rays = bishopRays
+	case 'q':
+		rays = append(append([][2]int{}, rookRays...), bishopRays...)
+	default:
+		return false
+	}
+
+	rank, file := from/8, from%8
+	for _, ray := range rays {
+		var pinned byte
+		for r, f := rank+ray[0], file+ray[1]; onBoard(r, f); r, f = r+ray[0], f+ray[1] {
+			piece := pos.squares[r*8+f]
+			if piece == 0 {
+				continue
+			}
+			if !pos.enemy(piece) {
+				break
+			}
+			if pinned == 0 {
+				if piece|0x20 == 'k' || piece|0x20 == 'p' {
+					break
+				}
+				pinned = piece
+				continue
+			}
+			if piece|0x20 == 'k' || pieceValues[piece] > pieceValues[pinned] {
+				return true
+			}
+			break
+		}
+	}
+	return false
+}
+
+// IsBackRankMate reports whether the side to move is checkmated on its back
+// rank by a rook or queen, hemmed in by its own pieces.
+func IsBackRankMate(fen string) bool {
+	pos := newSearchPosition(NewBoardFromFEN(fen))
+	if !pos.inCheck(pos.white) || len(pos.legalMoves()) > 0 {
+		return false
+	}
+
+	king, backRank, forward := byte('k'), 0, 1
+	if pos.white {
+		king, backRank, forward = 'K', 7, -1
+	}
+	kingFile := -1
+	for file := 0; file < 8; file++ {
+		if pos.squares[backRank*8+file] == king {
+			kingFile = file
+		}
+	}
+	if kingFile < 0 {
+		return false
+	}
+
+	for file := kingFile - 1; file <= kingFile+1; file++ {
+		if !onBoard(backRank+forward, file) {
+			continue
+		}
+		if !pos.own(pos.squares[(backRank+forward)*8+file]) {
+			return false
+		}
+	}
+
+	// The mating piece stands on the back rank
+	for file := 0; file < 8; file++ {
+		piece := pos.squares[backRank*8+file]
+		if pos.enemy(piece) && (piece|0x20 == 'r' || piece|0x20 == 'q') {
+			return true
+		}
+	}
+	return false
+}
+
+// motifPosition loads fen with the side owning the piece on square to move,
+// so its moves can be generated.
+func motifPosition(fen, square string) (*searchPosition, int, bool) {
+	at, err := parseSquare(square)
+	if err != nil {
+		return nil, 0, false
+	}
+	pos := newSearchPosition(NewBoardFromFEN(fen))
+	from := at.rank*8 + at.file
+	piece := pos.squares[from]
+	if piece == 0 {
+		return nil, 0, false
+	}
+	pos.white = isWhitePiece(piece)
+	return pos, from, true
+}
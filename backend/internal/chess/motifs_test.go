@@ -0,0 +1,46 @@
+package chess
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsFork(t *testing.T) {
+	// Knight on c7 attacks the king on e8 and the rook on a8
+	assert.True(t, IsFork("r3k3/2N5/8/8/8/8/8/4K3 b - - 0 1", "c7"))
+	// Only the rook is attacked
+	assert.False(t, IsFork("r7/2N1k3/8/8/8/8/8/4K3 b - - 0 1", "c7"))
+	// A queen attacking two defended rooks isn't winning either
+	assert.False(t, IsFork("4k3/8/1r3r2/2p1p3/3Q4/8/8/4K3 b - - 0 1", "d4"))
+	// The queen would just take the knight
+	assert.False(t, IsFork("r3k3/2N5/8/8/2q5/8/8/4K3 b - - 0 1", "c7"))
+}
+
+func TestIsPin(t *testing.T) {
+	// After ...d6 the bishop on b5 pins the knight on c6 to the king on e8
+	assert.True(t, IsPin("r1bqkbnr/ppp2ppp/2np4/1B2p3/4P3/5N2/PPPP1PPP/RNBQK2R b KQkq - 1 4", "b5"))
+	// Rook on e1 pins the knight on e5 to the queen on e8
+	assert.True(t, IsPin("4q1k1/8/8/4n3/8/8/8/4R1K1 b - - 0 1", "e1"))
+	// Nothing behind the knight
+	assert.False(t, IsPin("6k1/8/8/4n3/8/8/8/4R1K1 b - - 0 1", "e1"))
+	// Knights don't pin
+	assert.False(t, IsPin("r3k3/2N5/8/8/8/8/8/4K3 b - - 0 1", "c7"))
+}
+
+func TestIsBackRankMate(t *testing.T) {
+	assert.True(t, IsBackRankMate("4R1k1/5ppp/8/8/8/8/8/6K1 b - - 0 1"))
+	// Luft on h7: not mate
+	assert.False(t, IsBackRankMate("4R1k1/5pp1/7p/8/8/8/8/6K1 b - - 0 1"))
+	// Mate, but not on the back rank
+	assert.False(t, IsBackRankMate("rnb1kbnr/pppp1ppp/8/4p3/6Pq/5P2/PPPPP2P/RNBQKBNR w KQkq - 1 3"))
+}
+
+func TestRankMoves(t *testing.T) {
+	ranked := NewEngine("6k1/5ppp/8/8/8/8/5PPP/4R1K1 w - - 0 1").RankMoves(2)
+
+	assert.Equal(t, "e1e8", ranked[0].Move)
+	assert.Equal(t, 1, ranked[0].Mate)
+	assert.Equal(t, 0, ranked[1].Mate)
+	assert.Len(t, ranked, len(NewEngine("6k1/5ppp/8/8/8/8/5PPP/4R1K1 w - - 0 1").LegalMoves()))
+}
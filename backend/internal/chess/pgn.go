@@ -0,0 +1,542 @@
+package chess
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// PGNGame is one game read from a PGN file.
+type PGNGame struct {
+	Tags map[string]string
+	// Moves are the main line in standard algebraic notation, without move
+	// numbers, comments, variations or annotations.
+	Moves []string
+	// Tree holds every move with its variations, comments and annotations.
+	Tree []*PGNMove
+}
+
+// Result returns the game's result tag, "*" when it has none.
+func (g *PGNGame) Result() string {
+	if result, ok := g.Tags["Result"]; ok {
+		return result
+	}
+	return "*"
+}
+
+var (
+	pgnTagPattern = regexp.MustCompile(`^\[(\w+)\s+"(.*)"\]$`)
+	sanPattern    = regexp.MustCompile(`^([NBRQK])?([a-h])?([1-8])?x?([a-h][1-8])(=[NBRQ])?$`)
+
+	// Arrows and highlighted squares are embedded in comments as
+	// [%cal Ge2e4,Rd1d8] and [%csl Yd4], each prefixed with its colour.
+	pgnShapePattern  = regexp.MustCompile(`\[%(cal|csl)\s+([^\]]*)\]`)
+	arrowPattern     = regexp.MustCompile(`^[RGYB][a-h][1-8][a-h][1-8]$`)
+	highlightPattern = regexp.MustCompile(`^[RGYB][a-h][1-8]$`)
+)
+
+// ValidArrow reports whether arrow is a colour (R, G, Y or B) followed by
+// the squares the arrow goes from and to: "Ge2e4".
+func ValidArrow(arrow string) bool {
+	return arrowPattern.MatchString(arrow)
+}
+
+// ValidHighlight reports whether highlight is a colour (R, G, Y or B)
+// followed by a square: "Rd4".
+func ValidHighlight(highlight string) bool {
+	return highlightPattern.MatchString(highlight)
+}
+
+// ParsePGN reads every game in r.
+func ParsePGN(r io.Reader) ([]PGNGame, error) {
+	var games []PGNGame
+	var game *PGNGame
+	var movetext strings.Builder
+
+	finish := func() {
+		if game == nil {
+			return
+		}
+		game.Moves = parseMovetext(movetext.String())
+		game.Tree = parseMoveTree(movetext.String())
+		games = append(games, *game)
+		game = nil
+		movetext.Reset()
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "%") {
+			continue
+		}
+
+		if match := pgnTagPattern.FindStringSubmatch(line); match != nil {
+			// A tag after movetext starts the next game
+			if game != nil && movetext.Len() > 0 {
+				finish()
+			}
+			if game == nil {
+				game = &PGNGame{Tags: make(map[string]string)}
+			}
+			game.Tags[match[1]] = match[2]
+			continue
+		}
+
+		if game == nil {
+			game = &PGNGame{Tags: make(map[string]string)}
+		}
+		movetext.WriteString(line)
+		movetext.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read PGN: %w", err)
+	}
+	finish()
+
+	return games, nil
+}
+
+// parseMovetext extracts the main line's moves from PGN movetext.
+func parseMovetext(text string) []string {
+	var moves []string
+	var token strings.Builder
+	depth := 0 // nesting of variations
+	inComment := false
+	inLineComment := false
+
+	flush := func() {
+		word := token.String()
+		token.Reset()
+		if word == "" || depth > 0 {
+			return
+		}
+
+		// Move numbers may be glued to the move: "1.e4", "12...Nf6"
+		if i := strings.LastIndex(word, "."); i >= 0 {
+			word = word[i+1:]
+		}
+		switch {
+		case word == "", strings.HasPrefix(word, "$"):
+		case word == "1-0", word == "0-1", word == "1/2-1/2", word == "*":
+		default:
+			moves = append(moves, word)
+		}
+	}
+
+	for _, char := range text {
+		switch {
+		case inLineComment:
+			if char == '\n' {
+				inLineComment = false
+			}
+		case inComment:
+			if char == '}' {
+				inComment = false
+			}
+		case char == '{':
+			flush()
+			inComment = true
+		case char == ';':
+			flush()
+			inLineComment = true
+		case char == '(':
+			flush()
+			depth++
+		case char == ')':
+			flush()
+			if depth > 0 {
+				depth--
+			}
+		case char == ' ' || char == '\n' || char == '\t' || char == '\r':
+			flush()
+		default:
+			token.WriteRune(char)
+		}
+	}
+	flush()
+
+	return moves
+}
+
+// suffixNAGs are the glyphs that may follow a move instead of a NAG.
+var suffixNAGs = map[string]int{"!": 1, "?": 2, "!!": 3, "??": 4, "!?": 5, "?!": 6}
+
+// parseMoveTree reads PGN movetext into a tree of moves. A comment belongs to
+// the move before it; a comment before a line's first move is dropped.
+func parseMoveTree(text string) []*PGNMove {
+	var root []*PGNMove
+	type position struct {
+		list, prev *[]*PGNMove // where the next move goes, and where the last one went
+		last       *PGNMove
+	}
+	at := position{list: &root, prev: &root}
+	var stack []position
+	var token, comment strings.Builder
+	inComment := false
+	inLineComment := false
+
+	flush := func() {
+		word := token.String()
+		token.Reset()
+		if i := strings.LastIndex(word, "."); i >= 0 {
+			word = word[i+1:]
+		}
+		switch {
+		case word == "", word == "1-0", word == "0-1", word == "1/2-1/2", word == "*":
+		case strings.HasPrefix(word, "$"):
+			var nag int
+			if _, err := fmt.Sscan(word[1:], &nag); err == nil && at.last != nil {
+				at.last.NAGs = append(at.last.NAGs, nag)
+			}
+		default:
+			san := strings.TrimRight(word, "!?")
+			move := &PGNMove{SAN: san}
+			if nag, ok := suffixNAGs[word[len(san):]]; ok {
+				move.NAGs = append(move.NAGs, nag)
+			}
+			*at.list = append(*at.list, move)
+			at = position{list: &move.Next, prev: at.list, last: move}
+		}
+	}
+	addComment := func() {
+		text := comment.String()
+		comment.Reset()
+		if at.last == nil {
+			return
+		}
+		text = pgnShapePattern.ReplaceAllStringFunc(text, func(command string) string {
+			match := pgnShapePattern.FindStringSubmatch(command)
+			for _, shape := range strings.Split(match[2], ",") {
+				shape = strings.TrimSpace(shape)
+				switch {
+				case match[1] == "cal" && ValidArrow(shape):
+					at.last.Arrows = append(at.last.Arrows, shape)
+				case match[1] == "csl" && ValidHighlight(shape):
+					at.last.Highlights = append(at.last.Highlights, shape)
+				}
+			}
+			return " "
+		})
+		text = strings.Join(strings.Fields(text), " ")
+		if text == "" {
+			return
+		}
+		if at.last.Comment != "" {
+			text = at.last.Comment + " " + text
+		}
+		at.last.Comment = text
+	}
+
+	for _, char := range text {
+		switch {
+		case inLineComment:
+			if char == '\n' {
+				inLineComment = false
+				addComment()
+			} else {
+				comment.WriteRune(char)
+			}
+		case inComment:
+			if char == '}' {
+				inComment = false
+				addComment()
+			} else {
+				comment.WriteRune(char)
+			}
+		case char == '{':
+			flush()
+			inComment = true
+		case char == ';':
+			flush()
+			inLineComment = true
+		case char == '(':
+			// A variation is an alternative to the last move
+			flush()
+			stack = append(stack, at)
+			at = position{list: at.prev, prev: at.prev}
+		case char == ')':
+			flush()
+			if len(stack) > 0 {
+				at = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case char == ' ' || char == '\n' || char == '\t' || char == '\r':
+			flush()
+		default:
+			token.WriteRune(char)
+		}
+	}
+	flush()
+	if inLineComment {
+		addComment()
+	}
+
+	return root
+}
+
+// ParseSAN finds the legal move written as san in standard algebraic notation
+// and returns it as from and to squares run together ("g1f3"). Castling and
+// promotion aren't supported by the engine and fail with ErrUnsupportedMove.
+func (e *Engine) ParseSAN(san string) (string, error) {
+	move := strings.TrimRight(san, "+#!?")
+	if strings.HasPrefix(move, "O-O") || strings.HasPrefix(move, "0-0") {
+		return "", fmt.Errorf("castling is %w: %s", ErrUnsupportedMove, san)
+	}
+
+	match := sanPattern.FindStringSubmatch(move)
+	if match == nil {
+		return "", fmt.Errorf("invalid move notation: %s", san)
+	}
+	if match[5] != "" {
+		return "", fmt.Errorf("promotion is %w: %s", ErrUnsupportedMove, san)
+	}
+
+	pieceType := "P"
+	if match[1] != "" {
+		pieceType = match[1]
+	}
+	piece := pieceOfColor(pieceType, e.board.currentTurn)
+	fromFile, fromRank, to := match[2], match[3], match[4]
+
+	var found []string
+	for _, candidate := range e.LegalMoves() {
+		if candidate[2:] != to {
+			continue
+		}
+		from, _ := parseSquare(candidate[:2])
+		if e.board.GetPiece(from.rank, from.file) != piece {
+			continue
+		}
+		if (fromFile != "" && candidate[0:1] != fromFile) || (fromRank != "" && candidate[1:2] != fromRank) {
+			continue
+		}
+		found = append(found, candidate)
+	}
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("illegal move: %s", san)
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("ambiguous move: %s", san)
+	}
+}
+
+// PGNTag is a header of a written game, written in the order given.
+type PGNTag struct {
+	Name  string
+	Value string
+}
+
+// PGNMove is a move in a tree of variations, as read from or written to PGN.
+type PGNMove struct {
+	SAN        string
+	NAGs       []int
+	Comment    string
+	Arrows     []string // e.g. "Ge2e4", see ValidArrow
+	Highlights []string // e.g. "Rd4", see ValidHighlight
+	// Next holds the moves that can follow: the first continues the line,
+	// the rest are variations on it.
+	Next []*PGNMove
+}
+
+const pgnLineLength = 79
+
+// FormatPGN writes a game starting from fen whose first moves are line, the
+// first of them being the main line. A FEN tag is added when fen isn't the
+// standard starting position. Result goes in the tags and after the moves.
+func FormatPGN(tags []PGNTag, fen string, line []*PGNMove, result string) string {
+	var b strings.Builder
+	for _, tag := range tags {
+		fmt.Fprintf(&b, "[%s %q]\n", tag.Name, tag.Value)
+	}
+	fmt.Fprintf(&b, "[Result %q]\n", result)
+	if fen != StandardStartFEN {
+		fmt.Fprintf(&b, "[SetUp \"1\"]\n[FEN %q]\n", fen)
+	}
+	b.WriteString("\n")
+	b.WriteString(formatMoves(fen, line, result))
+	b.WriteString("\n")
+	return b.String()
+}
+
+// FormatMovetext writes line from fen as PGN movetext, without a result.
+func FormatMovetext(fen string, line []*PGNMove) string {
+	return formatMoves(fen, line, "")
+}
+
+func formatMoves(fen string, line []*PGNMove, result string) string {
+	w := pgnWriter{whiteFirst: true, firstMove: 1}
+	if fields := strings.Fields(fen); len(fields) == 6 {
+		w.whiteFirst = fields[1] != "b"
+		fmt.Sscan(fields[5], &w.firstMove)
+	}
+	w.writeLine(line, 0, true)
+	if result != "" {
+		w.token(result)
+	}
+	return w.text.String()
+}
+
+type pgnWriter struct {
+	text       strings.Builder
+	column     int
+	opened     bool // the last word opened a variation
+	whiteFirst bool
+	firstMove  int
+}
+
+// writeLine writes the first of moves, the others as variations, and then
+// carries on down the first. ply counts half-moves from the start.
+func (w *pgnWriter) writeLine(moves []*PGNMove, ply int, numbered bool) {
+	for len(moves) > 0 {
+		main := moves[0]
+		w.writeMove(main, ply, numbered)
+
+		for _, variation := range moves[1:] {
+			w.token("(")
+			w.writeMove(variation, ply, true)
+			w.writeLine(variation.Next, ply+1, variation.commented())
+			w.token(")")
+		}
+
+		// Black's move needs its number again after anything between moves
+		numbered = len(moves) > 1 || main.commented()
+		moves = main.Next
+		ply++
+	}
+}
+
+func (w *pgnWriter) writeMove(move *PGNMove, ply int, numbered bool) {
+	halfMoves := ply
+	if !w.whiteFirst {
+		halfMoves++
+	}
+	number := w.firstMove + halfMoves/2
+	if halfMoves%2 == 0 {
+		w.token(fmt.Sprintf("%d.", number))
+	} else if numbered {
+		w.token(fmt.Sprintf("%d...", number))
+	}
+
+	w.token(move.SAN)
+	for _, nag := range move.NAGs {
+		w.token(fmt.Sprintf("$%d", nag))
+	}
+	// Braces would end the comment early
+	comment := strings.NewReplacer("{", "(", "}", ")").Replace(move.Comment)
+	// Shape commands are kept on one line
+	var words []string
+	if len(move.Highlights) > 0 {
+		words = append(words, "[%csl "+strings.Join(move.Highlights, ",")+"]")
+	}
+	if len(move.Arrows) > 0 {
+		words = append(words, "[%cal "+strings.Join(move.Arrows, ",")+"]")
+	}
+	words = append(words, strings.Fields(comment)...)
+	for i, word := range words {
+		if i == 0 {
+			word = "{" + word
+		}
+		w.token(word)
+	}
+	if len(words) > 0 {
+		w.glue("}")
+	}
+}
+
+// commented reports whether the move is followed by a comment.
+func (m *PGNMove) commented() bool {
+	return strings.TrimSpace(m.Comment) != "" || len(m.Arrows) > 0 || len(m.Highlights) > 0
+}
+
+// token writes a space-separated word, wrapping long lines. Parentheses hug
+// the moves inside them.
+func (w *pgnWriter) token(word string) {
+	if word == ")" {
+		w.glue(word)
+		return
+	}
+	if w.column > 0 {
+		if w.column+1+len(word) > pgnLineLength {
+			w.text.WriteString("\n")
+			w.column = 0
+		} else if !w.opened {
+			w.text.WriteString(" ")
+			w.column++
+		}
+	}
+	w.glue(word)
+	w.opened = word == "("
+}
+
+func (w *pgnWriter) glue(word string) {
+	w.text.WriteString(word)
+	w.column += len(word)
+}
+
+// PGNChange is a difference between two trees of moves. Path is the line of
+// moves leading to the one that changed, which is last.
+type PGNChange struct {
+	Kind string   `json:"kind"` // added, removed, annotated or promoted
+	Path []string `json:"path"`
+}
+
+// DiffPGN lists what changed from the tree before to the tree after, parents
+// before children. Moves are matched by SAN among their siblings; a move
+// added or removed along with everything after it is reported once. A move
+// that became the main line in place of another is promoted.
+func DiffPGN(before, after []*PGNMove) []PGNChange {
+	var changes []PGNChange
+	var walk func(before, after []*PGNMove, path []string)
+	walk = func(before, after []*PGNMove, path []string) {
+		at := func(move *PGNMove) []string {
+			return append(path[:len(path):len(path)], move.SAN)
+		}
+		old := make(map[string]*PGNMove, len(before))
+		for _, move := range before {
+			old[move.SAN] = move
+		}
+		kept := make(map[string]bool, len(after))
+		for i, move := range after {
+			previous, ok := old[move.SAN]
+			if !ok {
+				changes = append(changes, PGNChange{Kind: "added", Path: at(move)})
+				continue
+			}
+			kept[move.SAN] = true
+			if i == 0 && before[0].SAN != move.SAN {
+				changes = append(changes, PGNChange{Kind: "promoted", Path: at(move)})
+			}
+			if previous.Comment != move.Comment || !equalSlices(previous.NAGs, move.NAGs) ||
+				!equalSlices(previous.Arrows, move.Arrows) || !equalSlices(previous.Highlights, move.Highlights) {
+				changes = append(changes, PGNChange{Kind: "annotated", Path: at(move)})
+			}
+			walk(previous.Next, move.Next, at(move))
+		}
+		for _, move := range before {
+			if !kept[move.SAN] {
+				changes = append(changes, PGNChange{Kind: "removed", Path: at(move)})
+			}
+		}
+	}
+	walk(before, after, nil)
+	return changes
+}
+
+func equalSlices[T comparable](a, b []T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
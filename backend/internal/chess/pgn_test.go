@@ -0,0 +1,191 @@
+package chess
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const twoGamesPGN = `[Event "Casual"]
+[White "Alice"]
+[Black "Bob"]
+[Result "0-1"]
+
+1. f3 e5 {Bob plays the main line} 2. g4 (2. e4 Nf6) $4 Qh4# 0-1
+
+[Event "Casual"]
+[Result "1/2-1/2"]
+
+1.e4 c5 2.Nf3 d6 ; Najdorf next
+3.d4 cxd4 4.Nxd4 1/2-1/2
+`
+
+func TestParsePGN(t *testing.T) {
+	games, err := ParsePGN(strings.NewReader(twoGamesPGN))
+
+	require.NoError(t, err)
+	require.Len(t, games, 2)
+	assert.Equal(t, "Alice", games[0].Tags["White"])
+	assert.Equal(t, "0-1", games[0].Result())
+	assert.Equal(t, []string{"f3", "e5", "g4", "Qh4#"}, games[0].Moves)
+	assert.Equal(t, []string{"e4", "c5", "Nf3", "d6", "d4", "cxd4", "Nxd4"}, games[1].Moves)
+}
+
+func TestParsePGN_Tree(t *testing.T) {
+	games, err := ParsePGN(strings.NewReader(twoGamesPGN))
+	require.NoError(t, err)
+
+	f3 := games[0].Tree[0]
+	e5 := f3.Next[0]
+	assert.Equal(t, "Bob plays the main line", e5.Comment)
+	require.Len(t, e5.Next, 2)
+	g4, e4 := e5.Next[0], e5.Next[1]
+	assert.Equal(t, "g4", g4.SAN)
+	assert.Equal(t, []int{4}, g4.NAGs)
+	assert.Equal(t, "Qh4#", g4.Next[0].SAN)
+	assert.Equal(t, "e4", e4.SAN)
+	assert.Equal(t, "Nf6", e4.Next[0].SAN)
+
+	d6 := games[1].Tree[0].Next[0].Next[0].Next[0]
+	assert.Equal(t, "d6", d6.SAN)
+	assert.Equal(t, "Najdorf next", d6.Comment)
+}
+
+func TestParsePGN_TreeRoundTrip(t *testing.T) {
+	movetext := "1. e4 $1 (1. d4 d5 (1... Nf6 2. c4) 2. c4) 1... c5 {Sicilian} 2. Nf3?! *"
+	games, err := ParsePGN(strings.NewReader(movetext))
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{6}, games[0].Tree[0].Next[0].Next[0].NAGs)
+	assert.Equal(t, "1. e4 $1 (1. d4 d5 (1... Nf6 2. c4) 2. c4) 1... c5 {Sicilian} 2. Nf3 $6",
+		FormatMovetext(StandardStartFEN, games[0].Tree))
+}
+
+func TestParsePGN_Shapes(t *testing.T) {
+	movetext := "1. e4 {[%csl Rd5,Ge4][%cal Gg1f3] Controls d5 [%clk 0:05:00]} 1... e5 {[%cal Xa1a2,Bd8h4]} 2. Nf3 *"
+	games, err := ParsePGN(strings.NewReader(movetext))
+	require.NoError(t, err)
+
+	e4, e5 := games[0].Tree[0], games[0].Tree[0].Next[0]
+	assert.Equal(t, []string{"Rd5", "Ge4"}, e4.Highlights)
+	assert.Equal(t, []string{"Gg1f3"}, e4.Arrows)
+	assert.Equal(t, "Controls d5 [%clk 0:05:00]", e4.Comment)
+	// Shapes in colours PGN doesn't know are dropped
+	assert.Equal(t, []string{"Bd8h4"}, e5.Arrows)
+	assert.Empty(t, e5.Comment)
+
+	assert.Equal(t, "1. e4 {[%csl Rd5,Ge4] [%cal Gg1f3] Controls d5 [%clk 0:05:00]} 1... e5\n{[%cal Bd8h4]} 2. Nf3",
+		FormatMovetext(StandardStartFEN, games[0].Tree))
+
+	plain, err := ParsePGN(strings.NewReader("1. e4 {Controls d5 [%clk 0:05:00]} 1... e5 2. Nf3 *"))
+	require.NoError(t, err)
+	assert.Equal(t, []PGNChange{
+		{Kind: "annotated", Path: []string{"e4"}},
+		{Kind: "annotated", Path: []string{"e4", "e5"}},
+	}, DiffPGN(plain[0].Tree, games[0].Tree))
+}
+
+func TestDiffPGN(t *testing.T) {
+	parse := func(movetext string) []*PGNMove {
+		games, err := ParsePGN(strings.NewReader(movetext))
+		require.NoError(t, err)
+		return games[0].Tree
+	}
+	before := parse("1. e4 e5 (1... c5 2. Nf3) 2. Nf3 Nc6 {Main}")
+	after := parse("1. e4 c5 (1... e5 2. Nf3 Nc6 {Main line}) 2. Nf3 d6 (2... e6)")
+
+	assert.Equal(t, []PGNChange{
+		{Kind: "promoted", Path: []string{"e4", "c5"}},
+		{Kind: "added", Path: []string{"e4", "c5", "Nf3", "d6"}},
+		{Kind: "added", Path: []string{"e4", "c5", "Nf3", "e6"}},
+		{Kind: "annotated", Path: []string{"e4", "e5", "Nf3", "Nc6"}},
+	}, DiffPGN(before, after))
+	assert.Empty(t, DiffPGN(after, after))
+	assert.Equal(t, []PGNChange{{Kind: "removed", Path: []string{"e4"}}}, DiffPGN(before, nil))
+}
+
+func TestFormatPGN(t *testing.T) {
+	qh4 := &PGNMove{SAN: "Qh4#"}
+	line := []*PGNMove{{SAN: "f3", Next: []*PGNMove{
+		{SAN: "e5", Comment: "the {main} line", Next: []*PGNMove{
+			{SAN: "g4", NAGs: []int{4}, Next: []*PGNMove{qh4}},
+			{SAN: "e4", Next: []*PGNMove{{SAN: "Nf6"}}},
+		}},
+		{SAN: "e6"},
+	}}}
+
+	pgn := FormatPGN([]PGNTag{{"Event", "Casual"}}, StandardStartFEN, line, "*")
+
+	assert.Equal(t, `[Event "Casual"]
+[Result "*"]
+
+1. f3 e5 {the (main) line} (1... e6) 2. g4 $4 (2. e4 Nf6) 2... Qh4# *
+`, pgn)
+
+	games, err := ParsePGN(strings.NewReader(pgn))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"f3", "e5", "g4", "Qh4#"}, games[0].Moves)
+}
+
+func TestFormatPGN_FromPosition(t *testing.T) {
+	fen := "4k3/8/8/8/8/8/4P3/4K3 b - - 0 40"
+	line := []*PGNMove{{SAN: "Kd7", Next: []*PGNMove{{SAN: "e4"}}}}
+
+	pgn := FormatPGN(nil, fen, line, "*")
+
+	assert.Contains(t, pgn, `[SetUp "1"]`)
+	assert.Contains(t, pgn, `[FEN "`+fen+`"]`)
+	assert.True(t, strings.HasSuffix(pgn, "\n40... Kd7 41. e4 *\n"))
+}
+
+func TestParseSAN(t *testing.T) {
+	// Knights on b1 and f3 can both reach d2
+	engine := NewEngine("rnbqkbnr/pppppppp/8/8/3P4/5N2/PPP1PPPP/RNBQKB1R w KQkq - 0 1")
+
+	tests := map[string]string{
+		"e4":    "e2e4",
+		"Nbd2":  "b1d2",
+		"Nfd2":  "f3d2",
+		"Bf4":   "c1f4",
+		"Qd3+!": "d1d3",
+	}
+	for san, want := range tests {
+		move, err := engine.ParseSAN(san)
+		require.NoError(t, err, san)
+		assert.Equal(t, want, move, san)
+	}
+
+	for san, want := range map[string]string{
+		"Nd2":   "ambiguous move: Nd2",
+		"Nd4":   "illegal move: Nd4",
+		"O-O":   "castling is not supported: O-O",
+		"e8=Q":  "promotion is not supported: e8=Q",
+		"hello": "invalid move notation: hello",
+	} {
+		_, err := engine.ParseSAN(san)
+		assert.EqualError(t, err, want, san)
+	}
+}
+
+func TestBookBuilder_AddPGN(t *testing.T) {
+	games, err := ParsePGN(strings.NewReader(twoGamesPGN))
+	require.NoError(t, err)
+
+	builder := NewBookBuilder(10)
+	for _, game := range games {
+		require.NoError(t, builder.AddPGN(game))
+	}
+
+	// Black won the first game, so only black's moves score
+	afterF3 := "rnbqkbnr/pppppppp/8/8/8/5P2/PPPPP1PP/RNBQKBNR b KQkq - 0 1"
+	entries := builder.Entries()
+	keys := map[uint64]bool{}
+	for _, entry := range entries {
+		keys[entry.Key] = true
+	}
+	assert.True(t, keys[PolyglotKey(afterF3)])
+	assert.True(t, keys[PolyglotKey(startFEN)])
+	assert.Len(t, entries, 2+7)
+}
@@ -0,0 +1,369 @@
+package chess
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Polyglot books are files of 16-byte big-endian entries sorted by key: the
+// position's Polyglot hash, the move, its weight and a learning field nobody
+// uses. A position has one entry per book move.
+
+const polyglotEntrySize = 16
+
+// polyglotPieces orders the pieces the way Polyglot numbers them.
+const polyglotPieces = "pPnNbBrRqQkK"
+
+// PolyglotKey returns the Polyglot hash of the position described by fen.
+func PolyglotKey(fen string) uint64 {
+	board := NewBoardFromFEN(fen)
+	var key uint64
+
+	for rank := 0; rank < 8; rank++ {
+		for file := 0; file < 8; file++ {
+			piece := board.squares[rank][file]
+			if piece == "" {
+				continue
+			}
+			kind := strings.Index(polyglotPieces, piece)
+			// Polyglot counts rows from white's side
+			row := 7 - rank
+			key ^= polyglotRandom[64*kind+8*row+file]
+		}
+	}
+
+	for i, right := range []string{"K", "Q", "k", "q"} {
+		if board.castling[right] {
+			key ^= polyglotRandom[768+i]
+		}
+	}
+
+	if board.canCaptureEnPassant() {
+		target, _ := parseSquare(board.enPassant)
+		key ^= polyglotRandom[772+target.file]
+	}
+
+	if board.currentTurn == "white" {
+		key ^= polyglotRandom[780]
+	}
+
+	return key
+}
+
+// BookEntry is one record of a Polyglot book.
+type BookEntry struct {
+	Key    uint64
+	Move   uint16
+	Weight uint16
+	Learn  uint32
+}
+
+// BookMove is a move a book suggests for a position.
+type BookMove struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Promotion string `json:"promotion,omitempty"` // n, b, r or q
+	Weight    int    `json:"weight"`
+}
+
+// UCI returns the move as from and to squares run together ("e2e4").
+func (m BookMove) UCI() string {
+	return m.From + m.To + m.Promotion
+}
+
+type Book struct {
+	entries []BookEntry
+}
+
+// OpenBook reads the Polyglot book at path.
+func OpenBook(path string) (*Book, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open book: %w", err)
+	}
+	defer file.Close()
+
+	return ReadBook(bufio.NewReader(file))
+}
+
+// ReadBook reads a Polyglot book.
+func ReadBook(r io.Reader) (*Book, error) {
+	book := &Book{}
+	var record [polyglotEntrySize]byte
+	for {
+		if _, err := io.ReadFull(r, record[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("book ends with a partial entry")
+			}
+			return nil, fmt.Errorf("failed to read book: %w", err)
+		}
+
+		book.entries = append(book.entries, BookEntry{
+			Key:    binary.BigEndian.Uint64(record[0:8]),
+			Move:   binary.BigEndian.Uint16(record[8:10]),
+			Weight: binary.BigEndian.Uint16(record[10:12]),
+			Learn:  binary.BigEndian.Uint32(record[12:16]),
+		})
+	}
+
+	// Books are meant to be sorted, but don't rely on it
+	sort.SliceStable(book.entries, func(i, j int) bool {
+		return book.entries[i].Key < book.entries[j].Key
+	})
+
+	return book, nil
+}
+
+// Len returns the number of entries in the book.
+func (b *Book) Len() int {
+	return len(b.entries)
+}
+
+// Moves returns the book moves for the position in fen, heaviest first.
+func (b *Book) Moves(fen string) []BookMove {
+	key := PolyglotKey(fen)
+	board := NewBoardFromFEN(fen)
+
+	first := sort.Search(len(b.entries), func(i int) bool { return b.entries[i].Key >= key })
+	var moves []BookMove
+	for i := first; i < len(b.entries) && b.entries[i].Key == key; i++ {
+		move := decodePolyglotMove(b.entries[i].Move, board)
+		move.Weight = int(b.entries[i].Weight)
+		moves = append(moves, move)
+	}
+
+	sort.SliceStable(moves, func(i, j int) bool { return moves[i].Weight > moves[j].Weight })
+	return moves
+}
+
+// PickMove chooses one of the book moves for fen at random, in proportion to
+// their weights. Moves the engine can't play, such as castling, are skipped.
+func (b *Book) PickMove(fen string, rng *rand.Rand) (BookMove, bool) {
+	var playable []BookMove
+	total := 0
+	for _, move := range b.Moves(fen) {
+		if move.Weight == 0 || move.Promotion != "" {
+			continue
+		}
+		if _, err := NewEngine(fen).ValidateMove(move.From, move.To); err != nil {
+			continue
+		}
+		playable = append(playable, move)
+		total += move.Weight
+	}
+	if total == 0 {
+		return BookMove{}, false
+	}
+
+	pick := rng.Intn(total)
+	for _, move := range playable {
+		if pick < move.Weight {
+			return move, true
+		}
+		pick -= move.Weight
+	}
+	return playable[len(playable)-1], true
+}
+
+// decodePolyglotMove unpacks a book move. Polyglot writes castling as the king
+// taking its own rook, which is turned into the usual king move here.
+func decodePolyglotMove(encoded uint16, board *Board) BookMove {
+	square := func(file, row uint16) string {
+		return squareName(Position{rank: 7 - int(row), file: int(file)})
+	}
+
+	move := BookMove{
+		From: square(encoded>>6&7, encoded>>9&7),
+		To:   square(encoded&7, encoded>>3&7),
+	}
+	if promotion := encoded >> 12 & 7; promotion > 0 && promotion < 5 {
+		move.Promotion = string("nbrq"[promotion-1])
+	}
+
+	from, _ := parseSquare(move.From)
+	if strings.ToLower(board.GetPiece(from.rank, from.file)) == "k" {
+		switch move.From + move.To {
+		case "e1h1", "e8h8":
+			move.To = "g" + move.To[1:]
+		case "e1a1", "e8a8":
+			move.To = "c" + move.To[1:]
+		}
+	}
+
+	return move
+}
+
+// encodePolyglotMove packs a move given as from and to squares.
+func encodePolyglotMove(from, to Position) uint16 {
+	return uint16(to.file) | uint16(7-to.rank)<<3 | uint16(from.file)<<6 | uint16(7-from.rank)<<9
+}
+
+// BookBuilder collects moves from games and writes them as a Polyglot book.
+// A move scores 2 for every game its side won and 1 for every draw; moves
+// that only ever lost are left out.
+type BookBuilder struct {
+	maxPly    int
+	weights   map[uint64]map[uint16]int
+	truncated int
+}
+
+// NewBookBuilder returns a builder that looks at the first maxPly half-moves
+// of each game.
+func NewBookBuilder(maxPly int) *BookBuilder {
+	return &BookBuilder{
+		maxPly:  maxPly,
+		weights: make(map[uint64]map[uint16]int),
+	}
+}
+
+// AddGame adds a game played from startFEN. Moves are from and to squares
+// run together ("e2e4") and result is a PGN result ("1-0", "0-1" or
+// "1/2-1/2"); unfinished games are ignored.
+func (bb *BookBuilder) AddGame(startFEN string, moves []string, result string) error {
+	if result != "1-0" && result != "0-1" && result != "1/2-1/2" {
+		return nil
+	}
+
+	fen := startFEN
+	for ply, move := range moves {
+		if ply >= bb.maxPly {
+			break
+		}
+		if len(move) != 4 {
+			return fmt.Errorf("move %d: invalid move %q", ply+1, move)
+		}
+
+		white := NewBoardFromFEN(fen).currentTurn == "white"
+		score := 0
+		switch {
+		case result == "1/2-1/2":
+			score = 1
+		case (result == "1-0") == white:
+			score = 2
+		}
+
+		played, err := NewEngine(fen).ValidateMove(move[:2], move[2:])
+		if err != nil {
+			return fmt.Errorf("move %d: %s: %w", ply+1, move, err)
+		}
+
+		if score > 0 {
+			key := PolyglotKey(fen)
+			if bb.weights[key] == nil {
+				bb.weights[key] = make(map[uint16]int)
+			}
+			from, _ := parseSquare(move[:2])
+			to, _ := parseSquare(move[2:])
+			bb.weights[key][encodePolyglotMove(from, to)] += score
+		}
+
+		fen = played.FENAfter
+	}
+
+	return nil
+}
+
+// AddPGN adds a game read from a PGN file. Games are followed until the
+// first move the engine can't play, such as castling; Truncated counts the
+// games cut short that way.
+func (bb *BookBuilder) AddPGN(game PGNGame) error {
+	fen := StandardStartFEN
+	if setup, ok := game.Tags["FEN"]; ok {
+		fen = setup
+	}
+
+	var moves []string
+	position := fen
+	for _, san := range game.Moves {
+		if len(moves) >= bb.maxPly {
+			break
+		}
+		engine := NewEngine(position)
+		move, err := engine.ParseSAN(san)
+		if err != nil {
+			bb.truncated++
+			break
+		}
+		played, err := engine.ValidateMove(move[:2], move[2:])
+		if err != nil {
+			bb.truncated++
+			break
+		}
+		moves = append(moves, move)
+		position = played.FENAfter
+	}
+
+	return bb.AddGame(fen, moves, game.Result())
+}
+
+// Truncated returns how many games AddPGN stopped following before the end
+// of their moves or the ply limit.
+func (bb *BookBuilder) Truncated() int {
+	return bb.truncated
+}
+
+// Entries returns the book's entries sorted by key, heaviest move first,
+// with weights scaled down to fit in 16 bits if needed.
+func (bb *BookBuilder) Entries() []BookEntry {
+	highest := 0
+	for _, moves := range bb.weights {
+		for _, weight := range moves {
+			if weight > highest {
+				highest = weight
+			}
+		}
+	}
+
+	var entries []BookEntry
+	for key, moves := range bb.weights {
+		for move, weight := range moves {
+			if highest > 0xFFFF {
+				weight = weight * 0xFFFF / highest
+			}
+			if weight == 0 {
+				weight = 1
+			}
+			entries = append(entries, BookEntry{Key: key, Move: move, Weight: uint16(weight)})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Key != entries[j].Key {
+			return entries[i].Key < entries[j].Key
+		}
+		if entries[i].Weight != entries[j].Weight {
+			return entries[i].Weight > entries[j].Weight
+		}
+		return entries[i].Move < entries[j].Move
+	})
+	return entries
+}
+
+// WriteTo writes the book in Polyglot format.
+func (bb *BookBuilder) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	var record [polyglotEntrySize]byte
+	for _, entry := range bb.Entries() {
+		binary.BigEndian.PutUint64(record[0:8], entry.Key)
+		binary.BigEndian.PutUint16(record[8:10], entry.Move)
+		binary.BigEndian.PutUint16(record[10:12], entry.Weight)
+		binary.BigEndian.PutUint32(record[12:16], entry.Learn)
+
+		n, err := w.Write(record[:])
+		written += int64(n)
+		if err != nil {
+			return written, fmt.Errorf("failed to write book: %w", err)
+		}
+	}
+	return written, nil
+}
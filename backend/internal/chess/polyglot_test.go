@@ -0,0 +1,132 @@
+package chess
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolyglotKey(t *testing.T) {
+	// Reference keys from the Polyglot book format specification
+	tests := []struct {
+		fen string
+		key uint64
+	}{
+		{startFEN, 0x463b96181691fc9c},
+		{"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", 0x823c9b50fd114196},
+		{"rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 2", 0x0756b94461c50fb0},
+		{"rnbqkbnr/ppp1pppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 2", 0x662fafb965db29d4},
+		{"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", 0x22a48b5a8e47ff78},
+		{"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPPKPPP/RNBQ1BNR b kq - 0 3", 0x652a607ca3f242c1},
+		{"rnbq1bnr/ppp1pkpp/8/3pPp2/8/8/PPPPKPPP/RNBQ1BNR w - - 0 4", 0x00fdd303c946bdd9},
+		{"rnbqkbnr/p1pppppp/8/8/PpP4P/8/1P1PPPP1/RNBQKBNR b KQkq c3 0 3", 0x3c8123ea7b067637},
+		{"rnbqkbnr/p1pppppp/8/8/P6P/R1p5/1P1PPPP1/1NBQKBNR b Kkq - 0 4", 0x5c3f9b829b279560},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.key, PolyglotKey(tt.fen), tt.fen)
+	}
+}
+
+func TestBookBuilder_RoundTrip(t *testing.T) {
+	builder := NewBookBuilder(4)
+	require.NoError(t, builder.AddGame(startFEN, []string{"e2e4", "e7e5", "g1f3"}, "1-0"))
+	require.NoError(t, builder.AddGame(startFEN, []string{"e2e4", "c7c5"}, "1/2-1/2"))
+	require.NoError(t, builder.AddGame(startFEN, []string{"d2d4", "d7d5"}, "0-1"))
+	require.NoError(t, builder.AddGame(startFEN, []string{"c2c4"}, "*"))
+
+	var buf bytes.Buffer
+	written, err := builder.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), written)
+
+	book, err := ReadBook(&buf)
+	require.NoError(t, err)
+
+	// 1.e4 won once and drew once; 1.d4 only lost and 1.c4 never finished
+	assert.Equal(t, []BookMove{{From: "e2", To: "e4", Weight: 3}}, book.Moves(startFEN))
+
+	afterE4 := "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"
+	assert.Equal(t, []BookMove{{From: "c7", To: "c5", Weight: 1}}, book.Moves(afterE4))
+
+	afterD4 := "rnbqkbnr/pppppppp/8/8/3P4/8/PPP1PPPP/RNBQKBNR b KQkq d3 0 1"
+	assert.Equal(t, []BookMove{{From: "d7", To: "d5", Weight: 2}}, book.Moves(afterD4))
+}
+
+func TestBookBuilder_AddPGNKeys(t *testing.T) {
+	// The specification's test line: after 3.Ke2 white has lost its castling
+	// rights, which the key has to reflect
+	games, err := ParsePGN(strings.NewReader("[Result \"1/2-1/2\"]\n\n1. e4 d5 2. e5 f5 3. Ke2 Kf7 1/2-1/2\n"))
+	require.NoError(t, err)
+	builder := NewBookBuilder(10)
+	require.NoError(t, builder.AddPGN(games[0]))
+
+	e8, _ := parseSquare("e8")
+	f7, _ := parseSquare("f7")
+	assert.Contains(t, builder.Entries(), BookEntry{Key: 0x652a607ca3f242c1, Move: encodePolyglotMove(e8, f7), Weight: 1})
+	assert.Zero(t, builder.Truncated())
+}
+
+func TestBookBuilder_AddPGNCountsTruncatedGames(t *testing.T) {
+	games, err := ParsePGN(strings.NewReader(
+		"[Result \"1-0\"]\n\n1. e4 e5 2. Nf3 Nc6 3. Bc4 Nf6 4. O-O Be7 1-0\n\n" +
+			"[Result \"1-0\"]\n\n1. d4 d5 1-0\n"))
+	require.NoError(t, err)
+	builder := NewBookBuilder(20)
+	for _, game := range games {
+		require.NoError(t, builder.AddPGN(game))
+	}
+
+	// The first game is kept up to castling: the winner's 1.e4, 2.Nf3 and
+	// 3.Bc4, then 1.d4 from the second
+	assert.Equal(t, 1, builder.Truncated())
+	assert.Len(t, builder.Entries(), 4)
+}
+
+func TestBook_PickMoveFollowsWeights(t *testing.T) {
+	builder := NewBookBuilder(1)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, builder.AddGame(startFEN, []string{"e2e4"}, "1-0"))
+	}
+	require.NoError(t, builder.AddGame(startFEN, []string{"d2d4"}, "1-0"))
+
+	var buf bytes.Buffer
+	_, err := builder.WriteTo(&buf)
+	require.NoError(t, err)
+	book, err := ReadBook(&buf)
+	require.NoError(t, err)
+
+	rng := rand.New(rand.NewSource(1))
+	picks := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		move, ok := book.PickMove(startFEN, rng)
+		require.True(t, ok)
+		picks[move.UCI()]++
+	}
+
+	assert.InDelta(t, 750, picks["e2e4"], 60)
+	assert.InDelta(t, 250, picks["d2d4"], 60)
+
+	_, ok := book.PickMove("rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", rng)
+	assert.False(t, ok)
+}
+
+func TestDecodePolyglotMove_Castling(t *testing.T) {
+	board := NewBoardFromFEN("r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1")
+	e1, _ := parseSquare("e1")
+	h1, _ := parseSquare("h1")
+	a1, _ := parseSquare("a1")
+
+	assert.Equal(t, "e1g1", decodePolyglotMove(encodePolyglotMove(e1, h1), board).UCI())
+	assert.Equal(t, "e1c1", decodePolyglotMove(encodePolyglotMove(e1, a1), board).UCI())
+}
+
+func TestReadBook_PartialEntry(t *testing.T) {
+	_, err := ReadBook(bytes.NewReader(make([]byte, 20)))
+
+	assert.EqualError(t, err, "book ends with a partial entry")
+}
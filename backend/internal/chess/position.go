@@ -0,0 +1,155 @@
+package chess
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// PositionKey identifies the position described by fen independently of how
+// it was reached: the move counters are dropped, and the en passant square is
+// kept only when a pawn can actually capture onto it. Two games that transpose
+// into the same position get the same key.
+func PositionKey(fen string) string {
+	board := NewBoardFromFEN(fen)
+	fields := strings.Fields(board.ToFEN())
+	if !board.canCaptureEnPassant() {
+		fields[3] = "-"
+	}
+	return strings.Join(fields[:4], " ")
+}
+
+// PositionHash is a 64-bit hash of PositionKey, compact enough to index.
+func PositionHash(fen string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(PositionKey(fen)))
+	return hash.Sum64()
+}
+
+// ValidateFEN checks that fen has all six fields and eight ranks of eight
+// squares each. It doesn't check that the position is reachable.
+func ValidateFEN(fen string) error {
+	fields := strings.Fields(fen)
+	if len(fields) != 6 {
+		return fmt.Errorf("FEN must have 6 fields, got %d", len(fields))
+	}
+
+	placement := fields[0]
+	if i := strings.IndexByte(placement, '['); i >= 0 {
+		placement = placement[:i]
+	}
+	ranks := strings.Split(placement, "/")
+	if len(ranks) != 8 {
+		return fmt.Errorf("FEN must have 8 ranks, got %d", len(ranks))
+	}
+	for i, rank := range ranks {
+		squares := 0
+		for _, char := range rank {
+			switch {
+			case char >= '1' && char <= '8':
+				squares += int(char - '0')
+			case strings.ContainsRune("pnbrqkPNBRQK", char):
+				squares++
+			case char == '~':
+			default:
+				return fmt.Errorf("invalid character %q in rank %d", char, 8-i)
+			}
+		}
+		if squares != 8 {
+			return fmt.Errorf("rank %d has %d squares", 8-i, squares)
+		}
+	}
+
+	if fields[1] != "w" && fields[1] != "b" {
+		return fmt.Errorf("invalid side to move %q", fields[1])
+	}
+	return nil
+}
+
+// PawnBitboards returns the squares holding white and black pawns in fen, one
+// bit per square from a1 (bit 0) to h8 (bit 63). Only the piece placement is
+// read, so fen may be a placement on its own.
+func PawnBitboards(fen string) (white, black uint64, err error) {
+	fields := strings.Fields(fen)
+	if len(fields) == 0 {
+		return 0, 0, fmt.Errorf("empty piece placement")
+	}
+	ranks := strings.Split(fields[0], "/")
+	if len(ranks) != 8 {
+		return 0, 0, fmt.Errorf("piece placement must have 8 ranks, got %d", len(ranks))
+	}
+
+	for i, rank := range ranks {
+		file := 0
+		for _, char := range rank {
+			if char >= '1' && char <= '8' {
+				file += int(char - '0')
+				continue
+			}
+			if !strings.ContainsRune("KQRBNPkqrbnp", char) {
+				return 0, 0, fmt.Errorf("invalid character %q in rank %d", char, 8-i)
+			}
+			if file < 8 {
+				bit := uint64(1) << ((7-i)*8 + file)
+				switch char {
+				case 'P':
+					white |= bit
+				case 'p':
+					black |= bit
+				}
+			}
+			file++
+		}
+		if file != 8 {
+			return 0, 0, fmt.Errorf("rank %d has %d squares", 8-i, file)
+		}
+	}
+	return white, black, nil
+}
+
+// canCaptureEnPassant reports whether a pawn of the side to move stands next
+// to the pawn that just made a double step.
+func (b *Board) canCaptureEnPassant() bool {
+	if b.enPassant == "-" {
+		return false
+	}
+	target, err := parseSquare(b.enPassant)
+	if err != nil {
+		return false
+	}
+
+	// The capturing pawn stands on the rank the double-stepped pawn landed on
+	pawn, rank := "P", target.rank+1
+	if b.currentTurn == "black" {
+		pawn, rank = "p", target.rank-1
+	}
+	return b.GetPiece(rank, target.file-1) == pawn || b.GetPiece(rank, target.file+1) == pawn
+}
+
+// RemovePieces returns fen with squares emptied, as in odds games where a
+// side starts without some of its pieces. Castling rights that needed a
+// removed rook go with it.
+func RemovePieces(fen string, squares ...string) (string, error) {
+	board := NewBoardFromFEN(fen)
+	for _, square := range squares {
+		pos, err := parseSquare(square)
+		if err != nil {
+			return "", err
+		}
+		board.SetPiece(pos.rank, pos.file, "")
+	}
+	for right, rook := range map[string]string{"K": "h1", "Q": "a1", "k": "h8", "q": "a8"} {
+		pos, _ := parseSquare(rook)
+		if board.GetPiece(pos.rank, pos.file) == "" {
+			board.castling[right] = false
+		}
+	}
+	return board.ToFEN(), nil
+}
+
+// WithSideToMove returns fen with color ("white" or "black") to move.
+func WithSideToMove(fen, color string) string {
+	board := NewBoardFromFEN(fen)
+	board.currentTurn = color
+	return board.ToFEN()
+}
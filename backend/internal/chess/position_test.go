@@ -0,0 +1,95 @@
+package chess
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPositionKey_IgnoresMoveOrder(t *testing.T) {
+	// 1.d4 Nf6 2.c4 e6 and 1.c4 e6 2.d4 Nf6 reach the same position
+	viaD4 := playMoves(t, "d2d4", "g8f6", "c2c4", "e7e6")
+	viaC4 := playMoves(t, "c2c4", "e7e6", "d2d4", "g8f6")
+
+	assert.NotEqual(t, viaD4, viaC4)
+	assert.Equal(t, PositionKey(viaD4), PositionKey(viaC4))
+	assert.Equal(t, PositionHash(viaD4), PositionHash(viaC4))
+}
+
+func TestPositionKey_EnPassantSquare(t *testing.T) {
+	// Nothing can take on e3, so the square doesn't distinguish the position
+	assert.Equal(t,
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq -",
+		PositionKey("rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"))
+
+	// The d4 pawn can, so it does
+	assert.Equal(t,
+		"rnbqkbnr/ppp1pppp/8/8/2Pp4/8/PP1PPPPP/RNBQKBNR b KQkq c3",
+		PositionKey("rnbqkbnr/ppp1pppp/8/8/2Pp4/8/PP1PPPPP/RNBQKBNR b KQkq c3 0 3"))
+}
+
+func TestValidateFEN(t *testing.T) {
+	assert.NoError(t, ValidateFEN(StandardStartFEN))
+	assert.NoError(t, ValidateFEN(CrazyhouseStartFEN))
+
+	tests := map[string]string{
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -":     "FEN must have 6 fields, got 4",
+		"rnbqkbnr/pppppppp/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1":   "FEN must have 8 ranks, got 7",
+		"rnbqkbnr/pppppppp/9/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1": "invalid character '9' in rank 6",
+		"rnbqkbnr/ppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1":  "rank 7 has 7 squares",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR x KQkq - 0 1": "invalid side to move \"x\"",
+	}
+	for fen, want := range tests {
+		assert.EqualError(t, ValidateFEN(fen), want, fen)
+	}
+}
+
+func TestPawnBitboards(t *testing.T) {
+	white, black, err := PawnBitboards(StandardStartFEN)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0xff00), white)
+	assert.Equal(t, uint64(0xff000000000000), black)
+
+	// A placement on its own is enough
+	white, black, err = PawnBitboards("8/8/8/3p4/3P4/8/8/8")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1)<<27, white) // d4
+	assert.Equal(t, uint64(1)<<35, black) // d5
+
+	_, _, err = PawnBitboards("8/8/8/3p4/3P4/8/8")
+	assert.EqualError(t, err, "piece placement must have 8 ranks, got 7")
+	_, _, err = PawnBitboards("8/8/8/3p5/3P4/8/8/8")
+	assert.EqualError(t, err, "rank 5 has 9 squares")
+}
+
+func playMoves(t *testing.T, moves ...string) string {
+	t.Helper()
+
+	fen := StandardStartFEN
+	for _, move := range moves {
+		played, err := NewEngine(fen).ValidateMove(move[:2], move[2:])
+		if err != nil {
+			t.Fatalf("%s: %v", move, err)
+		}
+		fen = played.FENAfter
+	}
+	return fen
+}
+
+func TestRemovePieces(t *testing.T) {
+	fen, err := RemovePieces(StandardStartFEN, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/1NBQKBNR w Kkq - 0 1", fen)
+
+	fen, err = RemovePieces(CrazyhouseStartFEN, "b8", "f7")
+	require.NoError(t, err)
+	assert.Equal(t, "r1bqkbnr/ppppp1pp/8/8/8/8/PPPPPPPP/RNBQKBNR[] w KQkq - 0 1", fen)
+
+	_, err = RemovePieces(StandardStartFEN, "i9")
+	assert.Error(t, err)
+}
+
+func TestWithSideToMove(t *testing.T) {
+	assert.Equal(t, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR b KQkq - 0 1", WithSideToMove(StandardStartFEN, "black"))
+}
@@ -0,0 +1,532 @@
+package chess
+
+import (
+	"sort"
+	"strings"
+)
+
+// The search works on its own compact copy of the board and follows the same
+// rules as the Engine: no castling, en passant or promotion, and no drops.
+// Scores are in centipawns.
+
+const (
+	mateScore      = 100000
+	quiescenceCap  = 8 // plies of captures searched past the nominal depth
+	scoreInfinity  = mateScore + 1
+	maxSearchDepth = 64
+)
+
+var pieceValues = [128]int{
+	'p': 100, 'n': 320, 'b': 330, 'r': 500, 'q': 900,
+	'P': 100, 'N': 320, 'B': 330, 'R': 500, 'Q': 900,
+}
+
+// centreBonus rewards minor pieces and pawns for standing near the centre,
+// indexed by distance from it (0-3).
+var centreBonus = [4]int{20, 10, 0, -10}
+
+var (
+	knightOffsets = [][2]int{{-2, -1}, {-2, 1}, {-1, -2}, {-1, 2}, {1, -2}, {1, 2}, {2, -1}, {2, 1}}
+	kingOffsets   = [][2]int{{-1, -1}, {-1, 0}, {-1, 1}, {0, -1}, {0, 1}, {1, -1}, {1, 0}, {1, 1}}
+	rookRays      = [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+	bishopRays    = [][2]int{{-1, -1}, {-1, 1}, {1, -1}, {1, 1}}
+)
+
+// SearchResult is the outcome of searching a position.
+type SearchResult struct {
+	// BestMove is from and to squares run together ("e2e4"), empty when the
+	// side to move has no legal moves.
+	BestMove string `json:"best_move"`
+	// Score is from white's point of view.
+	Score int `json:"score"`
+	// Mate counts moves to a forced mate, positive when white mates and
+	// negative when black does; zero when no mate was found.
+	Mate  int      `json:"mate,omitempty"`
+	PV    []string `json:"pv"`
+	Nodes int      `json:"nodes"`
+}
+
+// Search looks depth plies ahead from the engine's position and returns the
+// best move found with its principal variation.
+func (e *Engine) Search(depth int) SearchResult {
+	if depth < 1 {
+		depth = 1
+	}
+	if depth > maxSearchDepth {
+		depth = maxSearchDepth
+	}
+
+	s := &searcher{pos: newSearchPosition(e.board)}
+	var score int
+	for d := 1; d <= depth; d++ {
+		score = s.negamax(d, 0, -scoreInfinity, scoreInfinity)
+		s.previousPV = append(s.previousPV[:0], s.pv[0][:s.pvLength[0]]...)
+	}
+
+	result := SearchResult{Nodes: s.nodes}
+	for _, move := range s.previousPV {
+		result.PV = append(result.PV, move.uci())
+	}
+	if len(result.PV) > 0 {
+		result.BestMove = result.PV[0]
+	}
+
+	result.Score, result.Mate = whitePOV(score, s.pos.white)
+	return result
+}
+
+// RankedMove is a legal move with the score the position has after it.
+type RankedMove struct {
+	Move  string `json:"move"`
+	Score int    `json:"score"` // from white's point of view
+	Mate  int    `json:"mate,omitempty"`
+}
+
+// RankMoves searches every legal move depth plies deep and returns them best
+// first for the side to move. It costs a full search per move, so keep depth
+// low.
+func (e *Engine) RankMoves(depth int) []RankedMove {
+	if depth < 1 {
+		depth = 1
+	}
+	if depth > maxSearchDepth {
+		depth = maxSearchDepth
+	}
+
+	s := &searcher{pos: newSearchPosition(e.board)}
+	white := s.pos.white
+	moves := s.pos.legalMoves()
+	s.orderMoves(moves, 0)
+
+	type scored struct {
+		move  searchMove
+		score int
+	}
+	results := make([]scored, 0, len(moves))
+	for _, move := range moves {
+		s.pos.makeMove(move)
+		score := -s.negamax(depth-1, 1, -scoreInfinity, scoreInfinity)
+		s.pos.unmakeMove(move)
+		results = append(results, scored{move, score})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].score > results[j].score })
+
+	ranked := make([]RankedMove, 0, len(results))
+	for _, result := range results {
+		score, mate := whitePOV(result.score, white)
+		ranked = append(ranked, RankedMove{Move: result.move.uci(), Score: score, Mate: mate})
+	}
+	return ranked
+}
+
+// SearchLines searches every legal move depth plies deep and returns the
+// best lines for the side to move, at most lines of them and best first,
+// each with its principal variation. It costs as much as RankMoves.
+func (e *Engine) SearchLines(depth, lines int) []SearchResult {
+	if depth < 1 {
+		depth = 1
+	}
+	if depth > maxSearchDepth {
+		depth = maxSearchDepth
+	}
+
+	s := &searcher{pos: newSearchPosition(e.board)}
+	white := s.pos.white
+	moves := s.pos.legalMoves()
+	s.orderMoves(moves, 0)
+
+	type line struct {
+		score int
+		pv    []searchMove
+		nodes int
+	}
+	results := make([]line, 0, len(moves))
+	for _, move := range moves {
+		nodes := s.nodes
+		s.pos.makeMove(move)
+		score := -s.negamax(depth-1, 1, -scoreInfinity, scoreInfinity)
+		s.pos.unmakeMove(move)
+		pv := append([]searchMove{move}, s.pv[1][:s.pvLength[1]]...)
+		results = append(results, line{score, pv, s.nodes - nodes})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].score > results[j].score })
+	if len(results) > lines {
+		results = results[:lines]
+	}
+
+	found := make([]SearchResult, len(results))
+	for i, result := range results {
+		found[i] = SearchResult{BestMove: result.pv[0].uci(), Nodes: result.nodes}
+		for _, move := range result.pv {
+			found[i].PV = append(found[i].PV, move.uci())
+		}
+		found[i].Score, found[i].Mate = whitePOV(result.score, white)
+	}
+	return found
+}
+
+// whitePOV turns a score for the side to move into one from white's point of
+// view, with the number of moves to mate if it is a forced mate.
+func whitePOV(score int, whiteToMove bool) (int, int) {
+	if !whiteToMove {
+		score = -score
+	}
+	mate := 0
+	if abs(score) > mateScore-maxSearchDepth*2 {
+		plies := mateScore - abs(score)
+		mate = (plies + 1) / 2 * sign(score)
+	}
+	return score, mate
+}
+
+type searchMove struct {
+	from, to int
+	piece    byte
+	captured byte
+}
+
+func (m searchMove) uci() string {
+	return squareName(Position{rank: m.from / 8, file: m.from % 8}) +
+		squareName(Position{rank: m.to / 8, file: m.to % 8})
+}
+
+// searchPosition indexes squares as rank*8+file with rank 0 being the eighth
+// rank, like Board.
+type searchPosition struct {
+	squares [64]byte
+	white   bool
+}
+
+func newSearchPosition(board *Board) *searchPosition {
+	pos := &searchPosition{white: board.currentTurn == "white"}
+	for rank := 0; rank < 8; rank++ {
+		for file := 0; file < 8; file++ {
+			if piece := board.squares[rank][file]; piece != "" {
+				pos.squares[rank*8+file] = piece[0]
+			}
+		}
+	}
+	return pos
+}
+
+func isWhitePiece(piece byte) bool {
+	return piece >= 'A' && piece <= 'Z'
+}
+
+func (p *searchPosition) own(piece byte) bool {
+	return piece != 0 && isWhitePiece(piece) == p.white
+}
+
+func (p *searchPosition) enemy(piece byte) bool {
+	return piece != 0 && isWhitePiece(piece) != p.white
+}
+
+func onBoard(rank, file int) bool {
+	return rank >= 0 && rank < 8 && file >= 0 && file < 8
+}
+
+// pseudoMoves appends every move of the side to move, including ones that
+// leave its king in check.
+func (p *searchPosition) pseudoMoves(moves []searchMove) []searchMove {
+	add := func(from, to int) {
+		moves = append(moves, searchMove{from: from, to: to, piece: p.squares[from], captured: p.squares[to]})
+	}
+
+	for from, piece := range p.squares {
+		if !p.own(piece) {
+			continue
+		}
+		rank, file := from/8, from%8
+
+		switch piece | 0x20 { // lower case
+		case 'p':
+			direction, startRank := 1, 1
+			if isWhitePiece(piece) {
+				direction, startRank = -1, 6
+			}
+			if onBoard(rank+direction, file) && p.squares[from+8*direction] == 0 {
+				add(from, from+8*direction)
+				if rank == startRank && p.squares[from+16*direction] == 0 {
+					add(from, from+16*direction)
+				}
+			}
+			for _, df := range []int{-1, 1} {
+				if onBoard(rank+direction, file+df) && p.enemy(p.squares[from+8*direction+df]) {
+					add(from, from+8*direction+df)
+				}
+			}
+		case 'n', 'k':
+			offsets := knightOffsets
+			if piece|0x20 == 'k' {
+				offsets = kingOffsets
+			}
+			for _, offset := range offsets {
+				r, f := rank+offset[0], file+offset[1]
+				if onBoard(r, f) && !p.own(p.squares[r*8+f]) {
+					add(from, r*8+f)
+				}
+			}
+		default:
+			var rays [][2]int
+			switch piece | 0x20 {
+			case 'r':
+				rays = rookRays
+			case 'b':
+				rays = bishopRays
+			case 'q':
+				rays = append(append([][2]int{}, rookRays...), bishopRays...)
+			}
+			for _, ray := range rays {
+				for r, f := rank+ray[0], file+ray[1]; onBoard(r, f); r, f = r+ray[0], f+ray[1] {
+					target := p.squares[r*8+f]
+					if p.own(target) {
+						break
+					}
+					add(from, r*8+f)
+					if target != 0 {
+						break
+					}
+				}
+			}
+		}
+	}
+
+	return moves
+}
+
+// attacked reports whether square is attacked by white pieces when byWhite
+// is set, black ones otherwise.
+func (p *searchPosition) attacked(square int, byWhite bool) bool {
+	rank, file := square/8, square%8
+	attacker := func(r, f int, kinds string) bool {
+		if !onBoard(r, f) {
+			return false
+		}
+		piece := p.squares[r*8+f]
+		return piece != 0 && isWhitePiece(piece) == byWhite && strings.IndexByte(kinds, piece|0x20) >= 0
+	}
+
+	// A white pawn attacks from the rank below (higher index)
+	pawnRank := rank - 1
+	if byWhite {
+		pawnRank = rank + 1
+	}
+	if attacker(pawnRank, file-1, "p") || attacker(pawnRank, file+1, "p") {
+		return true
+	}
+	for _, offset := range knightOffsets {
+		if attacker(rank+offset[0], file+offset[1], "n") {
+			return true
+		}
+	}
+	for _, offset := range kingOffsets {
+		if attacker(rank+offset[0], file+offset[1], "k") {
+			return true
+		}
+	}
+	for _, rays := range []struct {
+		dirs  [][2]int
+		kinds string
+	}{{rookRays, "rq"}, {bishopRays, "bq"}} {
+		for _, ray := range rays.dirs {
+			for r, f := rank+ray[0], file+ray[1]; onBoard(r, f); r, f = r+ray[0], f+ray[1] {
+				if p.squares[r*8+f] == 0 {
+					continue
+				}
+				if attacker(r, f, rays.kinds) {
+					return true
+				}
+				break
+			}
+		}
+	}
+	return false
+}
+
+func (p *searchPosition) inCheck(white bool) bool {
+	king := byte('k')
+	if white {
+		king = 'K'
+	}
+	for square, piece := range p.squares {
+		if piece == king {
+			return p.attacked(square, !white)
+		}
+	}
+	return false
+}
+
+func (p *searchPosition) makeMove(move searchMove) {
+	p.squares[move.to] = move.piece
+	p.squares[move.from] = 0
+	p.white = !p.white
+}
+
+func (p *searchPosition) unmakeMove(move searchMove) {
+	p.white = !p.white
+	p.squares[move.from] = move.piece
+	p.squares[move.to] = move.captured
+}
+
+// legalMoves returns the moves that don't leave the mover in check.
+func (p *searchPosition) legalMoves() []searchMove {
+	pseudo := p.pseudoMoves(make([]searchMove, 0, 48))
+	legal := pseudo[:0]
+	for _, move := range pseudo {
+		p.makeMove(move)
+		if !p.inCheck(!p.white) {
+			legal = append(legal, move)
+		}
+		p.unmakeMove(move)
+	}
+	return legal
+}
+
+// evaluate scores the position for the side to move.
+func (p *searchPosition) evaluate() int {
+	score := 0
+	for square, piece := range p.squares {
+		if piece == 0 {
+			continue
+		}
+		value := pieceValues[piece]
+		rank, file := square/8, square%8
+		switch piece | 0x20 {
+		case 'n', 'b':
+			value += centreBonus[centreDistance(rank, file)]
+		case 'p':
+			// Pawns gain as they advance
+			advanced := 6 - rank
+			if !isWhitePiece(piece) {
+				advanced = rank - 1
+			}
+			value += advanced * 5
+			if file >= 2 && file <= 5 {
+				value += centreBonus[centreDistance(rank, file)] / 2
+			}
+		}
+		if isWhitePiece(piece) {
+			score += value
+		} else {
+			score -= value
+		}
+	}
+	if !p.white {
+		score = -score
+	}
+	return score
+}
+
+func centreDistance(rank, file int) int {
+	distance := func(x int) int {
+		if x < 4 {
+			return 3 - x
+		}
+		return x - 4
+	}
+	d := distance(rank)
+	if df := distance(file); df > d {
+		d = df
+	}
+	return d
+}
+
+type searcher struct {
+	pos        *searchPosition
+	nodes      int
+	pv         [maxSearchDepth + quiescenceCap + 1][maxSearchDepth + quiescenceCap + 1]searchMove
+	pvLength   [maxSearchDepth + quiescenceCap + 1]int
+	previousPV []searchMove
+}
+
+// orderMoves puts the previous iteration's move first, then captures of the
+// most valuable pieces by the least valuable ones.
+func (s *searcher) orderMoves(moves []searchMove, ply int) {
+	var pvMove searchMove
+	hasPV := ply < len(s.previousPV)
+	if hasPV {
+		pvMove = s.previousPV[ply]
+	}
+	priority := func(m searchMove) int {
+		if hasPV && m == pvMove {
+			return 1 << 20
+		}
+		if m.captured != 0 {
+			return pieceValues[m.captured]*10 - pieceValues[m.piece]
+		}
+		return 0
+	}
+	sort.SliceStable(moves, func(i, j int) bool { return priority(moves[i]) > priority(moves[j]) })
+}
+
+func (s *searcher) negamax(depth, ply int, alpha, beta int) int {
+	s.nodes++
+	s.pvLength[ply] = 0
+
+	moves := s.pos.legalMoves()
+	if len(moves) == 0 {
+		if s.pos.inCheck(s.pos.white) {
+			return -mateScore + ply
+		}
+		return 0
+	}
+	if depth == 0 {
+		return s.quiescence(ply, alpha, beta)
+	}
+
+	s.orderMoves(moves, ply)
+	for _, move := range moves {
+		s.pos.makeMove(move)
+		score := -s.negamax(depth-1, ply+1, -beta, -alpha)
+		s.pos.unmakeMove(move)
+
+		if score > alpha {
+			alpha = score
+			s.pv[ply][0] = move
+			copy(s.pv[ply][1:], s.pv[ply+1][:s.pvLength[ply+1]])
+			s.pvLength[ply] = s.pvLength[ply+1] + 1
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return alpha
+}
+
+// quiescence only follows captures, so the search never stops in the middle
+// of an exchange.
+func (s *searcher) quiescence(ply, alpha, beta int) int {
+	s.nodes++
+	s.pvLength[ply] = 0
+
+	standPat := s.pos.evaluate()
+	if standPat >= beta || ply >= len(s.pvLength)-1 {
+		return standPat
+	}
+	if standPat > alpha {
+		alpha = standPat
+	}
+
+	moves := s.pos.legalMoves()
+	captures := moves[:0]
+	for _, move := range moves {
+		if move.captured != 0 {
+			captures = append(captures, move)
+		}
+	}
+	s.orderMoves(captures, len(s.previousPV))
+
+	for _, move := range captures {
+		s.pos.makeMove(move)
+		score := -s.quiescence(ply+1, -beta, -alpha)
+		s.pos.unmakeMove(move)
+
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return alpha
+}
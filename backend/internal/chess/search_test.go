@@ -0,0 +1,93 @@
+package chess
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch_FindsMateInOne(t *testing.T) {
+	// Back-rank mate: Re8#
+	engine := NewEngine("6k1/5ppp/8/8/8/8/5PPP/4R1K1 w - - 0 1")
+
+	result := engine.Search(3)
+
+	assert.Equal(t, "e1e8", result.BestMove)
+	assert.Equal(t, 1, result.Mate)
+	assert.Greater(t, result.Score, 0)
+}
+
+func TestSearch_BlackMateIsNegative(t *testing.T) {
+	// Fool's mate: Qh4#
+	engine := NewEngine("rnbqkbnr/pppp1ppp/8/4p3/6P1/5P2/PPPPP2P/RNBQKBNR b KQkq - 0 2")
+
+	result := engine.Search(2)
+
+	assert.Equal(t, "d8h4", result.BestMove)
+	assert.Equal(t, -1, result.Mate)
+	assert.Less(t, result.Score, 0)
+}
+
+func TestSearch_WinsHangingQueen(t *testing.T) {
+	engine := NewEngine("4k3/8/8/3q4/8/8/3R4/4K3 w - - 0 1")
+
+	result := engine.Search(2)
+
+	assert.Equal(t, "d2d5", result.BestMove)
+	assert.Greater(t, result.Score, 300)
+	assert.Equal(t, "d2d5", result.PV[0])
+}
+
+func TestSearch_NoMoves(t *testing.T) {
+	stalemate := NewEngine("7k/5Q2/6K1/8/8/8/8/8 b - - 0 1").Search(3)
+	assert.Empty(t, stalemate.BestMove)
+	assert.Equal(t, 0, stalemate.Score)
+	assert.Equal(t, 0, stalemate.Mate)
+
+	mated := NewEngine("7k/6Q1/6K1/8/8/8/8/8 b - - 0 1").Search(3)
+	assert.Empty(t, mated.BestMove)
+	assert.Equal(t, 0, mated.Mate)
+	assert.Greater(t, mated.Score, 0)
+}
+
+func TestSearchLines(t *testing.T) {
+	engine := NewEngine("6k1/5ppp/8/8/8/8/5PPP/4R1K1 w - - 0 1")
+
+	lines := engine.SearchLines(3, 3)
+
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "e1e8", lines[0].BestMove)
+	assert.Equal(t, []string{"e1e8"}, lines[0].PV)
+	assert.Equal(t, 1, lines[0].Mate)
+	for i, line := range lines {
+		assert.Equal(t, line.BestMove, line.PV[0])
+		if i > 0 {
+			assert.LessOrEqual(t, line.Score, lines[i-1].Score)
+			assert.Zero(t, line.Mate)
+		}
+	}
+	assert.Equal(t, engine.Search(3).Score, lines[0].Score)
+
+	assert.Empty(t, NewEngine("7k/6Q1/6K1/8/8/8/8/8 b - - 0 1").SearchLines(3, 3))
+}
+
+func TestSearch_MoveGenerationMatchesEngine(t *testing.T) {
+	for _, fen := range []string{
+		startFEN,
+		"rnbqkbnr/pppp1ppp/8/4p3/6P1/5P2/PPPPP2P/RNBQKBNR b KQkq - 0 2",
+		"r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4",
+		"4k3/8/8/3q4/8/8/3R4/4K3 w - - 0 1",
+		"8/8/3k4/3r4/8/3PK3/3R4/8 b - - 0 1",
+	} {
+		engine := NewEngine(fen)
+		var got []string
+		for _, move := range newSearchPosition(engine.board).legalMoves() {
+			got = append(got, move.uci())
+		}
+		want := engine.LegalMoves()
+		sort.Strings(got)
+		sort.Strings(want)
+		assert.Equal(t, want, got, fen)
+	}
+}
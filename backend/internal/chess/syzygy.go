@@ -0,0 +1,404 @@
+package chess
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Syzygy tablebases come as one WDL file (win/draw/loss, .rtbw) and one DTZ
+// file (distance to zeroing move, .rtbz) per material signature, named after
+// the pieces with the stronger side first: KQvK.rtbw, KRPvKR.rtbz.
+//
+// Tablebase finds and checks those files when opened, and reads a table's
+// header the first time a position needs it. Probes read the few bytes they
+// need from the file, so a large set costs little memory.
+
+var (
+	ErrNotInTablebase    = errors.New("position is not covered by the tablebase")
+	ErrTablebaseDecoding = errors.New("corrupt Syzygy table")
+)
+
+var (
+	syzygyWDLMagic = []byte{0x71, 0xe8, 0x23, 0x5d}
+	syzygyDTZMagic = []byte{0xd7, 0x66, 0x0c, 0xa5}
+)
+
+// WDL is a tablebase result from the point of view of the side to move.
+// Cursed wins and blessed losses are wins and losses that the fifty-move
+// rule turns into draws.
+type WDL int
+
+const (
+	WDLLoss        WDL = -2
+	WDLBlessedLoss WDL = -1
+	WDLDraw        WDL = 0
+	WDLCursedWin   WDL = 1
+	WDLWin         WDL = 2
+)
+
+func (w WDL) String() string {
+	switch w {
+	case WDLLoss:
+		return "loss"
+	case WDLBlessedLoss:
+		return "blessed loss"
+	case WDLDraw:
+		return "draw"
+	case WDLCursedWin:
+		return "cursed win"
+	case WDLWin:
+		return "win"
+	default:
+		return fmt.Sprintf("WDL(%d)", int(w))
+	}
+}
+
+type Tablebase struct {
+	dir       string
+	wdl       map[string]string // material signature to file path
+	dtz       map[string]string
+	maxPieces int
+
+	mu     sync.Mutex
+	tables map[string]*syzygyTable // by file path, once read
+	files  []*os.File
+}
+
+// OpenTablebase loads the tables in dir. Files whose header isn't a Syzygy
+// table are rejected.
+func OpenTablebase(dir string) (*Tablebase, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tablebase directory: %w", err)
+	}
+
+	tb := &Tablebase{
+		dir:    dir,
+		wdl:    make(map[string]string),
+		dtz:    make(map[string]string),
+		tables: make(map[string]*syzygyTable),
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		var magic []byte
+		var tables map[string]string
+		switch filepath.Ext(name) {
+		case ".rtbw":
+			magic, tables = syzygyWDLMagic, tb.wdl
+		case ".rtbz":
+			magic, tables = syzygyDTZMagic, tb.dtz
+		default:
+			continue
+		}
+
+		signature := strings.TrimSuffix(name, filepath.Ext(name))
+		pieces, ok := signaturePieces(signature)
+		if !ok {
+			return nil, fmt.Errorf("%s: not a material signature", name)
+		}
+
+		path := filepath.Join(dir, name)
+		if err := checkTableHeader(path, magic); err != nil {
+			return nil, err
+		}
+
+		tables[signature] = path
+		if pieces > tb.maxPieces {
+			tb.maxPieces = pieces
+		}
+	}
+
+	return tb, nil
+}
+
+// Tables returns the number of WDL and DTZ tables found.
+func (tb *Tablebase) Tables() (wdl, dtz int) {
+	return len(tb.wdl), len(tb.dtz)
+}
+
+// MaxPieces is the largest number of pieces, kings included, that any table
+// covers.
+func (tb *Tablebase) MaxPieces() int {
+	return tb.maxPieces
+}
+
+// Close closes the table files opened by probes.
+func (tb *Tablebase) Close() error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	var firstErr error
+	for _, file := range tb.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	tb.files = nil
+	tb.tables = make(map[string]*syzygyTable)
+	return firstErr
+}
+
+// Covers reports whether a WDL table exists for the position in fen. Syzygy
+// tables assume no castling rights remain.
+func (tb *Tablebase) Covers(fen string) bool {
+	board := NewBoardFromFEN(fen)
+	for _, allowed := range board.castling {
+		if allowed {
+			return false
+		}
+	}
+	_, ok := tb.wdl[MaterialSignature(fen)]
+	return ok
+}
+
+// ProbeWDL returns the win/draw/loss result of the position in fen, for the
+// side to move.
+func (tb *Tablebase) ProbeWDL(fen string) (WDL, error) {
+	if !tb.Covers(fen) {
+		return WDLDraw, ErrNotInTablebase
+	}
+	wdl, _, err := tb.searchWDL(newTBPosition(NewBoardFromFEN(fen)), false)
+	return wdl, err
+}
+
+// ProbeDTZ returns the distance in plies to the next capture or pawn move
+// that keeps the result, or to mate, negative when the side to move is
+// losing and zero for a draw. Results the fifty-move rule turns into draws
+// are counted 100 plies further.
+func (tb *Tablebase) ProbeDTZ(fen string) (int, error) {
+	if !tb.Covers(fen) {
+		return 0, ErrNotInTablebase
+	}
+	if _, ok := tb.dtz[MaterialSignature(fen)]; !ok {
+		return 0, ErrNotInTablebase
+	}
+	return tb.probeDTZ(newTBPosition(NewBoardFromFEN(fen)))
+}
+
+// TablebaseMove is a move with the result it keeps, for the side making it.
+type TablebaseMove struct {
+	Move string `json:"move"` // from and to squares run together, then any promotion ("e7e8q")
+	WDL  WDL    `json:"wdl"`
+	// DTZ is the position's after the move, counted from before it: one for
+	// a move that mates or wins by capturing or moving a pawn.
+	DTZ  int  `json:"dtz"`
+	Mate bool `json:"mate,omitempty"`
+}
+
+// BestMove returns the move in fen that keeps the best result: of winning
+// moves the one that mates or zeroes soonest, of losing ones the one that
+// holds out longest. It reports ErrNotInTablebase when the position isn't
+// covered and when the side to move has no moves.
+func (tb *Tablebase) BestMove(fen string) (TablebaseMove, error) {
+	if !tb.Covers(fen) {
+		return TablebaseMove{}, ErrNotInTablebase
+	}
+
+	pos := newTBPosition(NewBoardFromFEN(fen))
+	var best TablebaseMove
+	found := false
+	for _, move := range pos.moves() {
+		candidate, err := tb.rateMove(pos, move)
+		if err != nil {
+			return TablebaseMove{}, err
+		}
+		if !found || candidate.better(best) {
+			best, found = candidate, true
+		}
+	}
+	if !found {
+		return TablebaseMove{}, ErrNotInTablebase
+	}
+	return best, nil
+}
+
+// rateMove probes the position after move, for the side making it.
+func (tb *Tablebase) rateMove(pos *tbPosition, move tbMove) (TablebaseMove, error) {
+	undo := pos.play(move)
+	defer pos.takeBack(move, undo)
+
+	rated := TablebaseMove{Move: move.uci()}
+	if len(pos.moves()) == 0 && pos.inCheck(pos.white) {
+		rated.WDL, rated.DTZ, rated.Mate = WDLWin, 1, true
+		return rated, nil
+	}
+
+	wdl, _, err := tb.searchWDL(pos, false)
+	if err != nil {
+		return rated, err
+	}
+	rated.WDL = -wdl
+	if move.zeroing() {
+		rated.DTZ = dtzBeforeZeroing(rated.WDL)
+		return rated, nil
+	}
+	if wdl == WDLDraw {
+		return rated, nil
+	}
+	dtz, err := tb.probeDTZ(pos)
+	if err != nil {
+		return rated, err
+	}
+	rated.DTZ = -dtz + signOf(-dtz)
+	return rated, nil
+}
+
+// better reports whether m keeps a better result than other.
+func (m TablebaseMove) better(other TablebaseMove) bool {
+	if m.WDL != other.WDL {
+		return m.WDL > other.WDL
+	}
+	if m.Mate != other.Mate {
+		return m.Mate
+	}
+	// Closer to zeroing while winning, further while losing
+	return m.DTZ < other.DTZ
+}
+
+// table returns the table of signature, reading its header the first time.
+func (tb *Tablebase) table(signature string, dtz bool) (*syzygyTable, error) {
+	paths := tb.wdl
+	if dtz {
+		paths = tb.dtz
+	}
+	path, ok := paths[signature]
+	if !ok {
+		return nil, ErrNotInTablebase
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if table, ok := tb.tables[path]; ok {
+		return table, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open table: %w", err)
+	}
+	table := newSyzygyTable(signature, dtz)
+	if err := table.read(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	tb.tables[path] = table
+	tb.files = append(tb.files, file)
+	return table, nil
+}
+
+// MaterialSignature names the material in fen the way Syzygy names its
+// files: "KQvK", "KRPvKR", "KBvKN". See syzygyFirst for which side comes
+// first.
+func MaterialSignature(fen string) string {
+	board := NewBoardFromFEN(fen)
+	var white, black strings.Builder
+	for _, piece := range syzygyPieces {
+		for rank := 0; rank < 8; rank++ {
+			for file := 0; file < 8; file++ {
+				switch board.squares[rank][file] {
+				case string(piece):
+					white.WriteRune(piece)
+				case strings.ToLower(string(piece)):
+					black.WriteRune(piece)
+				}
+			}
+		}
+	}
+
+	if !syzygyFirst(white.String(), black.String()) {
+		return black.String() + "v" + white.String()
+	}
+	return white.String() + "v" + black.String()
+}
+
+// syzygyPieces is the order of pieces within a side of a Syzygy table name,
+// strongest first.
+const syzygyPieces = "KQRBNP"
+
+// syzygyFirst reports whether side a is named before side b in a Syzygy
+// table name, both sides listing their pieces in syzygyPieces order. The
+// side with more pieces comes first; between sides with as many pieces, the
+// one with the stronger piece at the first place they differ, so white's
+// knight against black's bishop is still "KBvKN". Equal sides keep their
+// order.
+func syzygyFirst(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			return strings.IndexByte(syzygyPieces, a[i]) < strings.IndexByte(syzygyPieces, b[i])
+		}
+	}
+	return true
+}
+
+// ParseMaterialSignature reads a material signature written the way players
+// write it, "R+P vs R" or "KRPvKR", and returns it as MaterialSignature would
+// name it, whichever side is written first. Kings may be left out.
+func ParseMaterialSignature(text string) (string, error) {
+	normalized := strings.ToUpper(strings.NewReplacer(" ", "", "+", "").Replace(text))
+	sides := strings.Split(strings.Replace(normalized, "VS", "V", 1), "V")
+	if len(sides) != 2 {
+		return "", fmt.Errorf("material %q must name both sides, e.g. \"R+P vs R\"", text)
+	}
+
+	for i, side := range sides {
+		if strings.Trim(side, syzygyPieces) != "" {
+			return "", fmt.Errorf("material %q has an unknown piece", side)
+		}
+		if strings.Count(side, "K") > 1 {
+			return "", fmt.Errorf("material %q has more than one king", side)
+		}
+		var sorted strings.Builder
+		sorted.WriteString("K")
+		for _, piece := range syzygyPieces[1:] {
+			sorted.WriteString(strings.Repeat(string(piece), strings.Count(side, string(piece))))
+		}
+		sides[i] = sorted.String()
+	}
+
+	if !syzygyFirst(sides[0], sides[1]) {
+		sides[0], sides[1] = sides[1], sides[0]
+	}
+	return sides[0] + "v" + sides[1], nil
+}
+
+// signaturePieces checks a material signature and counts its pieces.
+func signaturePieces(signature string) (int, bool) {
+	sides := strings.Split(signature, "v")
+	if len(sides) != 2 {
+		return 0, false
+	}
+	for _, side := range sides {
+		if !strings.HasPrefix(side, "K") || strings.Count(side, "K") != 1 ||
+			strings.Trim(side, syzygyPieces) != "" {
+			return 0, false
+		}
+	}
+	return len(sides[0]) + len(sides[1]), true
+}
+
+func checkTableHeader(path string, magic []byte) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open table: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(file, header); err != nil || !bytes.Equal(header, magic) {
+		return fmt.Errorf("%s: not a Syzygy table", filepath.Base(path))
+	}
+	return nil
+}
@@ -0,0 +1,606 @@
+package chess
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The tables in testdata/syzygy are written by TestGenerateSyzygyTables,
+// which solves each endgame by retrograde analysis and stores the result in
+// the Syzygy format: recursive pairing, canonical Huffman codes, a sparse
+// index, and one DTZ side to move. Regenerate them with
+//
+//	go test ./internal/chess -run TestGenerateSyzygyTables -syzygy.generate
+
+var generateSyzygy = flag.Bool("syzygy.generate", false, "regenerate the Syzygy tables in testdata/syzygy")
+
+const syzygyTestdata = "testdata/syzygy"
+
+// longestMates are the well-known longest wins, in plies with white to move:
+// mate in 10 with the queen and in 16 with the rook.
+var longestMates = map[string]int{"KQvK": 19, "KRvK": 31}
+
+func TestGenerateSyzygyTables(t *testing.T) {
+	if !*generateSyzygy {
+		t.Skip("run with -syzygy.generate to regenerate the tables")
+	}
+	require.NoError(t, os.MkdirAll(syzygyTestdata, 0o755))
+
+	// Promotions need the pawnless tables first
+	for _, signature := range []string{"KBvK", "KNvK", "KQvK", "KRvK", "KPvK"} {
+		tb, err := OpenTablebase(syzygyTestdata)
+		require.NoError(t, err)
+		solved := solveEndgame(t, tb, signature)
+		require.NoError(t, tb.Close())
+
+		for _, dtz := range []bool{false, true} {
+			data := writeSyzygyTable(t, signature, dtz, solved)
+			ext := ".rtbw"
+			if dtz {
+				ext = ".rtbz"
+			}
+			require.NoError(t, os.WriteFile(filepath.Join(syzygyTestdata, signature+ext), data, 0o644))
+		}
+		checkSolvedTables(t, signature, solved)
+		if plies, ok := longestMates[signature]; ok {
+			longest := 0
+			for state := 0; state < len(solved.legal)/2; state++ {
+				longest = max(longest, solved.dtz[state])
+			}
+			require.Equal(t, plies, longest, "%s longest win", signature)
+		}
+	}
+}
+
+// checkSolvedTables probes every position of an endgame from the tables
+// just written.
+func checkSolvedTables(t *testing.T, signature string, e *solvedEndgame) {
+	tb, err := OpenTablebase(syzygyTestdata)
+	require.NoError(t, err)
+	defer tb.Close()
+
+	for state, legal := range e.legal {
+		if !legal {
+			continue
+		}
+		pos, _ := e.position(state)
+		wdl, _, err := tb.searchWDL(pos, false)
+		require.NoError(t, err)
+		require.Equal(t, e.wdl[state], wdl, "%s WDL of state %d", signature, state)
+		dtz, err := tb.probeDTZ(pos)
+		require.NoError(t, err)
+		require.Equal(t, e.dtz[state], dtz, "%s DTZ of state %d", signature, state)
+	}
+}
+
+// solvedEndgame is every position of an endgame with white having the
+// first side, indexed by side to move and then each piece's square.
+type solvedEndgame struct {
+	pieces []byte // white's pieces then black's, as in FEN
+	legal  []bool
+	wdl    []WDL
+	dtz    []int
+}
+
+type endgameMove struct {
+	child   int // -1 when the move changes the material
+	wdl     WDL // for the mover, when child is -1
+	zeroing bool
+	mate    bool
+}
+
+func (e *solvedEndgame) position(state int) (*tbPosition, bool) {
+	pos := &tbPosition{enPassant: -1}
+	pos.white = state < len(e.legal)/2
+	for i := len(e.pieces) - 1; i >= 0; i-- {
+		square := state % 64
+		state /= 64
+		if pos.squares[square] != 0 {
+			return nil, false
+		}
+		if e.pieces[i]|0x20 == 'p' && (square < 8 || square >= 56) {
+			return nil, false
+		}
+		pos.squares[square] = e.pieces[i]
+	}
+	if pos.inCheck(!pos.white) {
+		return nil, false
+	}
+	return pos, true
+}
+
+func (e *solvedEndgame) state(pos *tbPosition) int {
+	state := 0
+	if !pos.white {
+		state = 1
+	}
+	seen := make(map[int]bool)
+	for _, piece := range e.pieces {
+		for square, p := range pos.squares {
+			if p == piece && !seen[square] {
+				seen[square] = true
+				state = state*64 + square
+				break
+			}
+		}
+	}
+	return state
+}
+
+func solveEndgame(t *testing.T, tb *Tablebase, signature string) *solvedEndgame {
+	sides := strings.SplitN(signature, "v", 2)
+	e := &solvedEndgame{pieces: []byte(sides[0] + strings.ToLower(sides[1]))}
+	states := 2
+	for range e.pieces {
+		states *= 64
+	}
+	e.legal = make([]bool, states)
+	e.wdl = make([]WDL, states)
+	e.dtz = make([]int, states)
+	known := make([]bool, states)
+	moves := make([][]endgameMove, states)
+
+	for state := 0; state < states; state++ {
+		pos, ok := e.position(state)
+		if !ok {
+			continue
+		}
+		e.legal[state] = true
+		for _, move := range pos.moves() {
+			undo := pos.play(move)
+			m := endgameMove{child: -1, zeroing: move.zeroing()}
+			m.mate = pos.inCheck(pos.white) && len(pos.moves()) == 0
+			if move.capture() || move.promotion != 0 {
+				wdl, _, err := tb.searchWDL(pos, false)
+				require.NoError(t, err, "%s after %s", signature, move.uci())
+				m.wdl = -wdl
+			} else {
+				m.child = e.state(pos)
+			}
+			pos.takeBack(move, undo)
+			moves[state] = append(moves[state], m)
+		}
+		if len(moves[state]) == 0 {
+			known[state] = true
+			if pos.inCheck(pos.white) {
+				e.wdl[state], e.dtz[state] = WDLLoss, -1
+			}
+		}
+	}
+
+	value := func(m endgameMove) (WDL, bool) {
+		if m.child < 0 {
+			return m.wdl, true
+		}
+		return -e.wdl[m.child], known[m.child]
+	}
+
+	// Win, draw or loss, ignoring the fifty-move rule
+	for changed := true; changed; {
+		changed = false
+		for state := range moves {
+			if !e.legal[state] || known[state] {
+				continue
+			}
+			best, unknown := WDLLoss, false
+			for _, m := range moves[state] {
+				v, ok := value(m)
+				if !ok {
+					unknown = true
+					continue
+				}
+				best = max(best, v)
+			}
+			if best == WDLWin || !unknown {
+				e.wdl[state], known[state], changed = best, true, true
+			}
+		}
+	}
+
+	// Distances to zeroing, a ply further each pass: a win is as far as its
+	// nearest losing reply, a loss as far as its furthest
+	unresolved := 0
+	for state := range moves {
+		if e.legal[state] && e.wdl[state] != WDLDraw && e.dtz[state] == 0 {
+			unresolved++
+		}
+	}
+	for n := 1; unresolved > 0; n++ {
+		require.Less(t, n, 100, "%s has positions the fifty-move rule spoils", signature)
+		var resolved []int
+		for state, stateMoves := range moves {
+			if !e.legal[state] || e.dtz[state] != 0 {
+				continue
+			}
+			switch e.wdl[state] {
+			case WDLWin:
+				for _, m := range stateMoves {
+					v, _ := value(m)
+					if v != WDLWin {
+						continue
+					}
+					if (n == 1 && (m.zeroing || m.mate)) ||
+						(n > 1 && !m.zeroing && m.child >= 0 && e.dtz[m.child] == 1-n) {
+						resolved = append(resolved, state)
+						break
+					}
+				}
+			case WDLLoss:
+				furthest, ready := 0, true
+				for _, m := range stateMoves {
+					d := 1
+					if !m.zeroing {
+						if e.dtz[m.child] == 0 {
+							ready = false
+							break
+						}
+						d += e.dtz[m.child]
+					}
+					furthest = max(furthest, d)
+				}
+				if ready && furthest == n {
+					resolved = append(resolved, state)
+				}
+			}
+		}
+		for _, state := range resolved {
+			e.dtz[state] = n * signOf(int(e.wdl[state]))
+		}
+		unresolved -= len(resolved)
+	}
+	return e
+}
+
+// writeSyzygyTable stores an endgame's WDL results, or its DTZ for white
+// to move, in the Syzygy format.
+func writeSyzygyTable(t *testing.T, signature string, dtz bool, e *solvedEndgame) []byte {
+	table := newSyzygyTable(signature, dtz)
+	pieces := make([]int, len(e.pieces))
+	for i, piece := range e.pieces {
+		pieces[i] = tbPiece(piece)
+	}
+	// Pawns lead; the pieces follow in the order solved
+	sort.SliceStable(pieces, func(i, j int) bool {
+		return pieces[i]&7 == tbPawn && pieces[j]&7 != tbPawn
+	})
+
+	flags := byte(0)
+	if dtz {
+		flags = tbFlagWinPlies | tbFlagLossPlies
+	}
+	values := make([][][]int, table.sides())
+	for side := range values {
+		values[side] = make([][]int, table.files())
+		for f := range values[side] {
+			d := &syzygyPairs{flags: flags}
+			copy(d.pieces[:], pieces)
+			table.setGroups(d, [2]int{0, 0xf}, f)
+			table.pairs[side][f] = d
+			values[side][f] = make([]int, d.size())
+			for i := range values[side][f] {
+				values[side][f][i] = -1
+			}
+		}
+	}
+
+	for state, legal := range e.legal {
+		if !legal {
+			continue
+		}
+		pos, _ := e.position(state)
+		probe, ok := table.locate(pos)
+		if !ok {
+			continue
+		}
+		value := int(e.wdl[state]) + 2
+		if dtz {
+			value = max(abs(e.dtz[state])-1, 0)
+		}
+		stored := &values[probe.stm][probe.file][probe.index]
+		require.True(t, *stored == -1 || *stored == value, "%s: positions sharing index %d differ", signature, probe.index)
+		*stored = value
+	}
+
+	var out bytes.Buffer
+	magic := syzygyWDLMagic
+	if dtz {
+		magic = syzygyDTZMagic
+	}
+	out.Write(magic)
+	fileFlags := byte(0)
+	if !table.symmetric {
+		fileFlags |= tbFileSplit
+	}
+	if table.pawns {
+		fileFlags |= tbFilePawns
+	}
+	out.WriteByte(fileFlags)
+	for f := 0; f < table.files(); f++ {
+		out.WriteByte(0) // the leading group is the least significant
+		for _, piece := range pieces {
+			out.WriteByte(byte(piece | piece<<4))
+		}
+	}
+	padTo(&out, 2)
+
+	var compressed [][]byte // sizes, then sparse index, block lengths and blocks
+	var sparse, lengths, blocks [][]byte
+	for f := 0; f < table.files(); f++ {
+		for side := range values {
+			sizes, index, blockLengths, data := compressTable(t, table.pairs[side][f].flags, values[side][f])
+			compressed = append(compressed, sizes)
+			sparse = append(sparse, index)
+			lengths = append(lengths, blockLengths)
+			blocks = append(blocks, data)
+		}
+	}
+	for _, sizes := range compressed {
+		out.Write(sizes)
+	}
+	padTo(&out, 2) // an empty DTZ map
+	for _, index := range sparse {
+		out.Write(index)
+	}
+	for _, blockLengths := range lengths {
+		out.Write(blockLengths)
+	}
+	for _, data := range blocks {
+		padTo(&out, 64)
+		out.Write(data)
+	}
+	return out.Bytes()
+}
+
+func padTo(out *bytes.Buffer, n int) {
+	for out.Len()%n != 0 {
+		out.WriteByte(0)
+	}
+}
+
+const (
+	genBlockBits = 6 // 64-byte blocks
+	genSpanBits  = 8
+	genMaxPairs  = 200
+)
+
+// compressTable encodes one table's values. Indices no position maps to take
+// the most common value.
+func compressTable(t *testing.T, flags byte, values []int) (sizes, index, blockLengths, data []byte) {
+	counts := make(map[int]int)
+	for _, v := range values {
+		if v >= 0 {
+			counts[v]++
+		}
+	}
+	common := -1
+	var distinct []int
+	for v, n := range counts {
+		distinct = append(distinct, v)
+		if common < 0 || n > counts[common] || (n == counts[common] && v < common) {
+			common = v
+		}
+	}
+	sort.Ints(distinct)
+	if len(distinct) <= 1 {
+		return []byte{flags | tbFlagSingleValue, byte(max(common, 0))}, nil, nil, nil
+	}
+
+	// Symbols: a leaf for each value, then pairs of symbols
+	type symbol struct{ left, right, length int }
+	var symbols []symbol
+	leaf := make(map[int]int)
+	for _, v := range distinct {
+		leaf[v] = len(symbols)
+		symbols = append(symbols, symbol{left: v, right: tbLeaf, length: 1})
+	}
+	seq := make([]int, len(values))
+	for i, v := range values {
+		if v < 0 {
+			v = common
+		}
+		seq[i] = leaf[v]
+	}
+
+	for round := 0; round < genMaxPairs; round++ {
+		pairs := make(map[[2]int]int)
+		for i := 0; i+1 < len(seq); i++ {
+			pairs[[2]int{seq[i], seq[i+1]}]++
+		}
+		var best [2]int
+		bestCount := 0
+		for pair, n := range pairs {
+			if n > bestCount || (n == bestCount && (pair[0] < best[0] || (pair[0] == best[0] && pair[1] < best[1]))) {
+				best, bestCount = pair, n
+			}
+		}
+		length := symbols[best[0]].length + symbols[best[1]].length
+		if bestCount < 32 || length > 1024 {
+			break
+		}
+		sym := len(symbols)
+		symbols = append(symbols, symbol{left: best[0], right: best[1], length: length})
+		merged := seq[:0]
+		for i := 0; i < len(seq); i++ {
+			if i+1 < len(seq) && seq[i] == best[0] && seq[i+1] == best[1] {
+				merged = append(merged, sym)
+				i++
+			} else {
+				merged = append(merged, seq[i])
+			}
+		}
+		seq = merged
+	}
+
+	// Canonical Huffman codes: longer codes first in symbol order
+	freq := make([]int, len(symbols))
+	for _, sym := range seq {
+		freq[sym]++
+	}
+	codeLen := huffmanLengths(freq)
+	order := make([]int, len(symbols))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := codeLen[order[i]], codeLen[order[j]]
+		if (a == 0) != (b == 0) {
+			return b == 0
+		}
+		return a > b
+	})
+	renumber := make([]int, len(symbols))
+	for id, sym := range order {
+		renumber[sym] = id
+	}
+
+	minLen, maxLen := 64, 0
+	for _, l := range codeLen {
+		if l > 0 {
+			minLen, maxLen = min(minLen, l), max(maxLen, l)
+		}
+	}
+	require.LessOrEqual(t, maxLen, 32)
+	count := make([]int, maxLen+1)
+	for _, l := range codeLen {
+		count[l]++
+	}
+	lowest := make([]int, maxLen+1)
+	base := make([]uint64, maxLen+1)
+	for l := maxLen - 1; l >= minLen; l-- {
+		lowest[l] = lowest[l+1] + count[l+1]
+		base[l] = (base[l+1] + uint64(count[l+1])) / 2
+	}
+	code := func(sym int) (uint64, int) {
+		l := codeLen[sym]
+		return base[l] + uint64(renumber[sym]-lowest[l]), l
+	}
+
+	var header bytes.Buffer
+	header.WriteByte(flags)
+	header.WriteByte(genBlockBits)
+	header.WriteByte(genSpanBits)
+	header.WriteByte(0) // padding
+	// blocks, filled in below
+	header.Write([]byte{0, 0, 0, 0})
+	header.WriteByte(byte(maxLen))
+	header.WriteByte(byte(minLen))
+	for l := minLen; l <= maxLen; l++ {
+		binary.Write(&header, binary.LittleEndian, uint16(lowest[l]))
+	}
+	binary.Write(&header, binary.LittleEndian, uint16(len(symbols)))
+	for _, sym := range order {
+		s := symbols[sym]
+		left, right := s.left, s.right
+		if right != tbLeaf {
+			left, right = renumber[left], renumber[right]
+		}
+		header.Write([]byte{byte(left), byte(left>>8) | byte(right<<4), byte(right >> 4)})
+	}
+	if len(symbols)&1 != 0 {
+		header.WriteByte(0)
+	}
+
+	// Blocks of whole symbols
+	blockSize := 1 << genBlockBits
+	var blockValues []int
+	var block []byte
+	bits, inBlock := 0, 0
+	flush := func() {
+		data = append(data, block...)
+		data = append(data, make([]byte, blockSize-len(block))...)
+		blockValues = append(blockValues, inBlock)
+		block, bits, inBlock = nil, 0, 0
+	}
+	for _, sym := range seq {
+		c, l := code(sym)
+		if bits+l > 8*blockSize || inBlock+symbols[sym].length > 1<<16 {
+			flush()
+		}
+		for i := l - 1; i >= 0; i-- {
+			if bits%8 == 0 {
+				block = append(block, 0)
+			}
+			if c>>i&1 != 0 {
+				block[bits/8] |= 0x80 >> (bits % 8)
+			}
+			bits++
+		}
+		inBlock += symbols[sym].length
+	}
+	flush()
+	sizes = header.Bytes()
+	binary.LittleEndian.PutUint32(sizes[4:], uint32(len(blockValues)))
+
+	for _, n := range blockValues {
+		blockLengths = binary.LittleEndian.AppendUint16(blockLengths, uint16(n-1))
+	}
+
+	span := 1 << genSpanBits
+	for k := 0; k*span < len(values); k++ {
+		target := k*span + span/2
+		block, start := 0, 0
+		for block < len(blockValues) && start+blockValues[block] <= target {
+			start += blockValues[block]
+			block++
+		}
+		index = binary.LittleEndian.AppendUint32(index, uint32(block))
+		index = binary.LittleEndian.AppendUint16(index, uint16(target-start))
+	}
+	return sizes, index, blockLengths, data
+}
+
+// huffmanLengths returns the length of each symbol's Huffman code, zero for
+// symbols that never occur.
+func huffmanLengths(freq []int) []int {
+	lengths := make([]int, len(freq))
+	h := &huffmanHeap{}
+	for sym, f := range freq {
+		if f > 0 {
+			heap.Push(h, &huffmanNode{weight: f, symbols: []int{sym}})
+		}
+	}
+	if h.Len() == 1 {
+		lengths[(*h)[0].symbols[0]] = 1
+		return lengths
+	}
+	for h.Len() > 1 {
+		a, b := heap.Pop(h).(*huffmanNode), heap.Pop(h).(*huffmanNode)
+		for _, sym := range append(a.symbols, b.symbols...) {
+			lengths[sym]++
+		}
+		heap.Push(h, &huffmanNode{weight: a.weight + b.weight, symbols: append(append([]int{}, a.symbols...), b.symbols...)})
+	}
+	return lengths
+}
+
+type huffmanNode struct {
+	weight  int
+	symbols []int
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].weight != h[j].weight {
+		return h[i].weight < h[j].weight
+	}
+	return h[i].symbols[0] < h[j].symbols[0]
+}
+func (h huffmanHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x any)   { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() any {
+	old := *h
+	node := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return node
+}
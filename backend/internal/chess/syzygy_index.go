@@ -0,0 +1,395 @@
+package chess
+
+import "sort"
+
+// Syzygy tables store one value per index, and the index of a position comes
+// from where its pieces stand once the board has been mirrored so that they
+// take as few arrangements as possible. This file holds the mappings behind
+// that index, computed as the tables' generator computes them.
+//
+// Squares here are numbered the tables' way, a1 = 0 to h8 = 63, rank by rank
+// from white's side; tbSquare converts from the search's numbering. Pieces are
+// coded 1-6 for white's pawn, knight, bishop, rook, queen and king, and 9-14
+// for black's.
+
+const (
+	tbMaxPieces = 7
+	tbPawn      = 1
+	tbKing      = 6
+	tbBlack     = 8
+
+	// uniqueTriangleSize is the number of ways to place three different
+	// pieces with the first in the a1-d1-d4 triangle.
+	uniqueTriangleSize = 31332
+	kingPairs          = 462
+)
+
+type syzygyMaps struct {
+	b1h1h7        [64]int // squares below the a1-h8 diagonal, 0-27
+	a1d1d4        [64]int // the a1-d1-d4 triangle, diagonal last, 0-9
+	kk            [10][64]int
+	binomial      [tbMaxPieces][64]uint64
+	pawns         [64]int // a2-h7, the highest being the leading pawn
+	leadPawnIndex [tbMaxPieces][64]uint64
+	leadPawnsSize [tbMaxPieces][4]uint64
+}
+
+var tbMaps = newSyzygyMaps()
+
+func newSyzygyMaps() *syzygyMaps {
+	m := &syzygyMaps{}
+
+	code := 0
+	for s := 0; s < 64; s++ {
+		if offDiagonal(s) < 0 {
+			m.b1h1h7[s] = code
+			code++
+		}
+	}
+
+	var diagonal []int
+	code = 0
+	for _, s := range []int{0, 1, 2, 3, 8, 9, 10, 11, 16, 17, 18, 19, 24, 25, 26, 27} {
+		if offDiagonal(s) < 0 {
+			m.a1d1d4[s] = code
+			code++
+		} else if offDiagonal(s) == 0 {
+			diagonal = append(diagonal, s)
+		}
+	}
+	for _, s := range diagonal {
+		m.a1d1d4[s] = code
+		code++
+	}
+
+	// The two kings, the first in the triangle and, when it is on the
+	// diagonal, the second not above it
+	type kingPair struct{ index, square int }
+	var bothOnDiagonal []kingPair
+	code = 0
+	for index := 0; index < 10; index++ {
+		for s1 := 0; s1 <= 27; s1++ {
+			if m.a1d1d4[s1] != index || (index == 0 && s1 != 1) {
+				continue
+			}
+			for s2 := 0; s2 < 64; s2++ {
+				switch {
+				case squareDistance(s1, s2) <= 1:
+				case offDiagonal(s1) == 0 && offDiagonal(s2) > 0:
+				case offDiagonal(s1) == 0 && offDiagonal(s2) == 0:
+					bothOnDiagonal = append(bothOnDiagonal, kingPair{index, s2})
+				default:
+					m.kk[index][s2] = code
+					code++
+				}
+			}
+		}
+	}
+	for _, pair := range bothOnDiagonal {
+		m.kk[pair.index][pair.square] = code
+		code++
+	}
+
+	m.binomial[0][0] = 1
+	for n := 1; n < 64; n++ {
+		for k := 0; k < tbMaxPieces && k <= n; k++ {
+			if k > 0 {
+				m.binomial[k][n] += m.binomial[k-1][n-1]
+			}
+			if k < n {
+				m.binomial[k][n] += m.binomial[k][n-1]
+			}
+		}
+	}
+
+	available := 47
+	for leadPawns := 1; leadPawns < tbMaxPieces; leadPawns++ {
+		for file := 0; file < 4; file++ {
+			var index uint64
+			for rank := 1; rank <= 6; rank++ {
+				s := rank*8 + file
+				if leadPawns == 1 {
+					m.pawns[s] = available
+					m.pawns[s^7] = available - 1
+					available -= 2
+				}
+				m.leadPawnIndex[leadPawns][s] = index
+				index += m.binomial[leadPawns-1][m.pawns[s]]
+			}
+			m.leadPawnsSize[leadPawns][file] = index
+		}
+	}
+
+	return m
+}
+
+// offDiagonal is how far s is above the a1-h8 diagonal, negative below it.
+func offDiagonal(s int) int {
+	return s/8 - s%8
+}
+
+func squareDistance(a, b int) int {
+	return max(abs(a/8-b/8), abs(a%8-b%8))
+}
+
+// tbSquare converts a square of the search's board, rank 8 first, to the
+// tables' numbering.
+func tbSquare(square int) int {
+	return square ^ 56
+}
+
+var tbPieceCodes = [128]int{
+	'P': 1, 'N': 2, 'B': 3, 'R': 4, 'Q': 5, 'K': 6,
+	'p': 9, 'n': 10, 'b': 11, 'r': 12, 'q': 13, 'k': 14,
+}
+
+// tbPiece codes a piece the tables' way, 0 for an empty square.
+func tbPiece(piece byte) int {
+	return tbPieceCodes[piece&0x7f]
+}
+
+// setGroups splits a table's pieces into the groups encoded together and
+// works out each group's weight in the index. order gives the position of
+// the leading group, and of the remaining pawns when both sides have some,
+// among the groups.
+func (t *syzygyTable) setGroups(d *syzygyPairs, order [2]int, file int) {
+	firstLen := 2
+	if t.pawns {
+		firstLen = 0
+	} else if t.uniquePieces {
+		firstLen = 3
+	}
+
+	n := 0
+	d.groupLen[0] = 1
+	for i := 1; i < t.pieceCount; i++ {
+		firstLen--
+		if firstLen > 0 || d.pieces[i] == d.pieces[i-1] {
+			d.groupLen[n]++
+		} else {
+			n++
+			d.groupLen[n] = 1
+		}
+	}
+	n++
+	d.groupLen[n] = 0
+
+	bothPawns := t.pawns && t.pawnCount[1] > 0
+	next := 1
+	freeSquares := 64 - d.groupLen[0]
+	if bothPawns {
+		next = 2
+		freeSquares -= d.groupLen[1]
+	}
+
+	idx := uint64(1)
+	for k := 0; next < n || k == order[0] || k == order[1]; k++ {
+		switch {
+		case k == order[0]:
+			d.groupIdx[0] = idx
+			switch {
+			case t.pawns:
+				idx *= tbMaps.leadPawnsSize[d.groupLen[0]][file]
+			case t.uniquePieces:
+				idx *= uniqueTriangleSize
+			default:
+				idx *= kingPairs
+			}
+		case k == order[1]:
+			d.groupIdx[1] = idx
+			idx *= tbMaps.binomial[d.groupLen[1]][48-d.groupLen[0]]
+		default:
+			d.groupIdx[next] = idx
+			idx *= tbMaps.binomial[d.groupLen[next]][freeSquares]
+			freeSquares -= d.groupLen[next]
+			next++
+		}
+	}
+	d.groupIdx[n] = idx
+}
+
+// size is the number of indices in a table.
+func (d *syzygyPairs) size() uint64 {
+	n := 0
+	for d.groupLen[n] != 0 {
+		n++
+	}
+	return d.groupIdx[n]
+}
+
+// tbProbe is a position about to be looked up in one table: which of its
+// sub-tables holds it and at what index.
+type tbProbe struct {
+	stm   int // the side to move, 1 for the table's second side
+	file  int // the leading pawn's file, folded onto a-d
+	index uint64
+}
+
+// locate finds where pos is stored in t. It reports false for a DTZ table
+// that only stores the other side to move.
+func (t *syzygyTable) locate(pos *tbPosition) (tbProbe, bool) {
+	var squares [tbMaxPieces]int
+	var pieces [tbMaxPieces]int
+
+	flip := t.flipped(pos)
+	flipColor, flipSquares := 0, 0
+	if flip {
+		flipColor, flipSquares = tbBlack, 56
+	}
+	probe := tbProbe{}
+	if flip == pos.white {
+		probe.stm = 1
+	}
+
+	size, leadPawnsCount := 0, 0
+	var leadPawns [64]bool
+	if t.pawns {
+		leadPawn := t.pairs[0][0].pieces[0] ^ flipColor
+		for square, piece := range pos.squares {
+			if piece != 0 && tbPiece(piece) == leadPawn {
+				leadPawns[square] = true
+			}
+		}
+		for s := 0; s < 64; s++ {
+			if leadPawns[tbSquare(s)] {
+				squares[size] = s ^ flipSquares
+				size++
+			}
+		}
+		leadPawnsCount = size
+
+		best := 0
+		for i := 1; i < leadPawnsCount; i++ {
+			if tbMaps.pawns[squares[i]] > tbMaps.pawns[squares[best]] {
+				best = i
+			}
+		}
+		squares[0], squares[best] = squares[best], squares[0]
+		probe.file = min(squares[0]%8, 7-squares[0]%8)
+	}
+
+	if t.dtz && !t.storesSide(probe.stm, probe.file) {
+		return probe, false
+	}
+
+	for s := 0; s < 64; s++ {
+		piece := pos.squares[tbSquare(s)]
+		if piece == 0 || leadPawns[tbSquare(s)] {
+			continue
+		}
+		squares[size] = s ^ flipSquares
+		pieces[size] = tbPiece(piece) ^ flipColor
+		size++
+	}
+
+	d := t.pairsFor(probe.stm, probe.file)
+
+	// Put the pieces in the table's order
+	for i := leadPawnsCount; i < size-1; i++ {
+		for j := i + 1; j < size; j++ {
+			if d.pieces[i] == pieces[j] {
+				pieces[i], pieces[j] = pieces[j], pieces[i]
+				squares[i], squares[j] = squares[j], squares[i]
+				break
+			}
+		}
+	}
+
+	// Mirror so that the leading piece is on files a-d
+	if squares[0]%8 > 3 {
+		for i := 0; i < size; i++ {
+			squares[i] ^= 7
+		}
+	}
+
+	var idx uint64
+	if t.pawns {
+		idx = tbMaps.leadPawnIndex[leadPawnsCount][squares[0]]
+		rest := squares[1:leadPawnsCount]
+		sort.SliceStable(rest, func(i, j int) bool {
+			return tbMaps.pawns[rest[i]] < tbMaps.pawns[rest[j]]
+		})
+		for i := 1; i < leadPawnsCount; i++ {
+			idx += tbMaps.binomial[i][tbMaps.pawns[squares[i]]]
+		}
+	} else {
+		idx = t.encodeLeadingPieces(d, squares[:size])
+	}
+
+	// The remaining groups, each as a combination of the squares left
+	idx *= d.groupIdx[0]
+	start := d.groupLen[0]
+	remainingPawns := t.pawns && t.pawnCount[1] > 0
+	for next := 1; d.groupLen[next] != 0; next++ {
+		group := squares[start : start+d.groupLen[next]]
+		sort.Ints(group)
+		var n uint64
+		for i, square := range group {
+			adjust := 0
+			for _, earlier := range squares[:start] {
+				if square > earlier {
+					adjust++
+				}
+			}
+			if remainingPawns {
+				adjust += 8
+			}
+			n += tbMaps.binomial[i+1][square-adjust]
+		}
+		remainingPawns = false
+		idx += n * d.groupIdx[next]
+		start += d.groupLen[next]
+	}
+
+	probe.index = idx
+	return probe, true
+}
+
+// encodeLeadingPieces mirrors a pawnless position so that its leading piece
+// is in the a1-d1-d4 triangle and encodes the leading group.
+func (t *syzygyTable) encodeLeadingPieces(d *syzygyPairs, squares []int) uint64 {
+	if squares[0]/8 > 3 {
+		for i := range squares {
+			squares[i] ^= 56
+		}
+	}
+	for i := 0; i < d.groupLen[0]; i++ {
+		if offDiagonal(squares[i]) == 0 {
+			continue
+		}
+		if offDiagonal(squares[i]) > 0 {
+			for j := i; j < len(squares); j++ {
+				squares[j] = ((squares[j] >> 3) | (squares[j] << 3)) & 63
+			}
+		}
+		break
+	}
+
+	if !t.uniquePieces {
+		return uint64(tbMaps.kk[tbMaps.a1d1d4[squares[0]]][squares[1]])
+	}
+
+	adjust1 := 0
+	if squares[1] > squares[0] {
+		adjust1 = 1
+	}
+	adjust2 := 0
+	if squares[2] > squares[0] {
+		adjust2++
+	}
+	if squares[2] > squares[1] {
+		adjust2++
+	}
+	rank0, rank1, rank2 := squares[0]/8, squares[1]/8, squares[2]/8
+
+	switch {
+	case offDiagonal(squares[0]) != 0:
+		return uint64((tbMaps.a1d1d4[squares[0]]*63+squares[1]-adjust1)*62 + squares[2] - adjust2)
+	case offDiagonal(squares[1]) != 0:
+		return uint64((6*63+rank0*28+tbMaps.b1h1h7[squares[1]])*62 + squares[2] - adjust2)
+	case offDiagonal(squares[2]) != 0:
+		return uint64(6*63*62 + 4*28*62 + rank0*7*28 + (rank1-adjust1)*28 + tbMaps.b1h1h7[squares[2]])
+	default:
+		return uint64(6*63*62 + 4*28*62 + 4*7*28 + rank0*7*6 + (rank1-adjust1)*6 + rank2 - adjust2)
+	}
+}
@@ -0,0 +1,320 @@
+package chess
+
+import (
+	"errors"
+	"strings"
+)
+
+// Probing follows the tables' own rules. A WDL table may store any value for
+// a position where the side to move has a capture at least as good, so the
+// captures are searched as well, and a DTZ table leaves out positions whose
+// best move resets the fifty-move count. DTZ tables also store only one side
+// to move; the other is probed a move deeper.
+
+var errOtherSideToMove = errors.New("table stores the other side to move")
+
+// tbPosition is the search's board with what it leaves out and the tables
+// need: en passant and promotion.
+type tbPosition struct {
+	searchPosition
+	enPassant int // the square a pawn may capture on en passant, or -1
+}
+
+type tbMove struct {
+	searchMove
+	promotion byte
+	enPassant bool
+}
+
+// tbUndo is what a move changed, to take it back.
+type tbUndo struct {
+	captured       byte
+	capturedSquare int
+	enPassant      int
+}
+
+func newTBPosition(board *Board) *tbPosition {
+	pos := &tbPosition{searchPosition: *newSearchPosition(board), enPassant: -1}
+	if board.canCaptureEnPassant() {
+		target, _ := parseSquare(board.enPassant)
+		pos.enPassant = target.rank*8 + target.file
+	}
+	return pos
+}
+
+// material returns the pieces of each side in syzygyPieces order.
+func (p *tbPosition) material() (white, black string) {
+	var counts [128]int
+	for _, piece := range p.squares {
+		counts[piece]++
+	}
+	var w, b strings.Builder
+	for _, piece := range []byte(syzygyPieces) {
+		w.WriteString(strings.Repeat(string(piece), counts[piece]))
+		b.WriteString(strings.Repeat(string(piece), counts[piece|0x20]))
+	}
+	return w.String(), b.String()
+}
+
+func (p *tbPosition) pieceCount() int {
+	count := 0
+	for _, piece := range p.squares {
+		if piece != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// signature is the name of the table holding the position.
+func (p *tbPosition) signature() string {
+	white, black := p.material()
+	if !syzygyFirst(white, black) {
+		return black + "v" + white
+	}
+	return white + "v" + black
+}
+
+// moves returns the legal moves.
+func (p *tbPosition) moves() []tbMove {
+	var moves []tbMove
+	for _, move := range p.pseudoMoves(make([]searchMove, 0, 48)) {
+		if move.piece|0x20 == 'p' && (move.to < 8 || move.to >= 56) {
+			for _, promotion := range []byte("qrbn") {
+				if isWhitePiece(move.piece) {
+					promotion &^= 0x20
+				}
+				moves = append(moves, tbMove{searchMove: move, promotion: promotion})
+			}
+			continue
+		}
+		moves = append(moves, tbMove{searchMove: move})
+	}
+
+	if p.enPassant >= 0 {
+		pawn, rank := byte('P'), p.enPassant/8+1
+		if !p.white {
+			pawn, rank = 'p', p.enPassant/8-1
+		}
+		for _, file := range []int{p.enPassant%8 - 1, p.enPassant%8 + 1} {
+			if onBoard(rank, file) && p.squares[rank*8+file] == pawn {
+				moves = append(moves, tbMove{
+					searchMove: searchMove{from: rank*8 + file, to: p.enPassant, piece: pawn},
+					enPassant:  true,
+				})
+			}
+		}
+	}
+
+	legal := moves[:0]
+	for _, move := range moves {
+		undo := p.play(move)
+		if !p.inCheck(!p.white) {
+			legal = append(legal, move)
+		}
+		p.takeBack(move, undo)
+	}
+	return legal
+}
+
+func (p *tbPosition) play(move tbMove) tbUndo {
+	undo := tbUndo{captured: move.captured, capturedSquare: move.to, enPassant: p.enPassant}
+	if move.enPassant {
+		undo.capturedSquare = move.from/8*8 + move.to%8
+		undo.captured = p.squares[undo.capturedSquare]
+		p.squares[undo.capturedSquare] = 0
+	}
+
+	p.enPassant = -1
+	if move.piece|0x20 == 'p' && abs(move.to-move.from) == 16 {
+		p.enPassant = (move.from + move.to) / 2
+	}
+	p.squares[move.from] = 0
+	p.squares[move.to] = move.piece
+	if move.promotion != 0 {
+		p.squares[move.to] = move.promotion
+	}
+	p.white = !p.white
+	return undo
+}
+
+func (p *tbPosition) takeBack(move tbMove, undo tbUndo) {
+	p.white = !p.white
+	p.squares[move.to] = 0
+	p.squares[undo.capturedSquare] = undo.captured
+	p.squares[move.from] = move.piece
+	p.enPassant = undo.enPassant
+}
+
+func (m tbMove) capture() bool {
+	return m.captured != 0 || m.enPassant
+}
+
+// zeroing reports whether m resets the fifty-move count.
+func (m tbMove) zeroing() bool {
+	return m.capture() || m.piece|0x20 == 'p'
+}
+
+func (m tbMove) uci() string {
+	move := m.searchMove.uci()
+	if m.promotion != 0 {
+		move += string(m.promotion | 0x20)
+	}
+	return move
+}
+
+// dtzBeforeZeroing is the DTZ of a position whose best move resets the
+// fifty-move count and leads to wdl.
+func dtzBeforeZeroing(wdl WDL) int {
+	switch wdl {
+	case WDLWin:
+		return 1
+	case WDLCursedWin:
+		return 101
+	case WDLBlessedLoss:
+		return -101
+	case WDLLoss:
+		return -1
+	}
+	return 0
+}
+
+func signOf(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
+
+// searchWDL returns the WDL of pos from its table and its captures, and
+// with zeroing set its pawn moves too. It reports whether the best of those
+// moves resets the fifty-move count, in which case a DTZ table can't be
+// trusted.
+func (tb *Tablebase) searchWDL(pos *tbPosition, zeroing bool) (WDL, bool, error) {
+	moves := pos.moves()
+	best, searched := WDLLoss, 0
+	for _, move := range moves {
+		if !move.capture() && (!zeroing || move.piece|0x20 != 'p') {
+			continue
+		}
+		searched++
+
+		undo := pos.play(move)
+		value, _, err := tb.searchWDL(pos, false)
+		pos.takeBack(move, undo)
+		if err != nil {
+			return WDLDraw, false, err
+		}
+
+		if -value > best {
+			best = -value
+			if best >= WDLWin {
+				return best, true, nil
+			}
+		}
+	}
+
+	// With every move searched the table isn't needed, and may be wrong
+	// when the best of them is en passant
+	allSearched := searched > 0 && searched == len(moves)
+	var value WDL
+	if allSearched {
+		value = best
+	} else {
+		stored, err := tb.probeTable(pos, false, WDLDraw)
+		if err != nil {
+			return WDLDraw, false, err
+		}
+		value = WDL(stored)
+	}
+
+	if best >= value {
+		return best, best > WDLDraw || allSearched, nil
+	}
+	return value, false, nil
+}
+
+// probeDTZ returns the DTZ of pos: the plies to the next zeroing move, or to
+// mate, with the best play for both sides, positive when the side to move
+// wins and zero for draws. Wins and losses the fifty-move rule spoils are
+// counted 100 plies further.
+func (tb *Tablebase) probeDTZ(pos *tbPosition) (int, error) {
+	wdl, zeroingBest, err := tb.searchWDL(pos, true)
+	if err != nil || wdl == WDLDraw {
+		return 0, err
+	}
+	if zeroingBest {
+		return dtzBeforeZeroing(wdl), nil
+	}
+
+	dtz, err := tb.probeTable(pos, true, wdl)
+	if err == nil {
+		bonus := 0
+		if wdl == WDLBlessedLoss || wdl == WDLCursedWin {
+			bonus = 100
+		}
+		return (dtz + bonus) * signOf(int(wdl)), nil
+	}
+	if !errors.Is(err, errOtherSideToMove) {
+		return 0, err
+	}
+
+	// The table has the other side to move: look a move ahead for the move
+	// that keeps the result closest to zeroing
+	best := 0xffff
+	for _, move := range pos.moves() {
+		undo := pos.play(move)
+		var dtz int
+		if move.zeroing() {
+			var value WDL
+			value, _, err = tb.searchWDL(pos, false)
+			dtz = -dtzBeforeZeroing(value)
+		} else {
+			dtz, err = tb.probeDTZ(pos)
+			dtz = -dtz
+		}
+		if dtz == 1 && pos.inCheck(pos.white) && len(pos.moves()) == 0 {
+			best = 1 // mate
+		}
+		pos.takeBack(move, undo)
+		if err != nil {
+			return 0, err
+		}
+
+		if !move.zeroing() {
+			dtz += signOf(dtz)
+		}
+		if dtz < best && signOf(dtz) == signOf(int(wdl)) {
+			best = dtz
+		}
+	}
+	if best == 0xffff {
+		return -1, nil
+	}
+	return best, nil
+}
+
+// probeTable reads pos's value from its WDL or DTZ table. For DTZ, wdl is
+// the position's result.
+func (tb *Tablebase) probeTable(pos *tbPosition, dtz bool, wdl WDL) (int, error) {
+	if pos.pieceCount() == 2 {
+		return int(WDLDraw), nil
+	}
+
+	table, err := tb.table(pos.signature(), dtz)
+	if err != nil {
+		return 0, err
+	}
+	probe, ok := table.locate(pos)
+	if !ok {
+		return 0, errOtherSideToMove
+	}
+	value, err := table.pairsFor(probe.stm, probe.file).value(table.r, probe.index)
+	if err != nil {
+		return 0, err
+	}
+	return table.score(probe.file, value, wdl), nil
+}
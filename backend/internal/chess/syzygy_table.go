@@ -0,0 +1,527 @@
+package chess
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Syzygy file holds a table for each side to move (WDL files of unequal
+// material) and, with pawns, for each file of the leading pawn from a to d.
+// Each table is a run of values compressed by recursive pairing, where a
+// symbol stands for a pair of symbols, and then Huffman coded in blocks of a
+// fixed size. The header gives each table's pieces, its symbols and codes,
+// and a sparse index into the blocks; syzygyTable reads the header and then
+// the blocks as they are needed.
+
+// Flags of a table's compressed data.
+const (
+	tbFlagSTM         = 1 // DTZ: the side to move the table stores
+	tbFlagMapped      = 2 // DTZ: values are looked up in the file's map
+	tbFlagWinPlies    = 4 // DTZ: wins are counted in plies, not moves
+	tbFlagLossPlies   = 8
+	tbFlagWide        = 16 // DTZ: the map has 16-bit entries
+	tbFlagSingleValue = 128
+)
+
+// File flags, after the magic number.
+const (
+	tbFileSplit = 1 // a table for each side to move
+	tbFilePawns = 2
+)
+
+const tbLeaf = 0xfff // right-hand symbol of a symbol that isn't a pair
+
+type syzygyTable struct {
+	r            io.ReaderAt
+	dtz          bool
+	white, black string // the sides as named, white's being first
+	symmetric    bool
+	pawns        bool
+	pieceCount   int
+	pawnCount    [2]int // the leading side's pawns, then the other side's
+	uniquePieces bool   // some side has a piece other than its king alone
+
+	pairs  [2][4]*syzygyPairs // by side to move and leading pawn file
+	dtzMap []byte
+}
+
+// syzygyPairs is one compressed table.
+type syzygyPairs struct {
+	flags    byte
+	pieces   [tbMaxPieces]int
+	groupLen [tbMaxPieces + 1]int
+	groupIdx [tbMaxPieces + 1]uint64
+
+	blockSize       uint64
+	span            uint64 // indices between sparse index entries
+	sparseIndexSize uint64
+	blocks          uint64
+	blockLengthSize uint64
+	minSymLen       int // the value itself when flags has tbFlagSingleValue
+	maxSymLen       int
+	lowestSym       []uint64
+	base64          []uint64
+	symlen          []int // values a symbol stands for, less one
+	btree           []byte
+
+	sparseIndex  int64 // file offsets
+	blockLengths int64
+	data         int64
+
+	mapIdx [4]int // DTZ: where the map for each result starts
+}
+
+// newSyzygyTable describes the table for signature, such as "KRPvKR",
+// before its file is read.
+func newSyzygyTable(signature string, dtz bool) *syzygyTable {
+	sides := strings.SplitN(signature, "v", 2)
+	t := &syzygyTable{
+		dtz:        dtz,
+		white:      sides[0],
+		black:      sides[1],
+		symmetric:  sides[0] == sides[1],
+		pieceCount: len(sides[0]) + len(sides[1]),
+	}
+
+	whitePawns, blackPawns := strings.Count(t.white, "P"), strings.Count(t.black, "P")
+	t.pawns = whitePawns+blackPawns > 0
+	// Pawns lead from the side with fewer of them
+	if blackPawns == 0 || (whitePawns > 0 && blackPawns >= whitePawns) {
+		t.pawnCount = [2]int{whitePawns, blackPawns}
+	} else {
+		t.pawnCount = [2]int{blackPawns, whitePawns}
+	}
+	for _, side := range sides {
+		for _, piece := range syzygyPieces[1:] {
+			if strings.Count(side, string(piece)) == 1 {
+				t.uniquePieces = true
+			}
+		}
+	}
+	return t
+}
+
+func (t *syzygyTable) sides() int {
+	if t.dtz || t.symmetric {
+		return 1
+	}
+	return 2
+}
+
+func (t *syzygyTable) files() int {
+	if t.pawns {
+		return 4
+	}
+	return 1
+}
+
+func (t *syzygyTable) pairsFor(stm, file int) *syzygyPairs {
+	if t.dtz {
+		stm = 0
+	}
+	if !t.pawns {
+		file = 0
+	}
+	return t.pairs[stm][file]
+}
+
+// storesSide reports whether a DTZ table has the positions with stm to move.
+func (t *syzygyTable) storesSide(stm, file int) bool {
+	return int(t.pairsFor(stm, file).flags&tbFlagSTM) == stm || (t.symmetric && !t.pawns)
+}
+
+// flipped reports whether pos has to be looked up with the colours swapped:
+// when black has the table's first side, or, the sides being equal, when
+// black is to move, as only white to move is stored.
+func (t *syzygyTable) flipped(pos *tbPosition) bool {
+	if t.symmetric {
+		return !pos.white
+	}
+	white, _ := pos.material()
+	return white != t.white
+}
+
+// read parses the file's header. The values stay in the file and are read
+// as they are probed.
+func (t *syzygyTable) read(r io.ReaderAt) error {
+	t.r = r
+	h := &tableHeader{r: bufio.NewReader(io.NewSectionReader(r, 4, 1<<62)), pos: 4}
+
+	flags := h.byte()
+	if (flags&tbFilePawns != 0) != t.pawns || (flags&tbFileSplit != 0) == t.symmetric {
+		return fmt.Errorf("%w: header doesn't match the material", ErrTablebaseDecoding)
+	}
+
+	bothPawns := t.pawns && t.pawnCount[1] > 0
+	for f := 0; f < t.files(); f++ {
+		for i := 0; i < t.sides(); i++ {
+			t.pairs[i][f] = &syzygyPairs{}
+		}
+		first := h.byte()
+		second := byte(0xff)
+		if bothPawns {
+			second = h.byte()
+		}
+		orders := [2][2]int{
+			{int(first & 0xf), int(second & 0xf)},
+			{int(first >> 4), int(second >> 4)},
+		}
+		for k := 0; k < t.pieceCount; k++ {
+			b := h.byte()
+			t.pairs[0][f].pieces[k] = int(b & 0xf)
+			if t.sides() == 2 {
+				t.pairs[1][f].pieces[k] = int(b >> 4)
+			}
+		}
+		for i := 0; i < t.sides(); i++ {
+			t.setGroups(t.pairs[i][f], orders[i], f)
+		}
+	}
+	h.align(2)
+
+	for f := 0; f < t.files(); f++ {
+		for i := 0; i < t.sides(); i++ {
+			if err := t.pairs[i][f].readSizes(h); err != nil {
+				return err
+			}
+		}
+	}
+
+	if t.dtz {
+		t.readDTZMap(h)
+	}
+	if h.err != nil {
+		return fmt.Errorf("%w: %v", ErrTablebaseDecoding, h.err)
+	}
+
+	offset := h.pos
+	for f := 0; f < t.files(); f++ {
+		for i := 0; i < t.sides(); i++ {
+			d := t.pairs[i][f]
+			d.sparseIndex = offset
+			offset += int64(d.sparseIndexSize) * 6
+		}
+	}
+	for f := 0; f < t.files(); f++ {
+		for i := 0; i < t.sides(); i++ {
+			d := t.pairs[i][f]
+			d.blockLengths = offset
+			offset += int64(d.blockLengthSize) * 2
+		}
+	}
+	for f := 0; f < t.files(); f++ {
+		for i := 0; i < t.sides(); i++ {
+			d := t.pairs[i][f]
+			offset = (offset + 63) &^ 63
+			d.data = offset
+			offset += int64(d.blocks * d.blockSize)
+		}
+	}
+	return nil
+}
+
+// readSizes reads a table's block layout and symbols.
+func (d *syzygyPairs) readSizes(h *tableHeader) error {
+	d.flags = h.byte()
+	if d.flags&tbFlagSingleValue != 0 {
+		d.minSymLen = int(h.byte())
+		return h.err
+	}
+
+	d.blockSize = 1 << h.byte()
+	d.span = 1 << h.byte()
+	d.sparseIndexSize = (d.size() + d.span - 1) / d.span
+	padding := uint64(h.byte())
+	d.blocks = uint64(h.uint32())
+	d.blockLengthSize = d.blocks + padding
+	d.maxSymLen = int(h.byte())
+	d.minSymLen = int(h.byte())
+	if h.err != nil {
+		return h.err
+	}
+	if d.minSymLen == 0 || d.maxSymLen < d.minSymLen || d.maxSymLen > 32 {
+		return fmt.Errorf("%w: symbol lengths %d to %d", ErrTablebaseDecoding, d.minSymLen, d.maxSymLen)
+	}
+
+	// Canonical Huffman codes: the longer a code, the lower its value, and
+	// the codes of one length are consecutive. base64 holds the lowest code
+	// of each length, left-aligned in 64 bits.
+	lengths := d.maxSymLen - d.minSymLen + 1
+	d.lowestSym = make([]uint64, lengths)
+	for i := range d.lowestSym {
+		d.lowestSym[i] = uint64(h.uint16())
+	}
+	d.base64 = make([]uint64, lengths)
+	for i := lengths - 2; i >= 0; i-- {
+		d.base64[i] = (d.base64[i+1] + d.lowestSym[i] - d.lowestSym[i+1]) / 2
+	}
+	for i := range d.base64 {
+		d.base64[i] <<= 64 - i - d.minSymLen
+	}
+
+	symbols := int(h.uint16())
+	d.btree = h.bytes(3 * symbols)
+	h.skip(symbols & 1)
+	if h.err != nil {
+		return h.err
+	}
+
+	d.symlen = make([]int, symbols)
+	visited := make([]bool, symbols)
+	for sym := 0; sym < symbols; sym++ {
+		if !visited[sym] {
+			length, err := d.setSymlen(sym, visited)
+			if err != nil {
+				return err
+			}
+			d.symlen[sym] = length
+		}
+	}
+	return nil
+}
+
+func (d *syzygyPairs) left(sym int) int {
+	return int(d.btree[3*sym+1]&0xf)<<8 | int(d.btree[3*sym])
+}
+
+func (d *syzygyPairs) right(sym int) int {
+	return int(d.btree[3*sym+2])<<4 | int(d.btree[3*sym+1]>>4)
+}
+
+func (d *syzygyPairs) setSymlen(sym int, visited []bool) (int, error) {
+	visited[sym] = true
+	right := d.right(sym)
+	if right == tbLeaf {
+		return 0, nil
+	}
+	left := d.left(sym)
+	if left >= len(d.symlen) || right >= len(d.symlen) {
+		return 0, fmt.Errorf("%w: symbol %d out of range", ErrTablebaseDecoding, sym)
+	}
+	for _, child := range []int{left, right} {
+		if !visited[child] {
+			length, err := d.setSymlen(child, visited)
+			if err != nil {
+				return 0, err
+			}
+			d.symlen[child] = length
+		}
+	}
+	return d.symlen[left] + d.symlen[right] + 1, nil
+}
+
+// readDTZMap reads the tables that turn stored DTZ values into distances.
+func (t *syzygyTable) readDTZMap(h *tableHeader) {
+	start := h.pos
+	var data []byte
+	for f := 0; f < t.files(); f++ {
+		d := t.pairs[0][f]
+		if d.flags&tbFlagMapped == 0 {
+			continue
+		}
+		for i := 0; i < 4; i++ {
+			if d.flags&tbFlagWide != 0 {
+				if (start+int64(len(data)))&1 != 0 {
+					data = append(data, h.byte())
+				}
+				d.mapIdx[i] = len(data)/2 + 1
+				lengthBytes := h.bytes(2)
+				data = append(data, lengthBytes...)
+				if len(lengthBytes) == 2 {
+					data = append(data, h.bytes(2*int(binary.LittleEndian.Uint16(lengthBytes)))...)
+				}
+			} else {
+				d.mapIdx[i] = len(data) + 1
+				length := h.byte()
+				data = append(append(data, length), h.bytes(int(length))...)
+			}
+		}
+	}
+	h.align(2)
+	t.dtzMap = data
+}
+
+// value decompresses the value stored at idx.
+func (d *syzygyPairs) value(r io.ReaderAt, idx uint64) (int, error) {
+	if d.flags&tbFlagSingleValue != 0 {
+		return d.minSymLen, nil
+	}
+	if idx >= d.size() {
+		return 0, fmt.Errorf("%w: index %d out of range", ErrTablebaseDecoding, idx)
+	}
+
+	// The sparse index gives the block and offset of every span'th value,
+	// from which the block holding idx is found by walking the blocks'
+	// lengths
+	k := idx / d.span
+	var entry [6]byte
+	if _, err := r.ReadAt(entry[:], d.sparseIndex+int64(6*k)); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrTablebaseDecoding, err)
+	}
+	block := int64(binary.LittleEndian.Uint32(entry[:4]))
+	offset := int64(binary.LittleEndian.Uint16(entry[4:])) + int64(idx%d.span) - int64(d.span/2)
+
+	blockLength := func(block int64) (int64, error) {
+		if block < 0 || uint64(block) >= d.blockLengthSize {
+			return 0, fmt.Errorf("%w: block %d out of range", ErrTablebaseDecoding, block)
+		}
+		var length [2]byte
+		if _, err := r.ReadAt(length[:], d.blockLengths+2*block); err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrTablebaseDecoding, err)
+		}
+		return int64(binary.LittleEndian.Uint16(length[:])), nil
+	}
+	for offset < 0 {
+		block--
+		length, err := blockLength(block)
+		if err != nil {
+			return 0, err
+		}
+		offset += length + 1
+	}
+	for {
+		length, err := blockLength(block)
+		if err != nil {
+			return 0, err
+		}
+		if offset <= length {
+			break
+		}
+		offset -= length + 1
+		block++
+	}
+	if uint64(block) >= d.blocks {
+		return 0, fmt.Errorf("%w: block %d out of range", ErrTablebaseDecoding, block)
+	}
+
+	data := make([]byte, d.blockSize)
+	if n, err := r.ReadAt(data, d.data+block*int64(d.blockSize)); err != nil && !(err == io.EOF && n > 0) {
+		return 0, fmt.Errorf("%w: %v", ErrTablebaseDecoding, err)
+	}
+	word := func(i int) uint64 {
+		var w [4]byte
+		if i < len(data) {
+			copy(w[:], data[i:])
+		}
+		return uint64(binary.BigEndian.Uint32(w[:]))
+	}
+
+	// Find the symbol whose values include the one at offset
+	buf64 := word(0)<<32 | word(4)
+	next, bits := 8, 64
+	var sym int
+	for {
+		length := 0
+		for buf64 < d.base64[length] {
+			length++
+		}
+		sym = int((buf64-d.base64[length])>>(64-length-d.minSymLen)) + int(d.lowestSym[length])
+		if sym >= len(d.symlen) {
+			return 0, fmt.Errorf("%w: symbol %d out of range", ErrTablebaseDecoding, sym)
+		}
+		if offset < int64(d.symlen[sym])+1 {
+			break
+		}
+		offset -= int64(d.symlen[sym]) + 1
+		length += d.minSymLen
+		buf64 <<= length
+		bits -= length
+		if bits <= 32 {
+			bits += 32
+			buf64 |= word(next) << (64 - bits)
+			next += 4
+		}
+	}
+
+	// and expand it down to that value
+	for d.symlen[sym] != 0 {
+		left := d.left(sym)
+		if offset < int64(d.symlen[left])+1 {
+			sym = left
+		} else {
+			offset -= int64(d.symlen[left]) + 1
+			sym = d.right(sym)
+		}
+	}
+	return d.left(sym), nil
+}
+
+// score turns a value read from the table into a WDL result or, given the
+// position's WDL, a DTZ in plies.
+func (t *syzygyTable) score(file, value int, wdl WDL) int {
+	if !t.dtz {
+		return value - 2
+	}
+
+	d := t.pairsFor(0, file)
+	if d.flags&tbFlagMapped != 0 {
+		// Maps are kept for wins, losses, cursed wins and blessed losses
+		idx := d.mapIdx[[]int{1, 3, 0, 2, 0}[wdl+2]] + value
+		if d.flags&tbFlagWide != 0 {
+			if 2*idx+1 < len(t.dtzMap) {
+				value = int(binary.LittleEndian.Uint16(t.dtzMap[2*idx:]))
+			}
+		} else if idx < len(t.dtzMap) {
+			value = int(t.dtzMap[idx])
+		}
+	}
+
+	if (wdl == WDLWin && d.flags&tbFlagWinPlies == 0) ||
+		(wdl == WDLLoss && d.flags&tbFlagLossPlies == 0) ||
+		wdl == WDLCursedWin || wdl == WDLBlessedLoss {
+		value *= 2
+	}
+	return value + 1
+}
+
+// tableHeader reads a file's header in order, keeping the first error.
+type tableHeader struct {
+	r   *bufio.Reader
+	pos int64
+	err error
+}
+
+func (h *tableHeader) bytes(n int) []byte {
+	if h.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	_, h.err = io.ReadFull(h.r, b)
+	h.pos += int64(n)
+	return b
+}
+
+func (h *tableHeader) byte() byte {
+	if b := h.bytes(1); len(b) == 1 {
+		return b[0]
+	}
+	return 0
+}
+
+func (h *tableHeader) uint16() uint16 {
+	if b := h.bytes(2); len(b) == 2 {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (h *tableHeader) uint32() uint32 {
+	if b := h.bytes(4); len(b) == 4 {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (h *tableHeader) skip(n int) {
+	h.bytes(n)
+}
+
+// align skips to the next multiple of n in the file.
+func (h *tableHeader) align(n int64) {
+	if rem := h.pos % n; rem != 0 {
+		h.skip(int(n - rem))
+	}
+}
@@ -0,0 +1,210 @@
+package chess
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTable(t *testing.T, dir, name string, header []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), append(header, make([]byte, 60)...), 0o644))
+}
+
+func TestOpenTablebase(t *testing.T) {
+	dir := t.TempDir()
+	writeTable(t, dir, "KQvK.rtbw", syzygyWDLMagic)
+	writeTable(t, dir, "KQvK.rtbz", syzygyDTZMagic)
+	writeTable(t, dir, "KRPvKR.rtbw", syzygyWDLMagic)
+	writeTable(t, dir, "README.txt", nil)
+
+	tb, err := OpenTablebase(dir)
+
+	require.NoError(t, err)
+	wdl, dtz := tb.Tables()
+	assert.Equal(t, 2, wdl)
+	assert.Equal(t, 1, dtz)
+	assert.Equal(t, 5, tb.MaxPieces())
+}
+
+func TestOpenTablebase_RejectsBadHeader(t *testing.T) {
+	dir := t.TempDir()
+	writeTable(t, dir, "KQvK.rtbw", syzygyDTZMagic)
+
+	_, err := OpenTablebase(dir)
+
+	assert.EqualError(t, err, "KQvK.rtbw: not a Syzygy table")
+}
+
+func TestTablebase_Probe(t *testing.T) {
+	dir := t.TempDir()
+	writeTable(t, dir, "KQvK.rtbw", syzygyWDLMagic)
+	tb, err := OpenTablebase(dir)
+	require.NoError(t, err)
+
+	// Black has the queen, so this is the KQvK table seen from the other side
+	covered := "8/8/8/3qk3/8/8/8/4K3 w - - 0 1"
+	assert.True(t, tb.Covers(covered))
+	_, err = tb.ProbeWDL(covered)
+	assert.ErrorIs(t, err, ErrTablebaseDecoding)
+	_, err = tb.ProbeDTZ(covered)
+	assert.ErrorIs(t, err, ErrNotInTablebase)
+
+	_, err = tb.ProbeWDL("8/8/8/3rk3/8/8/8/4K3 w - - 0 1")
+	assert.ErrorIs(t, err, ErrNotInTablebase)
+	assert.False(t, tb.Covers(startFEN))
+}
+
+// tablebaseResults are positions whose outcome and distance to zeroing are
+// known from endgame theory. They're checked against the generated tables in
+// testdata and, when SYZYGY_TEST_PATH is set, against the official ones.
+var tablebaseResults = []struct {
+	fen string
+	wdl WDL
+	dtz int
+}{
+	// Mate in one, mated, and mate in two
+	{"7k/8/6K1/8/8/8/8/1Q6 w - - 0 1", WDLWin, 1},
+	{"7k/6Q1/6K1/8/8/8/8/8 b - - 0 1", WDLLoss, -1},
+	{"6k1/8/5K2/8/8/8/8/7Q w - - 0 1", WDLWin, 3},
+	// Black has the queen: mate in three, or mated in four
+	{"8/8/8/8/3kq3/8/8/K7 b - - 0 1", WDLWin, 5},
+	{"8/8/8/8/3kq3/8/8/K7 w - - 0 1", WDLLoss, -8},
+	{"8/8/8/4k3/8/8/8/R3K3 w - - 0 1", WDLWin, 27},
+	{"8/8/8/4k3/8/8/8/R3K3 b - - 0 1", WDLLoss, -28},
+	{"8/8/8/8/8/8/3B4/K1k5 w - - 0 1", WDLDraw, 0},
+	// The pawn queens, or is taken
+	{"8/4P3/5K2/8/8/8/8/k7 w - - 0 1", WDLWin, 1},
+	{"8/8/8/8/3kP3/8/8/K7 b - - 0 1", WDLDraw, 0},
+	// The king in front of a rook pawn holds
+	{"k7/8/8/8/P7/8/8/4K3 w - - 0 1", WDLDraw, 0},
+	// The king can't catch the pawn, whichever color has it
+	{"7k/8/8/1P6/8/8/8/7K b - - 0 1", WDLLoss, -2},
+	{"7k/8/8/1p6/8/8/8/7K w - - 0 1", WDLLoss, -2},
+	// With the king on the sixth in front of its pawn, either side to move
+	{"4k3/8/4K3/4P3/8/8/8/8 w - - 0 1", WDLWin, 3},
+	{"4k3/8/4K3/4P3/8/8/8/8 b - - 0 1", WDLLoss, -4},
+}
+
+func checkTablebaseResults(t *testing.T, tb *Tablebase, exactDTZ bool) {
+	t.Helper()
+	for _, tc := range tablebaseResults {
+		wdl, err := tb.ProbeWDL(tc.fen)
+		require.NoError(t, err, tc.fen)
+		assert.Equal(t, tc.wdl, wdl, tc.fen)
+
+		dtz, err := tb.ProbeDTZ(tc.fen)
+		require.NoError(t, err, tc.fen)
+		if exactDTZ {
+			assert.Equal(t, tc.dtz, dtz, tc.fen)
+			continue
+		}
+		// The official tables may store a distance in moves rather than
+		// plies, so a probe can come back one ply short.
+		assert.Equal(t, tc.dtz > 0, dtz > 0, tc.fen)
+		assert.InDelta(t, tc.dtz, dtz, 1, tc.fen)
+	}
+}
+
+func TestTablebase_ProbeTables(t *testing.T) {
+	tb, err := OpenTablebase(syzygyTestdata)
+	require.NoError(t, err)
+	defer tb.Close()
+
+	checkTablebaseResults(t, tb, true)
+}
+
+func TestTablebase_ProbeOfficialTables(t *testing.T) {
+	dir := os.Getenv("SYZYGY_TEST_PATH")
+	if dir == "" {
+		t.Skip("SYZYGY_TEST_PATH not set")
+	}
+	tb, err := OpenTablebase(dir)
+	require.NoError(t, err)
+	defer tb.Close()
+
+	checkTablebaseResults(t, tb, false)
+}
+
+func TestTablebase_BestMove(t *testing.T) {
+	tb, err := OpenTablebase(syzygyTestdata)
+	require.NoError(t, err)
+	defer tb.Close()
+
+	move, err := tb.BestMove("7k/8/6K1/8/8/8/8/1Q6 w - - 0 1")
+	require.NoError(t, err)
+	assert.Equal(t, TablebaseMove{Move: "b1b8", WDL: WDLWin, DTZ: 1, Mate: true}, move)
+
+	move, err = tb.BestMove("8/4P3/5K2/8/8/8/8/k7 w - - 0 1")
+	require.NoError(t, err)
+	assert.Equal(t, TablebaseMove{Move: "e7e8q", WDL: WDLWin, DTZ: 1}, move)
+
+	// The winning side heads for mate, the losing side holds out
+	for _, fen := range []string{"8/8/8/4k3/8/8/8/R3K3 w - - 0 1", "8/8/8/4k3/8/8/8/R3K3 b - - 0 1"} {
+		dtz, err := tb.ProbeDTZ(fen)
+		require.NoError(t, err)
+		move, err := tb.BestMove(fen)
+		require.NoError(t, err)
+		assert.Equal(t, dtz, move.DTZ, fen)
+
+		pos := newTBPosition(NewBoardFromFEN(fen))
+		for _, m := range pos.moves() {
+			if m.uci() == move.Move {
+				pos.play(m)
+			}
+		}
+		after, err := tb.probeDTZ(pos)
+		require.NoError(t, err)
+		assert.Equal(t, -(dtz - signOf(dtz)), after, fen)
+	}
+
+	_, err = tb.BestMove("7k/6Q1/6K1/8/8/8/8/8 b - - 0 1")
+	assert.ErrorIs(t, err, ErrNotInTablebase)
+}
+
+func TestMaterialSignature(t *testing.T) {
+	assert.Equal(t, "KQRRBBNNPPPPPPPPvKQRRBBNNPPPPPPPP", MaterialSignature(startFEN))
+	assert.Equal(t, "KRPvKR", MaterialSignature("8/8/3k4/3r4/8/3PK3/3R4/8 b - - 0 1"))
+	assert.Equal(t, "KQvK", MaterialSignature("8/8/8/3qk3/8/8/8/4K3 w - - 0 1"))
+
+	// Sides of equal value are ordered by their pieces, whatever their color
+	assert.Equal(t, "KBvKN", MaterialSignature("8/8/3k4/3b4/8/3NK3/8/8 w - - 0 1"))
+	assert.Equal(t, "KBvKN", MaterialSignature("8/8/3k4/3n4/8/3BK3/8/8 w - - 0 1"))
+	assert.Equal(t, "KRBvKRN", MaterialSignature("8/8/3k4/2rb4/8/2RNK3/8/8 w - - 0 1"))
+
+	// More pieces come first even when they are worth less
+	assert.Equal(t, "KBPvKR", MaterialSignature("8/8/3k4/3r4/8/3BK3/3P4/8 w - - 0 1"))
+}
+
+func TestParseMaterialSignature(t *testing.T) {
+	for text, want := range map[string]string{
+		"R+P vs R": "KRPvKR",
+		"r vs rp":  "KRPvKR",
+		"KRPvKR":   "KRPvKR",
+		"PQ v":     "KQPvK",
+		"B+N vs":   "KBNvK",
+		"B vs N":   "KBvKN",
+		"N vs B":   "KBvKN",
+		"R vs B+P": "KBPvKR",
+	} {
+		signature, err := ParseMaterialSignature(text)
+		require.NoError(t, err, text)
+		assert.Equal(t, want, signature, text)
+	}
+
+	// A query names positions the way they were indexed, whichever color
+	// has the knight
+	for _, fen := range []string{"8/8/3k4/3b4/8/3NK3/8/8 w - - 0 1", "8/8/3k4/3n4/8/3BK3/8/8 b - - 0 1"} {
+		signature, err := ParseMaterialSignature("N vs B")
+		require.NoError(t, err)
+		assert.Equal(t, MaterialSignature(fen), signature, fen)
+	}
+
+	for _, text := range []string{"R+P", "R vs R vs R", "X vs R", "KK vs R"} {
+		_, err := ParseMaterialSignature(text)
+		assert.Error(t, err, text)
+	}
+}
@@ -4,15 +4,19 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
-	JWT      JWTConfig
+	Server         ServerConfig
+	Database       DatabaseConfig
+	Redis          RedisConfig
+	JWT            JWTConfig
+	Syzygy         SyzygyConfig
+	Spectator      SpectatorConfig
+	Correspondence CorrespondenceConfig
 }
 
 type ServerConfig struct {
@@ -41,6 +45,27 @@ type JWTConfig struct {
 	Secret string
 }
 
+// SyzygyConfig locates the endgame tablebase files, which adjudicate games
+// that reach the endgames they cover. An empty path disables tablebase
+// probing.
+type SyzygyConfig struct {
+	Path string
+}
+
+// SpectatorConfig controls the live evaluation shown to spectators. Delay is
+// how long after a move its evaluation is published; EvalSearches caps the
+// searches run at once for all games, zero meaning half the CPUs.
+type SpectatorConfig struct {
+	Delay        time.Duration
+	EvalSearches int
+}
+
+// CorrespondenceConfig controls how often correspondence deadlines are
+// checked for forfeits and reminders.
+type CorrespondenceConfig struct {
+	CheckInterval time.Duration
+}
+
 func Load() (*Config, error) {
 	_ = godotenv.Load() // Load environment variables from .env file if it exists
 	cfg := &Config{
@@ -66,6 +91,16 @@ func Load() (*Config, error) {
 		JWT: JWTConfig{
 			Secret: getEnv("JWT_SECRET", ""),
 		},
+		Syzygy: SyzygyConfig{
+			Path: getEnv("SYZYGY_PATH", ""),
+		},
+		Spectator: SpectatorConfig{
+			Delay:        time.Duration(getEnvInt("SPECTATOR_DELAY_SECONDS", 15)) * time.Second,
+			EvalSearches: getEnvInt("SPECTATOR_EVAL_SEARCHES", 0),
+		},
+		Correspondence: CorrespondenceConfig{
+			CheckInterval: time.Duration(getEnvInt("CORRESPONDENCE_CHECK_SECONDS", 60)) * time.Second,
+		},
 	}
 
 	// Validate required configuration
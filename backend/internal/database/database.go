@@ -27,8 +27,28 @@ func Initialize(cfg config.DatabaseConfig) (*gorm.DB, error) {
 		&models.User{},
 		&models.Game{},
 		&models.GameMove{},
+		&models.MoveAnnotation{},
+		&models.GameEvent{},
+		&models.Vacation{},
+		&models.ConditionalMove{},
+		&models.ConsultationMember{},
+		&models.ExplorerEntry{},
+		&models.GamePosition{},
+		&models.GameAnalysis{},
+		&models.MoveAnalysis{},
+		&models.Puzzle{},
+		&models.PuzzleRating{},
+		&models.PuzzleAttempt{},
+		&models.AnalysisRoom{},
+		&models.AnalysisNode{},
+		&models.Study{},
+		&models.StudyMember{},
+		&models.StudyChapter{},
+		&models.StudyRevision{},
 		&models.Avatar{},
 		&models.Arena{},
+		&models.Simul{},
+		&models.SimulParticipant{},
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
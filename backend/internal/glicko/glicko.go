@@ -0,0 +1,129 @@
+// Package glicko implements the Glicko-2 rating system, as described in Mark
+// Glickman's "Example of the Glicko-2 system". Every rated event (a game, a
+// puzzle attempt) is treated as its own rating period.
+package glicko
+
+import "math"
+
+const (
+	DefaultRating     = 1500.0
+	DefaultDeviation  = 350.0
+	DefaultVolatility = 0.06
+
+	// MinDeviation keeps ratings from settling so far that they stop moving.
+	MinDeviation = 45.0
+
+	// tau constrains how fast volatility changes.
+	tau = 0.5
+
+	scale            = 173.7178
+	convergenceLimit = 0.000001
+)
+
+type Rating struct {
+	Rating     float64 `json:"rating"`
+	Deviation  float64 `json:"deviation"`
+	Volatility float64 `json:"volatility"`
+}
+
+// Default is the rating of a newcomer.
+func Default() Rating {
+	return Rating{Rating: DefaultRating, Deviation: DefaultDeviation, Volatility: DefaultVolatility}
+}
+
+// Result is the outcome of one event against an opponent: 1 for a win, 0.5
+// for a draw and 0 for a loss.
+type Result struct {
+	Opponent Rating
+	Score    float64
+}
+
+// Expected returns the score player is expected to make against opponent.
+func Expected(player, opponent Rating) float64 {
+	mu, _ := toGlicko2(player)
+	opponentMu, opponentPhi := toGlicko2(opponent)
+	return expected(mu, opponentMu, opponentPhi)
+}
+
+// Update returns player's rating after the results of one rating period.
+// With no results only the deviation grows.
+func Update(player Rating, results []Result) Rating {
+	mu, phi := toGlicko2(player)
+	sigma := player.Volatility
+
+	if len(results) == 0 {
+		return fromGlicko2(mu, math.Sqrt(phi*phi+sigma*sigma), sigma)
+	}
+
+	var variance, improvement float64
+	for _, result := range results {
+		opponentMu, opponentPhi := toGlicko2(result.Opponent)
+		g := g(opponentPhi)
+		e := expected(mu, opponentMu, opponentPhi)
+		variance += g * g * e * (1 - e)
+		improvement += g * (result.Score - e)
+	}
+	v := 1 / variance
+	delta := v * improvement
+
+	sigma = newVolatility(sigma, phi, v, delta)
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * improvement
+
+	return fromGlicko2(mu, phi, sigma)
+}
+
+func toGlicko2(r Rating) (mu, phi float64) {
+	return (r.Rating - DefaultRating) / scale, r.Deviation / scale
+}
+
+func fromGlicko2(mu, phi, sigma float64) Rating {
+	deviation := math.Max(MinDeviation, math.Min(DefaultDeviation, phi*scale))
+	return Rating{Rating: mu*scale + DefaultRating, Deviation: deviation, Volatility: sigma}
+}
+
+func g(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func expected(mu, opponentMu, opponentPhi float64) float64 {
+	return 1 / (1 + math.Exp(-g(opponentPhi)*(mu-opponentMu)))
+}
+
+// newVolatility solves for the new volatility with the Illinois algorithm
+// (step 5 of the paper).
+func newVolatility(sigma, phi, v, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(tau*tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*tau) < 0 {
+			k++
+		}
+		B = a - k*tau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > convergenceLimit {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+
+	return math.Exp(A / 2)
+}
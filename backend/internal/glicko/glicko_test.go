@@ -0,0 +1,46 @@
+package glicko
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdate_GlickmanExample(t *testing.T) {
+	player := Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+
+	updated := Update(player, []Result{
+		{Opponent: Rating{Rating: 1400, Deviation: 30, Volatility: 0.06}, Score: 1},
+		{Opponent: Rating{Rating: 1550, Deviation: 100, Volatility: 0.06}, Score: 0},
+		{Opponent: Rating{Rating: 1700, Deviation: 300, Volatility: 0.06}, Score: 0},
+	})
+
+	assert.InDelta(t, 1464.06, updated.Rating, 0.01)
+	assert.InDelta(t, 151.52, updated.Deviation, 0.01)
+	assert.InDelta(t, 0.05999, updated.Volatility, 0.00001)
+}
+
+func TestUpdate_NoResults(t *testing.T) {
+	player := Rating{Rating: 1700, Deviation: 50, Volatility: 0.06}
+
+	updated := Update(player, nil)
+
+	assert.Equal(t, 1700.0, updated.Rating)
+	assert.Greater(t, updated.Deviation, 50.0)
+}
+
+func TestUpdate_DeviationBounds(t *testing.T) {
+	settled := Rating{Rating: 1500, Deviation: MinDeviation, Volatility: 0.06}
+	opponent := Default()
+	for i := 0; i < 50; i++ {
+		settled = Update(settled, []Result{{Opponent: opponent, Score: 0.5}})
+	}
+	assert.GreaterOrEqual(t, settled.Deviation, MinDeviation)
+
+	assert.LessOrEqual(t, Update(Default(), nil).Deviation, DefaultDeviation)
+}
+
+func TestExpected(t *testing.T) {
+	assert.InDelta(t, 0.5, Expected(Default(), Default()), 0.0001)
+	assert.Greater(t, Expected(Rating{Rating: 1800, Deviation: 60}, Rating{Rating: 1500, Deviation: 60}), 0.8)
+}
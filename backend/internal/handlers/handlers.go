@@ -1,31 +1,57 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"arcane-chess/internal/auth"
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/render"
 	"arcane-chess/internal/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
 )
 
 type Handler struct {
 	gameService      *services.GameService
 	userService      *services.UserService
 	avatarService    *services.AvatarService
+	arenaService     *services.ArenaService
+	explorerService  *services.ExplorerService
+	analysisService  *services.AnalysisService
+	puzzleService    *services.PuzzleService
+	renderService    *services.RenderService
+	analysisRooms    *services.AnalysisRoomService
+	studyService     *services.StudyService
+	positionSearch   *services.PositionSearchService
+	simulService     *services.SimulService
 	websocketManager *services.WebSocketManager
 	upgrader         websocket.Upgrader
 	jwtSecret        string
 }
 
-func NewHandler(gameService *services.GameService, userService *services.UserService, avatarService *services.AvatarService, jwtSecret string) *Handler {
+func NewHandler(gameService *services.GameService, userService *services.UserService, avatarService *services.AvatarService, arenaService *services.ArenaService, explorerService *services.ExplorerService, analysisService *services.AnalysisService, puzzleService *services.PuzzleService, renderService *services.RenderService, analysisRooms *services.AnalysisRoomService, studyService *services.StudyService, positionSearch *services.PositionSearchService, simulService *services.SimulService, jwtSecret string) *Handler {
 	return &Handler{
 		gameService:      gameService,
 		userService:      userService,
 		avatarService:    avatarService,
-		websocketManager: services.NewWebSocketManager(),
+		arenaService:     arenaService,
+		explorerService:  explorerService,
+		analysisService:  analysisService,
+		puzzleService:    puzzleService,
+		renderService:    renderService,
+		analysisRooms:    analysisRooms,
+		studyService:     studyService,
+		positionSearch:   positionSearch,
+		simulService:     simulService,
+		websocketManager: services.NewWebSocketManager(gameService, analysisRooms, studyService),
 		jwtSecret:        jwtSecret,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
@@ -35,6 +61,11 @@ func NewHandler(gameService *services.GameService, userService *services.UserSer
 	}
 }
 
+// WebSocketHub returns the hub shared by all WebSocket connections.
+func (h *Handler) WebSocketHub() *services.Hub {
+	return h.websocketManager.Hub
+}
+
 func (h *Handler) SetupRoutes(router *gin.Engine) {
 	// CORS middleware
 	router.Use(func(c *gin.Context) {
@@ -67,8 +98,32 @@ func (h *Handler) SetupRoutes(router *gin.Engine) {
 			games.GET("/", h.GetGames)
 			games.POST("/", h.AuthMiddleware(), h.CreateGame)
 			games.GET("/:id", h.GetGame)
+			games.GET("/:id/replay", h.ReplayGame)
+			games.GET("/:id/analysis", h.GetGameAnalysis)
+			games.GET("/:id/gif", h.GetGameGIF)
+			games.GET("/:id/pgn", h.ExportGamePGN)
+			games.POST("/:id/pgn", h.AuthMiddleware(), h.ImportGameAnnotations)
+			games.PUT("/:id/moves/:ply/annotation", h.AuthMiddleware(), h.AnnotateGameMove)
 			games.POST("/:id/join", h.AuthMiddleware(), h.JoinGame)
 			games.POST("/:id/move", h.AuthMiddleware(), h.MakeMove)
+			games.POST("/:id/resign", h.AuthMiddleware(), h.Resign)
+			games.POST("/:id/draw/offer", h.AuthMiddleware(), h.OfferDraw)
+			games.POST("/:id/draw/accept", h.AuthMiddleware(), h.AcceptDraw)
+			games.POST("/:id/draw/decline", h.AuthMiddleware(), h.DeclineDraw)
+			games.GET("/:id/conditional-moves", h.AuthMiddleware(), h.GetConditionalMoves)
+			games.PUT("/:id/conditional-moves", h.AuthMiddleware(), h.SetConditionalMoves)
+			games.GET("/:id/teams", h.GetConsultationTeams)
+			games.POST("/:id/teams/:color", h.AuthMiddleware(), h.JoinConsultationTeam)
+			games.POST("/:id/vote", h.AuthMiddleware(), h.VoteMove)
+			games.POST("/:id/premoves", h.AuthMiddleware(), h.QueuePremove)
+			games.DELETE("/:id/premoves", h.AuthMiddleware(), h.CancelPremoves)
+		}
+
+		// Correspondence vacations, which put back the player's deadlines
+		correspondence := api.Group("/correspondence")
+		{
+			correspondence.GET("/vacation", h.AuthMiddleware(), h.GetVacation)
+			correspondence.POST("/vacation", h.AuthMiddleware(), h.TakeVacation)
 		}
 
 		// Arena routes
@@ -77,14 +132,69 @@ func (h *Handler) SetupRoutes(router *gin.Engine) {
 			arenas.GET("/", h.GetArenas)
 			arenas.GET("/:id", h.GetArena)
 			arenas.GET("/:id/games", h.GetArenaGames)
+			arenas.GET("/:id/simuls", h.GetArenaSimuls)
+		}
+
+		// Simultaneous exhibitions; the arena's channel carries their results
+		simuls := api.Group("/simuls")
+		{
+			simuls.POST("/", h.AuthMiddleware(), h.CreateSimul)
+			simuls.GET("/:id", h.GetSimul)
+			simuls.POST("/:id/join", h.AuthMiddleware(), h.JoinSimul)
+			simuls.POST("/:id/leave", h.AuthMiddleware(), h.LeaveSimul)
+			simuls.POST("/:id/start", h.AuthMiddleware(), h.StartSimul)
+			simuls.GET("/:id/queue", h.AuthMiddleware(), h.GetSimulQueue)
+		}
+
+		// Opening explorer
+		api.GET("/explorer", h.ExploreOpenings)
+
+		// Position search over archived games
+		api.GET("/positions/search", h.SearchPositions)
+
+		// Puzzle routes
+		puzzles := api.Group("/puzzles")
+		{
+			puzzles.GET("/next", h.AuthMiddleware(), h.NextPuzzle)
+			puzzles.GET("/rating", h.AuthMiddleware(), h.GetPuzzleRating)
+			puzzles.POST("/attempts/:id/move", h.AuthMiddleware(), h.SubmitPuzzleMove)
+		}
+
+		// Shared analysis boards; edits are made over the WebSocket
+		analysisRooms := api.Group("/analysis-rooms")
+		{
+			analysisRooms.POST("/", h.AuthMiddleware(), h.CreateAnalysisRoom)
+			analysisRooms.GET("/:id", h.GetAnalysisRoom)
+			analysisRooms.GET("/:id/pgn", h.ExportAnalysisRoomPGN)
 		}
 
+		// Studies; members follow changes on the WebSocket
+		studies := api.Group("/studies", h.AuthMiddleware())
+		{
+			studies.POST("/", h.CreateStudy)
+			studies.GET("/:id", h.GetStudy)
+			studies.PUT("/:id", h.UpdateStudy)
+			studies.DELETE("/:id", h.DeleteStudy)
+			studies.GET("/:id/pgn", h.ExportStudyPGN)
+			studies.POST("/:id/pgn", h.ImportStudyPGN)
+			studies.PUT("/:id/members/:userId", h.SetStudyMember)
+			studies.DELETE("/:id/members/:userId", h.RemoveStudyMember)
+			studies.POST("/:id/chapters", h.AddStudyChapter)
+			studies.PUT("/:id/chapters/:chapterId", h.UpdateStudyChapter)
+			studies.DELETE("/:id/chapters/:chapterId", h.DeleteStudyChapter)
+			studies.PUT("/:id/chapters/:chapterId/annotation", h.AnnotateStudyMove)
+			studies.GET("/:id/chapters/:chapterId/history", h.GetStudyChapterHistory)
+		}
+
+		// Board diagrams
+		api.GET("/render/board", h.RenderBoard)
+
 		// Avatar routes
 		avatars := api.Group("/avatars")
 		{
-			avatars.GET("/me", h.GetMyAvatar)
-			avatars.PUT("/me", h.UpdateAvatar)
-			avatars.POST("/me/position", h.UpdateAvatarPosition)
+			avatars.GET("/me", h.AuthMiddleware(), h.GetMyAvatar)
+			avatars.PUT("/me", h.AuthMiddleware(), h.UpdateAvatar)
+			avatars.POST("/me/position", h.AuthMiddleware(), h.UpdateAvatarPosition)
 		}
 	}
 
@@ -257,26 +367,25 @@ func (h *Handler) GetGames(c *gin.Context) {
 
 func (h *Handler) CreateGame(c *gin.Context) {
 	// Get user from context (set by auth middleware)
-	userIDInterface, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-		return
-	}
-	
-	userIDStr, ok := userIDInterface.(string)
+	userID, ok := currentUserID(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
-		return
-	}
-
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
 		return
 	}
 
 	var createGameRequest struct {
-		ArenaID string `json:"arena_id" binding:"required"`
+		ArenaID     string             `json:"arena_id" binding:"required"`
+		Variant     models.GameVariant `json:"variant"`
+		DaysPerMove int                `json:"days_per_move"` // makes it a correspondence game
+		VoteWindow  int                `json:"vote_window"`   // makes it a consultation game
+		TimeControl int                `json:"time_control"`  // seconds for each side, or white's under time odds
+
+		// Odds, which make the game casual
+		BlackTimeControl int             `json:"black_time_control"`
+		WhiteIncrement   int             `json:"white_increment"`
+		BlackIncrement   int             `json:"black_increment"`
+		Handicap         models.Handicap `json:"handicap"`
+		HandicapSide     string          `json:"handicap_side"`
+		Casual           bool            `json:"casual"`
 	}
 
 	if err := c.ShouldBindJSON(&createGameRequest); err != nil {
@@ -290,7 +399,24 @@ func (h *Handler) CreateGame(c *gin.Context) {
 		return
 	}
 
-	game, err := h.gameService.CreateGame(arenaID, userID)
+	game, err := h.gameService.CreateGame(arenaID, userID, services.GameOptions{
+		Variant:     createGameRequest.Variant,
+		DaysPerMove: createGameRequest.DaysPerMove,
+		VoteWindow:  createGameRequest.VoteWindow,
+		TimeControl: createGameRequest.TimeControl,
+
+		BlackTimeControl: createGameRequest.BlackTimeControl,
+		WhiteIncrement:   createGameRequest.WhiteIncrement,
+		BlackIncrement:   createGameRequest.BlackIncrement,
+		Handicap:         createGameRequest.Handicap,
+		HandicapSide:     createGameRequest.HandicapSide,
+		Casual:           createGameRequest.Casual,
+	})
+	if errors.Is(err, services.ErrUnknownVariant) || errors.Is(err, services.ErrInvalidDaysPerMove) ||
+		errors.Is(err, services.ErrInvalidVoteWindow) || errors.Is(err, services.ErrInvalidOdds) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create game"})
 		return
@@ -300,6 +426,16 @@ func (h *Handler) CreateGame(c *gin.Context) {
 		"id":       game.ID,
 		"status":   game.Status,
 		"arena_id": game.ArenaID,
+		"variant":  game.Variant,
+		"days_per_move": game.DaysPerMove,
+		"vote_window": game.VoteWindow,
+		"time_control": game.TimeControl,
+		"black_time_control": game.BlackTimeControl,
+		"white_increment": game.WhiteIncrement,
+		"black_increment": game.BlackIncrement,
+		"handicap": game.Handicap,
+		"handicap_side": game.HandicapSide,
+		"casual": game.Casual,
 		"white_player_id": game.WhitePlayerID,
 		"black_player_id": game.BlackPlayerID,
 		"current_turn": game.CurrentTurn,
@@ -308,96 +444,1620 @@ func (h *Handler) CreateGame(c *gin.Context) {
 }
 
 func (h *Handler) GetGame(c *gin.Context) {
-	gameID := c.Param("id")
-	c.JSON(200, gin.H{
-		"id":     gameID,
-		"status": "active",
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	game, err := h.gameService.GetGame(gameID)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, game)
+}
+
+// ReplayGame returns the game rebuilt from its event log, optionally only up
+// to the ?ply= half-move.
+func (h *Handler) ReplayGame(c *gin.Context) {
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	ply := -1
+	if value := c.Query("ply"); value != "" {
+		ply, err = strconv.Atoi(value)
+		if err != nil || ply < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ply must be a non-negative integer"})
+			return
+		}
+	}
+
+	replay, err := h.gameService.ReplayGame(gameID, ply)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"game": replay.Game,
+		"ply":  replay.Ply,
 	})
 }
 
+// GetGameAnalysis returns the computer analysis of a finished game. Analysis
+// runs in the background once the game ends, so it may still be running.
+func (h *Handler) GetGameAnalysis(c *gin.Context) {
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	analysis, err := h.analysisService.GetAnalysis(gameID)
+	if err != nil {
+		if errors.Is(err, services.ErrAnalysisNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch analysis"})
+		return
+	}
+
+	c.JSON(http.StatusOK, analysis)
+}
+
 func (h *Handler) JoinGame(c *gin.Context) {
-	gameID := c.Param("id")
-	c.JSON(200, gin.H{
-		"id":      gameID,
-		"status":  "active",
-		"message": "Joined game successfully",
-	})
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	if _, err := h.gameService.JoinGame(gameID, userID); err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	game, err := h.gameService.GetGame(gameID)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, game)
 }
 
 func (h *Handler) MakeMove(c *gin.Context) {
-	gameID := c.Param("id")
-	c.JSON(200, gin.H{
-		"game_id": gameID,
-		"move":    "e2e4",
-		"status":  "success",
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	// Crazyhouse drops name the piece instead of a from square
+	var moveRequest struct {
+		From string `json:"from" binding:"required_without=Drop,omitempty,len=2"`
+		To   string `json:"to" binding:"required,len=2"`
+		Drop string `json:"drop" binding:"omitempty,oneof=P N B R Q"`
+	}
+
+	if err := c.ShouldBindJSON(&moveRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var move *models.GameMove
+	if moveRequest.Drop != "" {
+		move, err = h.gameService.MakeDrop(gameID, userID, moveRequest.Drop, moveRequest.To)
+	} else {
+		move, err = h.gameService.MakeMove(gameID, userID, moveRequest.From, moveRequest.To)
+	}
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	game, err := h.gameService.GetGame(gameID)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"move": move,
+		"game": game,
 	})
 }
 
-// Arena handlers
-func (h *Handler) GetArenas(c *gin.Context) {
-	c.JSON(200, gin.H{
-		"arenas": []gin.H{
-			{
-				"id":          "arena-1",
-				"name":        "Mystic Sanctum",
-				"theme":       "mystic",
-				"players":     42,
-				"max_players": 100,
-			},
-		},
-	})
+func (h *Handler) Resign(c *gin.Context) {
+	h.gameAction(c, h.gameService.Resign)
 }
 
-func (h *Handler) GetArena(c *gin.Context) {
-	arenaID := c.Param("id")
-	c.JSON(200, gin.H{
-		"id":          arenaID,
-		"name":        "Mystic Sanctum",
-		"theme":       "mystic",
-		"players":     42,
-		"max_players": 100,
-	})
+func (h *Handler) OfferDraw(c *gin.Context) {
+	h.gameAction(c, h.gameService.OfferDraw)
 }
 
-func (h *Handler) GetArenaGames(c *gin.Context) {
-	arenaID := c.Param("id")
-	c.JSON(200, gin.H{
-		"arena_id": arenaID,
-		"games":    []gin.H{},
-	})
+func (h *Handler) AcceptDraw(c *gin.Context) {
+	h.gameAction(c, h.gameService.AcceptDraw)
 }
 
-// Avatar handlers
-func (h *Handler) GetMyAvatar(c *gin.Context) {
-	c.JSON(200, gin.H{
-		"id":           "avatar-123",
-		"user_id":      "user-123",
-		"model_type":   "wizard",
-		"color_scheme": "blue",
-		"position_x":   0,
-		"position_y":   0,
-		"position_z":   0,
-	})
+func (h *Handler) DeclineDraw(c *gin.Context) {
+	h.gameAction(c, h.gameService.DeclineDraw)
 }
 
-func (h *Handler) UpdateAvatar(c *gin.Context) {
-	c.JSON(200, gin.H{
-		"message": "Avatar updated successfully",
-	})
+// gameAction runs a player action that takes the game ID from the path and
+// responds with the resulting game state.
+func (h *Handler) gameAction(c *gin.Context, action func(gameID, playerID uuid.UUID) (*models.Game, error)) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	game, err := action(gameID, userID)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, game)
 }
 
-func (h *Handler) UpdateAvatarPosition(c *gin.Context) {
-	c.JSON(200, gin.H{
-		"message": "Position updated successfully",
-	})
+// annotationRequest is the body of an edit to a move's annotation.
+type annotationRequest struct {
+	Comment    string        `json:"comment"`
+	NAGs       models.NAGs   `json:"nags"`
+	Arrows     models.Shapes `json:"arrows"`     // e.g. "Ge2e4"
+	Highlights models.Shapes `json:"highlights"` // e.g. "Rd4"
 }
 
-// WebSocket handler
-func (h *Handler) HandleWebSocket(c *gin.Context) {
-	// Get user info from query parameters (could also come from JWT token)
-	userID := c.Query("user_id")
-	username := c.Query("username")
-	
+func (r *annotationRequest) annotation() models.MoveAnnotation {
+	return models.MoveAnnotation{Comment: r.Comment, NAGs: r.NAGs, Arrows: r.Arrows, Highlights: r.Highlights}
+}
+
+// AnnotateGameMove replaces the annotation on the move a player played at
+// the :ply half-move of a finished game. An empty annotation removes it.
+func (h *Handler) AnnotateGameMove(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+	ply, err := strconv.Atoi(c.Param("ply"))
+	if err != nil || ply < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ply must be a positive integer"})
+		return
+	}
+
+	var request annotationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	annotation, err := h.gameService.AnnotateMove(gameID, userID, ply, request.annotation())
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"annotation": annotation})
+}
+
+// ExportGamePGN returns a game with its annotations as PGN.
+func (h *Handler) ExportGamePGN(c *gin.Context) {
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	pgn, err := h.gameService.ExportPGN(gameID)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/x-chess-pgn", []byte(pgn))
+}
+
+// ImportGameAnnotations replaces a finished game's annotations with those in
+// the PGN request body, which must be the same game.
+func (h *Handler) ImportGameAnnotations(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxPGNImportSize)
+	game, err := h.gameService.ImportAnnotations(gameID, userID, body)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, game)
+}
+
+// GetConditionalMoves returns the lines the player has queued in a
+// correspondence game.
+func (h *Handler) GetConditionalMoves(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	lines, err := h.gameService.ConditionalMoves(gameID, userID)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lines": lines})
+}
+
+// SetConditionalMoves replaces the player's queued lines in a correspondence
+// game, each the opponent's move followed by the reply, in coordinates such
+// as e7e5. An empty list clears them.
+func (h *Handler) SetConditionalMoves(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	var request struct {
+		Lines [][]string `json:"lines"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lines, err := h.gameService.SetConditionalMoves(gameID, userID, request.Lines)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lines": lines})
+}
+
+// QueuePremove queues a move to play as soon as the opponent has moved.
+func (h *Handler) QueuePremove(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	var request struct {
+		From string `json:"from"`
+		To   string `json:"to" binding:"required"`
+		Drop string `json:"drop"` // piece type dropped on to, in crazyhouse
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	premoves, err := h.gameService.QueuePremove(gameID, userID, request.From, request.To, request.Drop)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"premoves": premoves})
+}
+
+// CancelPremoves clears the player's queued premoves.
+func (h *Handler) CancelPremoves(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	if err := h.gameService.CancelPremoves(gameID, userID); err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetConsultationTeams returns the players on each side of a consultation
+// game.
+func (h *Handler) GetConsultationTeams(c *gin.Context) {
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	teams, err := h.gameService.ConsultationTeams(gameID)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, teams)
+}
+
+// JoinConsultationTeam puts the user on the :color team of a consultation
+// game.
+func (h *Handler) JoinConsultationTeam(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	member, err := h.gameService.JoinTeam(gameID, userID, c.Param("color"))
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, member)
+}
+
+// VoteMove votes for the next move of the user's team in a consultation
+// game. The game room sees the tally as vote_tally updates.
+func (h *Handler) VoteMove(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	var request struct {
+		From string `json:"from"`
+		To   string `json:"to" binding:"required"`
+		Drop string `json:"drop"` // piece type dropped on to, in crazyhouse
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tally, err := h.gameService.Vote(gameID, userID, request.From, request.To, request.Drop)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tally)
+}
+
+// GetVacation returns the player's vacation days left this year.
+func (h *Handler) GetVacation(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	vacation, err := h.gameService.Vacation(userID)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, vacation)
+}
+
+// TakeVacation spends some of the player's vacation days, putting back the
+// deadlines of their correspondence games.
+func (h *Handler) TakeVacation(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Days int `json:"days" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	vacation, err := h.gameService.TakeVacation(userID, request.Days)
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, vacation)
+}
+
+func (h *Handler) GetArenas(c *gin.Context) {
+	arenas, err := h.arenaService.GetPublicArenas()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch arenas"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"arenas": arenas,
+		"total":  len(arenas),
+	})
+}
+
+func (h *Handler) GetArena(c *gin.Context) {
+	arenaID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid arena ID format"})
+		return
+	}
+
+	arena, err := h.arenaService.GetArena(arenaID)
+	if err != nil {
+		respondArenaError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, arena)
+}
+
+func (h *Handler) GetArenaGames(c *gin.Context) {
+	arenaID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid arena ID format"})
+		return
+	}
+
+	if _, err := h.arenaService.GetArena(arenaID); err != nil {
+		respondArenaError(c, err)
+		return
+	}
+
+	games, err := h.gameService.GetActiveGames(arenaID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch games"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"arena_id": arenaID,
+		"games":    games,
+		"total":    len(games),
+	})
+}
+
+// GetArenaSimuls lists the simuls in an arena that are open or being played.
+func (h *Handler) GetArenaSimuls(c *gin.Context) {
+	arenaID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid arena ID format"})
+		return
+	}
+
+	simuls, err := h.simulService.ListSimuls(arenaID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch simuls"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"arena_id": arenaID,
+		"simuls":   simuls,
+		"total":    len(simuls),
+		"channel":  services.ArenaRoom(arenaID.String()),
+	})
+}
+
+// Explorer handlers
+
+// ExploreOpenings lists the moves played from the ?fen= position in our
+// games. Without a FEN it explores the starting position.
+func (h *Handler) ExploreOpenings(c *gin.Context) {
+	fen := c.DefaultQuery("fen", chess.StandardStartFEN)
+
+	result, err := h.explorerService.Explore(fen)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidFEN) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to explore position"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// SearchPositions finds the archived games that reached a position (?fen=),
+// a material signature (?material=R+P vs R) or a pawn structure (?pawns=, a
+// piece placement whose pawns are matched; ?exact_pawns=true to require no
+// other pawns). Pages are continued with ?after= set to the previous page's
+// next_cursor.
+func (h *Handler) SearchPositions(c *gin.Context) {
+	query := services.PositionQuery{
+		FEN:        c.Query("fen"),
+		Material:   c.Query("material"),
+		Pawns:      c.Query("pawns"),
+		ExactPawns: c.Query("exact_pawns") == "true",
+	}
+	if after := c.Query("after"); after != "" {
+		cursor, err := uuid.Parse(after)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		query.After = &cursor
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		query.Limit = n
+	}
+
+	result, err := h.positionSearch.Search(query)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPositionQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search positions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Puzzle handlers
+
+// NextPuzzle starts the user on a puzzle near their puzzle rating, or hands
+// back the one they left unfinished.
+func (h *Handler) NextPuzzle(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	challenge, err := h.puzzleService.NextPuzzle(userID)
+	if err != nil {
+		respondPuzzleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, challenge)
+}
+
+func (h *Handler) GetPuzzleRating(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	rating, err := h.puzzleService.GetRating(userID)
+	if err != nil {
+		respondPuzzleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rating)
+}
+
+func (h *Handler) SubmitPuzzleMove(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	attemptID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attempt ID format"})
+		return
+	}
+
+	var moveRequest struct {
+		From string `json:"from" binding:"required,len=2"`
+		To   string `json:"to" binding:"required,len=2"`
+	}
+	if err := c.ShouldBindJSON(&moveRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.puzzleService.SubmitMove(userID, attemptID, moveRequest.From, moveRequest.To)
+	if err != nil {
+		respondPuzzleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Avatar handlers
+func (h *Handler) GetMyAvatar(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	avatar, err := h.avatarService.GetAvatarByUserID(userID.String())
+	if err != nil {
+		respondAvatarError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, avatar)
+}
+
+func (h *Handler) UpdateAvatar(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var updateRequest struct {
+		Name        *string `json:"name" binding:"omitempty,min=1,max=50"`
+		ModelType   *string `json:"model_type" binding:"omitempty,max=50"`
+		ColorScheme *string `json:"color_scheme" binding:"omitempty,max=20"`
+		Accessories *string `json:"accessories"`
+		Animations  *string `json:"animations"`
+		IsVisible   *bool   `json:"is_visible"`
+	}
+
+	if err := c.ShouldBindJSON(&updateRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	avatar, err := h.avatarService.GetAvatarByUserID(userID.String())
+	if err != nil {
+		respondAvatarError(c, err)
+		return
+	}
+
+	if updateRequest.Name != nil {
+		avatar.Name = *updateRequest.Name
+	}
+	if updateRequest.ModelType != nil {
+		avatar.ModelType = *updateRequest.ModelType
+	}
+	if updateRequest.ColorScheme != nil {
+		avatar.ColorScheme = *updateRequest.ColorScheme
+	}
+	if updateRequest.Accessories != nil {
+		avatar.Accessories = *updateRequest.Accessories
+	}
+	if updateRequest.Animations != nil {
+		avatar.Animations = *updateRequest.Animations
+	}
+	if updateRequest.IsVisible != nil {
+		avatar.IsVisible = *updateRequest.IsVisible
+	}
+
+	if err := h.avatarService.UpdateAvatar(avatar); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update avatar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, avatar)
+}
+
+func (h *Handler) UpdateAvatarPosition(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var positionRequest struct {
+		X        float64 `json:"x"`
+		Y        float64 `json:"y"`
+		Z        float64 `json:"z"`
+		Rotation float64 `json:"rotation"`
+	}
+
+	if err := c.ShouldBindJSON(&positionRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.avatarService.UpdateAvatarPosition(userID.String(), positionRequest.X, positionRequest.Y, positionRequest.Z, positionRequest.Rotation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update position"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Position updated successfully",
+		"position_x": positionRequest.X,
+		"position_y": positionRequest.Y,
+		"position_z": positionRequest.Z,
+		"rotation_y": positionRequest.Rotation,
+	})
+}
+
+// currentUserID returns the authenticated user's ID set by AuthMiddleware.
+// It writes an error response and returns false when none is available.
+func currentUserID(c *gin.Context) (uuid.UUID, bool) {
+	userIDInterface, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return uuid.Nil, false
+	}
+
+	userIDStr, ok := userIDInterface.(string)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return uuid.Nil, false
+	}
+
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return uuid.Nil, false
+	}
+
+	return userID, true
+}
+
+// respondGameError maps GameService errors to HTTP status codes.
+func respondGameError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, services.ErrGameNotFound), errors.Is(err, services.ErrMoveNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNotGamePlayer):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNotPlayerTurn),
+		errors.Is(err, services.ErrGameNotActive),
+		errors.Is(err, services.ErrGameNotJoinable),
+		errors.Is(err, services.ErrAlreadyInGame),
+		errors.Is(err, services.ErrTimeExpired),
+		errors.Is(err, services.ErrNoDrawOffer),
+		errors.Is(err, services.ErrGameNotOver),
+		errors.Is(err, services.ErrNotCorrespondence),
+		errors.Is(err, services.ErrNotConsultation),
+		errors.Is(err, services.ErrVoteRequired),
+		errors.Is(err, services.ErrNotEnoughVacation),
+		errors.Is(err, services.ErrConcurrentUpdate):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidMove):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidAnnotation), errors.Is(err, services.ErrInvalidPGN),
+		errors.Is(err, services.ErrInvalidConditionalMoves), errors.Is(err, services.ErrInvalidVacation),
+		errors.Is(err, services.ErrInvalidTeam), errors.Is(err, services.ErrInvalidPremove):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "PGN file is too large"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+func respondArenaError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrArenaNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch arena"})
+}
+
+func respondPuzzleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrNoPuzzleAvailable),
+		errors.Is(err, services.ErrPuzzleAttemptNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPuzzleAttemptFinished),
+		errors.Is(err, services.ErrConcurrentUpdate):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidMove):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+func respondAvatarError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "avatar not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch avatar"})
+}
+
+// Analysis room handlers
+
+func (h *Handler) CreateAnalysisRoom(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name string `json:"name"`
+		FEN  string `json:"fen"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	room, err := h.analysisRooms.CreateRoom(userID, request.Name, request.FEN)
+	if err != nil {
+		respondAnalysisRoomError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, room)
+}
+
+// GetAnalysisRoom returns a room with its whole move tree. Clients join the
+// room's channel on the WebSocket to follow edits.
+func (h *Handler) GetAnalysisRoom(c *gin.Context) {
+	roomID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room ID format"})
+		return
+	}
+
+	room, err := h.analysisRooms.GetRoom(roomID)
+	if err != nil {
+		respondAnalysisRoomError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"room":    room,
+		"channel": services.AnalysisRoomChannel(room.ID.String()),
+	})
+}
+
+// ExportAnalysisRoomPGN returns the room's tree as PGN, or with ?node= the
+// line to that node and everything after it.
+func (h *Handler) ExportAnalysisRoomPGN(c *gin.Context) {
+	roomID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room ID format"})
+		return
+	}
+
+	var nodeID *uuid.UUID
+	if value := c.Query("node"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid node ID format"})
+			return
+		}
+		nodeID = &id
+	}
+
+	pgn, err := h.analysisRooms.ExportPGN(roomID, nodeID)
+	if err != nil {
+		respondAnalysisRoomError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/x-chess-pgn", []byte(pgn))
+}
+
+func respondAnalysisRoomError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrAnalysisRoomNotFound), errors.Is(err, services.ErrAnalysisNodeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidFEN):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+func (h *Handler) CreateStudy(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	study, err := h.studyService.CreateStudy(userID, request.Name, request.Description, request.Public)
+	if err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, study)
+}
+
+// GetStudy returns a study with its chapters and members. Clients join the
+// study's channel on the WebSocket to follow changes.
+func (h *Handler) GetStudy(c *gin.Context) {
+	userID, studyID, ok := studyParams(c)
+	if !ok {
+		return
+	}
+
+	study, err := h.studyService.GetStudy(studyID, userID)
+	if err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"study":   study,
+		"channel": services.StudyChannel(study.ID.String()),
+	})
+}
+
+func (h *Handler) UpdateStudy(c *gin.Context) {
+	userID, studyID, ok := studyParams(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	study, err := h.studyService.UpdateStudy(studyID, userID, request.Name, request.Description, request.Public)
+	if err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	h.broadcastStudy(userID, services.StudyUpdate{StudyID: studyID, Op: "study", Study: study})
+	c.JSON(http.StatusOK, study)
+}
+
+func (h *Handler) DeleteStudy(c *gin.Context) {
+	userID, studyID, ok := studyParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.studyService.DeleteStudy(studyID, userID); err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	h.broadcastStudy(userID, services.StudyUpdate{StudyID: studyID, Op: "deleted"})
+	c.Status(http.StatusNoContent)
+}
+
+// SetStudyMember adds a user to a study or changes their role.
+func (h *Handler) SetStudyMember(c *gin.Context) {
+	userID, studyID, ok := studyParams(c)
+	if !ok {
+		return
+	}
+	memberID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var request struct {
+		Role models.StudyRole `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member, err := h.studyService.SetMember(studyID, userID, memberID, request.Role)
+	if err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	h.broadcastStudy(userID, services.StudyUpdate{StudyID: studyID, Op: "member", Member: member})
+	c.JSON(http.StatusOK, member)
+}
+
+func (h *Handler) RemoveStudyMember(c *gin.Context) {
+	userID, studyID, ok := studyParams(c)
+	if !ok {
+		return
+	}
+	memberID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	if err := h.studyService.RemoveMember(studyID, userID, memberID); err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	h.broadcastStudy(userID, services.StudyUpdate{StudyID: studyID, Op: "member_removed", UserID: &memberID})
+	c.Status(http.StatusNoContent)
+}
+
+// AddStudyChapter appends a chapter made from a PGN game, or an empty one on
+// the starting position when no PGN is given.
+func (h *Handler) AddStudyChapter(c *gin.Context) {
+	userID, studyID, ok := studyParams(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name string `json:"name"`
+		PGN  string `json:"pgn"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chapter, err := h.studyService.AddChapter(studyID, userID, request.Name, request.PGN)
+	if err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	h.broadcastStudy(userID, services.StudyUpdate{StudyID: studyID, Op: "chapter", Chapter: chapter})
+	c.JSON(http.StatusCreated, chapter)
+}
+
+// UpdateStudyChapter renames a chapter or replaces its moves. The request
+// names the version it was made on and gets 409 Conflict if the chapter has
+// changed since.
+func (h *Handler) UpdateStudyChapter(c *gin.Context) {
+	userID, studyID, ok := studyParams(c)
+	if !ok {
+		return
+	}
+	chapterID, err := uuid.Parse(c.Param("chapterId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chapter ID format"})
+		return
+	}
+
+	var request struct {
+		Name    string  `json:"name"`
+		PGN     *string `json:"pgn"`
+		Version int     `json:"version" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chapter, changes, err := h.studyService.UpdateChapter(studyID, chapterID, userID, request.Version, request.Name, request.PGN)
+	if err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	h.broadcastStudy(userID, services.StudyUpdate{StudyID: studyID, Op: "chapter", Chapter: chapter, Changes: changes})
+	c.JSON(http.StatusOK, gin.H{
+		"chapter": chapter,
+		"changes": changes,
+	})
+}
+
+// AnnotateStudyMove replaces the annotation of the chapter move at the end
+// of path, the moves in SAN leading to it. Like UpdateStudyChapter it names
+// the version the edit was made on.
+func (h *Handler) AnnotateStudyMove(c *gin.Context) {
+	userID, studyID, ok := studyParams(c)
+	if !ok {
+		return
+	}
+	chapterID, err := uuid.Parse(c.Param("chapterId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chapter ID format"})
+		return
+	}
+
+	var request struct {
+		annotationRequest
+		Path    []string `json:"path" binding:"required,min=1"`
+		Version int      `json:"version" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chapter, changes, err := h.studyService.AnnotateMove(studyID, chapterID, userID, request.Version, request.Path, request.annotation())
+	if err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	h.broadcastStudy(userID, services.StudyUpdate{StudyID: studyID, Op: "chapter", Chapter: chapter, Changes: changes})
+	c.JSON(http.StatusOK, gin.H{
+		"chapter": chapter,
+		"changes": changes,
+	})
+}
+
+func (h *Handler) DeleteStudyChapter(c *gin.Context) {
+	userID, studyID, ok := studyParams(c)
+	if !ok {
+		return
+	}
+	chapterID, err := uuid.Parse(c.Param("chapterId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chapter ID format"})
+		return
+	}
+
+	if err := h.studyService.DeleteChapter(studyID, chapterID, userID); err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	h.broadcastStudy(userID, services.StudyUpdate{StudyID: studyID, Op: "chapter_deleted", ChapterID: &chapterID})
+	c.Status(http.StatusNoContent)
+}
+
+// GetStudyChapterHistory lists a chapter's revisions, each with what changed.
+func (h *Handler) GetStudyChapterHistory(c *gin.Context) {
+	userID, studyID, ok := studyParams(c)
+	if !ok {
+		return
+	}
+	chapterID, err := uuid.Parse(c.Param("chapterId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chapter ID format"})
+		return
+	}
+
+	history, err := h.studyService.ChapterHistory(studyID, chapterID, userID)
+	if err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revisions": history})
+}
+
+// ExportStudyPGN writes the study's chapters as a multi-game PGN file.
+func (h *Handler) ExportStudyPGN(c *gin.Context) {
+	userID, studyID, ok := studyParams(c)
+	if !ok {
+		return
+	}
+
+	pgn, err := h.studyService.ExportPGN(studyID, userID)
+	if err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/x-chess-pgn", []byte(pgn))
+}
+
+// maxPGNImportSize bounds the PGN file an import reads.
+const maxPGNImportSize = 1 << 20
+
+// ImportStudyPGN adds a chapter for each game in the PGN request body.
+func (h *Handler) ImportStudyPGN(c *gin.Context) {
+	userID, studyID, ok := studyParams(c)
+	if !ok {
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxPGNImportSize)
+	chapters, err := h.studyService.ImportPGN(studyID, userID, body)
+	if err != nil {
+		respondStudyError(c, err)
+		return
+	}
+
+	for i := range chapters {
+		h.broadcastStudy(userID, services.StudyUpdate{StudyID: studyID, Op: "chapter", Chapter: &chapters[i]})
+	}
+	c.JSON(http.StatusCreated, gin.H{"chapters": chapters})
+}
+
+// studyParams reads the current user and the study ID from the path.
+func studyParams(c *gin.Context) (userID, studyID uuid.UUID, ok bool) {
+	if userID, ok = currentUserID(c); !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+	studyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid study ID format"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, studyID, true
+}
+
+// broadcastStudy tells the members following a study about a change.
+func (h *Handler) broadcastStudy(userID uuid.UUID, update services.StudyUpdate) {
+	room := services.StudyChannel(update.StudyID.String())
+	h.websocketManager.Hub.BroadcastToRoom(room, services.Message{
+		Type:   "study_update",
+		Room:   room,
+		UserID: userID.String(),
+		Data:   update,
+	})
+}
+
+func respondStudyError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, services.ErrStudyNotFound),
+		errors.Is(err, services.ErrStudyChapterNotFound),
+		errors.Is(err, services.ErrStudyMemberNotFound),
+		errors.Is(err, services.ErrMoveNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrStudyForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrStudyConflict), errors.Is(err, services.ErrStudyFull):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPGN),
+		errors.Is(err, services.ErrInvalidFEN),
+		errors.Is(err, services.ErrInvalidStudyRole),
+		errors.Is(err, services.ErrInvalidAnnotation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "PGN file is too large"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+// Simul handlers
+
+func (h *Handler) CreateSimul(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		ArenaID         string             `json:"arena_id" binding:"required"`
+		Name            string             `json:"name"`
+		Variant         models.GameVariant `json:"variant"`
+		HostColor       string             `json:"host_color"`
+		TimeControl     int                `json:"time_control"`
+		HostClock       *bool              `json:"host_clock"` // on unless false
+		MaxParticipants int                `json:"max_participants"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	arenaID, err := uuid.Parse(request.ArenaID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid arena_id format"})
+		return
+	}
+
+	simul, err := h.simulService.CreateSimul(arenaID, userID, services.SimulOptions{
+		Name:            request.Name,
+		Variant:         request.Variant,
+		HostColor:       request.HostColor,
+		TimeControl:     request.TimeControl,
+		NoHostClock:     request.HostClock != nil && !*request.HostClock,
+		MaxParticipants: request.MaxParticipants,
+	})
+	if err != nil {
+		respondSimulError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, simul)
+}
+
+// GetSimul returns a simul with its participants, their games and the score.
+func (h *Handler) GetSimul(c *gin.Context) {
+	simulID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid simul ID format"})
+		return
+	}
+
+	simul, err := h.simulService.GetSimul(simulID)
+	if err != nil {
+		respondSimulError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"simul":   simul,
+		"channel": services.ArenaRoom(simul.ArenaID.String()),
+	})
+}
+
+func (h *Handler) JoinSimul(c *gin.Context) {
+	userID, simulID, ok := simulParams(c)
+	if !ok {
+		return
+	}
+
+	participant, err := h.simulService.Join(simulID, userID)
+	if err != nil {
+		respondSimulError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, participant)
+}
+
+func (h *Handler) LeaveSimul(c *gin.Context) {
+	userID, simulID, ok := simulParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.simulService.Leave(simulID, userID); err != nil {
+		respondSimulError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// StartSimul starts the host's games against every participant.
+func (h *Handler) StartSimul(c *gin.Context) {
+	userID, simulID, ok := simulParams(c)
+	if !ok {
+		return
+	}
+
+	simul, games, err := h.simulService.Start(simulID, userID)
+	if err != nil {
+		respondSimulError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"simul": simul,
+		"games": games,
+	})
+}
+
+// GetSimulQueue returns the host's boards where it's their move, longest
+// waiting first.
+func (h *Handler) GetSimulQueue(c *gin.Context) {
+	userID, simulID, ok := simulParams(c)
+	if !ok {
+		return
+	}
+
+	games, err := h.simulService.HostQueue(simulID, userID)
+	if err != nil {
+		respondSimulError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"games": games,
+		"total": len(games),
+	})
+}
+
+func simulParams(c *gin.Context) (userID, simulID uuid.UUID, ok bool) {
+	if userID, ok = currentUserID(c); !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+	simulID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid simul ID format"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, simulID, true
+}
+
+func respondSimulError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrSimulNotFound),
+		errors.Is(err, services.ErrArenaNotFound),
+		errors.Is(err, services.ErrNotInSimul):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNotSimulHost):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSimulNotOpen),
+		errors.Is(err, services.ErrSimulFull),
+		errors.Is(err, services.ErrAlreadyInSimul),
+		errors.Is(err, services.ErrNoSimulParticipants):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidSimul), errors.Is(err, services.ErrUnknownVariant):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+// RenderBoard draws a position as SVG, or PNG with format=png. Arrows are a
+// comma-separated list of moves such as "e2e4,g1f3".
+func (h *Handler) RenderBoard(c *gin.Context) {
+	fen := c.Query("fen")
+	if fen == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fen is required"})
+		return
+	}
+
+	opts := render.Options{
+		FEN:      fen,
+		LastMove: c.Query("last_move"),
+		Flipped:  c.Query("orientation") == "black",
+		Theme:    models.ArenaTheme(c.Query("theme")),
+	}
+	if arrows := c.Query("arrows"); arrows != "" {
+		opts.Arrows = strings.Split(arrows, ",")
+	}
+	if size := c.Query("size"); size != "" {
+		parsed, err := strconv.Atoi(size)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid size"})
+			return
+		}
+		opts.Size = parsed
+	}
+	format := render.Format(c.DefaultQuery("format", string(render.FormatSVG)))
+	if format != render.FormatSVG && format != render.FormatPNG {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be svg or png"})
+		return
+	}
+
+	board, err := h.renderService.RenderBoard(opts, format)
+	if err != nil {
+		if errors.Is(err, render.ErrInvalidOptions) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render board"})
+		return
+	}
+
+	respondImage(c, board)
+}
+
+// GetGameGIF returns an animated replay of a game. The delay between moves
+// is in milliseconds.
+func (h *Handler) GetGameGIF(c *gin.Context) {
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID format"})
+		return
+	}
+
+	opts := services.GameGIFOptions{
+		Flipped: c.Query("orientation") == "black",
+		Theme:   models.ArenaTheme(c.Query("theme")),
+	}
+	if size := c.Query("size"); size != "" {
+		if opts.Size, err = strconv.Atoi(size); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid size"})
+			return
+		}
+	}
+	if delay := c.Query("delay"); delay != "" {
+		ms, err := strconv.Atoi(delay)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delay"})
+			return
+		}
+		opts.Delay = time.Duration(ms) * time.Millisecond
+	}
+
+	replay, err := h.renderService.RenderGameGIF(gameID, opts)
+	if err != nil {
+		if errors.Is(err, render.ErrInvalidOptions) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrRenderBusy) {
+			c.Header("Retry-After", "10")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		respondGameError(c, err)
+		return
+	}
+	respondImage(c, replay)
+}
+
+// respondImage sends a rendered image with its hash as the ETag, or 304 when
+// the client already has it.
+func respondImage(c *gin.Context, image *services.RenderedBoard) {
+	etag := `"` + image.Hash + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, max-age=86400")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, image.ContentType, image.Data)
+}
+
+// WebSocket handler
+func (h *Handler) HandleWebSocket(c *gin.Context) {
+	// Browsers can't set headers on a WebSocket handshake, so the token may
+	// also come as ?token=. Without one the connection is anonymous: it can
+	// chat, but not play or follow the spectator room of a game in progress.
+	userID := c.Query("user_id")
+	username := c.Query("username")
+	authenticated := false
+
+	tokenString := c.Query("token")
+	if header := c.GetHeader("Authorization"); tokenString == "" && strings.HasPrefix(header, "Bearer ") {
+		tokenString = header[7:]
+	}
+	if tokenString != "" {
+		claims, err := auth.ValidateToken(tokenString, h.jwtSecret)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+		userID, username, authenticated = claims.UserID, claims.Username, true
+	}
+
 	if userID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
 		return
@@ -414,5 +2074,5 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 	}
 
 	// Handle the connection using the WebSocket manager
-	h.websocketManager.HandleConnection(conn, userID, username)
+	h.websocketManager.HandleConnection(conn, userID, username, authenticated)
 }
@@ -0,0 +1,667 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"arcane-chess/internal/auth"
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/services"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const handlerTestSecret = "test-jwt-secret-that-is-long-enough-for-validation-requirements"
+
+type handlerFixture struct {
+	handler     *Handler
+	router      *gin.Engine
+	mock        sqlmock.Sqlmock
+	redisClient *redis.Client
+	cleanup     func()
+}
+
+func newHandlerFixture(t *testing.T) *handlerFixture {
+	gin.SetMode(gin.TestMode)
+
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+
+	gameService := services.NewGameService(db, redisClient)
+	handler := NewHandler(
+		gameService,
+		services.NewUserService(db),
+		services.NewAvatarService(db, redisClient),
+		services.NewArenaService(db),
+		services.NewExplorerService(db),
+		services.NewAnalysisService(db, redisClient),
+		services.NewPuzzleService(db),
+		services.NewRenderService(db, redisClient),
+		services.NewAnalysisRoomService(db),
+		services.NewStudyService(db),
+		services.NewPositionSearchService(db),
+		services.NewSimulService(db, gameService),
+		handlerTestSecret,
+	)
+
+	router := gin.New()
+	handler.SetupRoutes(router)
+
+	return &handlerFixture{
+		handler:     handler,
+		router:      router,
+		mock:        mock,
+		redisClient: redisClient,
+		cleanup: func() {
+			sqlDB, _ := db.DB()
+			testutil.CleanupDB(sqlDB)
+			testutil.CleanupRedis(redisServer)
+		},
+	}
+}
+
+func (f *handlerFixture) request(t *testing.T, method, path, body string, userID uuid.UUID) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if userID != uuid.Nil {
+		token, err := auth.GenerateToken(userID.String(), "player", "player@example.com", handlerTestSecret)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+func (f *handlerFixture) cacheGame(t *testing.T, game *models.Game) {
+	gameJSON, err := json.Marshal(game)
+	require.NoError(t, err)
+	f.redisClient.Set(context.Background(), fmt.Sprintf("game:%s", game.ID), gameJSON, time.Hour)
+}
+
+func activeGame(white, black uuid.UUID, turn, fen string) *models.Game {
+	return &models.Game{
+		ID:            uuid.New(),
+		ArenaID:       uuid.New(),
+		WhitePlayerID: &white,
+		BlackPlayerID: &black,
+		Status:        models.GameStatusActive,
+		CurrentTurn:   turn,
+		BoardState:    fen,
+		TimeControl:   600,
+		WhiteTime:     600,
+		BlackTime:     600,
+	}
+}
+
+func TestGetGame_NotFound(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	gameID := uuid.New()
+	f.mock.ExpectQuery(`SELECT \* FROM "games" WHERE id = \$1`).
+		WithArgs(gameID).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	w := f.request(t, "GET", "/api/v1/games/"+gameID.String(), "", uuid.Nil)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	testutil.AssertJSONError(t, w.Body.String(), "game not found")
+}
+
+func TestGetGame_InvalidID(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	w := f.request(t, "GET", "/api/v1/games/not-a-uuid", "", uuid.Nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestExportGamePGN(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	gameID, white, e4 := uuid.New(), uuid.New(), uuid.New()
+	result := models.GameResultWhiteWins
+	f.mock.ExpectQuery(`SELECT \* FROM "games" WHERE id = \$1`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "white_player_id", "status", "result", "time_control", "created_at"}).
+			AddRow(gameID, white, models.GameStatusFinished, result, 300, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)))
+	f.mock.ExpectQuery(`SELECT \* FROM "game_moves" WHERE "game_moves"."game_id" = \$1 ORDER BY move_number ASC`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_id", "move_number", "notation", "is_check"}).
+			AddRow(e4, gameID, 1, "e4", false).
+			AddRow(uuid.New(), gameID, 2, "f6", false).
+			AddRow(uuid.New(), gameID, 3, "d4", false))
+	f.mock.ExpectQuery(`SELECT \* FROM "move_annotations" WHERE "move_annotations"."move_id" IN`).
+		WillReturnRows(sqlmock.NewRows([]string{"move_id", "author_id", "game_id", "comment", "nags", "arrows", "highlights"}).
+			AddRow(e4, white, gameID, "Best by test", "1", "Gd2d4", ""))
+	f.mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"."id" = \$1`).
+		WithArgs(white).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(white, "alice"))
+
+	w := f.request(t, "GET", "/api/v1/games/"+gameID.String()+"/pgn", "", uuid.Nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-chess-pgn", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "[White \"alice\"]\n[Black \"?\"]\n")
+	assert.Contains(t, w.Body.String(), "\n1. e4 $1 {[%cal Gd2d4] Best by test} 1... f6 2. d4 1-0\n")
+}
+
+func TestExportGamePGN_Odds(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	gameID := uuid.New()
+	result := models.GameResultBlackWins
+	f.mock.ExpectQuery(`SELECT \* FROM "games" WHERE id = \$1`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "result", "time_control", "black_time_control", "white_increment", "handicap", "handicap_side", "casual"}).
+			AddRow(gameID, models.GameStatusFinished, result, 300, 600, 2, models.HandicapPawnAndMove, "white", true))
+	f.mock.ExpectQuery(`SELECT \* FROM "game_moves" WHERE "game_moves"."game_id" = \$1 ORDER BY move_number ASC`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_id", "move_number", "notation"}).
+			AddRow(uuid.New(), gameID, 1, "e5"))
+	f.mock.ExpectQuery(`SELECT \* FROM "move_annotations" WHERE "move_annotations"."move_id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"move_id"}))
+
+	w := f.request(t, "GET", "/api/v1/games/"+gameID.String()+"/pgn", "", uuid.Nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "[WhiteTimeControl \"300+2\"]\n[BlackTimeControl \"600\"]\n")
+	assert.Contains(t, w.Body.String(), "[Handicap \"white gives pawn_and_move\"]\n")
+	assert.Contains(t, w.Body.String(), "[SetUp \"1\"]\n[FEN \"rnbqkbnr/pppppppp/8/8/8/8/PPPPP1PP/RNBQKBNR b KQkq - 0 1\"]\n")
+	assert.Contains(t, w.Body.String(), "\n1... e5 0-1\n")
+}
+
+func TestCreateGame_InvalidOdds(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	w := f.request(t, "POST", "/api/v1/games/", `{"arena_id":"`+uuid.NewString()+`","handicap":"queen"}`, uuid.New())
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	testutil.AssertJSONError(t, w.Body.String(), "invalid odds: the handicap must be given by white or black")
+}
+
+func TestAnnotateGameMove_GameNotOver(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	gameID, white := uuid.New(), uuid.New()
+	f.mock.ExpectQuery(`SELECT \* FROM "games" WHERE id = \$1 LIMIT 1`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "white_player_id", "status"}).
+			AddRow(gameID, white, models.GameStatusActive))
+
+	w := f.request(t, "PUT", "/api/v1/games/"+gameID.String()+"/moves/1/annotation", `{"comment":"Too early","nags":[2]}`, white)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	testutil.AssertJSONError(t, w.Body.String(), "game is still being played")
+
+	w = f.request(t, "PUT", "/api/v1/games/"+gameID.String()+"/moves/1/annotation", `{"arrows":["e2e4"]}`, white)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSimul_HostOnly(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	simulID, host := uuid.New(), uuid.New()
+	expectSimul := func() {
+		f.mock.ExpectQuery(`SELECT \* FROM "simuls" WHERE id = \$1 LIMIT 1`).
+			WithArgs(simulID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "arena_id", "host_id", "host_color", "status"}).
+				AddRow(simulID, uuid.New(), host, "white", models.SimulStatusOpen))
+	}
+
+	expectSimul()
+	w := f.request(t, "GET", "/api/v1/simuls/"+simulID.String()+"/queue", "", uuid.New())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	f.mock.ExpectBegin()
+	expectSimul()
+	f.mock.ExpectRollback()
+	w = f.request(t, "POST", "/api/v1/simuls/"+simulID.String()+"/start", "", uuid.New())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	testutil.AssertJSONError(t, w.Body.String(), "only the host can do that")
+
+	w = f.request(t, "POST", "/api/v1/simuls/", `{"arena_id":"`+uuid.NewString()+`","host_color":"green"}`, host)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestCorrespondence_Rejected(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	gameID, white, black := uuid.New(), uuid.New(), uuid.New()
+	f.mock.ExpectQuery(`SELECT \* FROM "games" WHERE id = \$1 LIMIT 1`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "white_player_id", "black_player_id", "status", "current_turn", "board_state"}).
+			AddRow(gameID, white, black, models.GameStatusActive, "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"))
+	w := f.request(t, "PUT", "/api/v1/games/"+gameID.String()+"/conditional-moves", `{"lines":[["e2e4","e7e5"]]}`, black)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	testutil.AssertJSONError(t, w.Body.String(), "not a correspondence game")
+
+	w = f.request(t, "POST", "/api/v1/games/", `{"arena_id":"`+uuid.NewString()+`","days_per_move":30}`, white)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = f.request(t, "POST", "/api/v1/correspondence/vacation", `{"days":-2}`, white)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestVoteMove_NotConsultation(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	white, black := uuid.New(), uuid.New()
+	game := activeGame(white, black, "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	f.cacheGame(t, game)
+
+	w := f.request(t, "POST", "/api/v1/games/"+game.ID.String()+"/vote", `{"from":"e2","to":"e4"}`, white)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	testutil.AssertJSONError(t, w.Body.String(), "not a consultation game")
+
+	w = f.request(t, "POST", "/api/v1/games/", `{"arena_id":"`+uuid.NewString()+`","vote_window":1}`, white)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestMakeMove_WrongTurn(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	white, black := uuid.New(), uuid.New()
+	game := activeGame(white, black, "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	f.cacheGame(t, game)
+
+	w := f.request(t, "POST", "/api/v1/games/"+game.ID.String()+"/move", `{"from":"e7","to":"e5"}`, black)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	testutil.AssertJSONError(t, w.Body.String(), "not player's turn")
+}
+
+func TestMakeMove_IllegalMove(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	white, black := uuid.New(), uuid.New()
+	game := activeGame(white, black, "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	f.cacheGame(t, game)
+
+	w := f.request(t, "POST", "/api/v1/games/"+game.ID.String()+"/move", `{"from":"e2","to":"e5"}`, white)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	testutil.AssertJSONError(t, w.Body.String(), "invalid move")
+}
+
+func TestMakeMove_Promotion(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	white, black := uuid.New(), uuid.New()
+	game := activeGame(white, black, "white", "8/4P3/8/8/8/8/8/k3K3 w - - 0 1")
+	f.cacheGame(t, game)
+
+	w := f.request(t, "POST", "/api/v1/games/"+game.ID.String()+"/move", `{"from":"e7","to":"e8","promotion":"q"}`, white)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	testutil.AssertJSONError(t, w.Body.String(), "invalid move")
+}
+
+func TestMakeMove_NotAPlayer(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	game := activeGame(uuid.New(), uuid.New(), "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	f.cacheGame(t, game)
+
+	w := f.request(t, "POST", "/api/v1/games/"+game.ID.String()+"/move", `{"from":"e2","to":"e4"}`, uuid.New())
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestMakeMove_RequiresAuth(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	w := f.request(t, "POST", "/api/v1/games/"+uuid.New().String()+"/move", `{"from":"e2","to":"e4"}`, uuid.Nil)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestJoinGame_NotFound(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	gameID := uuid.New()
+	f.mock.ExpectQuery(`SELECT \* FROM "games" WHERE id = \$1`).
+		WithArgs(gameID).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	w := f.request(t, "POST", "/api/v1/games/"+gameID.String()+"/join", "", uuid.New())
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetArena_NotFound(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	arenaID := uuid.New()
+	f.mock.ExpectQuery(`SELECT \* FROM "arenas" WHERE id = \$1`).
+		WithArgs(arenaID).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	w := f.request(t, "GET", "/api/v1/arenas/"+arenaID.String(), "", uuid.Nil)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetMyAvatar_NotFound(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	userID := uuid.New()
+	f.mock.ExpectQuery(`SELECT \* FROM "avatars" WHERE user_id = \$1`).
+		WithArgs(userID.String()).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	w := f.request(t, "GET", "/api/v1/avatars/me", "", userID)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestDeclineDraw_NoOffer(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	white, black := uuid.New(), uuid.New()
+	game := activeGame(white, black, "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	f.cacheGame(t, game)
+
+	w := f.request(t, "POST", "/api/v1/games/"+game.ID.String()+"/draw/decline", "", black)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	testutil.AssertJSONError(t, w.Body.String(), "no draw offer")
+}
+
+func TestReplayGame_InvalidPly(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	w := f.request(t, "GET", "/api/v1/games/"+uuid.New().String()+"/replay?ply=-2", "", uuid.Nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestReplayGame_NoEventLog(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	gameID := uuid.New()
+	f.mock.ExpectQuery(`SELECT \* FROM "game_events" WHERE game_id = \$1`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	w := f.request(t, "GET", "/api/v1/games/"+gameID.String()+"/replay", "", uuid.Nil)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetGameAnalysis(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	gameID := uuid.New()
+	f.mock.ExpectQuery(`SELECT \* FROM "game_analyses" WHERE game_id = \$1`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"game_id", "status", "depth", "white_accuracy", "black_accuracy"}).
+			AddRow(gameID, models.AnalysisStatusCompleted, 4, 55.2, 100))
+	f.mock.ExpectQuery(`SELECT \* FROM "move_analyses" WHERE "move_analyses"."game_id" = \$1 ORDER BY ply ASC`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"game_id", "ply", "move", "classification"}).
+			AddRow(gameID, 1, "f2f3", models.MoveClassificationGood).
+			AddRow(gameID, 2, "e7e5", models.MoveClassificationBest))
+
+	w := f.request(t, "GET", "/api/v1/games/"+gameID.String()+"/analysis", "", uuid.Nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body models.GameAnalysis
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, 55.2, body.WhiteAccuracy)
+	require.Len(t, body.Moves, 2)
+	assert.Equal(t, "e7e5", body.Moves[1].Move)
+}
+
+func TestGetGameAnalysis_NotFound(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	f.mock.ExpectQuery(`SELECT \* FROM "game_analyses"`).
+		WillReturnRows(sqlmock.NewRows([]string{"game_id"}))
+
+	w := f.request(t, "GET", "/api/v1/games/"+uuid.New().String()+"/analysis", "", uuid.Nil)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCreateAnalysisRoom(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	userID := uuid.New()
+	f.mock.ExpectBegin()
+	f.mock.ExpectQuery(`INSERT INTO "analysis_rooms"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}).AddRow(uuid.New(), 0))
+	f.mock.ExpectCommit()
+
+	w := f.request(t, "POST", "/api/v1/analysis-rooms/", `{"name":"Endgame study"}`, userID)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var room models.AnalysisRoom
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &room))
+	assert.Equal(t, "Endgame study", room.Name)
+	assert.Equal(t, userID, room.ControllerID)
+	assert.Equal(t, chess.StandardStartFEN, room.FEN)
+}
+
+func TestCreateAnalysisRoom_InvalidFEN(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	w := f.request(t, "POST", "/api/v1/analysis-rooms/", `{"fen":"8/8 w"}`, uuid.New())
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestExportAnalysisRoomPGN_NotFound(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	f.mock.ExpectQuery(`SELECT \* FROM "analysis_rooms"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	w := f.request(t, "GET", "/api/v1/analysis-rooms/"+uuid.New().String()+"/pgn", "", uuid.Nil)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCreateStudy(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	userID := uuid.New()
+	f.mock.ExpectBegin()
+	f.mock.ExpectQuery(`INSERT INTO "studies"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	f.mock.ExpectCommit()
+
+	w := f.request(t, "POST", "/api/v1/studies/", `{"name":"  Rook endings ","public":true}`, userID)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var study models.Study
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &study))
+	assert.Equal(t, "Rook endings", study.Name)
+	assert.Equal(t, userID, study.OwnerID)
+	assert.True(t, study.Public)
+}
+
+func TestUpdateStudyChapter_Conflict(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	userID, studyID, chapterID := uuid.New(), uuid.New(), uuid.New()
+	f.mock.ExpectBegin()
+	f.mock.ExpectQuery(`SELECT \* FROM "studies"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id"}).AddRow(studyID, userID))
+	f.mock.ExpectQuery(`SELECT \* FROM "study_chapters"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "study_id", "version"}).AddRow(chapterID, studyID, 7))
+	f.mock.ExpectRollback()
+
+	path := "/api/v1/studies/" + studyID.String() + "/chapters/" + chapterID.String()
+	w := f.request(t, "PUT", path, `{"pgn":"1. e4","version":6}`, userID)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestExportStudyPGN_NotFound(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	f.mock.ExpectQuery(`SELECT \* FROM "studies"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	w := f.request(t, "GET", "/api/v1/studies/"+uuid.New().String()+"/pgn", "", uuid.New())
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSubmitPuzzleMove_AttemptNotFound(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	userID := uuid.New()
+	f.mock.ExpectQuery(`SELECT \* FROM "puzzle_attempts" WHERE id = \$1 AND user_id = \$2`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	w := f.request(t, "POST", "/api/v1/puzzles/attempts/"+uuid.New().String()+"/move", `{"from":"e2","to":"e4"}`, userID)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestExploreOpenings(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	f.mock.ExpectQuery(`SELECT \* FROM "explorer_entries" WHERE position_hash = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"position_hash", "move", "notation", "games", "white_wins", "draws", "black_wins", "rating_sum"}).
+			AddRow(1, "e2e4", "e4", 2, 1, 1, 0, 5000))
+
+	w := f.request(t, "GET", "/api/v1/explorer", "", uuid.Nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body services.ExplorerResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, chess.StandardStartFEN, body.FEN)
+	assert.Equal(t, 2, body.Games)
+	assert.Equal(t, 1250, body.Moves[0].AverageRating)
+}
+
+func TestSearchPositions(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	gameID := uuid.New()
+	f.mock.ExpectQuery(`SELECT game_id, MIN\(ply\) AS ply FROM "game_positions" WHERE material = \$1 GROUP BY "game_id" ORDER BY game_id ASC LIMIT 2`).
+		WithArgs("KRPvKR").
+		WillReturnRows(sqlmock.NewRows([]string{"game_id", "ply"}).
+			AddRow(gameID, 57).
+			AddRow(uuid.New(), 80))
+
+	w := f.request(t, "GET", "/api/v1/positions/search?material="+url.QueryEscape("R+P vs R")+"&limit=1", "", uuid.Nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body services.PositionSearchResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, []services.PositionMatch{{GameID: gameID, Ply: 57}}, body.Matches)
+	assert.Equal(t, &gameID, body.NextCursor)
+
+	for _, query := range []string{"", "?pawns=8%2F8", "?material=R&after=nope"} {
+		w = f.request(t, "GET", "/api/v1/positions/search"+query, "", uuid.Nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestRenderBoard(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	path := "/api/v1/render/board?fen=" + url.QueryEscape(chess.StandardStartFEN) + "&last_move=e2e4&orientation=black"
+	w := f.request(t, "GET", path, "", uuid.Nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "<svg")
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
+
+func TestRenderBoard_InvalidOptions(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	fen := url.QueryEscape(chess.StandardStartFEN)
+	for _, query := range []string{"", "fen=8%2F8+w", "fen=" + fen + "&format=gif", "fen=" + fen + "&theme=neon", "fen=" + fen + "&size=big"} {
+		w := f.request(t, "GET", "/api/v1/render/board?"+query, "", uuid.Nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestGetGameGIF_NotFound(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	gameID := uuid.New()
+	f.mock.ExpectQuery(`SELECT \* FROM "games" WHERE id = \$1`).
+		WithArgs(gameID).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	w := f.request(t, "GET", "/api/v1/games/"+gameID.String()+"/gif", "", uuid.Nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = f.request(t, "GET", "/api/v1/games/"+gameID.String()+"/gif?delay=slow", "", uuid.Nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestExploreOpenings_InvalidFEN(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	w := f.request(t, "GET", "/api/v1/explorer?fen=8%2F8+w", "", uuid.Nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -32,8 +32,9 @@ func setupTestServer() (*httptest.Server, func()) {
 	gameService := services.NewGameService(db, redisClient)
 	userService := services.NewUserService(db)
 	avatarService := services.NewAvatarService(db, redisClient)
+	arenaService := services.NewArenaService(db)
 
-	handler := NewHandler(gameService, userService, avatarService, "test-secret")
+	handler := NewHandler(gameService, userService, avatarService, arenaService, services.NewExplorerService(db), services.NewAnalysisService(db, redisClient), services.NewPuzzleService(db), services.NewRenderService(db, redisClient), services.NewAnalysisRoomService(db), services.NewStudyService(db), services.NewPositionSearchService(db), services.NewSimulService(db, gameService), "test-secret")
 
 	router := gin.New()
 	handler.SetupRoutes(router)
@@ -202,19 +203,16 @@ func TestWebSocketGameMoveMessage(t *testing.T) {
 	err = conn1.WriteJSON(moveMsg)
 	require.NoError(t, err)
 
-	// Player 2 should receive the move
-	conn2.SetReadDeadline(time.Now().Add(2 * time.Second))
-	var receivedMove services.Message
-	err = conn2.ReadJSON(&receivedMove)
+	// Without a token the move is refused and never reaches the room
+	conn1.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var reply services.Message
+	err = conn1.ReadJSON(&reply)
 	require.NoError(t, err)
-	assert.Equal(t, "game_move", receivedMove.Type)
+	assert.Equal(t, "game_move_error", reply.Type)
 
-	// Verify move data structure
-	moveData, ok := receivedMove.Data.(map[string]interface{})
-	require.True(t, ok)
-	assert.Equal(t, "e2", moveData["from"])
-	assert.Equal(t, "e4", moveData["to"])
-	assert.Equal(t, "P", moveData["piece"])
+	conn2.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	var relayed services.Message
+	assert.Error(t, conn2.ReadJSON(&relayed))
 }
 
 func TestWebSocketAvatarPositionUpdate(t *testing.T) {
@@ -1,15 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"arcane-chess/internal/auth"
 	"arcane-chess/internal/services"
 	"arcane-chess/internal/testutil"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -24,8 +27,9 @@ func setupTestHandler() (*Handler, func()) {
 	gameService := services.NewGameService(db, redisClient)
 	userService := services.NewUserService(db)
 	avatarService := services.NewAvatarService(db, redisClient)
+	arenaService := services.NewArenaService(db)
 
-	handler := NewHandler(gameService, userService, avatarService, "test-secret")
+	handler := NewHandler(gameService, userService, avatarService, arenaService, services.NewExplorerService(db), services.NewAnalysisService(db, redisClient), services.NewPuzzleService(db), services.NewRenderService(db, redisClient), services.NewAnalysisRoomService(db), services.NewStudyService(db), services.NewPositionSearchService(db), services.NewSimulService(db, gameService), "test-secret")
 
 	cleanup := func() {
 		sqlDB, _ := db.DB()
@@ -175,79 +179,334 @@ func TestWebSocketMultipleClients(t *testing.T) {
 	assert.Equal(t, "chat_message", receivedMsg.Type)
 }
 
-func TestWebSocketGameMove(t *testing.T) {
-	gin.SetMode(gin.TestMode)
-	handler, cleanup := setupTestHandler()
-	defer cleanup()
+// dialAsPlayer opens a socket authenticated with a token for userID and
+// reads the connection confirmation.
+func dialAsPlayer(t *testing.T, server *httptest.Server, userID uuid.UUID) *websocket.Conn {
+	token, err := auth.GenerateToken(userID.String(), "player", "player@example.com", handlerTestSecret)
+	require.NoError(t, err)
 
-	router := gin.New()
-	handler.SetupRoutes(router)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
 
-	server := httptest.NewServer(router)
+	var established services.Message
+	require.NoError(t, conn.ReadJSON(&established))
+	require.Equal(t, "connection_established", established.Type)
+
+	return conn
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) services.Message {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var message services.Message
+	require.NoError(t, conn.ReadJSON(&message))
+	return message
+}
+
+func TestWebSocketGameMove(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	server := httptest.NewServer(f.router)
 	defer server.Close()
 
-	// Connect two clients
-	wsURL1 := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user_id=user1&username=player1"
-	conn1, _, err := websocket.DefaultDialer.Dial(wsURL1, nil)
-	require.NoError(t, err)
-	defer conn1.Close()
+	white, black := uuid.New(), uuid.New()
+	game := activeGame(white, black, "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	f.cacheGame(t, game)
 
-	wsURL2 := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user_id=user2&username=player2"
-	conn2, _, err := websocket.DefaultDialer.Dial(wsURL2, nil)
-	require.NoError(t, err)
-	defer conn2.Close()
+	// The room hears of moves through the game's Redis channel
+	bridge := services.NewHubBridge(f.handler.WebSocketHub(), f.redisClient)
+	require.NoError(t, bridge.Start(context.Background()))
+	defer bridge.Close()
 
-	// Clear connection messages
-	var msg1, msg2 services.Message
-	err = conn1.ReadJSON(&msg1)
-	require.NoError(t, err)
-	err = conn2.ReadJSON(&msg2)
-	require.NoError(t, err)
+	whiteConn := dialAsPlayer(t, server, white)
+	defer whiteConn.Close()
+	blackConn := dialAsPlayer(t, server, black)
+	defer blackConn.Close()
 
-	// Join same game room
-	gameID := uuid.New().String()
-	joinMsg := services.Message{
-		Type: "join_room",
-		Data: map[string]interface{}{
-			"room_id": gameID,
-		},
+	room := services.GameRoom(game.ID.String())
+	joinMsg := services.Message{Type: "join_room", Data: map[string]interface{}{"room_id": room}}
+	require.NoError(t, whiteConn.WriteJSON(joinMsg))
+	require.NoError(t, blackConn.WriteJSON(joinMsg))
+	time.Sleep(100 * time.Millisecond)
+
+	f.mock.ExpectBegin()
+	f.mock.ExpectExec(`UPDATE "games" SET`).WillReturnResult(sqlmock.NewResult(1, 1))
+	f.mock.ExpectQuery(`INSERT INTO "game_moves"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	f.mock.ExpectQuery(`INSERT INTO "game_events"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	f.mock.ExpectCommit()
+
+	// The piece the client claims to move is ignored
+	require.NoError(t, whiteConn.WriteJSON(services.Message{
+		Type:      "game_move",
+		RequestID: "req-1",
+		Data:      services.GameMoveMessage{GameID: game.ID.String(), From: "e2", To: "e4", Piece: "Q"},
+	}))
+
+	// The mover gets the ack and, like their opponent, the move once as the
+	// game's update; the ack may arrive either side of it
+	received := map[*websocket.Conn]map[string]services.Message{}
+	for conn, count := range map[*websocket.Conn]int{whiteConn: 2, blackConn: 1} {
+		received[conn] = map[string]services.Message{}
+		for i := 0; i < count; i++ {
+			message := readMessage(t, conn)
+			received[conn][message.Type] = message
+		}
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		var extra services.Message
+		assert.Error(t, conn.ReadJSON(&extra))
 	}
 
-	err = conn1.WriteJSON(joinMsg)
-	require.NoError(t, err)
-	err = conn2.WriteJSON(joinMsg)
-	require.NoError(t, err)
+	ack, ok := received[whiteConn]["game_move_ack"]
+	require.True(t, ok)
+	assert.Equal(t, "req-1", ack.RequestID)
+
+	for _, conn := range []*websocket.Conn{whiteConn, blackConn} {
+		broadcast, ok := received[conn]["game_update"]
+		require.True(t, ok)
+		update := broadcast.Data.(map[string]interface{})
+		assert.Equal(t, "move", update["event_type"])
+		result := update["data"].(map[string]interface{})
+		assert.Equal(t, "e4", result["notation"])
+		assert.Equal(t, "P", result["piece"])
+		assert.Equal(t, "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", result["fen_after"])
+		assert.Equal(t, float64(600), result["white_time"])
+		assert.Equal(t, false, result["is_checkmate"])
+	}
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestWebSocketAnalysisMove(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	server := httptest.NewServer(f.router)
+	defer server.Close()
+
+	host, guest := uuid.New(), uuid.New()
+	roomID := uuid.New()
+	hostConn := dialAsPlayer(t, server, host)
+	defer hostConn.Close()
+	guestConn := dialAsPlayer(t, server, guest)
+	defer guestConn.Close()
 
+	channel := services.AnalysisRoomChannel(roomID.String())
+	require.NoError(t, guestConn.WriteJSON(services.Message{Type: "join_room", Data: map[string]interface{}{"room_id": channel}}))
 	time.Sleep(100 * time.Millisecond)
 
-	// Send game move from player 1
-	moveMsg := services.Message{
-		Type: "game_move",
-		Room: gameID,
-		Data: services.GameMoveMessage{
-			GameID: gameID,
-			From:   "e2",
-			To:     "e4",
-			Piece:  "P",
-		},
-	}
+	f.mock.ExpectBegin()
+	f.mock.ExpectQuery(`SELECT \* FROM "analysis_rooms" WHERE id = \$1 LIMIT 1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "host_id", "controller_id", "fen", "version"}).
+			AddRow(roomID, host, host, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", 0))
+	f.mock.ExpectQuery(`SELECT \* FROM "analysis_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	f.mock.ExpectQuery(`SELECT count\(\*\)`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	f.mock.ExpectQuery(`INSERT INTO "analysis_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	f.mock.ExpectExec(`UPDATE "analysis_rooms" SET "version"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectCommit()
+
+	require.NoError(t, hostConn.WriteJSON(services.Message{
+		Type:      "analysis_move",
+		RequestID: "req-1",
+		Data:      services.AnalysisEditMessage{RoomID: roomID.String(), From: "g1", To: "f3"},
+	}))
+
+	ack := readMessage(t, hostConn)
+	assert.Equal(t, "analysis_move_ack", ack.Type)
+	assert.Equal(t, "req-1", ack.RequestID)
+
+	update := readMessage(t, guestConn)
+	assert.Equal(t, "analysis_update", update.Type)
+	assert.Equal(t, channel, update.Room)
+	data := update.Data.(map[string]interface{})
+	assert.Equal(t, "move", data["op"])
+	assert.Equal(t, float64(1), data["version"])
+	assert.Equal(t, "Nf3", data["node"].(map[string]interface{})["san"])
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+
+	// Only the controller may edit
+	f.mock.ExpectBegin()
+	f.mock.ExpectQuery(`SELECT \* FROM "analysis_rooms"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "host_id", "controller_id", "version"}).AddRow(roomID, host, host, 1))
+	f.mock.ExpectRollback()
+
+	require.NoError(t, guestConn.WriteJSON(services.Message{
+		Type:      "analysis_move",
+		RequestID: "req-2",
+		Data:      services.AnalysisEditMessage{RoomID: roomID.String(), From: "e2", To: "e4"},
+	}))
+
+	reply := readMessage(t, guestConn)
+	assert.Equal(t, "analysis_move_error", reply.Type)
+	assert.Equal(t, "forbidden", reply.Data.(map[string]interface{})["code"])
+}
+
+func TestWebSocketJoinStudy(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	server := httptest.NewServer(f.router)
+	defer server.Close()
+
+	studyID, userID := uuid.New(), uuid.New()
+	conn := dialAsPlayer(t, server, userID)
+	defer conn.Close()
+	channel := services.StudyChannel(studyID.String())
+
+	// A private study the user isn't a member of
+	f.mock.ExpectQuery(`SELECT \* FROM "studies"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "public"}).AddRow(studyID, uuid.New(), false))
+	f.mock.ExpectQuery(`SELECT \* FROM "study_members"`).
+		WillReturnRows(sqlmock.NewRows([]string{"study_id", "user_id", "role"}))
+
+	require.NoError(t, conn.WriteJSON(services.Message{Type: "join_room", Data: map[string]interface{}{"room_id": channel}}))
+
+	reply := readMessage(t, conn)
+	assert.Equal(t, "join_room_error", reply.Type)
+	assert.Equal(t, "forbidden", reply.Data.(map[string]interface{})["code"])
+
+	// Once a member, the user follows the study's changes
+	f.mock.ExpectQuery(`SELECT \* FROM "studies"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "public"}).AddRow(studyID, uuid.New(), false))
+	f.mock.ExpectQuery(`SELECT \* FROM "study_members"`).
+		WillReturnRows(sqlmock.NewRows([]string{"study_id", "user_id", "role"}).AddRow(studyID, userID, "viewer"))
+
+	require.NoError(t, conn.WriteJSON(services.Message{Type: "join_room", Data: map[string]interface{}{"room_id": channel}}))
+	time.Sleep(100 * time.Millisecond)
+	assert.Contains(t, f.handler.WebSocketHub().RoomMembers(channel), userID.String())
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestWebSocketJoinSpectatorRoom(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	server := httptest.NewServer(f.router)
+	defer server.Close()
+
+	white, black, member, spectator := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	game := activeGame(white, black, "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	game.VoteWindow = 30
+	f.cacheGame(t, game)
+	room := services.GameSpectatorRoom(game.ID.String())
+	join := services.Message{Type: "join_room", Data: map[string]interface{}{"room_id": room}}
+
+	// Neither the seated player nor a member of their team may watch the
+	// evaluation
+	whiteConn := dialAsPlayer(t, server, white)
+	defer whiteConn.Close()
+	require.NoError(t, whiteConn.WriteJSON(join))
+	reply := readMessage(t, whiteConn)
+	assert.Equal(t, "join_room_error", reply.Type)
+	assert.Equal(t, "forbidden", reply.Data.(map[string]interface{})["code"])
+
+	memberConn := dialAsPlayer(t, server, member)
+	defer memberConn.Close()
+	f.mock.ExpectQuery(`SELECT \* FROM "consultation_members"`).
+		WillReturnRows(sqlmock.NewRows([]string{"game_id", "user_id", "color"}).AddRow(game.ID, member, "black"))
+	require.NoError(t, memberConn.WriteJSON(join))
+	reply = readMessage(t, memberConn)
+	assert.Equal(t, "join_room_error", reply.Type)
+
+	spectatorConn := dialAsPlayer(t, server, spectator)
+	defer spectatorConn.Close()
+	f.mock.ExpectQuery(`SELECT \* FROM "consultation_members"`).
+		WillReturnRows(sqlmock.NewRows([]string{"game_id", "user_id", "color"}))
+	require.NoError(t, spectatorConn.WriteJSON(join))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{spectator.String()}, f.handler.WebSocketHub().RoomMembers(room))
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestWebSocketJoinSpectatorRoom_Anonymous(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	server := httptest.NewServer(f.router)
+	defer server.Close()
+
+	white, black := uuid.New(), uuid.New()
+	game := activeGame(white, black, "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	f.cacheGame(t, game)
+	room := services.GameSpectatorRoom(game.ID.String())
 
-	err = conn1.WriteJSON(moveMsg)
+	// Without a token the user ID is only claimed, so it could be a player's
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user_id=" + uuid.New().String()
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
 	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, "connection_established", readMessage(t, conn).Type)
 
-	// Player 2 should receive the move
-	conn2.SetReadDeadline(time.Now().Add(2 * time.Second))
-	var receivedMove services.Message
-	err = conn2.ReadJSON(&receivedMove)
+	require.NoError(t, conn.WriteJSON(services.Message{Type: "join_room", Data: map[string]interface{}{"room_id": room}}))
+	reply := readMessage(t, conn)
+	assert.Equal(t, "join_room_error", reply.Type)
+	assert.Equal(t, "forbidden", reply.Data.(map[string]interface{})["code"])
+	assert.Empty(t, f.handler.WebSocketHub().RoomMembers(room))
+}
+
+func TestWebSocketGameMove_Rejected(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	server := httptest.NewServer(f.router)
+	defer server.Close()
+
+	white, black := uuid.New(), uuid.New()
+	game := activeGame(white, black, "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	f.cacheGame(t, game)
+
+	blackConn := dialAsPlayer(t, server, black)
+	defer blackConn.Close()
+
+	require.NoError(t, blackConn.WriteJSON(services.Message{
+		Type:      "game_move",
+		RequestID: "req-2",
+		Data:      services.GameMoveMessage{GameID: game.ID.String(), From: "e7", To: "e5"},
+	}))
+
+	reply := readMessage(t, blackConn)
+	assert.Equal(t, "game_move_error", reply.Type)
+	assert.Equal(t, "req-2", reply.RequestID)
+	assert.Equal(t, map[string]interface{}{"code": "conflict", "error": "not player's turn"}, reply.Data)
+}
+
+func TestWebSocketGameMove_RequiresToken(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	server := httptest.NewServer(f.router)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user_id=" + uuid.New().String()
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
 	require.NoError(t, err)
-	assert.Equal(t, "game_move", receivedMove.Type)
+	defer conn.Close()
+	readMessage(t, conn)
+
+	require.NoError(t, conn.WriteJSON(services.Message{
+		Type:      "game_move",
+		RequestID: "req-3",
+		Data:      services.GameMoveMessage{GameID: uuid.New().String(), From: "e2", To: "e4"},
+	}))
+
+	reply := readMessage(t, conn)
+	assert.Equal(t, "game_move_error", reply.Type)
+	assert.Equal(t, "req-3", reply.RequestID)
+	assert.Equal(t, "unauthenticated", reply.Data.(map[string]interface{})["code"])
+}
 
-	// Verify move data
-	moveData, ok := receivedMove.Data.(map[string]interface{})
-	require.True(t, ok)
-	assert.Equal(t, "e2", moveData["from"])
-	assert.Equal(t, "e4", moveData["to"])
-	assert.Equal(t, "P", moveData["piece"])
+func TestWebSocketInvalidToken(t *testing.T) {
+	f := newHandlerFixture(t)
+	defer f.cleanup()
+
+	server := httptest.NewServer(f.router)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?token=not-a-token"
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+
+	assert.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 }
 
 func TestWebSocketAvatarPosition(t *testing.T) {
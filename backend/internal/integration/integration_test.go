@@ -67,6 +67,15 @@ func (suite *IntegrationTestSuite) SetupSuite() {
 		suite.gameService,
 		suite.userService,
 		suite.avatarService,
+		services.NewArenaService(dbInstance),
+		services.NewExplorerService(dbInstance),
+		services.NewAnalysisService(dbInstance, redisInstance),
+		services.NewPuzzleService(dbInstance),
+		services.NewRenderService(dbInstance, redisInstance),
+		services.NewAnalysisRoomService(dbInstance),
+		services.NewStudyService(dbInstance),
+		services.NewPositionSearchService(dbInstance),
+		services.NewSimulService(dbInstance, suite.gameService),
 		cfg.JWT.Secret,
 	)
 
@@ -90,6 +99,7 @@ func (suite *IntegrationTestSuite) TearDownSuite() {
 	// Clean up test data
 	if suite.db != nil {
 		// Delete test data
+		suite.db.Exec("DELETE FROM game_events")
 		suite.db.Exec("DELETE FROM game_moves")
 		suite.db.Exec("DELETE FROM games")
 		suite.db.Exec("DELETE FROM avatars")
@@ -412,8 +422,9 @@ func BenchmarkIntegration_UserRegistration(b *testing.B) {
 	userService := services.NewUserService(db)
 	gameService := services.NewGameService(db, redis)
 	avatarService := services.NewAvatarService(db, redis)
+	arenaService := services.NewArenaService(db)
 
-	handler := handlers.NewHandler(gameService, userService, avatarService, cfg.JWT.Secret)
+	handler := handlers.NewHandler(gameService, userService, avatarService, arenaService, services.NewExplorerService(db), services.NewAnalysisService(db, redis), services.NewPuzzleService(db), services.NewRenderService(db, redis), services.NewAnalysisRoomService(db), services.NewStudyService(db), services.NewPositionSearchService(db), services.NewSimulService(db, gameService), cfg.JWT.Secret)
 
 	gin.SetMode(gin.TestMode)
 	app := gin.New()
@@ -453,8 +464,9 @@ func BenchmarkIntegration_GameCreation(b *testing.B) {
 	userService := services.NewUserService(db)
 	gameService := services.NewGameService(db, redis)
 	avatarService := services.NewAvatarService(db, redis)
+	arenaService := services.NewArenaService(db)
 
-	handler := handlers.NewHandler(gameService, userService, avatarService, cfg.JWT.Secret)
+	handler := handlers.NewHandler(gameService, userService, avatarService, arenaService, services.NewExplorerService(db), services.NewAnalysisService(db, redis), services.NewPuzzleService(db), services.NewRenderService(db, redis), services.NewAnalysisRoomService(db), services.NewStudyService(db), services.NewPositionSearchService(db), services.NewSimulService(db, gameService), cfg.JWT.Secret)
 
 	gin.SetMode(gin.TestMode)
 	app := gin.New()
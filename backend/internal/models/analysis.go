@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AnalysisStatus string
+
+const (
+	AnalysisStatusRunning   AnalysisStatus = "running"
+	AnalysisStatusCompleted AnalysisStatus = "completed"
+	AnalysisStatusFailed    AnalysisStatus = "failed"
+)
+
+type MoveClassification string
+
+const (
+	MoveClassificationBest       MoveClassification = "best"
+	MoveClassificationGood       MoveClassification = "good"
+	MoveClassificationInaccuracy MoveClassification = "inaccuracy"
+	MoveClassificationMistake    MoveClassification = "mistake"
+	MoveClassificationBlunder    MoveClassification = "blunder"
+)
+
+// GameAnalysis is the computer review of a finished game. Accuracies are
+// percentages, averaged over each player's moves.
+type GameAnalysis struct {
+	GameID        uuid.UUID      `gorm:"type:uuid;primary_key" json:"game_id"`
+	Status        AnalysisStatus `gorm:"size:20;not null" json:"status"`
+	Depth         int            `gorm:"not null" json:"depth"` // plies searched per position
+	WhiteAccuracy float64        `json:"white_accuracy"`
+	BlackAccuracy float64        `json:"black_accuracy"`
+	Error         string         `gorm:"type:text" json:"error,omitempty"`
+	CompletedAt   *time.Time     `json:"completed_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+
+	// Relationships
+	Moves []MoveAnalysis `gorm:"foreignKey:GameID;references:GameID" json:"moves"`
+}
+
+// MoveAnalysis reviews one move. Evaluations are in centipawns from white's
+// point of view; win chances are percentages from the mover's.
+type MoveAnalysis struct {
+	ID             uuid.UUID          `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	GameID         uuid.UUID          `gorm:"type:uuid;not null;uniqueIndex:idx_move_analysis_ply" json:"game_id"`
+	Ply            int                `gorm:"not null;uniqueIndex:idx_move_analysis_ply" json:"ply"`
+	Move           string             `gorm:"size:5;not null" json:"move"` // e.g., "e2e4"
+	Notation       string             `gorm:"size:10;not null" json:"notation"`
+	Eval           int                `gorm:"not null" json:"eval"`       // after the move
+	Mate           *int               `json:"mate,omitempty"`             // moves to mate after the move, negative when black mates
+	BestMove       string             `gorm:"size:5" json:"best_move"`    // the engine's choice in the position before
+	BestLine       string             `gorm:"type:text" json:"best_line"` // space separated, starting with BestMove
+	WinChanceLoss  float64            `gorm:"not null" json:"win_chance_loss"`
+	Accuracy       float64            `gorm:"not null" json:"accuracy"`
+	Classification MoveClassification `gorm:"size:20;not null" json:"classification"`
+	CreatedAt      time.Time          `json:"created_at"`
+}
+
+func (ma *MoveAnalysis) BeforeCreate(tx *gorm.DB) error {
+	if ma.ID == uuid.Nil {
+		ma.ID = uuid.New()
+	}
+	return nil
+}
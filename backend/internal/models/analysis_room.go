@@ -0,0 +1,95 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AnalysisRoom is a shared board on which several users explore a position.
+// Only the controller edits the move tree; the host can hand control to
+// anyone and take it back.
+type AnalysisRoom struct {
+	ID           uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name         string    `gorm:"size:100;not null" json:"name"`
+	HostID       uuid.UUID `gorm:"type:uuid;not null;index" json:"host_id"`
+	ControllerID uuid.UUID `gorm:"type:uuid;not null" json:"controller_id"`
+	FEN          string    `gorm:"type:text;not null" json:"fen"`     // the position the tree grows from
+	Version      int       `gorm:"not null;default:0" json:"version"` // bumped on every edit, for optimistic locking
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	Nodes []AnalysisNode `gorm:"foreignKey:RoomID" json:"nodes,omitempty"`
+}
+
+func (r *AnalysisRoom) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// AnalysisNode is a move in a room's tree. Moves from the room's position
+// have no parent. Among the moves from the same position the lowest rank is
+// the main line and the others are variations.
+type AnalysisNode struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	RoomID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"room_id"`
+	ParentID  *uuid.UUID `gorm:"type:uuid;index" json:"parent_id"`
+	Ply       int        `gorm:"not null" json:"ply"` // half-moves from the room's position
+	Move      string     `gorm:"size:4;not null" json:"move"`
+	SAN       string     `gorm:"size:10;not null" json:"san"`
+	FEN       string     `gorm:"type:text;not null" json:"fen"`
+	Rank      int        `gorm:"not null;default:0" json:"rank"`
+	Comment   string     `gorm:"type:text" json:"comment,omitempty"`
+	NAGs      NAGs       `gorm:"column:nags;type:text" json:"nags,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (n *AnalysisNode) BeforeCreate(tx *gorm.DB) error {
+	if n.ID == uuid.Nil {
+		n.ID = uuid.New()
+	}
+	return nil
+}
+
+// NAGs are PGN Numeric Annotation Glyphs, such as 1 for "!" or 14 for "+=",
+// stored as space-separated numbers.
+type NAGs []int
+
+func (n NAGs) Value() (driver.Value, error) {
+	parts := make([]string, len(n))
+	for i, nag := range n {
+		parts[i] = strconv.Itoa(nag)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func (n *NAGs) Scan(value interface{}) error {
+	var text string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into NAGs", value)
+	}
+
+	nags := NAGs{}
+	for _, field := range strings.Fields(text) {
+		nag, err := strconv.Atoi(field)
+		if err != nil {
+			return fmt.Errorf("invalid NAG %q: %w", field, err)
+		}
+		nags = append(nags, nag)
+	}
+	*n = nags
+	return nil
+}
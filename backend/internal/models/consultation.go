@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ConsultationMember is a user on one side's team in a consultation game,
+// besides the player seated on that side. The team votes on each of its
+// moves; the seated player also resigns and handles draws for it.
+type ConsultationMember struct {
+	GameID    uuid.UUID `gorm:"type:uuid;primaryKey" json:"game_id"`
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey;index" json:"user_id"`
+	Color     string    `gorm:"size:5;not null" json:"color"` // 'white' or 'black'
+	CreatedAt time.Time `json:"created_at"`
+
+	User *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
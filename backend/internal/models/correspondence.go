@@ -0,0 +1,67 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Vacation is a player's time off from their correspondence games. While it
+// lasts, the deadlines of the games waiting for them are put back. Each year
+// brings a fresh allowance of days.
+type Vacation struct {
+	UserID    uuid.UUID  `gorm:"type:uuid;primaryKey" json:"user_id"`
+	Year      int        `gorm:"not null" json:"year"`      // the year DaysLeft is for
+	DaysLeft  int        `gorm:"not null" json:"days_left"` // of this year's allowance
+	Until     *time.Time `json:"until,omitempty"`           // the end of the vacation taken last
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// OnVacation reports whether the vacation lasts past at.
+func (v *Vacation) OnVacation(at time.Time) bool {
+	return v.Until != nil && v.Until.After(at)
+}
+
+// ConditionalMove is one line a player has queued in a correspondence game
+// while waiting for their opponent: the opponent's moves alternating with
+// the replies to play to them, starting with the move after ply.
+type ConditionalMove struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	GameID    uuid.UUID `gorm:"type:uuid;not null;index:idx_conditional_moves_player" json:"game_id"`
+	PlayerID  uuid.UUID `gorm:"type:uuid;not null;index:idx_conditional_moves_player" json:"player_id"`
+	Ply       int       `gorm:"not null" json:"ply"`
+	Moves     MoveList  `gorm:"type:text;not null" json:"moves"` // e.g. "e7e5 g1f3"
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c *ConditionalMove) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// MoveList is a line of moves in coordinate notation, stored space-separated.
+type MoveList []string
+
+func (m MoveList) Value() (driver.Value, error) {
+	return strings.Join(m, " "), nil
+}
+
+func (m *MoveList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = MoveList{}
+	case string:
+		*m = strings.Fields(v)
+	case []byte:
+		*m = strings.Fields(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into MoveList", value)
+	}
+	return nil
+}
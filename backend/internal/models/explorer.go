@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ExplorerEntry aggregates the finished games in which a move was played from
+// a position. Positions are identified by chess.PositionHash, stored as a
+// signed integer because that's what the column holds.
+type ExplorerEntry struct {
+	PositionHash int64     `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	Move         string    `gorm:"primaryKey;size:5" json:"uci"` // from and to squares, e.g. "e2e4"
+	Notation     string    `gorm:"size:10;not null" json:"san"`
+	Games        int       `gorm:"not null;default:0" json:"games"`
+	WhiteWins    int       `gorm:"not null;default:0" json:"white_wins"`
+	Draws        int       `gorm:"not null;default:0" json:"draws"`
+	BlackWins    int       `gorm:"not null;default:0" json:"black_wins"`
+	RatingSum    int64     `gorm:"not null;default:0" json:"-"` // sum over games of both players' ratings
+	UpdatedAt    time.Time `json:"updated_at"`
+}
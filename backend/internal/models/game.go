@@ -16,6 +16,23 @@ const (
 	GameStatusAbandoned GameStatus = "abandoned"
 )
 
+type GameVariant string
+
+const (
+	GameVariantStandard   GameVariant = "standard"
+	GameVariantCrazyhouse GameVariant = "crazyhouse"
+)
+
+// Handicap is the material a side gives as odds by starting without it.
+type Handicap string
+
+const (
+	HandicapKnight      Handicap = "knight" // the queen's knight
+	HandicapRook        Handicap = "rook"   // the queen's rook
+	HandicapQueen       Handicap = "queen"
+	HandicapPawnAndMove Handicap = "pawn_and_move" // the f-pawn, and the first move
+)
+
 type GameResult string
 
 const (
@@ -26,22 +43,38 @@ const (
 )
 
 type Game struct {
-	ID            uuid.UUID   `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	ArenaID       uuid.UUID   `gorm:"type:uuid;not null" json:"arena_id"`
-	WhitePlayerID *uuid.UUID  `gorm:"type:uuid" json:"white_player_id"`
-	BlackPlayerID *uuid.UUID  `gorm:"type:uuid" json:"black_player_id"`
-	Status        GameStatus  `gorm:"default:'waiting'" json:"status"`
-	Result        *GameResult `json:"result,omitempty"`
-	CurrentTurn   string      `gorm:"default:'white'" json:"current_turn"` // 'white' or 'black'
-	BoardState    string      `gorm:"type:text" json:"board_state"`        // FEN notation
-	MoveCount     int         `gorm:"default:0" json:"move_count"`
-	TimeControl   int         `gorm:"default:600" json:"time_control"` // seconds
-	WhiteTime     int         `json:"white_time"`
-	BlackTime     int         `json:"black_time"`
-	StartedAt     *time.Time  `json:"started_at"`
-	FinishedAt    *time.Time  `json:"finished_at"`
-	CreatedAt     time.Time   `json:"created_at"`
-	UpdatedAt     time.Time   `json:"updated_at"`
+	ID               uuid.UUID   `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ArenaID          uuid.UUID   `gorm:"type:uuid;not null" json:"arena_id"`
+	Variant          GameVariant `gorm:"size:20;not null;default:'standard'" json:"variant"`
+	WhitePlayerID    *uuid.UUID  `gorm:"type:uuid" json:"white_player_id"`
+	BlackPlayerID    *uuid.UUID  `gorm:"type:uuid" json:"black_player_id"`
+	Status           GameStatus  `gorm:"default:'waiting'" json:"status"`
+	Result           *GameResult `json:"result,omitempty"`
+	CurrentTurn      string      `gorm:"default:'white'" json:"current_turn"` // 'white' or 'black'
+	BoardState       string      `gorm:"type:text" json:"board_state"`        // FEN notation
+	MoveCount        int         `gorm:"default:0" json:"move_count"`
+	ECO              string      `gorm:"size:3" json:"eco,omitempty"` // opening classification, updated as moves are played
+	OpeningName      string      `gorm:"size:100" json:"opening_name,omitempty"`
+	TimeControl      int         `gorm:"default:600" json:"time_control"`     // seconds white starts with
+	BlackTimeControl int         `gorm:"default:0" json:"black_time_control"` // seconds black starts with, TimeControl when zero
+	WhiteIncrement   int         `gorm:"default:0" json:"white_increment"`    // seconds added to white's clock after each of its moves
+	BlackIncrement   int         `gorm:"default:0" json:"black_increment"`
+	DaysPerMove      int         `gorm:"default:0" json:"days_per_move,omitempty"` // set for correspondence games, which have no running clock
+	VoteWindow       int         `gorm:"default:0" json:"vote_window,omitempty"`   // seconds a team votes on each move, set for consultation games
+	UntimedSide      string      `gorm:"size:5" json:"untimed_side,omitempty"`     // a side whose clock never runs, such as a simul host's
+	Handicap         Handicap    `gorm:"size:20" json:"handicap,omitempty"`        // material odds given by HandicapSide
+	HandicapSide     string      `gorm:"size:5" json:"handicap_side,omitempty"`
+	Casual           bool        `gorm:"not null;default:false" json:"casual"` // doesn't count towards ratings, as odds games never do
+	SimulID          *uuid.UUID  `gorm:"type:uuid;index" json:"simul_id,omitempty"`
+	WhiteTime        int         `json:"white_time"`
+	BlackTime        int         `json:"black_time"`
+	StartedAt        *time.Time  `json:"started_at"`
+	FinishedAt       *time.Time  `json:"finished_at"`
+	LastMoveAt       *time.Time  `json:"last_move_at"`                         // when the side to move's clock started
+	MoveDeadline     *time.Time  `gorm:"index" json:"move_deadline,omitempty"` // when the side to move forfeits a correspondence game
+	Version          int         `gorm:"not null;default:0" json:"version"`    // bumped on every write, for optimistic locking
+	CreatedAt        time.Time   `json:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at"`
 
 	// Relationships
 	Arena       Arena      `gorm:"foreignKey:ArenaID" json:"arena,omitempty"`
@@ -50,6 +83,16 @@ type Game struct {
 	Moves       []GameMove `gorm:"foreignKey:GameID" json:"moves,omitempty"`
 }
 
+// HasOdds reports whether one side starts with less material or time, or
+// gains less time per move, than the other.
+func (g *Game) HasOdds() bool {
+	blackTimeControl := g.BlackTimeControl
+	if blackTimeControl == 0 {
+		blackTimeControl = g.TimeControl
+	}
+	return g.Handicap != "" || blackTimeControl != g.TimeControl || g.WhiteIncrement != g.BlackIncrement
+}
+
 func (g *Game) BeforeCreate(tx *gorm.DB) error {
 	if g.ID == uuid.Nil {
 		g.ID = uuid.New()
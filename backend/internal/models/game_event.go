@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type GameEventType string
+
+const (
+	GameEventCreated       GameEventType = "created"
+	GameEventJoined        GameEventType = "joined"
+	GameEventMoved         GameEventType = "moved"
+	GameEventClockExpired  GameEventType = "clock_expired"
+	GameEventDrawOffered   GameEventType = "draw_offered"
+	GameEventDrawDeclined  GameEventType = "draw_declined"
+	GameEventDrawAccepted  GameEventType = "draw_accepted"
+	GameEventResigned      GameEventType = "resigned"
+	GameEventSpellCast     GameEventType = "spell_cast"
+	GameEventDeadlineMoved GameEventType = "deadline_moved" // a correspondence deadline put back by a vacation
+)
+
+// GameEvent is one entry in a game's append-only history. The games row is a
+// projection of these events and can be rebuilt from them.
+type GameEvent struct {
+	ID        uuid.UUID     `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	GameID    uuid.UUID     `gorm:"type:uuid;not null;uniqueIndex:idx_game_event_sequence" json:"game_id"`
+	Sequence  int           `gorm:"not null;uniqueIndex:idx_game_event_sequence" json:"sequence"` // the game's version after this event
+	Type      GameEventType `gorm:"size:20;not null" json:"type"`
+	PlayerID  *uuid.UUID    `gorm:"type:uuid" json:"player_id,omitempty"`
+	Payload   string        `gorm:"type:jsonb;not null" json:"payload"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+func (ge *GameEvent) BeforeCreate(tx *gorm.DB) error {
+	if ge.ID == uuid.Nil {
+		ge.ID = uuid.New()
+	}
+	return nil
+}
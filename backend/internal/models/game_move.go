@@ -9,9 +9,9 @@ import (
 
 type GameMove struct {
 	ID            uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	GameID        uuid.UUID `gorm:"type:uuid;not null" json:"game_id"`
+	GameID        uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_game_move_number" json:"game_id"`
 	PlayerID      uuid.UUID `gorm:"type:uuid;not null" json:"player_id"`
-	MoveNumber    int       `gorm:"not null" json:"move_number"`
+	MoveNumber    int       `gorm:"not null;uniqueIndex:idx_game_move_number" json:"move_number"`
 	FromSquare    string    `gorm:"size:2;not null" json:"from_square"` // e.g., "e2"
 	ToSquare      string    `gorm:"size:2;not null" json:"to_square"`   // e.g., "e4"
 	Piece         string    `gorm:"size:2;not null" json:"piece"`       // e.g., "P" for pawn
@@ -26,8 +26,9 @@ type GameMove struct {
 	CreatedAt     time.Time `json:"created_at"`
 
 	// Relationships
-	Game   Game `gorm:"foreignKey:GameID" json:"game,omitempty"`
-	Player User `gorm:"foreignKey:PlayerID" json:"player,omitempty"`
+	Game        Game             `gorm:"foreignKey:GameID" json:"game,omitempty"`
+	Player      User             `gorm:"foreignKey:PlayerID" json:"player,omitempty"`
+	Annotations []MoveAnnotation `gorm:"foreignKey:MoveID" json:"annotations,omitempty"` // one per player who annotated the move
 }
 
 func (gm *GameMove) BeforeCreate(tx *gorm.DB) error {
@@ -0,0 +1,16 @@
+package models
+
+import "github.com/google/uuid"
+
+// GamePosition indexes the position a finished game reached after Ply
+// half-moves, for position search. The hashes and bitboards are unsigned in
+// the chess package and stored as signed integers because that's what the
+// columns hold.
+type GamePosition struct {
+	GameID     uuid.UUID `gorm:"type:uuid;primaryKey" json:"game_id"`
+	Ply        int       `gorm:"primaryKey;autoIncrement:false" json:"ply"`
+	ZobristKey int64     `gorm:"not null;index" json:"-"`                          // chess.PolyglotKey
+	Material   string    `gorm:"size:40;not null;index" json:"-"`                  // chess.MaterialSignature
+	WhitePawns int64     `gorm:"not null;index:idx_game_positions_pawns" json:"-"` // chess.PawnBitboards
+	BlackPawns int64     `gorm:"not null;index:idx_game_positions_pawns" json:"-"`
+}
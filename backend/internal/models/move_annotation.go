@@ -0,0 +1,50 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MoveAnnotation is a player's commentary on a move of a finished game. Each
+// player keeps their own on a move, which only they can change.
+type MoveAnnotation struct {
+	MoveID     uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	AuthorID   uuid.UUID `gorm:"type:uuid;primaryKey" json:"author_id"`
+	GameID     uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	Comment    string    `gorm:"type:text" json:"comment,omitempty"`
+	NAGs       NAGs      `gorm:"column:nags;type:text" json:"nags,omitempty"`
+	Arrows     Shapes    `gorm:"type:text" json:"arrows,omitempty"`     // e.g. "Ge2e4"
+	Highlights Shapes    `gorm:"type:text" json:"highlights,omitempty"` // e.g. "Rd4"
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Empty reports whether the annotation says nothing.
+func (a *MoveAnnotation) Empty() bool {
+	return a.Comment == "" && len(a.NAGs) == 0 && len(a.Arrows) == 0 && len(a.Highlights) == 0
+}
+
+// Shapes are the arrows or highlighted squares drawn on the board, as in
+// PGN's [%cal] and [%csl] commands, stored space-separated.
+type Shapes []string
+
+func (s Shapes) Value() (driver.Value, error) {
+	return strings.Join(s, " "), nil
+}
+
+func (s *Shapes) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = Shapes{}
+	case string:
+		*s = strings.Fields(v)
+	case []byte:
+		*s = strings.Fields(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into Shapes", value)
+	}
+	return nil
+}
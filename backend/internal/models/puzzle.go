@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Puzzle is a tactic to solve. FEN is the position before the opponent's
+// move that sets the puzzle up; Moves starts with that move and then
+// alternates between the solver's moves and the opponent's replies, as in
+// the common CSV puzzle dumps. Puzzles come either from such a dump or from
+// blunders in our own games.
+type Puzzle struct {
+	ID               uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ExternalID       *string    `gorm:"size:20;uniqueIndex" json:"external_id,omitempty"`                   // id in the dump it was imported from
+	GameID           *uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_puzzle_game_ply" json:"game_id,omitempty"` // our game it was mined from
+	GamePly          int        `gorm:"uniqueIndex:idx_puzzle_game_ply" json:"-"`                           // the blunder's ply in that game
+	FEN              string     `gorm:"type:text;not null" json:"fen"`
+	Moves            string     `gorm:"type:text;not null" json:"-"`  // space separated, e.g. "e2e4 d7d5"
+	Themes           string     `gorm:"size:255" json:"themes"`       // space separated, e.g. "fork middlegame"
+	Rating           float64    `gorm:"not null;index" json:"rating"` // Glicko-2
+	RatingDeviation  float64    `gorm:"not null" json:"rating_deviation"`
+	RatingVolatility float64    `gorm:"not null" json:"-"`
+	Plays            int        `gorm:"not null;default:0" json:"plays"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+func (p *Puzzle) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// PuzzleRating is a user's Glicko-2 puzzle rating, kept apart from their game
+// rating.
+type PuzzleRating struct {
+	UserID     uuid.UUID `gorm:"type:uuid;primary_key" json:"user_id"`
+	Rating     float64   `gorm:"not null" json:"rating"`
+	Deviation  float64   `gorm:"not null" json:"deviation"`
+	Volatility float64   `gorm:"not null" json:"-"`
+	Attempts   int       `gorm:"not null;default:0" json:"attempts"`
+	Solved     int       `gorm:"not null;default:0" json:"solved"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type PuzzleAttemptStatus string
+
+const (
+	PuzzleAttemptActive PuzzleAttemptStatus = "active"
+	PuzzleAttemptSolved PuzzleAttemptStatus = "solved"
+	PuzzleAttemptFailed PuzzleAttemptStatus = "failed"
+)
+
+// PuzzleAttempt is one user's try at a puzzle. Ply counts the moves of the
+// puzzle's line played so far, the opponent's setup move included.
+type PuzzleAttempt struct {
+	ID           uuid.UUID           `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID       uuid.UUID           `gorm:"type:uuid;not null;index:idx_puzzle_attempt_user_puzzle" json:"user_id"`
+	PuzzleID     uuid.UUID           `gorm:"type:uuid;not null;index:idx_puzzle_attempt_user_puzzle" json:"puzzle_id"`
+	Ply          int                 `gorm:"not null" json:"ply"`
+	Status       PuzzleAttemptStatus `gorm:"size:20;not null" json:"status"`
+	RatingChange float64             `json:"rating_change"` // the user's, once finished
+	CreatedAt    time.Time           `json:"created_at"`
+	FinishedAt   *time.Time          `json:"finished_at"`
+
+	// Relationships
+	Puzzle Puzzle `gorm:"foreignKey:PuzzleID" json:"puzzle,omitempty"`
+}
+
+func (pa *PuzzleAttempt) BeforeCreate(tx *gorm.DB) error {
+	if pa.ID == uuid.Nil {
+		pa.ID = uuid.New()
+	}
+	return nil
+}
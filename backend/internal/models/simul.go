@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SimulStatus string
+
+const (
+	SimulStatusOpen     SimulStatus = "open" // taking sign-ups
+	SimulStatusActive   SimulStatus = "active"
+	SimulStatusFinished SimulStatus = "finished"
+)
+
+// Simul is a simultaneous exhibition: its host plays every participant at
+// once, in a game of their own, with the same color on every board.
+type Simul struct {
+	ID              uuid.UUID   `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ArenaID         uuid.UUID   `gorm:"type:uuid;not null;index" json:"arena_id"`
+	HostID          uuid.UUID   `gorm:"type:uuid;not null;index" json:"host_id"`
+	Name            string      `gorm:"size:100;not null" json:"name"`
+	Variant         GameVariant `gorm:"size:20;not null;default:'standard'" json:"variant"`
+	HostColor       string      `gorm:"size:5;not null;default:'white'" json:"host_color"` // 'white' or 'black'
+	TimeControl     int         `gorm:"not null;default:1800" json:"time_control"`         // seconds, for each game
+	HostClock       bool        `gorm:"not null;default:false" json:"host_clock"`          // whether the host's clocks run
+	MaxParticipants int         `gorm:"not null;default:20" json:"max_participants"`
+	Status          SimulStatus `gorm:"size:10;not null;default:'open'" json:"status"`
+	StartedAt       *time.Time  `json:"started_at"`
+	FinishedAt      *time.Time  `json:"finished_at"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+
+	Host         *User              `gorm:"foreignKey:HostID" json:"host,omitempty"`
+	Participants []SimulParticipant `gorm:"foreignKey:SimulID" json:"participants,omitempty"`
+}
+
+func (s *Simul) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// SimulParticipant signs a user up for a simul. GameID is set when the simul
+// starts.
+type SimulParticipant struct {
+	SimulID   uuid.UUID  `gorm:"type:uuid;primaryKey" json:"simul_id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;primaryKey;index" json:"user_id"`
+	GameID    *uuid.UUID `gorm:"type:uuid" json:"game_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	User *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Game *Game `gorm:"foreignKey:GameID" json:"game,omitempty"`
+}
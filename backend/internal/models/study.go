@@ -0,0 +1,118 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Study is a persistent collection of chapters, each a tree of moves from
+// its own position. The owner shares it with members who view, contribute
+// to or administer it; public studies can be viewed by anyone.
+type Study struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	OwnerID     uuid.UUID `gorm:"type:uuid;not null;index" json:"owner_id"`
+	Name        string    `gorm:"size:100;not null" json:"name"`
+	Description string    `gorm:"type:text" json:"description,omitempty"`
+	Public      bool      `gorm:"not null;default:false" json:"public"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	Chapters []StudyChapter `gorm:"foreignKey:StudyID" json:"chapters,omitempty"`
+	Members  []StudyMember  `gorm:"foreignKey:StudyID" json:"members,omitempty"`
+}
+
+func (s *Study) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// RoleOf returns userID's role in the study, judged by the members loaded
+// with it, or nothing when they have none. The owner is an admin.
+func (s *Study) RoleOf(userID uuid.UUID) StudyRole {
+	if userID == s.OwnerID {
+		return StudyRoleAdmin
+	}
+	for _, member := range s.Members {
+		if member.UserID == userID {
+			return member.Role
+		}
+	}
+	return ""
+}
+
+type StudyRole string
+
+const (
+	StudyRoleViewer      StudyRole = "viewer"
+	StudyRoleContributor StudyRole = "contributor" // edits chapters
+	StudyRoleAdmin       StudyRole = "admin"       // also edits the study and its members
+)
+
+// Allows reports whether the role includes everything other can do.
+func (r StudyRole) Allows(other StudyRole) bool {
+	return studyRoleLevels[r] >= studyRoleLevels[other]
+}
+
+// Valid reports whether r is one of the known roles.
+func (r StudyRole) Valid() bool {
+	return studyRoleLevels[r] > 0
+}
+
+var studyRoleLevels = map[StudyRole]int{
+	StudyRoleViewer:      1,
+	StudyRoleContributor: 2,
+	StudyRoleAdmin:       3,
+}
+
+// StudyMember gives a user a role in a study. The owner isn't a member and
+// always has every right.
+type StudyMember struct {
+	StudyID   uuid.UUID `gorm:"type:uuid;primaryKey" json:"study_id"`
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey;index" json:"user_id"`
+	Role      StudyRole `gorm:"size:20;not null" json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StudyChapter is one tree of moves in a study, kept as PGN movetext.
+type StudyChapter struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	StudyID   uuid.UUID `gorm:"type:uuid;not null;index" json:"study_id"`
+	Name      string    `gorm:"size:100;not null" json:"name"`
+	Position  int       `gorm:"not null;default:0" json:"position"` // order within the study
+	FEN       string    `gorm:"type:text;not null" json:"fen"`
+	Movetext  string    `gorm:"type:text" json:"movetext"`
+	Version   int       `gorm:"not null;default:1" json:"version"` // the latest revision
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (c *StudyChapter) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// StudyRevision records a chapter as one of its edits left it, so its
+// history can be listed and compared.
+type StudyRevision struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ChapterID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_study_revision" json:"chapter_id"`
+	Version   int       `gorm:"not null;uniqueIndex:idx_study_revision" json:"version"`
+	AuthorID  uuid.UUID `gorm:"type:uuid;not null" json:"author_id"`
+	Name      string    `gorm:"size:100;not null" json:"name"`
+	FEN       string    `gorm:"type:text;not null" json:"fen"`
+	Movetext  string    `gorm:"type:text" json:"movetext"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (r *StudyRevision) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
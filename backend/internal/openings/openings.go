@@ -0,0 +1,92 @@
+// Package openings classifies chess positions by the bundled ECO opening
+// table.
+package openings
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+
+	"arcane-chess/internal/chess"
+)
+
+//go:embed eco.tsv
+var ecoTable string
+
+type Opening struct {
+	ECO  string `json:"eco"`
+	Name string `json:"name"`
+}
+
+var (
+	loadOnce sync.Once
+	byHash   map[uint64]Opening
+)
+
+// Lookup returns the opening whose table line reaches the position in fen.
+func Lookup(fen string) (Opening, bool) {
+	loadOnce.Do(func() {
+		table, err := parseTable(ecoTable)
+		if err != nil {
+			panic("openings: " + err.Error())
+		}
+		byHash = table
+	})
+
+	opening, ok := byHash[chess.PositionHash(fen)]
+	return opening, ok
+}
+
+// Classify names the opening of a game from the positions after each of its
+// moves. The deepest position found in the table wins, so a game that leaves
+// known theory keeps the name of the last named position it went through.
+func Classify(positions []string) (Opening, bool) {
+	for i := len(positions) - 1; i >= 0; i-- {
+		if opening, ok := Lookup(positions[i]); ok {
+			return opening, true
+		}
+	}
+	return Opening{}, false
+}
+
+// parseTable plays out every line of the table and indexes the position it
+// reaches.
+func parseTable(data string) (map[uint64]Opening, error) {
+	table := make(map[uint64]Opening)
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected 3 tab-separated fields, got %d", lineNumber, len(fields))
+		}
+		opening := Opening{ECO: fields[0], Name: fields[1]}
+
+		fen := chess.StandardStartFEN
+		for _, move := range strings.Fields(fields[2]) {
+			if len(move) != 4 {
+				return nil, fmt.Errorf("line %d: malformed move %q", lineNumber, move)
+			}
+			played, err := chess.NewEngine(fen).ValidateMove(move[:2], move[2:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: illegal move %s: %w", lineNumber, move, err)
+			}
+			fen = played.FENAfter
+		}
+
+		hash := chess.PositionHash(fen)
+		if existing, ok := table[hash]; ok {
+			return nil, fmt.Errorf("line %d: %s reaches the same position as %s", lineNumber, opening.Name, existing.Name)
+		}
+		table[hash] = opening
+	}
+
+	return table, scanner.Err()
+}
@@ -0,0 +1,59 @@
+package openings
+
+import (
+	"testing"
+
+	"arcane-chess/internal/chess"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundledTableLoads(t *testing.T) {
+	table, err := parseTable(ecoTable)
+
+	require.NoError(t, err)
+	assert.Greater(t, len(table), 100)
+}
+
+func TestParseTable_RejectsIllegalLine(t *testing.T) {
+	_, err := parseTable("C20\tBroken\te2e4 e7e4\n")
+
+	assert.EqualError(t, err, "line 1: illegal move e7e4: illegal move for p")
+}
+
+func TestParseTable_RejectsDuplicatePosition(t *testing.T) {
+	_, err := parseTable("A45\tIndian Defense\td2d4 g8f6\nA45\tAgain\tg1f3 g8f6 f3g1 f6g8 d2d4 g8f6\n")
+
+	assert.ErrorContains(t, err, "line 2: Again reaches the same position as Indian Defense")
+}
+
+func TestClassify_Transposition(t *testing.T) {
+	// 1.c4 e6 2.d4 Nf6 3.Nc3 Bb4 is a Nimzo-Indian by transposition
+	positions := play(t, "c2c4", "e7e6", "d2d4", "g8f6", "b1c3", "f8b4", "a2a3")
+
+	opening, ok := Classify(positions)
+
+	require.True(t, ok)
+	assert.Equal(t, Opening{ECO: "E20", Name: "Nimzo-Indian Defense"}, opening)
+}
+
+func TestClassify_Unknown(t *testing.T) {
+	_, ok := Classify(play(t, "h2h4", "h7h5"))
+
+	assert.False(t, ok)
+}
+
+func play(t *testing.T, moves ...string) []string {
+	t.Helper()
+
+	var positions []string
+	fen := chess.StandardStartFEN
+	for _, move := range moves {
+		played, err := chess.NewEngine(fen).ValidateMove(move[:2], move[2:])
+		require.NoError(t, err, move)
+		fen = played.FENAfter
+		positions = append(positions, fen)
+	}
+	return positions
+}
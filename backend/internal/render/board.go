@@ -0,0 +1,362 @@
+// Package render draws board diagrams as SVG or PNG, and game replays as
+// animated GIFs, for sharing positions and games outside the client.
+package render
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+)
+
+const (
+	squareUnits = 45
+	boardUnits  = 8 * squareUnits
+
+	DefaultSize = 400
+	MinSize     = 64
+	MaxSize     = 2048
+
+	outlineWidth = 1.5
+)
+
+type Format string
+
+const (
+	FormatSVG Format = "svg"
+	FormatPNG Format = "png"
+)
+
+var ErrInvalidOptions = errors.New("invalid render options")
+
+// Options describe a diagram. Moves and arrows are pairs of squares such as
+// "e2e4"; Size is the image's width and height in pixels.
+type Options struct {
+	FEN      string
+	LastMove string
+	Arrows   []string
+	Flipped  bool // black at the bottom
+	Theme    models.ArenaTheme
+	Size     int
+}
+
+type palette struct {
+	light, dark, highlight, arrow color.RGBA
+}
+
+// Board colours follow the arena themes of the client.
+var palettes = map[models.ArenaTheme]palette{
+	models.ArenaThemeClassic: {rgb(0xf0d9b5), rgb(0xb58863), rgba(0xcdd26a, 0xc0), rgba(0x15781b, 0xc0)},
+	models.ArenaThemeMystic:  {rgb(0xdcd0f0), rgb(0x7b5ea7), rgba(0xf2c14e, 0xa0), rgba(0xe0457b, 0xc0)},
+	models.ArenaThemeFuture:  {rgb(0xd0e4f5), rgb(0x3c6e91), rgba(0x4fe3c1, 0xa0), rgba(0xff8c1a, 0xc0)},
+	models.ArenaThemeNature:  {rgb(0xe8edc9), rgb(0x6f8f4e), rgba(0xf5e663, 0xa0), rgba(0x8a3b12, 0xc0)},
+	models.ArenaThemeFire:    {rgb(0xf6d7a7), rgb(0xc0503a), rgba(0xffd23f, 0xa0), rgba(0x7a1f0f, 0xc0)},
+	models.ArenaThemeIce:     {rgb(0xeaf4fb), rgb(0x8fb8d3), rgba(0x9ee6ff, 0xa0), rgba(0x1d4e89, 0xc0)},
+}
+
+var (
+	whiteFill    = rgb(0xffffff)
+	blackFill    = rgb(0x333333)
+	outlineColor = rgb(0x000000)
+	blackDetail  = rgb(0xffffff)
+)
+
+func rgb(hex uint32) color.RGBA {
+	return rgba(hex, 0xff)
+}
+
+func rgba(hex uint32, alpha uint8) color.RGBA {
+	return color.RGBA{R: uint8(hex >> 16), G: uint8(hex >> 8), B: uint8(hex), A: alpha}
+}
+
+// Normalize validates o and fills in the default theme and size.
+func (o Options) Normalize() (Options, error) {
+	if err := chess.ValidateFEN(o.FEN); err != nil {
+		return o, fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+	}
+	if o.Theme == "" {
+		o.Theme = models.ArenaThemeClassic
+	}
+	if _, ok := palettes[o.Theme]; !ok {
+		return o, fmt.Errorf("%w: unknown theme %q", ErrInvalidOptions, o.Theme)
+	}
+	if o.Size == 0 {
+		o.Size = DefaultSize
+	}
+	if o.Size < MinSize || o.Size > MaxSize {
+		return o, fmt.Errorf("%w: size must be between %d and %d", ErrInvalidOptions, MinSize, MaxSize)
+	}
+	if o.LastMove != "" {
+		if _, _, ok := parseMove(o.LastMove); !ok {
+			return o, fmt.Errorf("%w: invalid last move %q", ErrInvalidOptions, o.LastMove)
+		}
+	}
+	for _, arrow := range o.Arrows {
+		if _, _, ok := parseMove(arrow); !ok {
+			return o, fmt.Errorf("%w: invalid arrow %q", ErrInvalidOptions, arrow)
+		}
+	}
+	return o, nil
+}
+
+// Hash identifies the image o renders to in format. Only the placement part
+// of the FEN is drawn, so positions differing in the other fields share it.
+func (o Options) Hash(format Format) string {
+	placement := strings.Fields(o.FEN)
+	key := strings.Join([]string{
+		string(format),
+		strings.Join(placement[:min(len(placement), 1)], ""),
+		o.LastMove,
+		strings.Join(o.Arrows, ","),
+		fmt.Sprint(o.Flipped),
+		string(o.Theme),
+		fmt.Sprint(o.Size),
+	}, "|")
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Render draws the diagram described by o.
+func Render(o Options, format Format) ([]byte, error) {
+	o, err := o.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatSVG:
+		return renderSVG(o), nil
+	case FormatPNG:
+		return renderPNG(o)
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidOptions, format)
+	}
+}
+
+// ContentType returns the MIME type of images in format.
+func ContentType(format Format) string {
+	if format == FormatPNG {
+		return "image/png"
+	}
+	return "image/svg+xml"
+}
+
+// square is a board square as a file and rank counted from a1.
+type square struct {
+	file, rank int
+}
+
+func parseSquare(name string) (square, bool) {
+	if len(name) != 2 || name[0] < 'a' || name[0] > 'h' || name[1] < '1' || name[1] > '8' {
+		return square{}, false
+	}
+	return square{int(name[0] - 'a'), int(name[1] - '1')}, true
+}
+
+func parseMove(move string) (square, square, bool) {
+	if len(move) != 4 {
+		return square{}, square{}, false
+	}
+	from, ok := parseSquare(move[:2])
+	if !ok {
+		return square{}, square{}, false
+	}
+	to, ok := parseSquare(move[2:])
+	return from, to, ok
+}
+
+// origin returns the top-left corner of sq in board units.
+func (o Options) origin(sq square) point {
+	column, row := sq.file, 7-sq.rank
+	if o.Flipped {
+		column, row = 7-column, 7-row
+	}
+	return point{float64(column * squareUnits), float64(row * squareUnits)}
+}
+
+func (o Options) center(sq square) point {
+	corner := o.origin(sq)
+	return point{corner.x + squareUnits/2, corner.y + squareUnits/2}
+}
+
+type placedPiece struct {
+	square square
+	kind   byte // lower case, as in FEN
+	white  bool
+	shape  []part
+}
+
+func (o Options) pieces() []placedPiece {
+	board := chess.NewBoardFromFEN(o.FEN)
+	var pieces []placedPiece
+	for rank := 0; rank < 8; rank++ {
+		for file := 0; file < 8; file++ {
+			piece := board.GetPiece(rank, file)
+			if piece == "" {
+				continue
+			}
+			kind := strings.ToLower(piece)[0]
+			shape, ok := pieceShapes[kind]
+			if !ok {
+				continue
+			}
+			pieces = append(pieces, placedPiece{
+				square: square{file, 7 - rank},
+				kind:   kind,
+				white:  piece == strings.ToUpper(piece),
+				shape:  shape,
+			})
+		}
+	}
+	return pieces
+}
+
+func (o Options) highlighted() []square {
+	from, to, ok := parseMove(o.LastMove)
+	if !ok {
+		return nil
+	}
+	return []square{from, to}
+}
+
+// arrowPolygon outlines an arrow between the centres of a move's squares.
+func (o Options) arrowPolygon(move string) []point {
+	from, to, _ := parseMove(move)
+	start, end := o.center(from), o.center(to)
+	dx, dy := end.x-start.x, end.y-start.y
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return nil
+	}
+
+	const shaftWidth, headWidth, headLength = squareUnits * 0.2, squareUnits * 0.5, squareUnits * 0.45
+	ux, uy := dx/length, dy/length
+	nx, ny := -uy, ux
+	neck := point{end.x - ux*headLength, end.y - uy*headLength}
+	side := func(p point, width float64) point {
+		return point{p.x + nx*width/2, p.y + ny*width/2}
+	}
+	return []point{
+		side(start, shaftWidth), side(neck, shaftWidth), side(neck, headWidth),
+		end,
+		side(neck, -headWidth), side(neck, -shaftWidth), side(start, -shaftWidth),
+	}
+}
+
+func renderPNG(o Options) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, drawBoard(o, newSpriteSet())); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// drawBoard rasterizes the diagram described by normalized options, taking
+// pieces from sprites, which must be for the same size of board.
+func drawBoard(o Options, sprites *spriteSet) *image.RGBA {
+	colors := palettes[o.Theme]
+	c := newCanvas(o.Size)
+
+	for rank := 0; rank < 8; rank++ {
+		for file := 0; file < 8; file++ {
+			fill := colors.dark
+			if (file+rank)%2 == 1 {
+				fill = colors.light
+			}
+			c.fillRect(c.squareRect(o.origin(square{file, rank})), fill)
+		}
+	}
+	for _, sq := range o.highlighted() {
+		c.fillRect(c.squareRect(o.origin(sq)), colors.highlight)
+	}
+
+	for _, piece := range o.pieces() {
+		corner := o.origin(piece.square)
+		at := image.Pt(int(math.Round(corner.x*c.scale)), int(math.Round(corner.y*c.scale)))
+		sprite := sprites.get(piece, c.scale)
+		draw.Draw(c.img, sprite.Bounds().Add(at), sprite, image.Point{}, draw.Over)
+	}
+
+	for _, arrow := range o.Arrows {
+		c.fillPolygon(o.arrowPolygon(arrow), colors.arrow)
+	}
+	return c.img
+}
+
+func renderSVG(o Options) []byte {
+	colors := palettes[o.Theme]
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d">`,
+		boardUnits, boardUnits, o.Size, o.Size)
+
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="%s"/>`, boardUnits, boardUnits, svgColor(colors.light))
+	for rank := 0; rank < 8; rank++ {
+		for file := 0; file < 8; file++ {
+			if (file+rank)%2 == 0 {
+				corner := o.origin(square{file, rank})
+				fmt.Fprintf(&b, `<rect x="%g" y="%g" width="%d" height="%d" fill="%s"/>`,
+					corner.x, corner.y, squareUnits, squareUnits, svgColor(colors.dark))
+			}
+		}
+	}
+	for _, sq := range o.highlighted() {
+		corner := o.origin(sq)
+		fmt.Fprintf(&b, `<rect class="last-move" x="%g" y="%g" width="%d" height="%d" fill="%s" fill-opacity="%s"/>`,
+			corner.x, corner.y, squareUnits, squareUnits, svgColor(colors.highlight), svgOpacity(colors.highlight))
+	}
+
+	for _, piece := range o.pieces() {
+		fill, detailColor := whiteFill, outlineColor
+		if !piece.white {
+			fill, detailColor = blackFill, blackDetail
+		}
+		corner := o.origin(piece.square)
+		fmt.Fprintf(&b, `<g transform="translate(%g %g)" stroke="%s" stroke-width="%g" stroke-linejoin="round" fill="%s">`,
+			corner.x, corner.y, svgColor(outlineColor), outlineWidth, svgColor(fill))
+		for _, p := range piece.shape {
+			attrs := ""
+			if p.detail {
+				attrs = fmt.Sprintf(` fill="%s" stroke="none"`, svgColor(detailColor))
+			}
+			if p.circle != nil {
+				fmt.Fprintf(&b, `<circle cx="%g" cy="%g" r="%g"%s/>`, p.circle.center.x, p.circle.center.y, p.circle.radius, attrs)
+				continue
+			}
+			fmt.Fprintf(&b, `<polygon points="%s"%s/>`, svgPoints(p.polygon), attrs)
+		}
+		b.WriteString(`</g>`)
+	}
+
+	for _, arrow := range o.Arrows {
+		fmt.Fprintf(&b, `<polygon class="arrow" points="%s" fill="%s" fill-opacity="%s"/>`,
+			svgPoints(o.arrowPolygon(arrow)), svgColor(colors.arrow), svgOpacity(colors.arrow))
+	}
+
+	b.WriteString(`</svg>`)
+	return []byte(b.String())
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func svgOpacity(c color.RGBA) string {
+	return fmt.Sprintf("%.2f", float64(c.A)/255)
+}
+
+func svgPoints(points []point) string {
+	parts := make([]string, len(points))
+	for i, p := range points {
+		parts[i] = fmt.Sprintf("%.2f,%.2f", p.x, p.y)
+	}
+	return strings.Join(parts, " ")
+}
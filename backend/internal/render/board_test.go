@@ -0,0 +1,86 @@
+package render
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender_SVG(t *testing.T) {
+	data, err := Render(Options{
+		FEN:      chess.StandardStartFEN,
+		LastMove: "e2e4",
+		Arrows:   []string{"g1f3"},
+	}, FormatSVG)
+	require.NoError(t, err)
+
+	svg := string(data)
+	assert.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg"`))
+	assert.Contains(t, svg, `width="400"`)
+	assert.Equal(t, 32, strings.Count(svg, "<g "), "one group per piece")
+	assert.Equal(t, 2, strings.Count(svg, `class="last-move"`))
+	assert.Equal(t, 1, strings.Count(svg, `class="arrow"`))
+	assert.Contains(t, svg, "#b58863", "classic dark squares")
+}
+
+func TestRender_PNG(t *testing.T) {
+	data, err := Render(Options{FEN: "8/8/8/8/8/8/8/K6k w - - 0 1", Theme: models.ArenaThemeIce, Size: 160}, FormatPNG)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 160, img.Bounds().Dx())
+
+	// a8 is light and h8 dark; corners are clear of pieces
+	r, g, b, _ := img.At(1, 1).RGBA()
+	assert.Equal(t, []uint32{0xea, 0xf4, 0xfb}, []uint32{r >> 8, g >> 8, b >> 8})
+	r, g, b, _ = img.At(158, 1).RGBA()
+	assert.Equal(t, []uint32{0x8f, 0xb8, 0xd3}, []uint32{r >> 8, g >> 8, b >> 8})
+}
+
+func TestRender_Flipped(t *testing.T) {
+	opts := Options{FEN: "8/8/8/8/8/8/8/K6k w - - 0 1", Flipped: true}
+	assert.Equal(t, point{315, 0}, opts.origin(square{0, 0}), "a1 at the top right")
+
+	opts.Flipped = false
+	assert.Equal(t, point{0, 315}, opts.origin(square{0, 0}), "a1 at the bottom left")
+}
+
+func TestRender_InvalidOptions(t *testing.T) {
+	valid := Options{FEN: chess.StandardStartFEN}
+	tests := map[string]Options{
+		"fen":       {FEN: "8/8 w - - 0 1"},
+		"theme":     {FEN: valid.FEN, Theme: "neon"},
+		"size":      {FEN: valid.FEN, Size: 10},
+		"last move": {FEN: valid.FEN, LastMove: "e2e9"},
+		"arrow":     {FEN: valid.FEN, Arrows: []string{"e2"}},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Render(opts, FormatSVG)
+			assert.ErrorIs(t, err, ErrInvalidOptions)
+		})
+	}
+}
+
+func TestOptions_Hash(t *testing.T) {
+	opts, err := Options{FEN: chess.StandardStartFEN}.Normalize()
+	require.NoError(t, err)
+
+	// Only the placement is drawn, so the move counters don't matter
+	sameBoard := opts
+	sameBoard.FEN = strings.Replace(opts.FEN, "0 1", "4 9", 1)
+	assert.Equal(t, opts.Hash(FormatSVG), sameBoard.Hash(FormatSVG))
+
+	assert.NotEqual(t, opts.Hash(FormatSVG), opts.Hash(FormatPNG))
+	flipped := opts
+	flipped.Flipped = true
+	assert.NotEqual(t, opts.Hash(FormatSVG), flipped.Hash(FormatSVG))
+}
@@ -0,0 +1,292 @@
+package render
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"time"
+
+	"arcane-chess/internal/models"
+)
+
+// Animated replays show one frame per position with the players' names and
+// clocks above and below the board, and optionally a last frame announcing
+// the result.
+
+const (
+	DefaultGIFDelay = time.Second
+	MinGIFDelay     = 100 * time.Millisecond
+	MaxGIFDelay     = 10 * time.Second
+	MaxGIFFrames    = 600
+	MaxGIFSize      = 600
+	// MaxGIFPixels bounds the pixels drawn for a replay, over all its frames:
+	// a full-length replay at the default size, or a shorter one larger.
+	MaxGIFPixels = 120_000_000
+
+	resultFrameHold = 3 // the result frame shows this many times longer
+	minCaptionBar   = 16
+)
+
+var (
+	captionBackground = rgb(0x262421)
+	captionText       = rgb(0xffffff)
+	captionClock      = rgb(0xbababa)
+	resultBackground  = rgba(0x262421, 0xe0)
+)
+
+// GIFFrame is one position of a replay. Clocks are only drawn when the
+// options ask for them.
+type GIFFrame struct {
+	FEN        string
+	LastMove   string
+	WhiteClock time.Duration
+	BlackClock time.Duration
+}
+
+type GIFOptions struct {
+	White   string
+	Black   string
+	Frames  []GIFFrame
+	Result  string // shown on a final frame when set, e.g. "1-0 checkmate"
+	Clocks  bool
+	Flipped bool
+	Theme   models.ArenaTheme
+	Size    int // of the board; the captions add to the height
+	Delay   time.Duration
+}
+
+// Normalize validates o and fills in the default theme, size and delay.
+func (o GIFOptions) Normalize() (GIFOptions, error) {
+	if len(o.Frames) == 0 {
+		return o, fmt.Errorf("%w: no frames", ErrInvalidOptions)
+	}
+	if len(o.Frames) > MaxGIFFrames {
+		return o, fmt.Errorf("%w: at most %d frames", ErrInvalidOptions, MaxGIFFrames)
+	}
+	if o.Delay == 0 {
+		o.Delay = DefaultGIFDelay
+	}
+	if o.Delay < MinGIFDelay || o.Delay > MaxGIFDelay {
+		return o, fmt.Errorf("%w: delay must be between %v and %v", ErrInvalidOptions, MinGIFDelay, MaxGIFDelay)
+	}
+
+	for _, frame := range o.Frames {
+		board, err := o.board(frame).Normalize()
+		if err != nil {
+			return o, err
+		}
+		o.Theme, o.Size = board.Theme, board.Size
+	}
+	if o.Size > MaxGIFSize {
+		return o, fmt.Errorf("%w: replays are at most %d pixels wide", ErrInvalidOptions, MaxGIFSize)
+	}
+	if o.pixels() > MaxGIFPixels {
+		return o, fmt.Errorf("%w: %d frames are too many at %d pixels; use fewer or a smaller size", ErrInvalidOptions, o.frameCount(), o.Size)
+	}
+	return o, nil
+}
+
+// frameCount is the number of frames in the animation, the result's included.
+func (o GIFOptions) frameCount() int {
+	if o.Result != "" {
+		return len(o.Frames) + 1
+	}
+	return len(o.Frames)
+}
+
+// pixels is the number of pixels drawn for the animation.
+func (o GIFOptions) pixels() int {
+	return o.frameCount() * o.Size * (o.Size + 2*o.captionBar())
+}
+
+// Hash identifies the animation o renders to.
+func (o GIFOptions) Hash() string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "gif|%q|%q|%q|%v|%v|%s|%d|%d", o.White, o.Black, o.Result, o.Clocks, o.Flipped, o.Theme, o.Size, o.Delay)
+	for _, frame := range o.Frames {
+		fmt.Fprintf(hash, "|%s|%s|%d|%d", frame.FEN, frame.LastMove, frame.WhiteClock, frame.BlackClock)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (o GIFOptions) board(frame GIFFrame) Options {
+	return Options{FEN: frame.FEN, LastMove: frame.LastMove, Flipped: o.Flipped, Theme: o.Theme, Size: o.Size}
+}
+
+// RenderGIF draws the replay described by o as a looping animated GIF.
+func RenderGIF(o GIFOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteGIF(&buf, o); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteGIF draws the replay described by o to w, encoding each frame as soon
+// as it's drawn.
+func WriteGIF(w io.Writer, o GIFOptions) error {
+	o, err := o.Normalize()
+	if err != nil {
+		return err
+	}
+
+	q := newQuantizer(palettes[o.Theme])
+	sprites := newSpriteSet()
+	delay := int(o.Delay / (10 * time.Millisecond))
+	var stream *gifStream
+	var last *image.RGBA
+	for _, frame := range o.Frames {
+		last = o.drawFrame(frame, sprites)
+		if stream == nil {
+			stream = newGIFStream(w, last.Bounds(), q.palette)
+		}
+		stream.frame(q.paletted(last), delay)
+	}
+	if o.Result != "" {
+		o.drawResult(last)
+		stream.frame(q.paletted(last), delay*resultFrameHold)
+	}
+
+	if err := stream.close(); err != nil {
+		return fmt.Errorf("failed to encode GIF: %w", err)
+	}
+	return nil
+}
+
+func (o GIFOptions) captionBar() int {
+	return max(minCaptionBar, o.Size/12)
+}
+
+func (o GIFOptions) textScale() int {
+	return max(1, o.captionBar()*6/10/glyphHeight)
+}
+
+func (o GIFOptions) drawFrame(frame GIFFrame, sprites *spriteSet) *image.RGBA {
+	bar := o.captionBar()
+	img := image.NewRGBA(image.Rect(0, 0, o.Size, o.Size+2*bar))
+	fillRect(img, img.Bounds(), captionBackground)
+	draw.Draw(img, image.Rect(0, bar, o.Size, bar+o.Size), drawBoard(o.board(frame), sprites), image.Point{}, draw.Src)
+
+	top, topClock := o.Black, frame.BlackClock
+	bottom, bottomClock := o.White, frame.WhiteClock
+	if o.Flipped {
+		top, bottom = bottom, top
+		topClock, bottomClock = bottomClock, topClock
+	}
+	o.drawCaption(img, 0, top, topClock)
+	o.drawCaption(img, bar+o.Size, bottom, bottomClock)
+	return img
+}
+
+// drawCaption writes a player's name, and clock if shown, in the bar at y.
+func (o GIFOptions) drawCaption(img *image.RGBA, y int, name string, clock time.Duration) {
+	bar, scale := o.captionBar(), o.textScale()
+	padding := (bar - glyphHeight*scale) / 2
+	textY := y + padding
+
+	nameWidth := o.Size - 2*padding
+	if o.Clocks {
+		text := formatClock(clock)
+		width := textWidth(text, scale)
+		drawText(img, o.Size-padding-width, textY, scale, text, captionClock)
+		nameWidth -= width + 2*padding
+	}
+	drawText(img, padding, textY, scale, fitText(name, scale, nameWidth), captionText)
+}
+
+// drawResult lays a band with the result across the middle of a frame.
+func (o GIFOptions) drawResult(img *image.RGBA) {
+	scale := o.textScale() * 2
+	if textWidth(o.Result, scale) > o.Size*9/10 {
+		scale /= 2
+	}
+	text := fitText(o.Result, scale, o.Size*9/10)
+	height := glyphHeight * scale * 3
+	top := img.Bounds().Dy()/2 - height/2
+
+	band := image.Rect(0, top, o.Size, top+height)
+	draw.Draw(img, band, image.NewUniform(resultBackground), image.Point{}, draw.Over)
+	drawText(img, (o.Size-textWidth(text, scale))/2, top+glyphHeight*scale, scale, text, captionText)
+}
+
+func formatClock(clock time.Duration) string {
+	seconds := max(0, int(clock/time.Second))
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// quantizer maps frames onto one palette: the theme's exact colours, so
+// squares stay flat, then a colour cube and greys for the anti-aliased
+// edges. Pixels are matched to the nearest entry without dithering.
+type quantizer struct {
+	palette color.Palette
+	cache   map[color.RGBA]uint8
+}
+
+func newQuantizer(colors palette) *quantizer {
+	exact := []color.RGBA{
+		colors.light, colors.dark,
+		over(colors.highlight, colors.light), over(colors.highlight, colors.dark),
+		whiteFill, blackFill, outlineColor, blackDetail,
+		captionBackground, captionText, captionClock,
+		over(resultBackground, colors.light), over(resultBackground, colors.dark),
+	}
+
+	seen := make(map[color.RGBA]bool)
+	var p color.Palette
+	add := func(c color.RGBA) {
+		if !seen[c] && len(p) < 256 {
+			seen[c] = true
+			p = append(p, c)
+		}
+	}
+	for _, c := range exact {
+		add(c)
+	}
+	for r := 0; r < 6; r++ {
+		for g := 0; g < 6; g++ {
+			for b := 0; b < 6; b++ {
+				add(color.RGBA{uint8(r * 51), uint8(g * 51), uint8(b * 51), 0xff})
+			}
+		}
+	}
+	for grey := 0; len(p) < 256 && grey < 256; grey += 8 {
+		add(color.RGBA{uint8(grey), uint8(grey), uint8(grey), 0xff})
+	}
+	return &quantizer{palette: p, cache: make(map[color.RGBA]uint8)}
+}
+
+func (q *quantizer) paletted(img *image.RGBA) *image.Paletted {
+	out := image.NewPaletted(img.Bounds(), q.palette)
+	var last color.RGBA
+	var lastIndex uint8
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		c := color.RGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], 0xff}
+		if c != last || i == 0 {
+			index, ok := q.cache[c]
+			if !ok {
+				index = uint8(q.palette.Index(c))
+				q.cache[c] = index
+			}
+			last, lastIndex = c, index
+		}
+		out.Pix[i/4] = lastIndex
+	}
+	return out
+}
+
+// over composites a translucent colour over an opaque one.
+func over(top, bottom color.RGBA) color.RGBA {
+	alpha := float64(top.A) / 255
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a)*alpha + float64(b)*(1-alpha) + 0.5)
+	}
+	return color.RGBA{mix(top.R, bottom.R), mix(top.G, bottom.G), mix(top.B, bottom.B), 0xff}
+}
@@ -0,0 +1,135 @@
+package render
+
+import (
+	"bufio"
+	"compress/lzw"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+)
+
+// gifStream writes a looping animation one frame at a time, so that a replay
+// never holds more than the frame being drawn. image/gif only encodes whole
+// animations. Every frame shares the global palette, which is what the
+// quantizer gives them anyway.
+type gifStream struct {
+	w      *bufio.Writer
+	bounds image.Rectangle
+	depth  int // bits per palette index
+	err    error
+}
+
+func newGIFStream(w io.Writer, bounds image.Rectangle, p color.Palette) *gifStream {
+	s := &gifStream{w: bufio.NewWriter(w), bounds: bounds, depth: 1}
+	for 1<<s.depth < len(p) {
+		s.depth++
+	}
+
+	s.write([]byte("GIF89a"))
+	s.uint16(bounds.Dx())
+	s.uint16(bounds.Dy())
+	// A global colour table of 2^depth entries, and 8 bits of colour resolution
+	s.write([]byte{0x80 | 0x70 | byte(s.depth-1), 0, 0})
+	table := make([]byte, 3<<s.depth)
+	for i, c := range p {
+		r, g, b, _ := c.RGBA()
+		table[3*i], table[3*i+1], table[3*i+2] = byte(r>>8), byte(g>>8), byte(b>>8)
+	}
+	s.write(table)
+	// Loop forever
+	s.write([]byte{0x21, 0xff, 0x0b})
+	s.write([]byte("NETSCAPE2.0"))
+	s.write([]byte{0x03, 0x01, 0x00, 0x00, 0x00})
+	return s
+}
+
+// frame writes img, shown for delay hundredths of a second.
+func (s *gifStream) frame(img *image.Paletted, delay int) {
+	s.write([]byte{0x21, 0xf9, 0x04, 0x00})
+	s.uint16(delay)
+	s.write([]byte{0x00, 0x00})
+
+	s.write([]byte{0x2c})
+	s.uint16(0)
+	s.uint16(0)
+	s.uint16(s.bounds.Dx())
+	s.uint16(s.bounds.Dy())
+	s.write([]byte{0x00})
+
+	// LZW codes need at least 2 bits
+	litWidth := max(2, s.depth)
+	s.write([]byte{byte(litWidth)})
+	blocks := &gifBlocks{w: s.w}
+	lzwWriter := lzw.NewWriter(blocks, lzw.LSB, litWidth)
+	if _, err := lzwWriter.Write(img.Pix); err != nil && s.err == nil {
+		s.err = err
+	}
+	if err := lzwWriter.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	if err := blocks.close(); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// close ends the animation, returning the first error met writing it.
+func (s *gifStream) close() error {
+	s.write([]byte{0x3b})
+	if err := s.w.Flush(); err != nil && s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
+
+func (s *gifStream) write(b []byte) {
+	if s.err == nil {
+		_, s.err = s.w.Write(b)
+	}
+}
+
+func (s *gifStream) uint16(v int) {
+	s.write(binary.LittleEndian.AppendUint16(nil, uint16(v)))
+}
+
+// gifBlocks splits image data into the sub-blocks of up to 255 bytes GIF
+// stores it in, ending with an empty one.
+type gifBlocks struct {
+	w   io.Writer
+	buf [256]byte
+	n   int
+}
+
+func (b *gifBlocks) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		copied := copy(b.buf[1+b.n:], p)
+		b.n += copied
+		p = p[copied:]
+		written += copied
+		if b.n == 255 {
+			if err := b.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (b *gifBlocks) flush() error {
+	if b.n == 0 {
+		return nil
+	}
+	b.buf[0] = byte(b.n)
+	_, err := b.w.Write(b.buf[:1+b.n])
+	b.n = 0
+	return err
+}
+
+func (b *gifBlocks) close() error {
+	if err := b.flush(); err != nil {
+		return err
+	}
+	_, err := b.w.Write([]byte{0x00})
+	return err
+}
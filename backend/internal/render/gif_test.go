@@ -0,0 +1,89 @@
+package render
+
+import (
+	"bytes"
+	"image/gif"
+	"testing"
+	"time"
+
+	"arcane-chess/internal/chess"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scholarsFrames() []GIFFrame {
+	return []GIFFrame{
+		{FEN: chess.StandardStartFEN, WhiteClock: 5 * time.Minute, BlackClock: 5 * time.Minute},
+		{FEN: "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1", LastMove: "e2e4", WhiteClock: 298 * time.Second, BlackClock: 5 * time.Minute},
+		{FEN: "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2", LastMove: "e7e5", WhiteClock: 298 * time.Second, BlackClock: 297 * time.Second},
+	}
+}
+
+func TestRenderGIF(t *testing.T) {
+	data, err := RenderGIF(GIFOptions{
+		White:  "alice",
+		Black:  "bob",
+		Frames: scholarsFrames(),
+		Result: "1-0 checkmate",
+		Clocks: true,
+		Size:   200,
+		Delay:  500 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	animation, err := gif.DecodeAll(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Len(t, animation.Image, 4, "a frame per position and one for the result")
+	assert.Equal(t, []int{50, 50, 50, 150}, animation.Delay)
+	assert.Equal(t, 200, animation.Image[0].Bounds().Dx())
+	assert.Equal(t, 200+2*minCaptionBar, animation.Image[0].Bounds().Dy())
+}
+
+func TestRenderGIF_InvalidOptions(t *testing.T) {
+	_, err := RenderGIF(GIFOptions{})
+	assert.ErrorIs(t, err, ErrInvalidOptions, "no frames")
+
+	_, err = RenderGIF(GIFOptions{Frames: scholarsFrames(), Delay: time.Minute})
+	assert.ErrorIs(t, err, ErrInvalidOptions, "delay")
+
+	_, err = RenderGIF(GIFOptions{Frames: []GIFFrame{{FEN: "8/8 w"}}})
+	assert.ErrorIs(t, err, ErrInvalidOptions, "FEN")
+}
+
+func TestGIFOptions_Hash(t *testing.T) {
+	opts := GIFOptions{White: "alice", Black: "bob", Frames: scholarsFrames()}
+	assert.Equal(t, opts.Hash(), GIFOptions{White: "alice", Black: "bob", Frames: scholarsFrames()}.Hash())
+
+	moved := opts
+	moved.Frames = scholarsFrames()[:2]
+	assert.NotEqual(t, opts.Hash(), moved.Hash())
+}
+
+func TestFormatClock(t *testing.T) {
+	assert.Equal(t, "0:00", formatClock(-time.Second))
+	assert.Equal(t, "4:58", formatClock(298*time.Second))
+	assert.Equal(t, "1:30:05", formatClock(90*time.Minute+5*time.Second))
+}
+
+func TestFitText(t *testing.T) {
+	assert.Equal(t, "alice", fitText("alice", 1, 100))
+	assert.Equal(t, "magn..", fitText("magnuscarlsen", 1, textWidth("magn..", 1)))
+	assert.Equal(t, "", fitText("magnus", 1, 5))
+}
+
+func TestRenderGIF_ResourceLimits(t *testing.T) {
+	_, err := RenderGIF(GIFOptions{Frames: scholarsFrames(), Size: MaxGIFSize + 1})
+	assert.ErrorIs(t, err, ErrInvalidOptions, "size")
+
+	frames := make([]GIFFrame, MaxGIFFrames)
+	for i := range frames {
+		frames[i] = GIFFrame{FEN: chess.StandardStartFEN}
+	}
+	_, err = GIFOptions{Frames: frames}.Normalize()
+	assert.NoError(t, err, "a full-length replay at the default size")
+	_, err = GIFOptions{Frames: frames, Size: MaxGIFSize}.Normalize()
+	assert.ErrorIs(t, err, ErrInvalidOptions, "a full-length replay at the largest size")
+	_, err = GIFOptions{Frames: frames[:200], Size: MaxGIFSize}.Normalize()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,131 @@
+package render
+
+import (
+	"image"
+	"math"
+)
+
+// Pieces are drawn from polygons and circles in a 45×45 square, in order, so
+// later parts cover the outlines of earlier ones. The same shapes feed the
+// SVG output and the PNG rasterizer.
+
+type part struct {
+	polygon []point
+	circle  *circleShape
+	detail  bool // drawn in the outline colour, without an outline
+}
+
+type circleShape struct {
+	center point
+	radius float64
+}
+
+func poly(points ...float64) part {
+	p := part{polygon: make([]point, 0, len(points)/2)}
+	for i := 0; i+1 < len(points); i += 2 {
+		p.polygon = append(p.polygon, point{points[i], points[i+1]})
+	}
+	return p
+}
+
+func disc(x, y, radius float64) part {
+	return part{circle: &circleShape{point{x, y}, radius}}
+}
+
+func detail(p part) part {
+	p.detail = true
+	return p
+}
+
+var pieceBase = poly(10, 36, 35, 36, 35, 39, 10, 39)
+
+var pieceShapes = map[byte][]part{
+	'p': {
+		poly(11, 36, 34, 36, 34, 39, 11, 39),
+		poly(15, 36, 30, 36, 27, 26, 18, 26),
+		poly(17, 27, 28, 27, 26, 23, 19, 23),
+		disc(22.5, 17.5, 5.5),
+	},
+	'r': {
+		poly(9, 36, 36, 36, 36, 39, 9, 39),
+		poly(12, 32, 33, 32, 33, 36, 12, 36),
+		poly(14, 17, 31, 17, 31, 32, 14, 32),
+		poly(12, 14, 33, 14, 33, 17, 12, 17),
+		poly(12, 14, 12, 9, 16, 9, 16, 11, 20, 11, 20, 9, 25, 9, 25, 11, 29, 11, 29, 9, 33, 9, 33, 14),
+	},
+	'n': {
+		pieceBase,
+		poly(13, 36, 33, 36, 31, 30, 31, 20, 29, 14, 25, 10, 22, 8, 21, 10, 19, 10, 17, 12,
+			11, 19, 9, 24, 10, 27, 13, 27, 16, 25, 19, 23, 20, 25, 16, 30, 14, 34),
+		detail(disc(17, 16, 1.3)),
+	},
+	'b': {
+		pieceBase,
+		poly(15, 36, 30, 36, 28, 31, 17, 31),
+		poly(17, 31, 28, 31, 30, 26, 29, 21, 26, 16, 22.5, 12, 19, 16, 16, 21, 15, 26),
+		disc(22.5, 10, 2.5),
+		detail(poly(24, 18, 25.5, 19.5, 21, 24, 19.5, 22.5)),
+	},
+	'q': {
+		pieceBase,
+		poly(12, 36, 33, 36, 31, 29, 37, 14, 29, 24, 29, 11, 25, 23, 22.5, 9, 20, 23,
+			16, 11, 16, 24, 8, 14, 14, 29),
+		disc(8, 13, 2),
+		disc(16, 10, 2),
+		disc(22.5, 8, 2),
+		disc(29, 10, 2),
+		disc(37, 13, 2),
+	},
+	'k': {
+		pieceBase,
+		poly(21.3, 5, 23.7, 5, 23.7, 17, 21.3, 17),
+		poly(19, 8, 26, 8, 26, 10.4, 19, 10.4),
+		poly(19, 17, 26, 17, 27, 22, 18, 22),
+		poly(12, 36, 33, 36, 34, 26, 31, 21, 26, 20, 22.5, 24, 19, 20, 14, 21, 11, 26),
+	},
+}
+
+// spriteSet rasterizes each kind of piece once per board size, since a board
+// has at most twelve distinct pieces but often dozens of frames.
+type spriteSet struct {
+	sprites map[spriteKey]*image.RGBA
+}
+
+type spriteKey struct {
+	kind  byte
+	white bool
+}
+
+func newSpriteSet() *spriteSet {
+	return &spriteSet{sprites: make(map[spriteKey]*image.RGBA)}
+}
+
+// get returns piece drawn on a transparent square at scale pixels per unit.
+func (s *spriteSet) get(piece placedPiece, scale float64) *image.RGBA {
+	key := spriteKey{piece.kind, piece.white}
+	if sprite, ok := s.sprites[key]; ok {
+		return sprite
+	}
+
+	side := int(math.Ceil(squareUnits * scale))
+	c := &canvas{img: image.NewRGBA(image.Rect(0, 0, side, side)), scale: scale}
+	fill, detailColor := whiteFill, outlineColor
+	if !piece.white {
+		fill, detailColor = blackFill, blackDetail
+	}
+	for _, p := range piece.shape {
+		points := p.polygon
+		if p.circle != nil {
+			points = circlePoints(p.circle.center, p.circle.radius)
+		}
+		if p.detail {
+			c.fillPolygon(points, detailColor)
+			continue
+		}
+		c.fillPolygon(points, fill)
+		c.strokePolygon(points, outlineWidth, outlineColor)
+	}
+
+	s.sprites[key] = c.img
+	return c.img
+}
@@ -0,0 +1,177 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// A small scanline rasterizer, enough to draw the board's polygons with
+// anti-aliasing: coverage is exact horizontally and sampled vertically.
+
+const subScanlines = 4
+
+type point struct {
+	x, y float64
+}
+
+type canvas struct {
+	img   *image.RGBA
+	scale float64 // pixels per board unit
+}
+
+func newCanvas(size int) *canvas {
+	return &canvas{
+		img:   image.NewRGBA(image.Rect(0, 0, size, size)),
+		scale: float64(size) / boardUnits,
+	}
+}
+
+// fillPolygon fills points, given in board units, with the nonzero winding
+// rule.
+func (c *canvas) fillPolygon(points []point, col color.RGBA) {
+	if len(points) < 3 {
+		return
+	}
+
+	scaled := make([]point, len(points))
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	for i, p := range points {
+		scaled[i] = point{p.x * c.scale, p.y * c.scale}
+		minY, maxY = math.Min(minY, scaled[i].y), math.Max(maxY, scaled[i].y)
+		minX, maxX = math.Min(minX, scaled[i].x), math.Max(maxX, scaled[i].x)
+	}
+
+	bounds := c.img.Bounds()
+	top := max(int(math.Floor(minY)), bounds.Min.Y)
+	bottom := min(int(math.Ceil(maxY)), bounds.Max.Y)
+	left := max(int(math.Floor(minX)), bounds.Min.X)
+	right := min(int(math.Ceil(maxX)), bounds.Max.X)
+	if top >= bottom || left >= right {
+		return
+	}
+
+	type crossing struct {
+		x       float64
+		winding int
+	}
+	coverage := make([]float64, right-left)
+	var crossings []crossing
+
+	for py := top; py < bottom; py++ {
+		for i := range coverage {
+			coverage[i] = 0
+		}
+
+		for sub := 0; sub < subScanlines; sub++ {
+			y := float64(py) + (float64(sub)+0.5)/subScanlines
+			crossings = crossings[:0]
+			for i := range scaled {
+				a, b := scaled[i], scaled[(i+1)%len(scaled)]
+				if a.y == b.y {
+					continue
+				}
+				winding := 1
+				if a.y > b.y {
+					a, b = b, a
+					winding = -1
+				}
+				if y < a.y || y >= b.y {
+					continue
+				}
+				x := a.x + (y-a.y)*(b.x-a.x)/(b.y-a.y)
+				crossings = append(crossings, crossing{x, winding})
+			}
+			sort.Slice(crossings, func(i, j int) bool { return crossings[i].x < crossings[j].x })
+
+			winding := 0
+			for i, cr := range crossings {
+				winding += cr.winding
+				if winding == 0 || i+1 == len(crossings) {
+					continue
+				}
+				c.addSpan(coverage, left, cr.x, crossings[i+1].x)
+			}
+		}
+
+		for i, cov := range coverage {
+			if cov > 0 {
+				c.blend(left+i, py, col, math.Min(cov, 1))
+			}
+		}
+	}
+}
+
+// addSpan adds one sub-scanline's coverage of [x0, x1) to the row.
+func (c *canvas) addSpan(coverage []float64, left int, x0, x1 float64) {
+	x0 = math.Max(x0, float64(left))
+	x1 = math.Min(x1, float64(left+len(coverage)))
+	for px := int(math.Floor(x0)); float64(px) < x1; px++ {
+		overlap := math.Min(x1, float64(px+1)) - math.Max(x0, float64(px))
+		if overlap > 0 {
+			coverage[px-left] += overlap / subScanlines
+		}
+	}
+}
+
+func (c *canvas) blend(x, y int, col color.RGBA, coverage float64) {
+	alpha := float64(col.A) / 255 * coverage
+	offset := c.img.PixOffset(x, y)
+	pix := c.img.Pix[offset : offset+4]
+	for i, channel := range []uint8{col.R, col.G, col.B} {
+		pix[i] = uint8(math.Round(float64(channel)*alpha + float64(pix[i])*(1-alpha)))
+	}
+	pix[3] = uint8(math.Round(255*alpha + float64(pix[3])*(1-alpha)))
+}
+
+// squareRect returns the pixels of the square with its corner at corner.
+// Squares snap to whole pixels so neighbours meet without a seam.
+func (c *canvas) squareRect(corner point) image.Rectangle {
+	snap := func(units float64) int {
+		return int(math.Round(units * c.scale))
+	}
+	return image.Rect(snap(corner.x), snap(corner.y), snap(corner.x+squareUnits), snap(corner.y+squareUnits))
+}
+
+// fillRect blends col over the pixels in rect.
+func (c *canvas) fillRect(rect image.Rectangle, col color.RGBA) {
+	rect = rect.Intersect(c.img.Bounds())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c.blend(x, y, col, 1)
+		}
+	}
+}
+
+// strokePolygon outlines a closed polygon with round joins.
+func (c *canvas) strokePolygon(points []point, width float64, col color.RGBA) {
+	for i := range points {
+		c.strokeLine(points[i], points[(i+1)%len(points)], width, col)
+	}
+}
+
+func (c *canvas) strokeLine(a, b point, width float64, col color.RGBA) {
+	dx, dy := b.x-a.x, b.y-a.y
+	length := math.Hypot(dx, dy)
+	if length > 0 {
+		nx, ny := -dy/length*width/2, dx/length*width/2
+		c.fillPolygon([]point{
+			{a.x + nx, a.y + ny}, {b.x + nx, b.y + ny},
+			{b.x - nx, b.y - ny}, {a.x - nx, a.y - ny},
+		}, col)
+	}
+	c.fillPolygon(circlePoints(b, width/2), col)
+}
+
+// circlePoints approximates a circle with a polygon.
+func circlePoints(center point, radius float64) []point {
+	const segments = 32
+	points := make([]point, segments)
+	for i := range points {
+		angle := 2 * math.Pi * float64(i) / segments
+		points[i] = point{center.x + radius*math.Cos(angle), center.y + radius*math.Sin(angle)}
+	}
+	return points
+}
@@ -0,0 +1,441 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Analysis rooms keep their move tree in the database so every instance sees
+// the same one. Each edit checks the room's version when it commits, so of
+// two edits made at once from different instances one fails and is retried
+// by its client. Edits reach the room's users through the Hub.
+
+const (
+	maxAnalysisNodes   = 2000
+	maxAnalysisComment = 2000
+	maxAnalysisNAGs    = 8
+	maxAnalysisName    = 100
+)
+
+var (
+	ErrAnalysisRoomNotFound = errors.New("analysis room not found")
+	ErrAnalysisNodeNotFound = errors.New("analysis node not found")
+	ErrNotRoomController    = errors.New("only the controller can edit the analysis")
+	ErrNotRoomHost          = errors.New("only the host can hand over control")
+	ErrAnalysisRoomFull     = errors.New("analysis room has too many moves")
+	ErrInvalidAnnotation    = errors.New("invalid annotation")
+	ErrAnalysisRoomConflict = errors.New("analysis room was updated concurrently")
+)
+
+// AnalysisRoomChannel is the Hub room that carries an analysis room's edits.
+func AnalysisRoomChannel(roomID string) string {
+	return "analysis:" + roomID
+}
+
+// AnalysisRoomUpdate describes one edit to a room, as broadcast to its users.
+type AnalysisRoomUpdate struct {
+	RoomID       uuid.UUID            `json:"room_id"`
+	Op           string               `json:"op"` // move, delete, annotate, promote, control or select
+	Version      int                  `json:"version"`
+	Node         *models.AnalysisNode `json:"node,omitempty"`
+	Removed      []uuid.UUID          `json:"removed,omitempty"`
+	ControllerID *uuid.UUID           `json:"controller_id,omitempty"`
+
+	unchanged bool // nothing was edited, so the version stays
+}
+
+type AnalysisRoomService struct {
+	db *gorm.DB
+}
+
+func NewAnalysisRoomService(db *gorm.DB) *AnalysisRoomService {
+	return &AnalysisRoomService{db: db}
+}
+
+// CreateRoom opens a room on fen, the standard starting position when empty,
+// with hostID in control.
+func (rs *AnalysisRoomService) CreateRoom(hostID uuid.UUID, name, fen string) (*models.AnalysisRoom, error) {
+	if fen == "" {
+		fen = chess.StandardStartFEN
+	}
+	if err := chess.ValidateFEN(fen); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFEN, err)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Analysis"
+	}
+	if len(name) > maxAnalysisName {
+		name = name[:maxAnalysisName]
+	}
+
+	room := models.AnalysisRoom{Name: name, HostID: hostID, ControllerID: hostID, FEN: fen}
+	if err := rs.db.Create(&room).Error; err != nil {
+		return nil, fmt.Errorf("failed to create analysis room: %w", err)
+	}
+	return &room, nil
+}
+
+// GetRoom returns a room with its whole tree, parents before children.
+func (rs *AnalysisRoomService) GetRoom(roomID uuid.UUID) (*models.AnalysisRoom, error) {
+	var room models.AnalysisRoom
+	err := rs.db.Preload("Nodes", func(db *gorm.DB) *gorm.DB {
+		return db.Order("ply ASC, rank ASC, created_at ASC")
+	}).First(&room, "id = ?", roomID).Error
+	if err != nil {
+		return nil, roomLookupError(err)
+	}
+	return &room, nil
+}
+
+// AddMove plays from-to after the node parentID, or from the room's position
+// when parentID is nil. Playing a move that's already in the tree returns the
+// existing node without an edit, reported by added being false.
+func (rs *AnalysisRoomService) AddMove(roomID, userID uuid.UUID, parentID *uuid.UUID, from, to string) (update *AnalysisRoomUpdate, added bool, err error) {
+	update, err = rs.edit(roomID, userID, "move", func(tx *gorm.DB, room *models.AnalysisRoom) (*AnalysisRoomUpdate, error) {
+		fen, ply := room.FEN, 1
+		siblings := tx.Where("room_id = ? AND parent_id IS NULL", roomID)
+		if parentID != nil {
+			parent, err := findNode(tx, roomID, *parentID)
+			if err != nil {
+				return nil, err
+			}
+			fen, ply = parent.FEN, parent.Ply+1
+			siblings = tx.Where("room_id = ? AND parent_id = ?", roomID, *parentID)
+		}
+
+		var existing []models.AnalysisNode
+		if err := siblings.Find(&existing).Error; err != nil {
+			return nil, fmt.Errorf("failed to load moves: %w", err)
+		}
+		rank := 0
+		for i := range existing {
+			if existing[i].Move == from+to {
+				return &AnalysisRoomUpdate{Node: &existing[i], unchanged: true}, nil
+			}
+			rank = max(rank, existing[i].Rank+1)
+		}
+
+		var count int64
+		if err := tx.Model(&models.AnalysisNode{}).Where("room_id = ?", roomID).Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("failed to count moves: %w", err)
+		}
+		if count >= maxAnalysisNodes {
+			return nil, ErrAnalysisRoomFull
+		}
+
+		move, err := chess.NewEngine(fen).ValidateMove(from, to)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidMove, err)
+		}
+		node := models.AnalysisNode{
+			RoomID:   roomID,
+			ParentID: parentID,
+			Ply:      ply,
+			Move:     from + to,
+			SAN:      move.Notation,
+			FEN:      move.FENAfter,
+			Rank:     rank,
+		}
+		if err := tx.Create(&node).Error; err != nil {
+			return nil, fmt.Errorf("failed to save move: %w", err)
+		}
+		return &AnalysisRoomUpdate{Node: &node}, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return update, !update.unchanged, nil
+}
+
+// DeleteNode removes a node and every move after it.
+func (rs *AnalysisRoomService) DeleteNode(roomID, userID, nodeID uuid.UUID) (*AnalysisRoomUpdate, error) {
+	return rs.edit(roomID, userID, "delete", func(tx *gorm.DB, room *models.AnalysisRoom) (*AnalysisRoomUpdate, error) {
+		var nodes []models.AnalysisNode
+		if err := tx.Select("id", "parent_id").Where("room_id = ?", roomID).Find(&nodes).Error; err != nil {
+			return nil, fmt.Errorf("failed to load moves: %w", err)
+		}
+
+		removed := subtree(nodes, nodeID)
+		if len(removed) == 0 {
+			return nil, ErrAnalysisNodeNotFound
+		}
+		if err := tx.Where("id IN ?", removed).Delete(&models.AnalysisNode{}).Error; err != nil {
+			return nil, fmt.Errorf("failed to delete moves: %w", err)
+		}
+		return &AnalysisRoomUpdate{Removed: removed}, nil
+	})
+}
+
+// AnnotateNode replaces a node's comment and NAGs.
+func (rs *AnalysisRoomService) AnnotateNode(roomID, userID, nodeID uuid.UUID, comment string, nags models.NAGs) (*AnalysisRoomUpdate, error) {
+	comment = strings.TrimSpace(comment)
+	if err := checkAnnotation(comment, nags, nil, nil); err != nil {
+		return nil, err
+	}
+	if nags == nil {
+		nags = models.NAGs{}
+	}
+
+	return rs.edit(roomID, userID, "annotate", func(tx *gorm.DB, room *models.AnalysisRoom) (*AnalysisRoomUpdate, error) {
+		node, err := findNode(tx, roomID, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Model(node).Updates(map[string]interface{}{"comment": comment, "nags": nags}).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to annotate move: %w", err)
+		}
+		node.Comment, node.NAGs = comment, nags
+		return &AnalysisRoomUpdate{Node: node}, nil
+	})
+}
+
+// PromoteNode makes a variation the main line from its position.
+func (rs *AnalysisRoomService) PromoteNode(roomID, userID, nodeID uuid.UUID) (*AnalysisRoomUpdate, error) {
+	return rs.edit(roomID, userID, "promote", func(tx *gorm.DB, room *models.AnalysisRoom) (*AnalysisRoomUpdate, error) {
+		node, err := findNode(tx, roomID, nodeID)
+		if err != nil {
+			return nil, err
+		}
+
+		siblings := tx.Model(&models.AnalysisNode{}).Where("room_id = ? AND parent_id IS NULL", roomID)
+		if node.ParentID != nil {
+			siblings = tx.Model(&models.AnalysisNode{}).Where("room_id = ? AND parent_id = ?", roomID, *node.ParentID)
+		}
+		var first int
+		if err := siblings.Select("COALESCE(MIN(rank), 0)").Scan(&first).Error; err != nil {
+			return nil, fmt.Errorf("failed to load moves: %w", err)
+		}
+		if node.Rank > first {
+			if err := tx.Model(node).Update("rank", first-1).Error; err != nil {
+				return nil, fmt.Errorf("failed to promote move: %w", err)
+			}
+			node.Rank = first - 1
+		}
+		return &AnalysisRoomUpdate{Node: node}, nil
+	})
+}
+
+// HandOverControl lets toUserID edit the room instead of the current
+// controller. Only the host may, and handing it to themselves takes it back.
+func (rs *AnalysisRoomService) HandOverControl(roomID, hostID, toUserID uuid.UUID) (*AnalysisRoomUpdate, error) {
+	var update *AnalysisRoomUpdate
+	err := rs.db.Transaction(func(tx *gorm.DB) error {
+		room, err := loadRoom(tx, roomID)
+		if err != nil {
+			return err
+		}
+		if room.HostID != hostID {
+			return ErrNotRoomHost
+		}
+
+		result := tx.Model(&models.AnalysisRoom{}).
+			Where("id = ? AND version = ?", roomID, room.Version).
+			Updates(map[string]interface{}{"controller_id": toUserID, "version": room.Version + 1})
+		if result.Error != nil {
+			return fmt.Errorf("failed to hand over control: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrAnalysisRoomConflict
+		}
+
+		update = &AnalysisRoomUpdate{RoomID: roomID, Op: "control", Version: room.Version + 1, ControllerID: &toUserID}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+// SelectNode shows the controller's current node to the room; a nil node is
+// the room's position. Nothing is saved.
+func (rs *AnalysisRoomService) SelectNode(roomID, userID uuid.UUID, nodeID *uuid.UUID) (*AnalysisRoomUpdate, error) {
+	room, err := loadRoom(rs.db, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room.ControllerID != userID {
+		return nil, ErrNotRoomController
+	}
+
+	update := &AnalysisRoomUpdate{RoomID: roomID, Op: "select", Version: room.Version}
+	if nodeID != nil {
+		if update.Node, err = findNode(rs.db, roomID, *nodeID); err != nil {
+			return nil, err
+		}
+	}
+	return update, nil
+}
+
+// ExportPGN writes the room's tree as PGN. With a node, the moves leading to
+// it are written as the main line, followed by everything after it.
+func (rs *AnalysisRoomService) ExportPGN(roomID uuid.UUID, nodeID *uuid.UUID) (string, error) {
+	room, err := rs.GetRoom(roomID)
+	if err != nil {
+		return "", err
+	}
+
+	moves := make(map[uuid.UUID]*chess.PGNMove, len(room.Nodes))
+	children := make(map[uuid.UUID][]*models.AnalysisNode)
+	var roots []*models.AnalysisNode
+	for i := range room.Nodes {
+		node := &room.Nodes[i]
+		moves[node.ID] = &chess.PGNMove{SAN: node.SAN, NAGs: node.NAGs, Comment: node.Comment}
+		if node.ParentID == nil {
+			roots = append(roots, node)
+		} else {
+			children[*node.ParentID] = append(children[*node.ParentID], node)
+		}
+	}
+	link := func(nodes []*models.AnalysisNode) []*chess.PGNMove {
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].Rank < nodes[j].Rank })
+		line := make([]*chess.PGNMove, len(nodes))
+		for i, node := range nodes {
+			line[i] = moves[node.ID]
+		}
+		return line
+	}
+	for i := range room.Nodes {
+		node := &room.Nodes[i]
+		moves[node.ID].Next = link(children[node.ID])
+	}
+	line := link(roots)
+
+	if nodeID != nil {
+		if line, err = pathTo(room.Nodes, moves, *nodeID); err != nil {
+			return "", err
+		}
+	}
+
+	tags := []chess.PGNTag{
+		{Name: "Event", Value: room.Name},
+		{Name: "Site", Value: "Arcane Chess"},
+		{Name: "Date", Value: room.CreatedAt.Format("2006.01.02")},
+		{Name: "White", Value: "?"},
+		{Name: "Black", Value: "?"},
+	}
+	return chess.FormatPGN(tags, room.FEN, line, "*"), nil
+}
+
+// pathTo returns the line from the room's position to nodeID, without the
+// variations along the way, continuing into the tree after it.
+func pathTo(nodes []models.AnalysisNode, moves map[uuid.UUID]*chess.PGNMove, nodeID uuid.UUID) ([]*chess.PGNMove, error) {
+	byID := make(map[uuid.UUID]*models.AnalysisNode, len(nodes))
+	for i := range nodes {
+		byID[nodes[i].ID] = &nodes[i]
+	}
+	node, ok := byID[nodeID]
+	if !ok {
+		return nil, ErrAnalysisNodeNotFound
+	}
+
+	tail := []*chess.PGNMove{moves[node.ID]}
+	for node.ParentID != nil {
+		node = byID[*node.ParentID]
+		step := *moves[node.ID]
+		step.Next = tail
+		tail = []*chess.PGNMove{&step}
+	}
+	return tail, nil
+}
+
+// edit runs change on the room in a transaction if userID controls it, then
+// advances the room's version, failing if another edit got there first.
+func (rs *AnalysisRoomService) edit(roomID, userID uuid.UUID, op string, change func(tx *gorm.DB, room *models.AnalysisRoom) (*AnalysisRoomUpdate, error)) (*AnalysisRoomUpdate, error) {
+	var update *AnalysisRoomUpdate
+	err := rs.db.Transaction(func(tx *gorm.DB) error {
+		room, err := loadRoom(tx, roomID)
+		if err != nil {
+			return err
+		}
+		if room.ControllerID != userID {
+			return ErrNotRoomController
+		}
+
+		update, err = change(tx, room)
+		if err != nil {
+			return err
+		}
+		update.RoomID, update.Op, update.Version = roomID, op, room.Version
+
+		if update.unchanged {
+			return nil
+		}
+
+		result := tx.Model(&models.AnalysisRoom{}).
+			Where("id = ? AND version = ?", roomID, room.Version).
+			Update("version", room.Version+1)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update analysis room: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrAnalysisRoomConflict
+		}
+		update.Version = room.Version + 1
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+func loadRoom(db *gorm.DB, roomID uuid.UUID) (*models.AnalysisRoom, error) {
+	var room models.AnalysisRoom
+	if err := db.Take(&room, "id = ?", roomID).Error; err != nil {
+		return nil, roomLookupError(err)
+	}
+	return &room, nil
+}
+
+func findNode(db *gorm.DB, roomID, nodeID uuid.UUID) (*models.AnalysisNode, error) {
+	var node models.AnalysisNode
+	if err := db.Take(&node, "id = ? AND room_id = ?", nodeID, roomID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAnalysisNodeNotFound
+		}
+		return nil, fmt.Errorf("failed to load analysis node: %w", err)
+	}
+	return &node, nil
+}
+
+func roomLookupError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrAnalysisRoomNotFound
+	}
+	return fmt.Errorf("failed to load analysis room: %w", err)
+}
+
+// subtree returns rootID and every node below it, or nothing when rootID
+// isn't among nodes.
+func subtree(nodes []models.AnalysisNode, rootID uuid.UUID) []uuid.UUID {
+	children := make(map[uuid.UUID][]uuid.UUID)
+	found := false
+	for _, node := range nodes {
+		if node.ID == rootID {
+			found = true
+		}
+		if node.ParentID != nil {
+			children[*node.ParentID] = append(children[*node.ParentID], node.ID)
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	ids := []uuid.UUID{rootID}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return ids
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	analysisRoomColumns = []string{"id", "name", "host_id", "controller_id", "fen", "version", "created_at"}
+	analysisNodeColumns = []string{"id", "room_id", "parent_id", "ply", "move", "san", "fen", "rank", "comment", "nags"}
+)
+
+func expectAnalysisRoom(mock sqlmock.Sqlmock, roomID, controllerID uuid.UUID, version int) {
+	mock.ExpectQuery(`SELECT \* FROM "analysis_rooms" WHERE id = \$1 LIMIT 1`).
+		WithArgs(roomID).
+		WillReturnRows(sqlmock.NewRows(analysisRoomColumns).
+			AddRow(roomID, "Sicilian prep", controllerID, controllerID, chess.StandardStartFEN, version, time.Now()))
+}
+
+func TestAnalysisRoomService_AddMove(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	roomID, userID := uuid.New(), uuid.New()
+	mock.ExpectBegin()
+	expectAnalysisRoom(mock, roomID, userID, 3)
+	mock.ExpectQuery(`SELECT \* FROM "analysis_nodes" WHERE room_id = \$1 AND parent_id IS NULL`).
+		WithArgs(roomID).
+		WillReturnRows(sqlmock.NewRows(analysisNodeColumns).
+			AddRow(uuid.New(), roomID, nil, 1, "d2d4", "d4", "fen", 0, "", ""))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "analysis_nodes" WHERE room_id = \$1`).
+		WithArgs(roomID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "analysis_nodes"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectExec(`UPDATE "analysis_rooms" SET "version"=\$1,"updated_at"=\$2 WHERE id = \$3 AND version = \$4`).
+		WithArgs(4, testutil.AnyTime{}, roomID, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	update, added, err := NewAnalysisRoomService(db).AddMove(roomID, userID, nil, "e2", "e4")
+
+	require.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, "move", update.Op)
+	assert.Equal(t, 4, update.Version)
+	assert.Equal(t, "e4", update.Node.SAN)
+	assert.Equal(t, 1, update.Node.Rank, "a variation on d4")
+	assert.Equal(t, "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", update.Node.FEN)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAnalysisRoomService_AddMove_Existing(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	roomID, userID, nodeID := uuid.New(), uuid.New(), uuid.New()
+	mock.ExpectBegin()
+	expectAnalysisRoom(mock, roomID, userID, 3)
+	mock.ExpectQuery(`SELECT \* FROM "analysis_nodes" WHERE room_id = \$1 AND parent_id IS NULL`).
+		WillReturnRows(sqlmock.NewRows(analysisNodeColumns).
+			AddRow(nodeID, roomID, nil, 1, "e2e4", "e4", "fen", 0, "", ""))
+	mock.ExpectCommit()
+
+	update, added, err := NewAnalysisRoomService(db).AddMove(roomID, userID, nil, "e2", "e4")
+
+	require.NoError(t, err)
+	assert.False(t, added)
+	assert.Equal(t, nodeID, update.Node.ID)
+	assert.Equal(t, 3, update.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAnalysisRoomService_AddMove_Rejected(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	roomID, hostID := uuid.New(), uuid.New()
+	rs := NewAnalysisRoomService(db)
+
+	mock.ExpectBegin()
+	expectAnalysisRoom(mock, roomID, hostID, 0)
+	mock.ExpectRollback()
+	_, _, err := rs.AddMove(roomID, uuid.New(), nil, "e2", "e4")
+	assert.ErrorIs(t, err, ErrNotRoomController)
+
+	mock.ExpectBegin()
+	expectAnalysisRoom(mock, roomID, hostID, 0)
+	mock.ExpectQuery(`SELECT \* FROM "analysis_nodes"`).WillReturnRows(sqlmock.NewRows(analysisNodeColumns))
+	mock.ExpectQuery(`SELECT count\(\*\)`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectRollback()
+	_, _, err = rs.AddMove(roomID, hostID, nil, "e2", "e5")
+	assert.ErrorIs(t, err, ErrInvalidMove)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAnalysisRoomService_HandOverControl(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	roomID, hostID, guestID := uuid.New(), uuid.New(), uuid.New()
+	rs := NewAnalysisRoomService(db)
+
+	mock.ExpectBegin()
+	expectAnalysisRoom(mock, roomID, hostID, 5)
+	mock.ExpectExec(`UPDATE "analysis_rooms" SET "controller_id"=\$1,"version"=\$2,"updated_at"=\$3 WHERE id = \$4 AND version = \$5`).
+		WithArgs(guestID, 6, testutil.AnyTime{}, roomID, 5).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	_, err := rs.HandOverControl(roomID, hostID, guestID)
+	assert.ErrorIs(t, err, ErrAnalysisRoomConflict, "another edit won")
+
+	mock.ExpectBegin()
+	expectAnalysisRoom(mock, roomID, hostID, 5)
+	mock.ExpectRollback()
+	_, err = rs.HandOverControl(roomID, guestID, guestID)
+	assert.ErrorIs(t, err, ErrNotRoomHost)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAnalysisRoomService_AnnotateNode_Invalid(t *testing.T) {
+	rs := NewAnalysisRoomService(nil)
+
+	_, err := rs.AnnotateNode(uuid.New(), uuid.New(), uuid.New(), strings.Repeat("x", maxAnalysisComment+1), nil)
+	assert.ErrorIs(t, err, ErrInvalidAnnotation)
+
+	_, err = rs.AnnotateNode(uuid.New(), uuid.New(), uuid.New(), "", models.NAGs{0})
+	assert.ErrorIs(t, err, ErrInvalidAnnotation)
+}
+
+func TestAnalysisRoomService_ExportPGN(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	roomID, hostID := uuid.New(), uuid.New()
+	e4, e5, c5, nf3 := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	mock.ExpectQuery(`SELECT \* FROM "analysis_rooms" WHERE id = \$1`).
+		WillReturnRows(sqlmock.NewRows(analysisRoomColumns).
+			AddRow(roomID, "Sicilian prep", hostID, hostID, chess.StandardStartFEN, 4, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)))
+	mock.ExpectQuery(`SELECT \* FROM "analysis_nodes" WHERE "analysis_nodes"."room_id" = \$1 ORDER BY ply ASC, rank ASC, created_at ASC`).
+		WillReturnRows(sqlmock.NewRows(analysisNodeColumns).
+			AddRow(e4, roomID, nil, 1, "e2e4", "e4", "fen", 0, "", "").
+			AddRow(c5, roomID, e4, 2, "c7c5", "c5", "fen", -1, "The Sicilian", "1").
+			AddRow(e5, roomID, e4, 2, "e7e5", "e5", "fen", 0, "", "").
+			AddRow(nf3, roomID, c5, 3, "g1f3", "Nf3", "fen", 0, "", ""))
+
+	rs := NewAnalysisRoomService(db)
+	pgn, err := rs.ExportPGN(roomID, nil)
+	require.NoError(t, err)
+	assert.Contains(t, pgn, `[Event "Sicilian prep"]`)
+	assert.Contains(t, pgn, `[Date "2026.03.01"]`)
+	assert.Contains(t, pgn, "\n1. e4 c5 $1 {The Sicilian} (1... e5) 2. Nf3 *\n")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAnalysisRoomService_ExportPGN_Node(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	roomID, hostID := uuid.New(), uuid.New()
+	e4, e5, c5, nf3 := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	mock.ExpectQuery(`SELECT \* FROM "analysis_rooms"`).
+		WillReturnRows(sqlmock.NewRows(analysisRoomColumns).
+			AddRow(roomID, "Prep", hostID, hostID, chess.StandardStartFEN, 4, time.Now()))
+	mock.ExpectQuery(`SELECT \* FROM "analysis_nodes"`).
+		WillReturnRows(sqlmock.NewRows(analysisNodeColumns).
+			AddRow(e4, roomID, nil, 1, "e2e4", "e4", "fen", 0, "", "").
+			AddRow(c5, roomID, e4, 2, "c7c5", "c5", "fen", 0, "", "").
+			AddRow(e5, roomID, e4, 2, "e7e5", "e5", "fen", 1, "", "").
+			AddRow(nf3, roomID, e5, 3, "g1f3", "Nf3", "fen", 0, "", ""))
+
+	pgn, err := NewAnalysisRoomService(db).ExportPGN(roomID, &e5)
+
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(pgn, "\n1. e4 e5 2. Nf3 *\n"), pgn)
+}
+
+func TestSubtree(t *testing.T) {
+	a, b, c, d := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	nodes := []models.AnalysisNode{
+		{ID: a},
+		{ID: b, ParentID: &a},
+		{ID: c, ParentID: &b},
+		{ID: d, ParentID: &a},
+	}
+
+	assert.ElementsMatch(t, []uuid.UUID{b, c}, subtree(nodes, b))
+	assert.ElementsMatch(t, []uuid.UUID{a, b, c, d}, subtree(nodes, a))
+	assert.Empty(t, subtree(nodes, uuid.New()))
+}
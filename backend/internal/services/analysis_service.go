@@ -0,0 +1,258 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Finished games are reviewed in the background: every position is searched,
+// and each move is judged by how much it lowered the mover's chance of
+// winning compared with the engine's choice. Progress is published to the
+// game's channel so the players and spectators in the room can follow it.
+
+const (
+	analysisDepth   = 4
+	analysisWorkers = 2 // games analysed at once per instance
+
+	// Win chance lost, in percentage points, from which a move counts as an
+	// inaccuracy, a mistake or a blunder.
+	inaccuracyThreshold = 10.0
+	mistakeThreshold    = 20.0
+	blunderThreshold    = 30.0
+)
+
+var ErrAnalysisNotFound = errors.New("analysis not found")
+
+type AnalysisService struct {
+	db    *gorm.DB
+	redis *redis.Client
+	depth int
+	slots chan struct{}
+}
+
+func NewAnalysisService(db *gorm.DB, redis *redis.Client) *AnalysisService {
+	return &AnalysisService{
+		db:    db,
+		redis: redis,
+		depth: analysisDepth,
+		slots: make(chan struct{}, analysisWorkers),
+	}
+}
+
+// GetAnalysis returns a game's analysis with its moves in order. Moves are
+// only present once the analysis has completed.
+func (as *AnalysisService) GetAnalysis(gameID uuid.UUID) (*models.GameAnalysis, error) {
+	var analysis models.GameAnalysis
+	err := as.db.Preload("Moves", func(tx *gorm.DB) *gorm.DB { return tx.Order("ply ASC") }).
+		First(&analysis, "game_id = ?", gameID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAnalysisNotFound
+		}
+		return nil, fmt.Errorf("failed to load analysis: %w", err)
+	}
+	return &analysis, nil
+}
+
+// AnalyzeFinishedGame is AnalyzeGame as a GameService.OnGameFinished hook. It
+// waits for a free worker so a burst of finished games can't starve the
+// server of CPU.
+func (as *AnalysisService) AnalyzeFinishedGame(game models.Game) {
+	as.slots <- struct{}{}
+	defer func() { <-as.slots }()
+
+	if err := as.AnalyzeGame(game); err != nil {
+		log.Printf("Error analysing game %s: %v", game.ID, err)
+	}
+}
+
+// AnalyzeGame reviews every move of a finished standard game played without
+// material odds, replacing any earlier analysis of it.
+func (as *AnalysisService) AnalyzeGame(game models.Game) error {
+	if game.Variant != models.GameVariantStandard && game.Variant != "" || game.Handicap != "" {
+		return nil
+	}
+
+	var moves []models.GameMove
+	err := as.db.Where("game_id = ?", game.ID).Order("move_number ASC").Find(&moves).Error
+	if err != nil {
+		return fmt.Errorf("failed to load game moves: %w", err)
+	}
+	if len(moves) == 0 {
+		return nil
+	}
+
+	analysis := models.GameAnalysis{
+		GameID: game.ID,
+		Status: models.AnalysisStatusRunning,
+		Depth:  as.depth,
+	}
+	err = as.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "game_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"status", "depth", "error", "completed_at", "updated_at"}),
+	}).Create(&analysis).Error
+	if err != nil {
+		return fmt.Errorf("failed to create analysis: %w", err)
+	}
+
+	reviewed := as.reviewMoves(game.ID, moves)
+	whiteAccuracy, blackAccuracy := playerAccuracies(reviewed)
+
+	err = as.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("game_id = ?", game.ID).Delete(&models.MoveAnalysis{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&reviewed).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.GameAnalysis{}).Where("game_id = ?", game.ID).Updates(map[string]interface{}{
+			"status":         models.AnalysisStatusCompleted,
+			"white_accuracy": whiteAccuracy,
+			"black_accuracy": blackAccuracy,
+			"completed_at":   time.Now(),
+		}).Error
+	})
+	if err != nil {
+		as.markFailed(game.ID, err)
+		return fmt.Errorf("failed to save analysis: %w", err)
+	}
+
+	publishGameUpdate(as.redis, game.ID, "analysis_complete", map[string]interface{}{
+		"white_accuracy": whiteAccuracy,
+		"black_accuracy": blackAccuracy,
+	})
+
+	return nil
+}
+
+// reviewMoves searches the position before and after every move and judges
+// the move by the difference, publishing each review as it's made.
+func (as *AnalysisService) reviewMoves(gameID uuid.UUID, moves []models.GameMove) []models.MoveAnalysis {
+	reviewed := make([]models.MoveAnalysis, 0, len(moves))
+	before := chess.NewEngine(chess.StandardStartFEN).Search(as.depth)
+	whiteMoved := true
+
+	for i, move := range moves {
+		after := chess.NewEngine(move.FENAfter).Search(as.depth)
+		played := move.FromSquare + move.ToSquare
+
+		review := reviewMove(before, after, whiteMoved, played)
+		review.GameID = gameID
+		review.Ply = i + 1
+		review.Move = played
+		review.Notation = move.Notation
+		reviewed = append(reviewed, review)
+
+		publishGameUpdate(as.redis, gameID, "analysis_progress", map[string]interface{}{
+			"ply":   review.Ply,
+			"total": len(moves),
+			"move":  review,
+		})
+
+		before = after
+		whiteMoved = !whiteMoved
+	}
+
+	return reviewed
+}
+
+func (as *AnalysisService) markFailed(gameID uuid.UUID, cause error) {
+	err := as.db.Model(&models.GameAnalysis{}).Where("game_id = ?", gameID).Updates(map[string]interface{}{
+		"status": models.AnalysisStatusFailed,
+		"error":  cause.Error(),
+	}).Error
+	if err != nil {
+		log.Printf("Error marking analysis of game %s as failed: %v", gameID, err)
+	}
+}
+
+// reviewMove judges the move played between two searched positions.
+func reviewMove(before, after chess.SearchResult, whiteMoved bool, played string) models.MoveAnalysis {
+	review := models.MoveAnalysis{
+		Eval:     after.Score,
+		BestMove: before.BestMove,
+		BestLine: strings.Join(before.PV, " "),
+	}
+	if after.Mate != 0 {
+		mate := after.Mate
+		review.Mate = &mate
+	}
+
+	if played == before.BestMove {
+		// The deeper search from before the move is the better judge of it
+		review.Classification = models.MoveClassificationBest
+		review.Accuracy = 100
+		return review
+	}
+
+	loss := math.Max(0, winChance(before.Score, whiteMoved)-winChance(after.Score, whiteMoved))
+	review.WinChanceLoss = roundTenth(loss)
+	review.Accuracy = roundTenth(moveAccuracy(loss))
+	switch {
+	case loss >= blunderThreshold:
+		review.Classification = models.MoveClassificationBlunder
+	case loss >= mistakeThreshold:
+		review.Classification = models.MoveClassificationMistake
+	case loss >= inaccuracyThreshold:
+		review.Classification = models.MoveClassificationInaccuracy
+	default:
+		review.Classification = models.MoveClassificationGood
+	}
+	return review
+}
+
+// winChance converts an evaluation from white's point of view into the
+// percentage chance of winning for white, or for black when forWhite is
+// false. Evaluations are capped at ten pawns either way, forced mates
+// included.
+func winChance(score int, forWhite bool) float64 {
+	if !forWhite {
+		score = -score
+	}
+	cp := math.Max(-1000, math.Min(1000, float64(score)))
+	return 50 + 50*(2/(1+math.Exp(-0.00368208*cp))-1)
+}
+
+// moveAccuracy maps the win chance a move gave away to a 0-100 score.
+func moveAccuracy(winChanceLoss float64) float64 {
+	accuracy := 103.1668*math.Exp(-0.04354*winChanceLoss) - 3.1669
+	return math.Max(0, math.Min(100, accuracy))
+}
+
+// playerAccuracies averages the accuracy of each side's moves.
+func playerAccuracies(moves []models.MoveAnalysis) (white, black float64) {
+	var whiteSum, blackSum float64
+	var whiteMoves, blackMoves int
+	for _, move := range moves {
+		if move.Ply%2 == 1 {
+			whiteSum += move.Accuracy
+			whiteMoves++
+		} else {
+			blackSum += move.Accuracy
+			blackMoves++
+		}
+	}
+	if whiteMoves > 0 {
+		white = roundTenth(whiteSum / float64(whiteMoves))
+	}
+	if blackMoves > 0 {
+		black = roundTenth(blackSum / float64(blackMoves))
+	}
+	return white, black
+}
+
+func roundTenth(x float64) float64 {
+	return math.Round(x*10) / 10
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReviewMove(t *testing.T) {
+	before := chess.SearchResult{BestMove: "e2e4", Score: 30, PV: []string{"e2e4", "e7e5"}}
+
+	best := reviewMove(before, chess.SearchResult{Score: 25}, true, "e2e4")
+	assert.Equal(t, models.MoveClassificationBest, best.Classification)
+	assert.Equal(t, 100.0, best.Accuracy)
+
+	good := reviewMove(before, chess.SearchResult{Score: 10}, true, "d2d4")
+	assert.Equal(t, models.MoveClassificationGood, good.Classification)
+	assert.Equal(t, "e2e4 e7e5", good.BestLine)
+
+	// Black's moves are judged from black's side
+	blackBefore := chess.SearchResult{BestMove: "e7e5", Score: 30}
+	assert.Equal(t, models.MoveClassificationInaccuracy, reviewMove(blackBefore, chess.SearchResult{Score: 150}, false, "a7a6").Classification)
+	assert.Equal(t, models.MoveClassificationMistake, reviewMove(blackBefore, chess.SearchResult{Score: 280}, false, "a7a6").Classification)
+	assert.Equal(t, models.MoveClassificationGood, reviewMove(blackBefore, chess.SearchResult{Score: -200}, false, "a7a6").Classification)
+
+	blunder := reviewMove(before, chess.SearchResult{Score: -99999, Mate: -1}, true, "g2g4")
+	assert.Equal(t, models.MoveClassificationBlunder, blunder.Classification)
+	assert.Equal(t, -1, *blunder.Mate)
+	assert.Less(t, blunder.Accuracy, 10.0)
+}
+
+func TestPlayerAccuracies(t *testing.T) {
+	white, black := playerAccuracies([]models.MoveAnalysis{
+		{Ply: 1, Accuracy: 100},
+		{Ply: 2, Accuracy: 80},
+		{Ply: 3, Accuracy: 50},
+	})
+
+	assert.Equal(t, 75.0, white)
+	assert.Equal(t, 80.0, black)
+}
+
+func TestAnalysisService_AnalyzeGame(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	result := models.GameResultBlackWins
+	game := models.Game{
+		ID:      uuid.New(),
+		Variant: models.GameVariantStandard,
+		Status:  models.GameStatusFinished,
+		Result:  &result,
+	}
+
+	// Fool's mate
+	rows := sqlmock.NewRows([]string{"game_id", "move_number", "from_square", "to_square", "notation", "fen_after"})
+	fen := chess.StandardStartFEN
+	for i, move := range [][2]string{{"f2", "f3"}, {"e7", "e5"}, {"g2", "g4"}, {"d8", "h4"}} {
+		played, err := chess.NewEngine(fen).ValidateMove(move[0], move[1])
+		require.NoError(t, err)
+		fen = played.FENAfter
+		rows.AddRow(game.ID, i+1, move[0], move[1], played.Notation, fen)
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM "game_moves" WHERE game_id = \$1 ORDER BY move_number ASC`).
+		WithArgs(game.ID).
+		WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "game_analyses" .* ON CONFLICT \("game_id"\) DO UPDATE SET "status"="excluded"."status"`).
+		WithArgs(game.ID, models.AnalysisStatusRunning, analysisDepth, 0.0, 0.0, "", nil, testutil.AnyTime{}, testutil.AnyTime{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "move_analyses" WHERE game_id = \$1`).
+		WithArgs(game.ID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO "move_analyses" .* RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()).AddRow(uuid.New()).AddRow(uuid.New()).AddRow(uuid.New()))
+	mock.ExpectExec(`UPDATE "game_analyses" SET "black_accuracy"=\$1,"completed_at"=\$2,"status"=\$3,"white_accuracy"=\$4,"updated_at"=\$5 WHERE game_id = \$6`).
+		WithArgs(100.0, testutil.AnyTime{}, models.AnalysisStatusCompleted, sqlmock.AnyArg(), testutil.AnyTime{}, game.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	subscription := redisClient.Subscribe(context.Background(), "game:"+game.ID.String())
+	defer subscription.Close()
+	_, err := subscription.Receive(context.Background())
+	require.NoError(t, err)
+
+	service := NewAnalysisService(db, redisClient)
+	require.NoError(t, service.AnalyzeGame(game))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Four progress updates, then the summary
+	type analysisUpdate struct {
+		EventType string `json:"event_type"`
+		Data      struct {
+			Total         int                 `json:"total"`
+			Move          models.MoveAnalysis `json:"move"`
+			WhiteAccuracy float64             `json:"white_accuracy"`
+		} `json:"data"`
+	}
+	var updates []analysisUpdate
+	for i := 0; i < 5; i++ {
+		message, err := subscription.ReceiveTimeout(context.Background(), time.Second)
+		require.NoError(t, err)
+		var update analysisUpdate
+		require.NoError(t, json.Unmarshal([]byte(message.(*redis.Message).Payload), &update))
+		updates = append(updates, update)
+	}
+
+	assert.Equal(t, "analysis_progress", updates[0].EventType)
+	assert.Equal(t, 4, updates[0].Data.Total)
+	assert.Equal(t, models.MoveClassificationBlunder, updates[2].Data.Move.Classification)
+	assert.Equal(t, "g2g4", updates[2].Data.Move.Move)
+	assert.Equal(t, models.MoveClassificationBest, updates[3].Data.Move.Classification)
+	assert.Equal(t, "analysis_complete", updates[4].EventType)
+	assert.Less(t, updates[4].Data.WhiteAccuracy, 100.0)
+}
+
+func TestAnalysisService_GetAnalysis_NotFound(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	gameID := uuid.New()
+	mock.ExpectQuery(`SELECT \* FROM "game_analyses" WHERE game_id = \$1`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"game_id"}))
+
+	_, err := NewAnalysisService(db, nil).GetAnalysis(gameID)
+
+	assert.ErrorIs(t, err, ErrAnalysisNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrArenaNotFound = errors.New("arena not found")
+
+type ArenaService struct {
+	db *gorm.DB
+}
+
+// ArenaSummary is an arena together with the number of avatars currently in it.
+type ArenaSummary struct {
+	models.Arena
+	Players int64 `json:"players"`
+}
+
+func NewArenaService(db *gorm.DB) *ArenaService {
+	return &ArenaService{
+		db: db,
+	}
+}
+
+func (as *ArenaService) GetPublicArenas() ([]ArenaSummary, error) {
+	var arenas []models.Arena
+	if err := as.db.Where("is_public = ?", true).Order("name ASC").Find(&arenas).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch arenas: %w", err)
+	}
+
+	var counts []struct {
+		CurrentArena uuid.UUID
+		Players      int64
+	}
+	err := as.db.Model(&models.Avatar{}).
+		Select("current_arena, count(*) AS players").
+		Where("current_arena IS NOT NULL").
+		Group("current_arena").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count arena players: %w", err)
+	}
+
+	players := make(map[uuid.UUID]int64, len(counts))
+	for _, c := range counts {
+		players[c.CurrentArena] = c.Players
+	}
+
+	summaries := make([]ArenaSummary, 0, len(arenas))
+	for _, arena := range arenas {
+		summaries = append(summaries, ArenaSummary{Arena: arena, Players: players[arena.ID]})
+	}
+
+	return summaries, nil
+}
+
+func (as *ArenaService) GetArena(arenaID uuid.UUID) (*ArenaSummary, error) {
+	var arena models.Arena
+	if err := as.db.First(&arena, "id = ?", arenaID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrArenaNotFound
+		}
+		return nil, fmt.Errorf("failed to load arena: %w", err)
+	}
+
+	var players int64
+	if err := as.db.Model(&models.Avatar{}).Where("current_arena = ?", arenaID).Count(&players).Error; err != nil {
+		return nil, fmt.Errorf("failed to count arena players: %w", err)
+	}
+
+	return &ArenaSummary{Arena: arena, Players: players}, nil
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var arenaColumns = []string{
+	"id", "name", "theme", "max_players", "max_games", "is_public",
+	"description", "settings", "created_at", "updated_at",
+}
+
+func TestArenaService_GetArena(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	arenaService := NewArenaService(db)
+	arenaID := uuid.New()
+
+	mock.ExpectQuery(`SELECT \* FROM "arenas" WHERE id = \$1`).
+		WithArgs(arenaID).
+		WillReturnRows(sqlmock.NewRows(arenaColumns).AddRow(
+			arenaID, "Mystic Sanctum", models.ArenaThemeMystic, 100, 10, true,
+			"", "", time.Now(), time.Now(),
+		))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "avatars" WHERE current_arena = \$1`).
+		WithArgs(arenaID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+
+	arena, err := arenaService.GetArena(arenaID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, arenaID, arena.ID)
+	assert.Equal(t, models.ArenaThemeMystic, arena.Theme)
+	assert.Equal(t, int64(42), arena.Players)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestArenaService_GetArena_NotFound(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	arenaService := NewArenaService(db)
+	arenaID := uuid.New()
+
+	mock.ExpectQuery(`SELECT \* FROM "arenas" WHERE id = \$1`).
+		WithArgs(arenaID).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	arena, err := arenaService.GetArena(arenaID)
+
+	assert.ErrorIs(t, err, ErrArenaNotFound)
+	assert.Nil(t, arena)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestArenaService_GetPublicArenas(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	arenaService := NewArenaService(db)
+	busyArena := uuid.New()
+	emptyArena := uuid.New()
+
+	mock.ExpectQuery(`SELECT \* FROM "arenas" WHERE is_public = \$1 ORDER BY name ASC`).
+		WithArgs(true).
+		WillReturnRows(sqlmock.NewRows(arenaColumns).
+			AddRow(busyArena, "Fire Pit", models.ArenaThemeFire, 100, 10, true, "", "", time.Now(), time.Now()).
+			AddRow(emptyArena, "Ice Hall", models.ArenaThemeIce, 100, 10, true, "", "", time.Now(), time.Now()))
+	mock.ExpectQuery(`SELECT current_arena, count\(\*\) AS players FROM "avatars"`).
+		WillReturnRows(sqlmock.NewRows([]string{"current_arena", "players"}).AddRow(busyArena, 7))
+
+	arenas, err := arenaService.GetPublicArenas()
+
+	assert.NoError(t, err)
+	assert.Len(t, arenas, 2)
+	assert.Equal(t, int64(7), arenas[0].Players)
+	assert.Equal(t, int64(0), arenas[1].Players)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// The coach helps learning players in casual games. On request it gives a
+// hint for the player's move, first the piece to move and, asked again, the
+// move itself. Players who turn it on also get advice over the WebSocket
+// whenever it's their move: what the opponent threatens and which of their
+// pieces are hanging. Everything comes from the engine's search and the
+// attack maps of the chess package. Rated games never get a coach.
+
+const (
+	coachDepth = 3
+	coachTTL   = 24 * time.Hour
+)
+
+var ErrCoachUnavailable = errors.New("the coach is only available in casual standard games")
+
+// CoachHint is a hint for a player's next move.
+type CoachHint struct {
+	GameID   uuid.UUID `json:"game_id"`
+	Ply      int       `json:"ply"`    // the half-move the hint is for
+	Square   string    `json:"square"` // where the piece to move stands
+	Piece    string    `json:"piece"`
+	Move     string    `json:"move,omitempty"` // the move itself, from the second hint on
+	Notation string    `json:"notation,omitempty"`
+	Text     string    `json:"text"`
+}
+
+// CoachAdvice explains the dangers in a position to one of its players.
+type CoachAdvice struct {
+	GameID  uuid.UUID            `json:"game_id"`
+	Ply     int                  `json:"ply"`              // the half-move about to be played, as in CoachHint
+	Threat  *chess.Threat        `json:"threat,omitempty"` // what the opponent threatens
+	Hanging []chess.HangingPiece `json:"hanging"`          // the player's pieces that can be won
+	Text    []string             `json:"text"`
+}
+
+var pieceNames = map[byte]string{
+	'p': "pawn", 'n': "knight", 'b': "bishop", 'r': "rook", 'q': "queen", 'k': "king",
+}
+
+// EnableCoach turns the coach on for userID in a game they play, and returns
+// its advice on the current position.
+func (gs *GameService) EnableCoach(gameID, userID uuid.UUID) (*CoachAdvice, error) {
+	game, color, err := gs.coachedGame(gameID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	key := coachKey(gameID)
+	if err := gs.redis.SAdd(ctx, key, userID.String()).Err(); err != nil {
+		return nil, fmt.Errorf("failed to enable coach: %w", err)
+	}
+	gs.redis.Expire(ctx, key, coachTTL)
+
+	return coachAdvice(game, color), nil
+}
+
+// DisableCoach stops the coach's advice to userID.
+func (gs *GameService) DisableCoach(gameID, userID uuid.UUID) error {
+	if err := gs.redis.SRem(context.Background(), coachKey(gameID), userID.String()).Err(); err != nil {
+		return fmt.Errorf("failed to disable coach: %w", err)
+	}
+	return nil
+}
+
+// CoachHint returns a hint for userID's move: the piece to move the first
+// time it's asked for in a position, the move after that.
+func (gs *GameService) CoachHint(gameID, userID uuid.UUID) (*CoachHint, error) {
+	game, color, err := gs.coachedGame(gameID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if color != game.CurrentTurn {
+		return nil, ErrNotPlayerTurn
+	}
+
+	best := chess.NewEngine(game.BoardState).Search(coachDepth).BestMove
+	if best == "" {
+		return nil, ErrGameNotActive
+	}
+	move, err := chess.NewEngine(game.BoardState).ValidateMove(best[:2], best[2:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to play hint %s: %w", best, err)
+	}
+
+	ctx := context.Background()
+	key := fmt.Sprintf("%s:%s:%d", coachKey(gameID), userID, game.MoveCount)
+	asked, err := gs.redis.Incr(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count hints: %w", err)
+	}
+	gs.redis.Expire(ctx, key, coachTTL)
+
+	hint := &CoachHint{
+		GameID: gameID,
+		Ply:    game.MoveCount + 1,
+		Square: best[:2],
+		Piece:  move.Piece,
+		Text:   fmt.Sprintf("Look for a move with your %s on %s.", pieceName(move.Piece), best[:2]),
+	}
+	if asked > 1 {
+		hint.Move, hint.Notation = best, move.Notation
+		hint.Text = fmt.Sprintf("Play %s.", move.Notation)
+	}
+	return hint, nil
+}
+
+// CoachMoved is a GameService.OnMove hook sending the coach's advice to the
+// player to move, if they turned the coach on.
+func (gs *GameService) CoachMoved(game models.Game, move models.GameMove) {
+	if gs.hub == nil || !coachable(&game) || isGameOver(&game) {
+		return
+	}
+	player := playerToMove(&game)
+	if player == nil {
+		return
+	}
+	coached, err := gs.redis.SIsMember(context.Background(), coachKey(game.ID), player.String()).Result()
+	if err != nil {
+		log.Printf("Error checking the coach of game %s: %v", game.ID, err)
+		return
+	}
+	if !coached {
+		return
+	}
+
+	gs.hub.SendToUser(player.String(), Message{Type: "coach_advice", Data: coachAdvice(&game, game.CurrentTurn)})
+}
+
+// coachedGame loads a game the coach may help userID with and returns it
+// with userID's color.
+func (gs *GameService) coachedGame(gameID, userID uuid.UUID) (*models.Game, string, error) {
+	game, err := gs.currentGame(gameID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !coachable(&game) {
+		return nil, "", ErrCoachUnavailable
+	}
+	color := playerColor(&game, userID)
+	if color == "" {
+		return nil, "", ErrNotGamePlayer
+	}
+	if game.Status != models.GameStatusActive {
+		return nil, "", ErrGameNotActive
+	}
+	return &game, color, nil
+}
+
+// coachAdvice looks for the opponent's threat and color's hanging pieces in
+// game's position.
+func coachAdvice(game *models.Game, color string) *CoachAdvice {
+	opponent := getOpponentColor(color)
+	advice := &CoachAdvice{
+		GameID:  game.ID,
+		Ply:     game.MoveCount + 1,
+		Hanging: chess.HangingPieces(game.BoardState, color),
+		Text:    []string{},
+	}
+	if advice.Hanging == nil {
+		advice.Hanging = []chess.HangingPiece{}
+	}
+
+	if threat, ok := chess.FindThreat(game.BoardState, opponent, coachDepth); ok {
+		advice.Threat = &threat
+		side := "White"
+		if opponent == "black" {
+			side = "Black"
+		}
+		switch {
+		case threat.Mate > 0:
+			advice.Text = append(advice.Text, fmt.Sprintf("%s threatens mate in %d, starting with %s.", side, threat.Mate, threat.Notation))
+		case threat.Captured != "":
+			advice.Text = append(advice.Text, fmt.Sprintf("%s threatens %s, winning your %s.", side, threat.Notation, pieceName(threat.Captured)))
+		default:
+			advice.Text = append(advice.Text, fmt.Sprintf("%s threatens %s, winning material.", side, threat.Notation))
+		}
+	}
+	for _, hanging := range advice.Hanging {
+		advice.Text = append(advice.Text, fmt.Sprintf("Your %s on %s is hanging.", pieceName(hanging.Piece), hanging.Square))
+	}
+	return advice
+}
+
+// coachable reports whether game may have a coach: it must be casual, and
+// standard chess, which is all the engine searches.
+func coachable(game *models.Game) bool {
+	return game.Casual && (game.Variant == models.GameVariantStandard || game.Variant == "")
+}
+
+// pieceName names a piece given by its FEN letter.
+func pieceName(piece string) string {
+	if piece == "" {
+		return "piece"
+	}
+	return pieceNames[piece[0]|0x20]
+}
+
+func coachKey(gameID uuid.UUID) string {
+	return fmt.Sprintf("game:%s:coach", gameID)
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cacheCasualGame caches an active casual game in position fen.
+func cacheCasualGame(t *testing.T, redisClient *redis.Client, white, black uuid.UUID, fen string) *models.Game {
+	game := cacheActiveGame(t, redisClient, white, black)
+	game.Casual = true
+	game.BoardState = fen
+	if sideToMove(fen) == "black" {
+		game.CurrentTurn = "black"
+		game.MoveCount = 1
+	}
+
+	gameJSON, err := json.Marshal(game)
+	require.NoError(t, err)
+	redisClient.Set(context.Background(), fmt.Sprintf("game:%s", game.ID), string(gameJSON), time.Hour)
+	return game
+}
+
+func TestGameService_CoachHint(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	white, black := uuid.New(), uuid.New()
+	game := cacheCasualGame(t, redisClient, white, black, "6k1/5ppp/8/8/8/8/5PPP/4R1K1 w - - 0 1")
+
+	// The piece first, then the move
+	hint, err := gameService.CoachHint(game.ID, white)
+	require.NoError(t, err)
+	assert.Equal(t, "e1", hint.Square)
+	assert.Empty(t, hint.Move)
+	assert.Equal(t, "Look for a move with your rook on e1.", hint.Text)
+	assert.Equal(t, game.MoveCount+1, hint.Ply)
+
+	hint, err = gameService.CoachHint(game.ID, white)
+	require.NoError(t, err)
+	assert.Equal(t, "e1e8", hint.Move)
+	assert.Equal(t, "Play Re8.", hint.Text)
+
+	_, err = gameService.CoachHint(game.ID, black)
+	assert.ErrorIs(t, err, ErrNotPlayerTurn)
+
+	_, err = gameService.CoachHint(game.ID, uuid.New())
+	assert.ErrorIs(t, err, ErrNotGamePlayer)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_Coach_RatedGame(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	white, black := uuid.New(), uuid.New()
+	game := cacheActiveGame(t, redisClient, white, black)
+
+	_, err := gameService.CoachHint(game.ID, white)
+	assert.ErrorIs(t, err, ErrCoachUnavailable)
+	_, err = gameService.EnableCoach(game.ID, white)
+	assert.ErrorIs(t, err, ErrCoachUnavailable)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_CoachMoved(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	hub := NewHub()
+	go hub.Run()
+	gameService := NewGameService(db, redisClient)
+	gameService.PublishTo(hub)
+	white, black := uuid.New(), uuid.New()
+	whiteClient := connectTestClient(t, hub, white.String())
+	blackClient := connectTestClient(t, hub, black.String())
+
+	// White's rook threatens mate on the back rank
+	game := cacheCasualGame(t, redisClient, white, black, "6k1/5ppp/8/8/8/8/r4PPP/4R1K1 b - - 0 1")
+	advice, err := gameService.EnableCoach(game.ID, black)
+	require.NoError(t, err)
+	require.NotNil(t, advice.Threat)
+	assert.Equal(t, game.MoveCount+1, advice.Ply)
+	assert.Equal(t, "e1e8", advice.Threat.Move)
+	assert.Equal(t, []string{"White threatens mate in 1, starting with Re8."}, advice.Text)
+
+	// White's last move attacked black's queen; only black, who turned the
+	// coach on, is told
+	game.BoardState = "6k1/5ppp/8/3q4/8/2N5/5PPP/6K1 b - - 0 3"
+	game.MoveCount = 3
+	gameService.CoachMoved(*game, models.GameMove{GameID: game.ID, MoveNumber: 3})
+
+	message := expectMessage(t, blackClient, "coach_advice")
+	data := message.Data.(map[string]interface{})
+	assert.Equal(t, float64(4), data["ply"])
+	assert.Equal(t, []interface{}{"White threatens Nxd5, winning your queen.", "Your queen on d5 is hanging."}, data["text"])
+	expectNoMessage(t, whiteClient)
+
+	require.NoError(t, gameService.DisableCoach(game.ID, black))
+	gameService.CoachMoved(*game, models.GameMove{GameID: game.ID, MoveNumber: 3})
+	expectNoMessage(t, blackClient)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCoachAdvice_Hanging(t *testing.T) {
+	game := &models.Game{
+		CurrentTurn: "white",
+		BoardState:  "4k3/4r3/4N3/3p4/2N5/8/8/2Q1K3 w - - 0 1",
+	}
+
+	advice := coachAdvice(game, "white")
+	assert.Len(t, advice.Hanging, 2)
+	assert.Contains(t, advice.Text, "Your knight on e6 is hanging.")
+	assert.Equal(t, chess.HangingPiece{Square: "c4", Piece: "N"}, advice.Hanging[1])
+}
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// In a consultation game each side is played by a team: the player seated on
+// it plus any members who joined it. Instead of moving, team members vote
+// for a move while it's their side's turn. Voting runs in windows of
+// Game.VoteWindow seconds from the start of the turn; when a window closes
+// with votes in, the actor plays the most voted move, the earliest proposed
+// one on a tie. A window without votes is followed by another, until the
+// clock runs out.
+//
+// Votes live in Redis, one hash per ply keyed by voter, so that every
+// instance counts the same ones.
+
+const (
+	minVoteWindow = 10  // seconds
+	maxVoteWindow = 120 // seconds
+	votesTTL      = 24 * time.Hour
+)
+
+var (
+	ErrInvalidVoteWindow = errors.New("vote window must be between 10 and 120 seconds, in a game with a clock")
+	ErrNotConsultation   = errors.New("not a consultation game")
+	ErrVoteRequired      = errors.New("moves in consultation games are voted on")
+	ErrInvalidTeam       = errors.New("team must be white or black")
+)
+
+// ConsultationTeams lists the players on each side of a consultation game,
+// the seated player first.
+type ConsultationTeams struct {
+	White []uuid.UUID `json:"white"`
+	Black []uuid.UUID `json:"black"`
+}
+
+// VoteTally is the standing of the vote on the side to move's next move.
+type VoteTally struct {
+	Ply      int         `json:"ply"`   // the half-move voted on
+	Color    string      `json:"color"` // the side voting
+	Deadline time.Time   `json:"deadline"`
+	Moves    []MoveVotes `json:"moves"` // most voted first
+}
+
+// MoveVotes counts the votes for one proposed move.
+type MoveVotes struct {
+	From       string    `json:"from,omitempty"`
+	To         string    `json:"to"`
+	Drop       string    `json:"drop,omitempty"`
+	Votes      int       `json:"votes"`
+	ProposedAt time.Time `json:"proposed_at"` // when the move first got a vote
+}
+
+// moveVote is one team member's vote, as stored in Redis.
+type moveVote struct {
+	From string    `json:"from,omitempty"`
+	To   string    `json:"to"`
+	Drop string    `json:"drop,omitempty"`
+	At   time.Time `json:"at"`
+}
+
+// JoinTeam puts userID on color's team in a consultation game that hasn't
+// finished.
+func (gs *GameService) JoinTeam(gameID, userID uuid.UUID, color string) (*models.ConsultationMember, error) {
+	if color != "white" && color != "black" {
+		return nil, ErrInvalidTeam
+	}
+
+	var game models.Game
+	if err := gs.db.Take(&game, "id = ?", gameID).Error; err != nil {
+		return nil, lookupError(err)
+	}
+	if game.VoteWindow == 0 {
+		return nil, ErrNotConsultation
+	}
+	if isGameOver(&game) {
+		return nil, ErrGameNotActive
+	}
+	current, err := gs.teamColor(&game, userID)
+	if err != nil {
+		return nil, err
+	}
+	if current != "" {
+		return nil, ErrAlreadyInGame
+	}
+
+	member := &models.ConsultationMember{GameID: gameID, UserID: userID, Color: color}
+	if err := gs.db.Create(member).Error; err != nil {
+		return nil, fmt.Errorf("failed to join team: %w", err)
+	}
+
+	gs.publishGameUpdate(gameID, "team_joined", map[string]interface{}{
+		"user_id": userID,
+		"color":   color,
+	})
+	return member, nil
+}
+
+// ConsultationTeams returns the teams of a consultation game.
+func (gs *GameService) ConsultationTeams(gameID uuid.UUID) (*ConsultationTeams, error) {
+	game, err := gs.GetGame(gameID)
+	if err != nil {
+		return nil, err
+	}
+	if game.VoteWindow == 0 {
+		return nil, ErrNotConsultation
+	}
+
+	var members []models.ConsultationMember
+	err = gs.db.Where("game_id = ?", gameID).Order("created_at ASC").Find(&members).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load teams: %w", err)
+	}
+
+	teams := &ConsultationTeams{White: []uuid.UUID{}, Black: []uuid.UUID{}}
+	if game.WhitePlayerID != nil {
+		teams.White = append(teams.White, *game.WhitePlayerID)
+	}
+	if game.BlackPlayerID != nil {
+		teams.Black = append(teams.Black, *game.BlackPlayerID)
+	}
+	for _, member := range members {
+		if member.Color == "white" {
+			teams.White = append(teams.White, member.UserID)
+		} else {
+			teams.Black = append(teams.Black, member.UserID)
+		}
+	}
+	return teams, nil
+}
+
+// teamMembers returns the team members of a consultation game, not counting
+// its seated players.
+func (gs *GameService) teamMembers(gameID uuid.UUID) ([]uuid.UUID, error) {
+	var members []uuid.UUID
+	err := gs.db.Model(&models.ConsultationMember{}).Where("game_id = ?", gameID).Pluck("user_id", &members).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load teams: %w", err)
+	}
+	return members, nil
+}
+
+// Vote records userID's vote for the next move of their team, replacing any
+// vote they gave before, and returns the tally. Drops name the piece in
+// drop and leave from empty.
+func (gs *GameService) Vote(gameID, userID uuid.UUID, from, to, drop string) (*VoteTally, error) {
+	result := gs.dispatch(gameID, gameCommand{kind: commandVote, playerID: userID, from: from, to: to, drop: drop})
+	return result.tally, result.err
+}
+
+// teamColor returns the side userID plays in game, as its seated player or,
+// in consultation games, as a team member; empty if neither.
+func (gs *GameService) teamColor(game *models.Game, userID uuid.UUID) (string, error) {
+	if color := playerColor(game, userID); color != "" || game.VoteWindow == 0 {
+		return color, nil
+	}
+
+	var member models.ConsultationMember
+	err := gs.db.Take(&member, "game_id = ? AND user_id = ?", game.ID, userID).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("failed to load team: %w", err)
+	}
+	return member.Color, nil
+}
+
+// vote records playerID's vote, after checking it's their team's turn and
+// the move is legal.
+func (a *gameActor) vote(playerID uuid.UUID, from, to, drop string) commandResult {
+	game := a.game
+	if game.Status != models.GameStatusActive {
+		return commandResult{err: ErrGameNotActive}
+	}
+	if game.VoteWindow == 0 {
+		return commandResult{err: ErrNotConsultation}
+	}
+	color, err := a.service.teamColor(game, playerID)
+	if err != nil {
+		return commandResult{err: err}
+	}
+	if color == "" {
+		return commandResult{err: ErrNotGamePlayer}
+	}
+	if color != game.CurrentTurn {
+		return commandResult{err: ErrNotPlayerTurn}
+	}
+
+	engine := chess.NewEngine(game.BoardState)
+	if drop != "" {
+		from = ""
+		_, err = engine.ValidateDrop(drop, to)
+	} else {
+		_, err = engine.ValidateMove(from, to)
+	}
+	if err != nil {
+		return commandResult{err: fmt.Errorf("%w: %v", ErrInvalidMove, err)}
+	}
+
+	ctx := context.Background()
+	key := votesKey(game)
+	vote, _ := json.Marshal(moveVote{From: from, To: to, Drop: drop, At: time.Now()})
+	if err := a.service.redis.HSet(ctx, key, playerID.String(), vote).Err(); err != nil {
+		return commandResult{err: fmt.Errorf("failed to record vote: %w", err)}
+	}
+	a.service.redis.Expire(ctx, key, votesTTL)
+
+	tally, err := a.service.voteTally(game, time.Now())
+	if err != nil {
+		return commandResult{err: err}
+	}
+	a.service.publishGameUpdate(game.ID, "vote_tally", tally)
+
+	return commandResult{game: a.snapshot(), tally: tally}
+}
+
+// resolveVotes plays the winning move once a voting window has closed.
+func (a *gameActor) resolveVotes() commandResult {
+	game := a.game
+	if game.Status != models.GameStatusActive || game.VoteWindow == 0 || game.LastMoveAt == nil {
+		return commandResult{game: a.snapshot()}
+	}
+
+	now := time.Now()
+	tally, err := a.service.voteTally(game, now)
+	if err != nil {
+		return commandResult{err: err}
+	}
+	if now.Sub(*game.LastMoveAt) < time.Duration(game.VoteWindow)*time.Second || len(tally.Moves) == 0 {
+		// Too early, or nobody voted: wait for the next window to close
+		a.scheduleVote()
+		return commandResult{game: a.snapshot()}
+	}
+
+	player := playerToMove(game)
+	if player == nil {
+		return commandResult{game: a.snapshot()}
+	}
+	key := votesKey(game)
+	winner := tally.Moves[0]
+	result := a.move(*player, winner.From, winner.To, winner.Drop)
+	if result.err != nil {
+		return result
+	}
+
+	if err := a.service.redis.Del(context.Background(), key).Err(); err != nil {
+		log.Printf("Error clearing the votes of game %s: %v", game.ID, err)
+	}
+	return result
+}
+
+// scheduleVote arms a timer that resolves the vote when the current voting
+// window closes. Like the flag timer, it outlives an idle actor.
+func (a *gameActor) scheduleVote() {
+	if a.voteTimer != nil {
+		a.voteTimer.Stop()
+		a.voteTimer = nil
+	}
+	if a.game.Status != models.GameStatusActive || a.game.VoteWindow == 0 || a.game.LastMoveAt == nil {
+		return
+	}
+
+	gs, gameID := a.service, a.gameID
+	a.voteTimer = time.AfterFunc(time.Until(voteDeadline(a.game, time.Now())), func() {
+		gs.dispatch(gameID, gameCommand{kind: commandResolveVotes})
+	})
+}
+
+// voteTally counts the votes on the side to move's next move.
+func (gs *GameService) voteTally(game *models.Game, now time.Time) (*VoteTally, error) {
+	votes, err := gs.redis.HGetAll(context.Background(), votesKey(game)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load votes: %w", err)
+	}
+
+	moves := make(map[string]*MoveVotes)
+	for voter, raw := range votes {
+		var vote moveVote
+		if err := json.Unmarshal([]byte(raw), &vote); err != nil {
+			log.Printf("Error decoding the vote of %s in game %s: %v", voter, game.ID, err)
+			continue
+		}
+		name := vote.From + vote.Drop + vote.To
+		move, ok := moves[name]
+		if !ok {
+			move = &MoveVotes{From: vote.From, To: vote.To, Drop: vote.Drop, ProposedAt: vote.At}
+			moves[name] = move
+		}
+		move.Votes++
+		if vote.At.Before(move.ProposedAt) {
+			move.ProposedAt = vote.At
+		}
+	}
+
+	tally := &VoteTally{
+		Ply:      game.MoveCount + 1,
+		Color:    game.CurrentTurn,
+		Deadline: voteDeadline(game, now),
+		Moves:    make([]MoveVotes, 0, len(moves)),
+	}
+	for _, move := range moves {
+		tally.Moves = append(tally.Moves, *move)
+	}
+	sort.Slice(tally.Moves, func(i, j int) bool {
+		if tally.Moves[i].Votes != tally.Moves[j].Votes {
+			return tally.Moves[i].Votes > tally.Moves[j].Votes
+		}
+		return tally.Moves[i].ProposedAt.Before(tally.Moves[j].ProposedAt)
+	})
+	return tally, nil
+}
+
+// voteDeadline returns when the voting window open at now closes. Windows
+// follow each other from the start of the turn.
+func voteDeadline(game *models.Game, now time.Time) time.Time {
+	if game.LastMoveAt == nil {
+		return now
+	}
+	window := time.Duration(game.VoteWindow) * time.Second
+	windows := now.Sub(*game.LastMoveAt)/window + 1
+	return game.LastMoveAt.Add(windows * window)
+}
+
+func votesKey(game *models.Game) string {
+	return fmt.Sprintf("game:%s:votes:%d", game.ID, game.MoveCount)
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cacheConsultationGame caches an active consultation game whose turn began
+// started ago.
+func cacheConsultationGame(t *testing.T, redisClient *redis.Client, white, black uuid.UUID, started time.Duration) *models.Game {
+	game := cacheActiveGame(t, redisClient, white, black)
+	lastMove := time.Now().Add(-started)
+	game.VoteWindow = 30
+	game.LastMoveAt = &lastMove
+
+	gameJSON, err := json.Marshal(game)
+	require.NoError(t, err)
+	redisClient.Set(context.Background(), fmt.Sprintf("game:%s", game.ID), string(gameJSON), time.Hour)
+	return game
+}
+
+func expectTeamMember(mock sqlmock.Sqlmock, gameID, userID uuid.UUID, color string) {
+	rows := sqlmock.NewRows([]string{"game_id", "user_id", "color"})
+	if color != "" {
+		rows.AddRow(gameID, userID, color)
+	}
+	mock.ExpectQuery(`SELECT \* FROM "consultation_members" WHERE game_id = \$1 AND user_id = \$2 LIMIT 1`).
+		WithArgs(gameID, userID).
+		WillReturnRows(rows)
+}
+
+func TestGameService_CreateGame_InvalidVoteWindow(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+
+	for _, opts := range []GameOptions{
+		{VoteWindow: minVoteWindow - 1},
+		{VoteWindow: maxVoteWindow + 1},
+		{VoteWindow: 30, DaysPerMove: 3},
+	} {
+		game, err := gameService.CreateGame(uuid.New(), uuid.New(), opts)
+		assert.ErrorIs(t, err, ErrInvalidVoteWindow)
+		assert.Nil(t, game)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_Vote(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	white, black, teammate, outsider := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	game := cacheConsultationGame(t, redisClient, white, black, 5*time.Second)
+
+	// Team members vote instead of moving
+	_, err := gameService.MakeMove(game.ID, white, "e2", "e4")
+	assert.ErrorIs(t, err, ErrVoteRequired)
+
+	tally, err := gameService.Vote(game.ID, white, "e2", "e4", "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, tally.Ply)
+	assert.Equal(t, "white", tally.Color)
+	assert.WithinDuration(t, game.LastMoveAt.Add(30*time.Second), tally.Deadline, time.Millisecond)
+
+	expectTeamMember(mock, game.ID, teammate, "white")
+	tally, err = gameService.Vote(game.ID, teammate, "d2", "d4", "")
+	require.NoError(t, err)
+	require.Len(t, tally.Moves, 2)
+	// A tie goes to the move proposed first
+	assert.Equal(t, "e4", tally.Moves[0].To)
+
+	// Changing a vote replaces it
+	expectTeamMember(mock, game.ID, teammate, "white")
+	tally, err = gameService.Vote(game.ID, teammate, "e2", "e4", "")
+	require.NoError(t, err)
+	require.Len(t, tally.Moves, 1)
+	assert.Equal(t, 2, tally.Moves[0].Votes)
+
+	_, err = gameService.Vote(game.ID, black, "e7", "e5", "")
+	assert.ErrorIs(t, err, ErrNotPlayerTurn)
+
+	expectTeamMember(mock, game.ID, outsider, "")
+	_, err = gameService.Vote(game.ID, outsider, "e2", "e4", "")
+	assert.ErrorIs(t, err, ErrNotGamePlayer)
+
+	_, err = gameService.Vote(game.ID, white, "e2", "e5", "")
+	assert.ErrorIs(t, err, ErrInvalidMove)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_ResolveVotes(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	white, black := uuid.New(), uuid.New()
+	game := cacheConsultationGame(t, redisClient, white, black, 31*time.Second)
+
+	// d4 and e4 have two votes each, but d4 was proposed first
+	start := time.Now().Add(-20 * time.Second)
+	for i, vote := range []moveVote{
+		{From: "e2", To: "e4", At: start.Add(2 * time.Second)},
+		{From: "d2", To: "d4", At: start.Add(time.Second)},
+		{From: "e2", To: "e4", At: start.Add(3 * time.Second)},
+		{From: "d2", To: "d4", At: start.Add(4 * time.Second)},
+		{From: "g1", To: "f3", At: start},
+	} {
+		raw, _ := json.Marshal(vote)
+		redisClient.HSet(context.Background(), votesKey(game), fmt.Sprintf("voter-%d", i), raw)
+	}
+
+	mock.ExpectBegin()
+	expectMoveUpdate(mock, game.ID, "black", 1, 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`INSERT INTO "game_moves"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	expectEvent(mock, game.ID, models.GameEventMoved, 1)
+	mock.ExpectCommit()
+
+	result := gameService.dispatch(game.ID, gameCommand{kind: commandResolveVotes})
+	require.NoError(t, result.err)
+	require.NotNil(t, result.move)
+	assert.Equal(t, white, result.move.PlayerID)
+	assert.Equal(t, "d4", result.move.ToSquare)
+	assert.Equal(t, "black", result.game.CurrentTurn)
+
+	// Black's vote starts from scratch
+	assert.False(t, redisServer.Exists(fmt.Sprintf("game:%s:votes:0", game.ID)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_ResolveVotes_WaitsForVotes(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	game := cacheConsultationGame(t, redisClient, uuid.New(), uuid.New(), 31*time.Second)
+
+	// Nobody voted in the first window, so nothing is played
+	result := gameService.dispatch(game.ID, gameCommand{kind: commandResolveVotes})
+	require.NoError(t, result.err)
+	assert.Nil(t, result.move)
+	assert.Equal(t, 0, result.game.MoveCount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVoteDeadline(t *testing.T) {
+	start := time.Now()
+	game := &models.Game{VoteWindow: 30, LastMoveAt: &start}
+
+	assert.Equal(t, start.Add(30*time.Second), voteDeadline(game, start.Add(10*time.Second)))
+	assert.Equal(t, start.Add(60*time.Second), voteDeadline(game, start.Add(30*time.Second)))
+	assert.Equal(t, start.Add(90*time.Second), voteDeadline(game, start.Add(75*time.Second)))
+}
+
+func TestGameService_JoinTeam(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	gameID, white, userID := uuid.New(), uuid.New(), uuid.New()
+	expectGame := func(voteWindow int) {
+		mock.ExpectQuery(`SELECT \* FROM "games" WHERE id = \$1 LIMIT 1`).
+			WithArgs(gameID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "white_player_id", "status", "vote_window"}).
+				AddRow(gameID, white, models.GameStatusWaiting, voteWindow))
+	}
+
+	_, err := gameService.JoinTeam(gameID, userID, "red")
+	assert.ErrorIs(t, err, ErrInvalidTeam)
+
+	expectGame(0)
+	_, err = gameService.JoinTeam(gameID, userID, "white")
+	assert.ErrorIs(t, err, ErrNotConsultation)
+
+	// The seated player is already on their team
+	expectGame(30)
+	_, err = gameService.JoinTeam(gameID, white, "black")
+	assert.ErrorIs(t, err, ErrAlreadyInGame)
+
+	expectGame(30)
+	expectTeamMember(mock, gameID, userID, "")
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "consultation_members"`).
+		WithArgs(gameID, userID, "black", testutil.AnyTime{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	member, err := gameService.JoinTeam(gameID, userID, "black")
+	require.NoError(t, err)
+	assert.Equal(t, "black", member.Color)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
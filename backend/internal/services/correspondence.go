@@ -0,0 +1,337 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Correspondence games give each side a number of days per move instead of
+// a running clock. The side to move has a deadline, put back while they're
+// on vacation, and forfeits when the CorrespondenceScheduler finds it
+// passed. While waiting, a player can queue conditional moves: lines of the
+// opponent's possible moves with the replies to play to them, which are
+// played as soon as the opponent follows one.
+
+const (
+	minDaysPerMove      = 1
+	maxDaysPerMove      = 14
+	secondsPerDay       = 24 * 60 * 60
+	vacationDaysPerYear = 30
+
+	maxConditionalLines = 16
+	maxConditionalPlies = 20 // per line
+)
+
+var (
+	ErrInvalidDaysPerMove      = errors.New("days per move must be between 1 and 14")
+	ErrNotCorrespondence       = errors.New("not a correspondence game")
+	ErrInvalidVacation         = errors.New("invalid vacation")
+	ErrNotEnoughVacation       = errors.New("not enough vacation days left this year")
+	ErrInvalidConditionalMoves = errors.New("invalid conditional moves")
+)
+
+var coordinateMovePattern = regexp.MustCompile(`^[a-h][1-8][a-h][1-8]$`)
+
+// deadlinePayload is the payload of a deadline_moved event.
+type deadlinePayload struct {
+	Deadline time.Time `json:"deadline"`
+}
+
+// Vacation returns userID's vacation allowance for this year.
+func (gs *GameService) Vacation(userID uuid.UUID) (*models.Vacation, error) {
+	vacation, err := loadVacation(gs.db, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return vacation, nil
+}
+
+// TakeVacation spends days of userID's allowance, starting now or when the
+// vacation they're on ends. The deadlines of the games waiting for them are
+// put back by as much.
+func (gs *GameService) TakeVacation(userID uuid.UUID, days int) (*models.Vacation, error) {
+	if days < 1 {
+		return nil, fmt.Errorf("%w: take at least one day", ErrInvalidVacation)
+	}
+
+	now := time.Now()
+	var vacation *models.Vacation
+	err := gs.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if vacation, err = loadVacation(tx, userID, now); err != nil {
+			return err
+		}
+		if days > vacation.DaysLeft {
+			return ErrNotEnoughVacation
+		}
+
+		start := now
+		if vacation.OnVacation(now) {
+			start = *vacation.Until
+		}
+		until := start.Add(time.Duration(days) * 24 * time.Hour)
+		vacation.Until = &until
+		vacation.DaysLeft -= days
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(vacation).Error; err != nil {
+			return fmt.Errorf("failed to save vacation: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var gameIDs []uuid.UUID
+	err = gs.db.Model(&models.Game{}).
+		Where("status = ? AND days_per_move > 0", models.GameStatusActive).
+		Where("(current_turn = ? AND white_player_id = ?) OR (current_turn = ? AND black_player_id = ?)", "white", userID, "black", userID).
+		Pluck("id", &gameIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load correspondence games: %w", err)
+	}
+	by := time.Duration(days) * 24 * time.Hour
+	for _, gameID := range gameIDs {
+		if result := gs.dispatch(gameID, gameCommand{kind: commandPutBackDeadline, playerID: userID, by: by}); result.err != nil {
+			log.Printf("Error putting back the deadline of game %s: %v", gameID, result.err)
+		}
+	}
+	return vacation, nil
+}
+
+// ConditionalMoves returns the lines userID has queued in a game.
+func (gs *GameService) ConditionalMoves(gameID, userID uuid.UUID) ([]models.ConditionalMove, error) {
+	lines := []models.ConditionalMove{}
+	err := gs.db.Where("game_id = ? AND player_id = ?", gameID, userID).
+		Order("created_at ASC").
+		Find(&lines).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load conditional moves: %w", err)
+	}
+	return lines, nil
+}
+
+// SetConditionalMoves replaces the lines userID has queued in a
+// correspondence game with lines, each the opponent's next move followed by
+// the reply to it, and so on. The lines must be legal from the current
+// position, and it must be the opponent's move.
+func (gs *GameService) SetConditionalMoves(gameID, userID uuid.UUID, lines [][]string) ([]models.ConditionalMove, error) {
+	if len(lines) > maxConditionalLines {
+		return nil, fmt.Errorf("%w: at most %d lines", ErrInvalidConditionalMoves, maxConditionalLines)
+	}
+
+	var game models.Game
+	if err := gs.db.Take(&game, "id = ?", gameID).Error; err != nil {
+		return nil, lookupError(err)
+	}
+	if game.DaysPerMove == 0 {
+		return nil, ErrNotCorrespondence
+	}
+	if err := checkParticipant(&game, userID); err != nil {
+		return nil, err
+	}
+	if isPlayerTurn(&game, userID) {
+		return nil, fmt.Errorf("%w: it's your move", ErrInvalidConditionalMoves)
+	}
+
+	conditional := make([]models.ConditionalMove, 0, len(lines))
+	for i, line := range lines {
+		if err := checkConditionalLine(game.BoardState, line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		conditional = append(conditional, models.ConditionalMove{
+			GameID:   gameID,
+			PlayerID: userID,
+			Ply:      game.MoveCount,
+			Moves:    line,
+		})
+	}
+
+	err := gs.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.ConditionalMove{}, "game_id = ? AND player_id = ?", gameID, userID).Error; err != nil {
+			return fmt.Errorf("failed to remove conditional moves: %w", err)
+		}
+		if len(conditional) == 0 {
+			return nil
+		}
+		if err := tx.Create(&conditional).Error; err != nil {
+			return fmt.Errorf("failed to save conditional moves: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conditional, nil
+}
+
+// moveDeadline returns when the side to move in a correspondence game
+// forfeits, counting its days per move from now or from the end of the
+// vacation they're on. Other games have no deadline.
+func (gs *GameService) moveDeadline(game *models.Game, now time.Time) *time.Time {
+	if game.DaysPerMove == 0 {
+		return nil
+	}
+
+	start := now
+	if player := playerToMove(game); player != nil {
+		var vacation models.Vacation
+		err := gs.db.Take(&vacation, "user_id = ?", *player).Error
+		switch {
+		case err == nil && vacation.OnVacation(now):
+			start = *vacation.Until
+		case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+			log.Printf("Error loading the vacation of %s: %v", *player, err)
+		}
+	}
+	deadline := start.Add(time.Duration(game.DaysPerMove) * 24 * time.Hour)
+	return &deadline
+}
+
+// putBackDeadline gives playerID more time for their move, if it's theirs.
+func (a *gameActor) putBackDeadline(playerID uuid.UUID, by time.Duration) commandResult {
+	game := *a.game
+	if game.Status != models.GameStatusActive || game.MoveDeadline == nil || !isPlayerTurn(&game, playerID) {
+		return commandResult{game: a.snapshot()}
+	}
+
+	now := time.Now()
+	deadline := game.MoveDeadline.Add(by)
+	game.MoveDeadline = &deadline
+	event := newGameEvent(models.GameEventDeadlineMoved, &playerID, deadlinePayload{Deadline: deadline})
+	if err := a.service.record(&game, event, nil, now); err != nil {
+		return commandResult{err: err}
+	}
+
+	a.commit(&game)
+	a.service.publishGameUpdate(game.ID, "deadline_moved", map[string]interface{}{
+		"player_id":     playerID,
+		"move_deadline": deadline,
+	})
+
+	return commandResult{game: a.snapshot()}
+}
+
+// playConditional answers move with the reply its opponent queued for it,
+// if they did. Lines that move doesn't follow are dropped, and the rest are
+// advanced past the reply.
+func (a *gameActor) playConditional(move *models.GameMove) {
+	player := playerToMove(a.game)
+	if player == nil {
+		return
+	}
+
+	var lines []models.ConditionalMove
+	err := a.service.db.Where("game_id = ? AND player_id = ?", a.gameID, *player).
+		Order("created_at ASC").
+		Find(&lines).Error
+	if err != nil {
+		log.Printf("Error loading conditional moves of game %s: %v", a.gameID, err)
+		return
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	played := move.FromSquare + move.ToSquare
+	var reply string
+	var stale []uuid.UUID
+	var advanced []models.ConditionalMove
+	for _, line := range lines {
+		// The first line followed decides the reply
+		if line.Ply+1 != move.MoveNumber || len(line.Moves) < 2 || line.Moves[0] != played ||
+			(reply != "" && line.Moves[1] != reply) {
+			stale = append(stale, line.ID)
+			continue
+		}
+		reply = line.Moves[1]
+		if len(line.Moves) == 2 {
+			stale = append(stale, line.ID)
+			continue
+		}
+		line.Moves = line.Moves[2:]
+		line.Ply += 2
+		advanced = append(advanced, line)
+	}
+
+	err = a.service.db.Transaction(func(tx *gorm.DB) error {
+		if len(stale) > 0 {
+			if err := tx.Delete(&models.ConditionalMove{}, "id IN ?", stale).Error; err != nil {
+				return err
+			}
+		}
+		for _, line := range advanced {
+			err := tx.Model(&models.ConditionalMove{}).Where("id = ?", line.ID).
+				Updates(map[string]interface{}{"moves": line.Moves, "ply": line.Ply}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error updating conditional moves of game %s: %v", a.gameID, err)
+		return
+	}
+
+	if reply == "" {
+		return
+	}
+	if result := a.move(*player, reply[:2], reply[2:], ""); result.err != nil {
+		log.Printf("Error playing conditional move %s in game %s: %v", reply, a.gameID, result.err)
+	}
+}
+
+// checkConditionalLine checks that line alternates the opponent's moves and
+// replies, legally, from the position fen.
+func checkConditionalLine(fen string, line []string) error {
+	if len(line) < 2 || len(line)%2 != 0 || len(line) > maxConditionalPlies {
+		return fmt.Errorf("%w: a line pairs each of the opponent's moves with a reply, up to %d moves", ErrInvalidConditionalMoves, maxConditionalPlies)
+	}
+	for _, coordinates := range line {
+		if !coordinateMovePattern.MatchString(coordinates) {
+			return fmt.Errorf("%w: %q is not a move such as e2e4", ErrInvalidConditionalMoves, coordinates)
+		}
+		move, err := chess.NewEngine(fen).ValidateMove(coordinates[:2], coordinates[2:])
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidConditionalMoves, coordinates, err)
+		}
+		fen = move.FENAfter
+	}
+	return nil
+}
+
+// loadVacation returns userID's vacation, with this year's allowance if it
+// hasn't been touched since last year.
+func loadVacation(db *gorm.DB, userID uuid.UUID, now time.Time) (*models.Vacation, error) {
+	var vacation models.Vacation
+	err := db.Take(&vacation, "user_id = ?", userID).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		vacation = models.Vacation{UserID: userID}
+	case err != nil:
+		return nil, fmt.Errorf("failed to load vacation: %w", err)
+	}
+	if vacation.Year != now.Year() {
+		vacation.Year = now.Year()
+		vacation.DaysLeft = vacationDaysPerYear
+	}
+	return &vacation, nil
+}
+
+// playerToMove returns the ID of the player whose move it is, if seated.
+func playerToMove(game *models.Game) *uuid.UUID {
+	if game.CurrentTurn == "black" {
+		return game.BlackPlayerID
+	}
+	return game.WhitePlayerID
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Correspondence deadlines are days away, so no timer waits for them. Every
+// instance runs a scheduler instead, which regularly forfeits the games whose
+// deadline has passed and reminds players whose deadline is getting close.
+// Forfeits go through the game's actor as clock checks, so instances that
+// find the same game settle it once, and a Redis key makes sure a reminder is
+// sent once per move.
+
+const (
+	correspondenceReminder = 24 * time.Hour // or a quarter of the time per move, if that's less
+	correspondenceBatch    = 100            // games forfeited per check
+)
+
+type CorrespondenceScheduler struct {
+	games    *GameService
+	hub      *Hub
+	interval time.Duration
+}
+
+// NewCorrespondenceScheduler checks deadlines every interval and sends
+// reminders through hub.
+func NewCorrespondenceScheduler(games *GameService, hub *Hub, interval time.Duration) *CorrespondenceScheduler {
+	return &CorrespondenceScheduler{games: games, hub: hub, interval: interval}
+}
+
+// Run checks the deadlines straight away and then every interval, until ctx
+// is done.
+func (cs *CorrespondenceScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(cs.interval)
+	defer ticker.Stop()
+
+	for {
+		cs.check(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cs *CorrespondenceScheduler) check(ctx context.Context, now time.Time) {
+	if err := cs.forfeit(now); err != nil {
+		log.Printf("Error forfeiting correspondence games: %v", err)
+	}
+	if err := cs.remind(ctx, now); err != nil {
+		log.Printf("Error sending correspondence reminders: %v", err)
+	}
+}
+
+// forfeit ends the games whose side to move let the deadline pass.
+func (cs *CorrespondenceScheduler) forfeit(now time.Time) error {
+	var gameIDs []uuid.UUID
+	err := cs.games.db.Model(&models.Game{}).
+		Where("status = ? AND days_per_move > 0 AND move_deadline <= ?", models.GameStatusActive, now).
+		Order("move_deadline ASC").
+		Limit(correspondenceBatch).
+		Pluck("id", &gameIDs).Error
+	if err != nil {
+		return fmt.Errorf("failed to load overdue games: %w", err)
+	}
+
+	for _, gameID := range gameIDs {
+		if result := cs.games.dispatch(gameID, gameCommand{kind: commandClockCheck}); result.err != nil {
+			log.Printf("Error checking the deadline of game %s: %v", gameID, result.err)
+		}
+	}
+	return nil
+}
+
+// remind tells the players whose deadline is close that a game is waiting
+// for their move.
+func (cs *CorrespondenceScheduler) remind(ctx context.Context, now time.Time) error {
+	var games []models.Game
+	err := cs.games.db.
+		Where("status = ? AND days_per_move > 0 AND move_deadline > ? AND move_deadline <= ?",
+			models.GameStatusActive, now, now.Add(correspondenceReminder)).
+		Find(&games).Error
+	if err != nil {
+		return fmt.Errorf("failed to load games due soon: %w", err)
+	}
+
+	for _, game := range games {
+		left := game.MoveDeadline.Sub(now)
+		player := playerToMove(&game)
+		if player == nil || left > min(correspondenceReminder, time.Duration(game.DaysPerMove)*24*time.Hour/4) {
+			continue
+		}
+
+		key := fmt.Sprintf("game:%s:reminder:%d", game.ID, game.MoveCount)
+		first, err := cs.games.redis.SetNX(ctx, key, player.String(), left+time.Hour).Result()
+		if err != nil {
+			return fmt.Errorf("failed to record reminder: %w", err)
+		}
+		if !first {
+			continue
+		}
+		cs.hub.SendToUser(player.String(), Message{
+			Type: "correspondence_reminder",
+			Data: map[string]interface{}{
+				"game_id":       game.ID,
+				"move_deadline": game.MoveDeadline,
+			},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGameService_CreateGame_InvalidDaysPerMove(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+
+	for _, days := range []int{-1, maxDaysPerMove + 1} {
+		game, err := gameService.CreateGame(uuid.New(), uuid.New(), GameOptions{DaysPerMove: days})
+		assert.ErrorIs(t, err, ErrInvalidDaysPerMove)
+		assert.Nil(t, game)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckConditionalLine(t *testing.T) {
+	// Black's conditional lines start from the position after 1.e4
+	afterE4 := "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"
+
+	assert.NoError(t, checkConditionalLine(startingFEN, []string{"e2e4", "e7e5"}))
+	assert.NoError(t, checkConditionalLine(afterE4, []string{"e7e5", "g1f3", "b8c6", "f1b5"}))
+
+	for _, line := range [][]string{
+		{"e2e4"},                 // no reply
+		{"e2e4", "e7e5", "g1f3"}, // odd length
+		{"e2e4", "e5"},           // not coordinates
+		{"e2e5", "e7e5"},         // illegal
+		{"e2e4", "e2e3"},         // reply with the wrong side
+	} {
+		assert.ErrorIs(t, checkConditionalLine(startingFEN, line), ErrInvalidConditionalMoves, "%v", line)
+	}
+}
+
+func TestGameService_SetConditionalMoves_Rejected(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	gameID, white, black := uuid.New(), uuid.New(), uuid.New()
+	expectGame := func(daysPerMove int) {
+		mock.ExpectQuery(`SELECT \* FROM "games" WHERE id = \$1 LIMIT 1`).
+			WithArgs(gameID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "white_player_id", "black_player_id", "status", "current_turn", "board_state", "days_per_move"}).
+				AddRow(gameID, white, black, models.GameStatusActive, "white", startingFEN, daysPerMove))
+	}
+	line := [][]string{{"e2e4", "e7e5"}}
+
+	expectGame(0)
+	_, err := gameService.SetConditionalMoves(gameID, black, line)
+	assert.ErrorIs(t, err, ErrNotCorrespondence)
+
+	// Conditional moves wait for the opponent's move
+	expectGame(3)
+	_, err = gameService.SetConditionalMoves(gameID, white, line)
+	assert.ErrorIs(t, err, ErrInvalidConditionalMoves)
+
+	expectGame(3)
+	_, err = gameService.SetConditionalMoves(gameID, uuid.New(), line)
+	assert.ErrorIs(t, err, ErrNotGamePlayer)
+
+	expectGame(3)
+	_, err = gameService.SetConditionalMoves(gameID, black, [][]string{{"e2e4", "e7e5"}, {"d2d4", "d2d4"}})
+	assert.ErrorIs(t, err, ErrInvalidConditionalMoves)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_TakeVacation(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	userID := uuid.New()
+	year := time.Now().Year()
+
+	// Three days already taken this year leave too few for thirty
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "vacations" WHERE user_id = \$1 LIMIT 1`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "year", "days_left"}).AddRow(userID, year, vacationDaysPerYear-3))
+	mock.ExpectRollback()
+
+	_, err := gameService.TakeVacation(userID, vacationDaysPerYear)
+	assert.ErrorIs(t, err, ErrNotEnoughVacation)
+
+	// Last year's allowance is replaced by this year's
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "vacations" WHERE user_id = \$1 LIMIT 1`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "year", "days_left"}).AddRow(userID, year-1, 0))
+	mock.ExpectExec(`INSERT INTO "vacations" .* ON CONFLICT \("user_id"\) DO UPDATE`).
+		WithArgs(userID, year, vacationDaysPerYear-5, testutil.AnyTime{}, testutil.AnyTime{}, testutil.AnyTime{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT "id" FROM "games" WHERE \(status = \$1 AND days_per_move > 0\) AND \(\(current_turn = \$2 AND white_player_id = \$3\) OR`).
+		WithArgs(models.GameStatusActive, "white", userID, "black", userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	vacation, err := gameService.TakeVacation(userID, 5)
+	require.NoError(t, err)
+	assert.Equal(t, vacationDaysPerYear-5, vacation.DaysLeft)
+	require.NotNil(t, vacation.Until)
+	assert.WithinDuration(t, time.Now().Add(5*24*time.Hour), *vacation.Until, time.Minute)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClockRemaining_Correspondence(t *testing.T) {
+	game := activeGame(uuid.New(), uuid.New())
+	game.CurrentTurn = "white"
+	game.DaysPerMove = 3
+	game.WhiteTime, game.BlackTime = 3*secondsPerDay, 3*secondsPerDay
+
+	_, running := clockRemaining(&game, time.Now())
+	assert.False(t, running)
+
+	deadline := time.Now().Add(2 * time.Hour)
+	game.MoveDeadline = &deadline
+	timeLeft, running := clockRemaining(&game, time.Now())
+	assert.True(t, running)
+	assert.InDelta(t, 2*60*60, timeLeft, 2)
+}
+
+func TestCorrespondenceScheduler_ForfeitsOverdueGames(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	white, black := uuid.New(), uuid.New()
+	game := cacheActiveGame(t, redisClient, white, black)
+	deadline := time.Now().Add(-time.Minute)
+	game.DaysPerMove = 1
+	game.MoveDeadline = &deadline
+	gameJSON, _ := json.Marshal(game)
+	redisClient.Set(context.Background(), fmt.Sprintf("game:%s", game.ID), string(gameJSON), time.Hour)
+
+	mock.ExpectQuery(`SELECT "id" FROM "games" WHERE status = \$1 AND days_per_move > 0 AND move_deadline <= \$2 ORDER BY move_deadline ASC LIMIT 100`).
+		WithArgs(models.GameStatusActive, testutil.AnyTime{}).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(game.ID))
+	expectGameOverUpdate(mock, game.ID, models.GameResultBlackWins, models.GameEventClockExpired, 0)
+
+	scheduler := NewCorrespondenceScheduler(gameService, NewHub(), time.Minute)
+	require.NoError(t, scheduler.forfeit(time.Now()))
+
+	cached, err := gameService.getGameFromCache(game.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.GameStatusFinished, cached.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCorrespondenceScheduler_RemindsOnce(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	hub := NewHub()
+	go hub.Run()
+	gameService := NewGameService(db, redisClient)
+	white, black := uuid.New(), uuid.New()
+	whiteClient := connectTestClient(t, hub, white.String())
+	blackClient := connectTestClient(t, hub, black.String())
+
+	now := time.Now()
+	soonID, laterID := uuid.New(), uuid.New()
+	dueSoon, dueLater := now.Add(3*time.Hour), now.Add(20*time.Hour)
+	expectGamesDue := func() {
+		mock.ExpectQuery(`SELECT \* FROM "games" WHERE status = \$1 AND days_per_move > 0 AND move_deadline > \$2 AND move_deadline <= \$3`).
+			WithArgs(models.GameStatusActive, testutil.AnyTime{}, testutil.AnyTime{}).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "white_player_id", "black_player_id", "current_turn", "move_count", "days_per_move", "move_deadline"}).
+				AddRow(soonID, white, black, "white", 4, 3, dueSoon).
+				// A quarter of one day per move is six hours, so this can wait
+				AddRow(laterID, white, black, "black", 5, 1, dueLater))
+	}
+
+	scheduler := NewCorrespondenceScheduler(gameService, hub, time.Minute)
+	expectGamesDue()
+	require.NoError(t, scheduler.remind(context.Background(), now))
+
+	message := expectMessage(t, whiteClient, "correspondence_reminder")
+	assert.Equal(t, soonID.String(), message.Data.(map[string]interface{})["game_id"])
+	expectNoMessage(t, blackClient)
+
+	// The next check doesn't repeat the reminder for the same move
+	expectGamesDue()
+	require.NoError(t, scheduler.remind(context.Background(), now))
+	expectNoMessage(t, whiteClient)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Every write to a game appends one event in the same transaction as the
+// projection update, and the event's sequence is the version the write gives
+// the game. The log therefore has no gaps, and replaying it up to a sequence
+// yields the games row as it was at that version.
+
+var ErrNoEventLog = errors.New("game has no event log")
+
+type createdPayload struct {
+	ArenaID       uuid.UUID          `json:"arena_id"`
+	Variant       models.GameVariant `json:"variant"`
+	WhitePlayerID *uuid.UUID         `json:"white_player_id"`
+	TimeControl   int                `json:"time_control"`
+	DaysPerMove   int                `json:"days_per_move,omitempty"`
+	VoteWindow    int                `json:"vote_window,omitempty"`
+	UntimedSide   string             `json:"untimed_side,omitempty"`
+	SimulID       *uuid.UUID         `json:"simul_id,omitempty"`
+	BoardState    string             `json:"board_state"`
+
+	BlackTimeControl int             `json:"black_time_control,omitempty"`
+	WhiteIncrement   int             `json:"white_increment,omitempty"`
+	BlackIncrement   int             `json:"black_increment,omitempty"`
+	Handicap         models.Handicap `json:"handicap,omitempty"`
+	HandicapSide     string          `json:"handicap_side,omitempty"`
+	Casual           bool            `json:"casual,omitempty"`
+}
+
+type movedPayload struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Drop     string `json:"drop,omitempty"` // piece type for crazyhouse drops
+	Notation string `json:"notation"`
+	TimeLeft int    `json:"time_left"`
+	// Adjudicated is the result a tablebase gave the position after the
+	// move, which ended the game
+	Adjudicated *models.GameResult `json:"adjudicated,omitempty"`
+}
+
+type EventStore struct {
+	db *gorm.DB
+}
+
+func NewEventStore(db *gorm.DB) *EventStore {
+	return &EventStore{db: db}
+}
+
+// Events returns a game's event log in order.
+func (s *EventStore) Events(gameID uuid.UUID) ([]models.GameEvent, error) {
+	var events []models.GameEvent
+	if err := s.db.Where("game_id = ?", gameID).Order("sequence ASC").Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("failed to load game events: %w", err)
+	}
+	return events, nil
+}
+
+// Rebuild replays a game's log up to ply half-moves, or entirely when ply is
+// negative.
+func (s *EventStore) Rebuild(gameID uuid.UUID, ply int) (*GameReplay, error) {
+	events, err := s.Events(gameID)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, ErrNoEventLog
+	}
+	return ReplayEvents(events, ply)
+}
+
+// Verify replays a game's whole log and lists every field in which the
+// stored game differs from the replay.
+func (s *EventStore) Verify(game *models.Game) ([]string, error) {
+	replay, err := s.Rebuild(game.ID, -1)
+	if err != nil {
+		return nil, err
+	}
+	return diffGames(game, replay.Game), nil
+}
+
+func newGameEvent(eventType models.GameEventType, playerID *uuid.UUID, payload interface{}) *models.GameEvent {
+	return &models.GameEvent{
+		Type:     eventType,
+		PlayerID: playerID,
+		Payload:  encodePayload(payload),
+	}
+}
+
+func encodePayload(payload interface{}) string {
+	if payload == nil {
+		return "{}"
+	}
+	data, _ := json.Marshal(payload)
+	return string(data)
+}
+
+// appendEvent stores event as the entry for the game's current version.
+func appendEvent(tx *gorm.DB, game *models.Game, event *models.GameEvent, now time.Time) error {
+	event.GameID = game.ID
+	event.Sequence = game.Version
+	event.CreatedAt = now
+	if err := tx.Create(event).Error; err != nil {
+		return fmt.Errorf("failed to append game event: %w", err)
+	}
+	return nil
+}
+
+// GameReplay is a game rebuilt from its event log, with an engine holding the
+// final position.
+type GameReplay struct {
+	Game   *models.Game
+	Engine *chess.Engine
+	Ply    int
+}
+
+// ReplayEvents folds events into a game, stopping after ply half-moves when
+// ply is not negative.
+func ReplayEvents(events []models.GameEvent, ply int) (*GameReplay, error) {
+	replay := &GameReplay{}
+
+	for i := range events {
+		event := &events[i]
+		if ply >= 0 && event.Type == models.GameEventMoved && replay.Ply == ply {
+			break
+		}
+
+		if i == 0 {
+			if event.Type != models.GameEventCreated {
+				return nil, fmt.Errorf("event log starts with %s instead of created", event.Type)
+			}
+		} else if event.Sequence != replay.Game.Version+1 {
+			return nil, fmt.Errorf("event log skips from sequence %d to %d", replay.Game.Version, event.Sequence)
+		}
+
+		if err := replay.apply(event); err != nil {
+			return nil, fmt.Errorf("event %d (%s): %w", event.Sequence, event.Type, err)
+		}
+
+		replay.Game.Version = event.Sequence
+		replay.Game.UpdatedAt = event.CreatedAt
+	}
+
+	if replay.Game == nil {
+		return nil, ErrNoEventLog
+	}
+	return replay, nil
+}
+
+func (r *GameReplay) apply(event *models.GameEvent) error {
+	if r.Game == nil && event.Type != models.GameEventCreated {
+		return errors.New("game was not created")
+	}
+	at := event.CreatedAt
+
+	switch event.Type {
+	case models.GameEventCreated:
+		var payload createdPayload
+		if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+			return fmt.Errorf("bad payload: %w", err)
+		}
+		r.Game = &models.Game{
+			ID:            event.GameID,
+			ArenaID:       payload.ArenaID,
+			Variant:       payload.Variant,
+			WhitePlayerID: payload.WhitePlayerID,
+			Status:        models.GameStatusWaiting,
+			CurrentTurn:   sideToMove(payload.BoardState),
+			BoardState:    payload.BoardState,
+			TimeControl:   payload.TimeControl,
+			DaysPerMove:   payload.DaysPerMove,
+			VoteWindow:    payload.VoteWindow,
+			UntimedSide:   payload.UntimedSide,
+			SimulID:       payload.SimulID,
+			WhiteTime:     payload.TimeControl,
+			BlackTime:     payload.TimeControl,
+			CreatedAt:     at,
+
+			BlackTimeControl: payload.BlackTimeControl,
+			WhiteIncrement:   payload.WhiteIncrement,
+			BlackIncrement:   payload.BlackIncrement,
+			Handicap:         payload.Handicap,
+			HandicapSide:     payload.HandicapSide,
+			Casual:           payload.Casual,
+		}
+		if payload.BlackTimeControl > 0 {
+			r.Game.BlackTime = payload.BlackTimeControl
+		}
+		r.Engine = chess.NewEngine(payload.BoardState)
+
+	case models.GameEventJoined:
+		r.Game.BlackPlayerID = event.PlayerID
+		r.Game.Status = models.GameStatusActive
+		r.Game.StartedAt = &at
+		r.Game.LastMoveAt = &at
+
+	case models.GameEventMoved:
+		var payload movedPayload
+		if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+			return fmt.Errorf("bad payload: %w", err)
+		}
+		var move *chess.Move
+		var err error
+		label := payload.From + payload.To
+		if payload.Drop != "" {
+			label = payload.Drop + "@" + payload.To
+			move, err = r.Engine.ValidateDrop(payload.Drop, payload.To)
+		} else {
+			move, err = r.Engine.ValidateMove(payload.From, payload.To)
+		}
+		if err != nil {
+			return fmt.Errorf("illegal move %s: %w", label, err)
+		}
+
+		setClock(r.Game, r.Game.CurrentTurn, payload.TimeLeft)
+		r.Game.BoardState = move.FENAfter
+		classifyOpening(r.Game)
+		r.Game.MoveCount++
+		r.Game.CurrentTurn = getOpponentColor(r.Game.CurrentTurn)
+		r.Game.LastMoveAt = &at
+		r.Ply++
+
+		if move.IsCheckmate {
+			finishGame(r.Game, winnerResult(getOpponentColor(r.Game.CurrentTurn)), at)
+		} else if move.IsStalemate {
+			finishGame(r.Game, models.GameResultDraw, at)
+		} else if payload.Adjudicated != nil {
+			finishGame(r.Game, *payload.Adjudicated, at)
+		}
+
+	case models.GameEventClockExpired:
+		setClock(r.Game, r.Game.CurrentTurn, 0)
+		finishGame(r.Game, winnerResult(getOpponentColor(r.Game.CurrentTurn)), at)
+
+	case models.GameEventDrawAccepted:
+		finishGame(r.Game, models.GameResultDraw, at)
+
+	case models.GameEventResigned:
+		if event.PlayerID == nil {
+			return errors.New("resignation without a player")
+		}
+		color := playerColor(r.Game, *event.PlayerID)
+		if color == "" {
+			return ErrNotGamePlayer
+		}
+		finishGame(r.Game, winnerResult(getOpponentColor(color)), at)
+
+	case models.GameEventDrawOffered, models.GameEventDrawDeclined:
+		// Offers only matter while they are pending, which Redis tracks
+
+	case models.GameEventSpellCast:
+		// Spells are recorded but the rules engine doesn't model them yet
+
+	case models.GameEventDeadlineMoved:
+		// Deadlines depend on vacations, which aren't part of the log
+
+	default:
+		return fmt.Errorf("unknown event type %q", event.Type)
+	}
+
+	return nil
+}
+
+// diffGames lists the projected fields in which stored differs from replayed.
+func diffGames(stored, replayed *models.Game) []string {
+	var diffs []string
+	check := func(field string, got, want interface{}) {
+		if got != want {
+			diffs = append(diffs, fmt.Sprintf("%s: stored %v, replayed %v", field, got, want))
+		}
+	}
+
+	check("board_state", stored.BoardState, replayed.BoardState)
+	check("current_turn", stored.CurrentTurn, replayed.CurrentTurn)
+	check("move_count", stored.MoveCount, replayed.MoveCount)
+	check("status", stored.Status, replayed.Status)
+	check("result", resultString(stored.Result), resultString(replayed.Result))
+	check("white_time", stored.WhiteTime, replayed.WhiteTime)
+	check("black_time", stored.BlackTime, replayed.BlackTime)
+	check("version", stored.Version, replayed.Version)
+
+	return diffs
+}
+
+func resultString(result *models.GameResult) string {
+	if result == nil {
+		return ""
+	}
+	return string(*result)
+}
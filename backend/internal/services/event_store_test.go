@@ -0,0 +1,237 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const startingFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+// eventLog builds a consistent log for one game.
+type eventLog struct {
+	gameID uuid.UUID
+	start  time.Time
+	events []models.GameEvent
+}
+
+func newEventLog(white uuid.UUID) *eventLog {
+	log := &eventLog{gameID: uuid.New(), start: time.Now().Add(-time.Hour)}
+	log.add(models.GameEventCreated, &white, createdPayload{
+		ArenaID:       uuid.New(),
+		WhitePlayerID: &white,
+		TimeControl:   600,
+		BoardState:    startingFEN,
+	})
+	return log
+}
+
+func (l *eventLog) add(eventType models.GameEventType, playerID *uuid.UUID, payload interface{}) *eventLog {
+	sequence := len(l.events)
+	l.events = append(l.events, models.GameEvent{
+		ID:        uuid.New(),
+		GameID:    l.gameID,
+		Sequence:  sequence,
+		Type:      eventType,
+		PlayerID:  playerID,
+		Payload:   encodePayload(payload),
+		CreatedAt: l.start.Add(time.Duration(sequence) * time.Second),
+	})
+	return l
+}
+
+func (l *eventLog) move(playerID uuid.UUID, from, to string, timeLeft int) *eventLog {
+	return l.add(models.GameEventMoved, &playerID, movedPayload{From: from, To: to, TimeLeft: timeLeft})
+}
+
+func TestReplayEvents_FullGame(t *testing.T) {
+	white, black := uuid.New(), uuid.New()
+	log := newEventLog(white).
+		add(models.GameEventJoined, &black, nil).
+		move(white, "f2", "f3", 598).
+		move(black, "e7", "e5", 597).
+		add(models.GameEventDrawOffered, &white, nil).
+		add(models.GameEventDrawDeclined, &black, nil).
+		move(white, "g2", "g4", 590).
+		move(black, "d8", "h4", 595)
+
+	replay, err := ReplayEvents(log.events, -1)
+
+	require.NoError(t, err)
+	game := replay.Game
+	assert.Equal(t, log.gameID, game.ID)
+	assert.Equal(t, "rnb1kbnr/pppp1ppp/8/4p3/6Pq/5P2/PPPPP2P/RNBQKBNR w KQkq - 1 3", game.BoardState)
+	assert.Equal(t, 4, game.MoveCount)
+	assert.Equal(t, 4, replay.Ply)
+	assert.Equal(t, models.GameStatusFinished, game.Status)
+	assert.Equal(t, models.GameResultBlackWins, *game.Result)
+	assert.Equal(t, 590, game.WhiteTime)
+	assert.Equal(t, 595, game.BlackTime)
+	assert.Equal(t, 7, game.Version)
+	assert.Equal(t, &black, game.BlackPlayerID)
+}
+
+func TestReplayEvents_StopsAtPly(t *testing.T) {
+	white, black := uuid.New(), uuid.New()
+	log := newEventLog(white).
+		add(models.GameEventJoined, &black, nil).
+		move(white, "e2", "e4", 598).
+		move(black, "e7", "e5", 597).
+		move(white, "g1", "f3", 590)
+
+	replay, err := ReplayEvents(log.events, 1)
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, replay.Ply)
+	assert.Equal(t, "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", replay.Game.BoardState)
+	assert.Equal(t, "black", replay.Game.CurrentTurn)
+	assert.Equal(t, 2, replay.Game.Version)
+	assert.Equal(t, "B00", replay.Game.ECO)
+
+	replay, err = ReplayEvents(log.events, 0)
+
+	require.NoError(t, err)
+	assert.Equal(t, startingFEN, replay.Game.BoardState)
+	assert.Equal(t, models.GameStatusActive, replay.Game.Status)
+	assert.Empty(t, replay.Game.ECO)
+}
+
+func TestReplayEvents_Resignation(t *testing.T) {
+	white, black := uuid.New(), uuid.New()
+	log := newEventLog(white).
+		add(models.GameEventJoined, &black, nil).
+		add(models.GameEventResigned, &white, nil)
+
+	replay, err := ReplayEvents(log.events, -1)
+
+	require.NoError(t, err)
+	assert.Equal(t, models.GameResultBlackWins, *replay.Game.Result)
+}
+
+func TestReplayEvents_Adjudicated(t *testing.T) {
+	white, black := uuid.New(), uuid.New()
+	adjudicated := models.GameResultWhiteWins
+	log := &eventLog{gameID: uuid.New(), start: time.Now().Add(-time.Hour)}
+	log.add(models.GameEventCreated, &white, createdPayload{
+		WhitePlayerID: &white,
+		TimeControl:   600,
+		BoardState:    "6k1/8/5K2/8/8/8/8/7Q w - - 0 1",
+	}).
+		add(models.GameEventJoined, &black, nil).
+		add(models.GameEventMoved, &white, movedPayload{From: "h1", To: "h2", TimeLeft: 598, Adjudicated: &adjudicated})
+
+	replay, err := ReplayEvents(log.events, -1)
+
+	require.NoError(t, err)
+	assert.Equal(t, models.GameStatusFinished, replay.Game.Status)
+	assert.Equal(t, models.GameResultWhiteWins, *replay.Game.Result)
+}
+
+func TestReplayEvents_CrazyhouseDrop(t *testing.T) {
+	white, black := uuid.New(), uuid.New()
+	log := &eventLog{gameID: uuid.New(), start: time.Now().Add(-time.Hour)}
+	log.add(models.GameEventCreated, &white, createdPayload{
+		Variant:       models.GameVariantCrazyhouse,
+		WhitePlayerID: &white,
+		TimeControl:   600,
+		BoardState:    "rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR[] w KQkq d6 0 2",
+	}).
+		add(models.GameEventJoined, &black, nil).
+		move(white, "e4", "d5", 598).
+		move(black, "d8", "d5", 597).
+		add(models.GameEventMoved, &white, movedPayload{Drop: "P", To: "e4", TimeLeft: 590})
+
+	replay, err := ReplayEvents(log.events, -1)
+
+	require.NoError(t, err)
+	assert.Equal(t, models.GameVariantCrazyhouse, replay.Game.Variant)
+	assert.Equal(t, "rnb1kbnr/ppp1pppp/8/3q4/4P3/8/PPPP1PPP/RNBQKBNR[p] b KQkq - 0 3", replay.Game.BoardState)
+}
+
+func TestReplayEvents_RejectsGap(t *testing.T) {
+	white, black := uuid.New(), uuid.New()
+	log := newEventLog(white).
+		add(models.GameEventJoined, &black, nil).
+		move(white, "e2", "e4", 598)
+	log.events = append(log.events[:1], log.events[2:]...)
+
+	_, err := ReplayEvents(log.events, -1)
+
+	assert.EqualError(t, err, "event log skips from sequence 0 to 2")
+}
+
+func TestReplayEvents_RejectsIllegalMove(t *testing.T) {
+	white, black := uuid.New(), uuid.New()
+	log := newEventLog(white).
+		add(models.GameEventJoined, &black, nil).
+		move(white, "e2", "e5", 598)
+
+	_, err := ReplayEvents(log.events, -1)
+
+	assert.ErrorContains(t, err, "event 2 (moved): illegal move e2e5")
+}
+
+func TestEventStore_Verify(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	white, black := uuid.New(), uuid.New()
+	log := newEventLog(white).
+		add(models.GameEventJoined, &black, nil).
+		move(white, "e2", "e4", 598)
+
+	rows := sqlmock.NewRows([]string{"id", "game_id", "sequence", "type", "player_id", "payload", "created_at"})
+	for _, event := range log.events {
+		rows.AddRow(event.ID, event.GameID, event.Sequence, event.Type, event.PlayerID, event.Payload, event.CreatedAt)
+	}
+	mock.ExpectQuery(`SELECT \* FROM "game_events" WHERE game_id = \$1 ORDER BY sequence ASC`).
+		WithArgs(log.gameID).
+		WillReturnRows(rows)
+
+	// The stored row missed the move's board update
+	stored := &models.Game{
+		ID:          log.gameID,
+		Status:      models.GameStatusActive,
+		CurrentTurn: "black",
+		BoardState:  startingFEN,
+		MoveCount:   1,
+		WhiteTime:   598,
+		BlackTime:   600,
+		Version:     2,
+	}
+
+	diffs, err := NewEventStore(db).Verify(stored)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"board_state: stored " + startingFEN + ", replayed rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1",
+	}, diffs)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEventStore_VerifyWithoutLog(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	gameID := uuid.New()
+	mock.ExpectQuery(`SELECT \* FROM "game_events"`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err := NewEventStore(db).Verify(&models.Game{ID: gameID})
+
+	assert.ErrorIs(t, err, ErrNoEventLog)
+}
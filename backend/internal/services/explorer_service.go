@@ -0,0 +1,205 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/openings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The opening explorer counts, for every position in the first explorerMaxPly
+// half-moves of our finished standard games, which moves were played from it
+// and how those games ended. The counts are updated once per game when it
+// finishes rather than computed on request.
+
+const explorerMaxPly = 30
+
+var ErrInvalidFEN = errors.New("invalid FEN")
+
+type ExplorerService struct {
+	db *gorm.DB
+}
+
+// ExplorerMove is one move played from the explored position. Percentages are
+// of the games in which the move was played.
+type ExplorerMove struct {
+	UCI           string  `json:"uci"`
+	SAN           string  `json:"san"`
+	Games         int     `json:"games"`
+	WhitePercent  float64 `json:"white_percent"`
+	DrawPercent   float64 `json:"draw_percent"`
+	BlackPercent  float64 `json:"black_percent"`
+	AverageRating int     `json:"average_rating"`
+}
+
+type ExplorerResult struct {
+	FEN     string            `json:"fen"`
+	Opening *openings.Opening `json:"opening,omitempty"`
+	Games   int               `json:"games"`
+	Moves   []ExplorerMove    `json:"moves"`
+}
+
+func NewExplorerService(db *gorm.DB) *ExplorerService {
+	return &ExplorerService{db: db}
+}
+
+// Explore returns the moves played from fen in our games, most popular first.
+func (es *ExplorerService) Explore(fen string) (*ExplorerResult, error) {
+	if err := chess.ValidateFEN(fen); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFEN, err)
+	}
+
+	var entries []models.ExplorerEntry
+	err := es.db.Where("position_hash = ?", int64(chess.PositionHash(fen))).
+		Order("games DESC").
+		Find(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load explorer entries: %w", err)
+	}
+
+	result := &ExplorerResult{FEN: fen, Moves: make([]ExplorerMove, 0, len(entries))}
+	if opening, ok := openings.Lookup(fen); ok {
+		result.Opening = &opening
+	}
+	for _, entry := range entries {
+		result.Games += entry.Games
+		result.Moves = append(result.Moves, ExplorerMove{
+			UCI:           entry.Move,
+			SAN:           entry.Notation,
+			Games:         entry.Games,
+			WhitePercent:  percentage(entry.WhiteWins, entry.Games),
+			DrawPercent:   percentage(entry.Draws, entry.Games),
+			BlackPercent:  percentage(entry.BlackWins, entry.Games),
+			AverageRating: int(entry.RatingSum / int64(2*entry.Games)),
+		})
+	}
+
+	return result, nil
+}
+
+// RecordGame adds a finished game's opening moves to the explorer. A position
+// the game passed through more than once only counts the first time, with the
+// move played then, so a game adds at most one to a position's total.
+func (es *ExplorerService) RecordGame(game models.Game) error {
+	if game.Result == nil || *game.Result == models.GameResultAbandoned {
+		return nil
+	}
+	if game.Variant != models.GameVariantStandard && game.Variant != "" {
+		return nil
+	}
+	// Casual games, odds games among them, don't count towards ratings, and
+	// the explorer's statistics are rated play
+	if game.Casual {
+		return nil
+	}
+
+	var moves []models.GameMove
+	err := es.db.Where("game_id = ?", game.ID).
+		Order("move_number ASC").
+		Limit(explorerMaxPly).
+		Find(&moves).Error
+	if err != nil {
+		return fmt.Errorf("failed to load game moves: %w", err)
+	}
+	if len(moves) == 0 {
+		return nil
+	}
+
+	ratingSum, err := es.ratingSum(game)
+	if err != nil {
+		return err
+	}
+
+	var whiteWins, draws, blackWins int
+	switch *game.Result {
+	case models.GameResultWhiteWins:
+		whiteWins = 1
+	case models.GameResultDraw:
+		draws = 1
+	case models.GameResultBlackWins:
+		blackWins = 1
+	}
+
+	seen := make(map[int64]bool, len(moves))
+	entries := make([]models.ExplorerEntry, 0, len(moves))
+	fen := chess.StandardStartFEN
+	for _, move := range moves {
+		hash := int64(chess.PositionHash(fen))
+		fen = move.FENAfter
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		entries = append(entries, models.ExplorerEntry{
+			PositionHash: hash,
+			Move:         move.FromSquare + move.ToSquare,
+			Notation:     move.Notation,
+			Games:        1,
+			WhiteWins:    whiteWins,
+			Draws:        draws,
+			BlackWins:    blackWins,
+			RatingSum:    ratingSum,
+		})
+	}
+
+	err = es.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "position_hash"}, {Name: "move"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"games":      gorm.Expr("explorer_entries.games + excluded.games"),
+			"white_wins": gorm.Expr("explorer_entries.white_wins + excluded.white_wins"),
+			"draws":      gorm.Expr("explorer_entries.draws + excluded.draws"),
+			"black_wins": gorm.Expr("explorer_entries.black_wins + excluded.black_wins"),
+			"rating_sum": gorm.Expr("explorer_entries.rating_sum + excluded.rating_sum"),
+			"updated_at": gorm.Expr("excluded.updated_at"),
+		}),
+	}).Create(&entries).Error
+	if err != nil {
+		return fmt.Errorf("failed to update explorer entries: %w", err)
+	}
+
+	return nil
+}
+
+// RecordFinishedGame is RecordGame as a GameService.OnGameFinished hook.
+func (es *ExplorerService) RecordFinishedGame(game models.Game) {
+	if err := es.RecordGame(game); err != nil {
+		log.Printf("Error adding game %s to the opening explorer: %v", game.ID, err)
+	}
+}
+
+// ratingSum adds up the current ratings of both players.
+func (es *ExplorerService) ratingSum(game models.Game) (int64, error) {
+	var ids []uuid.UUID
+	for _, id := range []*uuid.UUID{game.WhitePlayerID, game.BlackPlayerID} {
+		if id != nil {
+			ids = append(ids, *id)
+		}
+	}
+
+	var sum int64
+	err := es.db.Model(&models.User{}).
+		Select("COALESCE(SUM(rating), 0)").
+		Where("id IN ?", ids).
+		Scan(&sum).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to load player ratings: %w", err)
+	}
+	return sum, nil
+}
+
+// percentage returns part as a percentage of total, to one decimal place.
+func percentage(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(part)*1000/float64(total)) / 10
+}
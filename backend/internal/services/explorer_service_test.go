@@ -0,0 +1,144 @@
+package services
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplorerService_Explore(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	fen := "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"
+	mock.ExpectQuery(`SELECT \* FROM "explorer_entries" WHERE position_hash = \$1 ORDER BY games DESC`).
+		WithArgs(int64(chess.PositionHash(fen))).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"position_hash", "move", "notation", "games", "white_wins", "draws", "black_wins", "rating_sum",
+		}).
+			AddRow(1, "c7c5", "c5", 3, 1, 1, 1, 9000).
+			AddRow(1, "e7e5", "e5", 1, 1, 0, 0, 2400))
+
+	result, err := NewExplorerService(db).Explore(fen)
+
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.Games)
+	assert.Equal(t, "B00", result.Opening.ECO)
+	require.Len(t, result.Moves, 2)
+	assert.Equal(t, ExplorerMove{
+		UCI:           "c7c5",
+		SAN:           "c5",
+		Games:         3,
+		WhitePercent:  33.3,
+		DrawPercent:   33.3,
+		BlackPercent:  33.3,
+		AverageRating: 1500,
+	}, result.Moves[0])
+	assert.Equal(t, 100.0, result.Moves[1].WhitePercent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExplorerService_Explore_InvalidFEN(t *testing.T) {
+	db, _ := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	_, err := NewExplorerService(db).Explore("not a position")
+
+	assert.ErrorIs(t, err, ErrInvalidFEN)
+}
+
+func TestExplorerService_RecordGame(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	white, black := uuid.New(), uuid.New()
+	result := models.GameResultWhiteWins
+	game := models.Game{
+		ID:            uuid.New(),
+		Variant:       models.GameVariantStandard,
+		WhitePlayerID: &white,
+		BlackPlayerID: &black,
+		Status:        models.GameStatusFinished,
+		Result:        &result,
+	}
+
+	// The knights go out and come back, and 1.e4 is played from the starting
+	// position the second time
+	moves := [][2]string{{"g1", "f3"}, {"g8", "f6"}, {"f3", "g1"}, {"f6", "g8"}, {"e2", "e4"}}
+	rows := sqlmock.NewRows([]string{"game_id", "move_number", "from_square", "to_square", "notation", "fen_after"})
+	fen := chess.StandardStartFEN
+	var positions []string
+	for i, move := range moves {
+		positions = append(positions, fen)
+		played, err := chess.NewEngine(fen).ValidateMove(move[0], move[1])
+		require.NoError(t, err)
+		fen = played.FENAfter
+		rows.AddRow(game.ID, i+1, move[0], move[1], played.Notation, fen)
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM "game_moves" WHERE game_id = \$1 ORDER BY move_number ASC LIMIT 30`).
+		WithArgs(game.ID).
+		WillReturnRows(rows)
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(rating\), 0\) FROM "users" WHERE id IN \(\$1,\$2\)`).
+		WithArgs(white, black).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(3100))
+
+	// The game counts once for the starting position, with 1.Nf3
+	var args []driver.Value
+	for i, move := range moves[:4] {
+		args = append(args,
+			int64(chess.PositionHash(positions[i])), move[0]+move[1], sqlmock.AnyArg(),
+			1, 1, 0, 0, int64(3100), testutil.AnyTime{})
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "explorer_entries" .* ON CONFLICT \("position_hash","move"\) DO UPDATE SET .*"games"=explorer_entries.games \+ excluded.games`).
+		WithArgs(args...).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectCommit()
+
+	err := NewExplorerService(db).RecordGame(game)
+
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExplorerService_RecordGame_SkipsOtherVariants(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	result := models.GameResultDraw
+	now := time.Now()
+	game := models.Game{
+		ID:         uuid.New(),
+		Variant:    models.GameVariantCrazyhouse,
+		Status:     models.GameStatusFinished,
+		Result:     &result,
+		FinishedAt: &now,
+	}
+
+	err := NewExplorerService(db).RecordGame(game)
+
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
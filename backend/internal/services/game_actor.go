@@ -0,0 +1,786 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Every game that receives commands on this instance is owned by one actor
+// goroutine. Moves, draw offers, resignations and clock checks are delivered
+// through the actor's mailbox and applied one at a time, so requests for the
+// same game never interleave within a process.
+//
+// Several server instances may each run an actor for the same game, so every
+// write is also guarded by Game.Version: the update only matches the row if
+// nobody else wrote it since we loaded it. When it doesn't, the actor drops its
+// state, reloads the game from the database and applies the command again.
+// Commands that never reach a versioned write, because the actor's copy made
+// them fail or because they only touch Redis (premoves and votes), compare
+// the stored version first and reload the same way.
+
+const (
+	actorIdleTimeout   = 5 * time.Minute
+	maxConflictRetries = 3
+)
+
+var errVersionConflict = errors.New("game version conflict")
+
+type commandKind int
+
+const (
+	commandJoin commandKind = iota
+	commandMove
+	commandOfferDraw
+	commandAcceptDraw
+	commandDeclineDraw
+	commandResign
+	commandClockCheck
+	commandPutBackDeadline
+	commandVote
+	commandResolveVotes
+	commandPremove
+	commandCancelPremoves
+)
+
+type gameCommand struct {
+	kind     commandKind
+	playerID uuid.UUID
+	from     string
+	to       string
+	drop     string        // piece type dropped on to, in crazyhouse
+	by       time.Duration // how far to put back a correspondence deadline
+	reply    chan commandResult
+}
+
+type commandResult struct {
+	game     *models.Game
+	move     *models.GameMove
+	tally    *VoteTally
+	premoves []Premove
+	err      error
+}
+
+type gameActor struct {
+	gameID  uuid.UUID
+	service *GameService
+	mailbox chan gameCommand
+	done    chan struct{}
+
+	game      *models.Game
+	flagTimer *time.Timer
+	voteTimer *time.Timer
+}
+
+// dispatch delivers cmd to the game's actor, starting one if needed, and waits
+// for the result.
+func (gs *GameService) dispatch(gameID uuid.UUID, cmd gameCommand) commandResult {
+	cmd.reply = make(chan commandResult, 1)
+	for {
+		actor := gs.actorFor(gameID)
+		select {
+		case actor.mailbox <- cmd:
+			return <-cmd.reply
+		case <-actor.done:
+			// The actor retired before taking the command; start a new one
+		}
+	}
+}
+
+func (gs *GameService) actorFor(gameID uuid.UUID) *gameActor {
+	gs.actorsMu.Lock()
+	defer gs.actorsMu.Unlock()
+
+	if gs.actors == nil {
+		gs.actors = make(map[uuid.UUID]*gameActor)
+	}
+	if actor, ok := gs.actors[gameID]; ok {
+		return actor
+	}
+
+	actor := &gameActor{
+		gameID:  gameID,
+		service: gs,
+		mailbox: make(chan gameCommand),
+		done:    make(chan struct{}),
+	}
+	gs.actors[gameID] = actor
+	go actor.run()
+
+	return actor
+}
+
+func (gs *GameService) retireActor(actor *gameActor) {
+	gs.actorsMu.Lock()
+	if gs.actors[actor.gameID] == actor {
+		delete(gs.actors, actor.gameID)
+	}
+	gs.actorsMu.Unlock()
+	close(actor.done)
+}
+
+func (a *gameActor) run() {
+	idle := time.NewTimer(actorIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case cmd := <-a.mailbox:
+			cmd.reply <- a.handle(cmd)
+			if a.game != nil && isGameOver(a.game) {
+				a.service.retireActor(a)
+				return
+			}
+			idle.Reset(actorIdleTimeout)
+
+		case <-idle.C:
+			a.service.retireActor(a)
+			return
+		}
+	}
+}
+
+func (a *gameActor) handle(cmd gameCommand) commandResult {
+	for attempt := 0; ; attempt++ {
+		fresh := attempt > 0
+		if err := a.load(fresh); err != nil {
+			return commandResult{err: err}
+		}
+
+		result := commandResult{err: errVersionConflict}
+		if fresh || !unversioned(cmd.kind) || !a.stale() {
+			result = a.apply(cmd)
+		}
+		// A rejection may only mean our copy is out of date
+		if result.err != nil && !errors.Is(result.err, errVersionConflict) && !fresh && a.stale() {
+			result.err = errVersionConflict
+		}
+		if !errors.Is(result.err, errVersionConflict) {
+			return result
+		}
+
+		// Another instance wrote the game; our copy is stale
+		a.game = nil
+		if attempt == maxConflictRetries {
+			return commandResult{err: ErrConcurrentUpdate}
+		}
+	}
+}
+
+// unversioned reports whether kind's commands are recorded without a
+// versioned write to the game, so nothing catches them acting on a stale copy.
+func unversioned(kind commandKind) bool {
+	return kind == commandPremove || kind == commandVote
+}
+
+// stale reports whether another instance has written the game since the
+// actor loaded it. A failed lookup counts as up to date: writes still check
+// the version.
+func (a *gameActor) stale() bool {
+	if a.game == nil {
+		return false
+	}
+	var version int
+	err := a.service.db.Model(&models.Game{}).Select("version").Where("id = ?", a.gameID).Scan(&version).Error
+	if err != nil {
+		return false
+	}
+	return version != a.game.Version
+}
+
+// load makes sure the actor holds the game state, reading it from the cache
+// or, when fresh is set or the cache misses, from the database.
+func (a *gameActor) load(fresh bool) error {
+	if a.game != nil {
+		return nil
+	}
+
+	var game models.Game
+	var err error
+	if !fresh {
+		game, err = a.service.getGameFromCache(a.gameID)
+	}
+	if fresh || err != nil {
+		if err := a.service.db.First(&game, "id = ?", a.gameID).Error; err != nil {
+			return lookupError(err)
+		}
+	}
+
+	a.game = &game
+	a.scheduleFlag()
+	a.scheduleVote()
+	return nil
+}
+
+func (a *gameActor) apply(cmd gameCommand) commandResult {
+	switch cmd.kind {
+	case commandJoin:
+		return a.join(cmd.playerID)
+	case commandMove:
+		if a.game.VoteWindow > 0 {
+			return commandResult{err: ErrVoteRequired}
+		}
+		if isPlayerTurn(a.game, cmd.playerID) {
+			// Moving by hand replaces the premoves
+			if result := a.cancelPremoves(cmd.playerID); result.err != nil {
+				return result
+			}
+		}
+		return a.move(cmd.playerID, cmd.from, cmd.to, cmd.drop)
+	case commandOfferDraw:
+		return a.offerDraw(cmd.playerID)
+	case commandAcceptDraw:
+		return a.acceptDraw(cmd.playerID)
+	case commandDeclineDraw:
+		return a.declineDraw(cmd.playerID)
+	case commandResign:
+		return a.resign(cmd.playerID)
+	case commandClockCheck:
+		return a.checkClock()
+	case commandPutBackDeadline:
+		return a.putBackDeadline(cmd.playerID, cmd.by)
+	case commandVote:
+		return a.vote(cmd.playerID, cmd.from, cmd.to, cmd.drop)
+	case commandResolveVotes:
+		return a.resolveVotes()
+	case commandPremove:
+		return a.queuePremove(cmd.playerID, cmd.from, cmd.to, cmd.drop)
+	case commandCancelPremoves:
+		return a.cancelPremoves(cmd.playerID)
+	default:
+		return commandResult{err: fmt.Errorf("unknown game command %d", cmd.kind)}
+	}
+}
+
+func (a *gameActor) join(playerID uuid.UUID) commandResult {
+	game := *a.game
+
+	if game.Status != models.GameStatusWaiting {
+		return commandResult{err: ErrGameNotJoinable}
+	}
+
+	if game.WhitePlayerID != nil && *game.WhitePlayerID == playerID {
+		return commandResult{err: ErrAlreadyInGame}
+	}
+	if color, err := a.service.teamColor(&game, playerID); err != nil {
+		return commandResult{err: err}
+	} else if color != "" {
+		return commandResult{err: ErrAlreadyInGame}
+	}
+
+	// Assign as black player and start white's clock
+	now := time.Now()
+	game.BlackPlayerID = &playerID
+	game.Status = models.GameStatusActive
+	game.StartedAt = &now
+	game.LastMoveAt = &now
+	game.MoveDeadline = a.service.moveDeadline(&game, now)
+
+	event := newGameEvent(models.GameEventJoined, &playerID, nil)
+	if err := a.service.record(&game, event, nil, now); err != nil {
+		return commandResult{err: err}
+	}
+
+	a.commit(&game)
+	a.service.publishGameUpdate(game.ID, "joined", &game)
+
+	return commandResult{game: a.snapshot()}
+}
+
+// moveUpdate is the "move" game update: the move as stored, with the clocks
+// and state of the game after it. It is the one broadcast of a move to the
+// game room, however the move was made.
+type moveUpdate struct {
+	*models.GameMove
+	WhiteTime int                `json:"white_time"`
+	BlackTime int                `json:"black_time"`
+	Status    models.GameStatus  `json:"status"`
+	Result    *models.GameResult `json:"result,omitempty"`
+}
+
+// move plays from-to, or drops a piece on to when drop is set.
+func (a *gameActor) move(playerID uuid.UUID, from, to, drop string) commandResult {
+	// Work on a copy so a failed write leaves the actor's state untouched
+	game := *a.game
+
+	if err := checkParticipant(&game, playerID); err != nil {
+		return commandResult{err: err}
+	}
+
+	// Validate player's turn
+	if !isPlayerTurn(&game, playerID) {
+		return commandResult{err: ErrNotPlayerTurn}
+	}
+
+	now := time.Now()
+	timeLeft, running := clockRemaining(&game, now)
+	if running && timeLeft <= 0 {
+		result := a.flag(&game, now)
+		if result.err == nil {
+			result.err = ErrTimeExpired
+		}
+		return result
+	}
+
+	// Validate and execute move using chess engine
+	chessEngine := chess.NewEngine(game.BoardState)
+	var move *chess.Move
+	var err error
+	if drop != "" {
+		move, err = chessEngine.ValidateDrop(drop, to)
+	} else {
+		move, err = chessEngine.ValidateMove(from, to)
+	}
+	if err != nil {
+		return commandResult{err: fmt.Errorf("%w: %v", ErrInvalidMove, err)}
+	}
+	if running && game.DaysPerMove == 0 {
+		timeLeft += increment(&game, game.CurrentTurn)
+	}
+
+	// Create move record
+	gameMove := &models.GameMove{
+		GameID:        game.ID,
+		PlayerID:      playerID,
+		MoveNumber:    game.MoveCount + 1,
+		FromSquare:    from,
+		ToSquare:      to,
+		Piece:         move.Piece,
+		CapturedPiece: move.CapturedPiece,
+		Promotion:     move.Promotion,
+		IsCheck:       move.IsCheck,
+		IsCheckmate:   move.IsCheckmate,
+		IsStalemate:   move.IsStalemate,
+		Notation:      move.Notation,
+		FENAfter:      move.FENAfter,
+		TimeLeft:      timeLeft,
+	}
+
+	// Update game state
+	setClock(&game, game.CurrentTurn, timeLeft)
+	game.BoardState = move.FENAfter
+	classifyOpening(&game)
+	game.MoveCount++
+	game.CurrentTurn = getOpponentColor(game.CurrentTurn)
+	// The next clock starts now, even if the mover's wasn't running
+	if game.LastMoveAt != nil {
+		game.LastMoveAt = &now
+	}
+	game.MoveDeadline = a.service.moveDeadline(&game, now)
+
+	// Handle game end conditions
+	if move.IsCheckmate {
+		// CurrentTurn has already passed to the mated side
+		finishGame(&game, winnerResult(getOpponentColor(game.CurrentTurn)), now)
+	} else if move.IsStalemate {
+		finishGame(&game, models.GameResultDraw, now)
+	}
+	adjudicated := a.service.adjudicate(&game)
+	if adjudicated != nil {
+		finishGame(&game, *adjudicated, now)
+	}
+
+	event := newGameEvent(models.GameEventMoved, &playerID, movedPayload{
+		From:        from,
+		To:          to,
+		Drop:        drop,
+		Notation:    move.Notation,
+		TimeLeft:    timeLeft,
+		Adjudicated: adjudicated,
+	})
+	if err := a.service.record(&game, event, gameMove, now); err != nil {
+		return commandResult{err: err}
+	}
+
+	a.commit(&game)
+
+	// Moving instead of accepting declines the opponent's draw offer
+	if offeredBy, ok := a.service.getDrawOffer(game.ID); ok && offeredBy != playerID {
+		a.service.clearDrawOffer(game.ID)
+	}
+
+	// Publish move to Redis for real-time updates
+	a.service.publishGameUpdate(game.ID, "move", moveUpdate{
+		GameMove:  gameMove,
+		WhiteTime: game.WhiteTime,
+		BlackTime: game.BlackTime,
+		Status:    game.Status,
+		Result:    game.Result,
+	})
+	if isGameOver(&game) {
+		a.service.publishGameUpdate(game.ID, "game_over", &game)
+	}
+	a.service.notifyMoved(&game, gameMove)
+
+	result := commandResult{game: a.snapshot(), move: gameMove}
+	if !isGameOver(&game) {
+		if game.DaysPerMove > 0 {
+			a.playConditional(gameMove)
+		}
+		a.playPremove()
+	}
+	return result
+}
+
+func (a *gameActor) offerDraw(playerID uuid.UUID) commandResult {
+	game := *a.game
+	if err := checkParticipant(&game, playerID); err != nil {
+		return commandResult{err: err}
+	}
+
+	offeredBy, ok := a.service.getDrawOffer(game.ID)
+	if ok && offeredBy != playerID {
+		// Both sides want a draw
+		return a.acceptDraw(playerID)
+	}
+
+	now := time.Now()
+	event := newGameEvent(models.GameEventDrawOffered, &playerID, nil)
+	if err := a.service.record(&game, event, nil, now); err != nil {
+		return commandResult{err: err}
+	}
+
+	a.commit(&game)
+	if err := a.service.setDrawOffer(game.ID, playerID); err != nil {
+		return commandResult{err: err}
+	}
+
+	a.service.publishGameUpdate(game.ID, "draw_offered", map[string]interface{}{
+		"player_id": playerID,
+	})
+
+	return commandResult{game: a.snapshot()}
+}
+
+func (a *gameActor) acceptDraw(playerID uuid.UUID) commandResult {
+	game := *a.game
+	if err := checkParticipant(&game, playerID); err != nil {
+		return commandResult{err: err}
+	}
+
+	offeredBy, ok := a.service.getDrawOffer(game.ID)
+	if !ok || offeredBy == playerID {
+		return commandResult{err: ErrNoDrawOffer}
+	}
+
+	now := time.Now()
+	finishGame(&game, models.GameResultDraw, now)
+	event := newGameEvent(models.GameEventDrawAccepted, &playerID, nil)
+	if err := a.service.record(&game, event, nil, now); err != nil {
+		return commandResult{err: err}
+	}
+
+	a.commit(&game)
+	a.service.clearDrawOffer(game.ID)
+	a.service.publishGameUpdate(game.ID, "game_over", &game)
+
+	return commandResult{game: a.snapshot()}
+}
+
+func (a *gameActor) declineDraw(playerID uuid.UUID) commandResult {
+	game := *a.game
+	if err := checkParticipant(&game, playerID); err != nil {
+		return commandResult{err: err}
+	}
+
+	offeredBy, ok := a.service.getDrawOffer(game.ID)
+	if !ok || offeredBy == playerID {
+		return commandResult{err: ErrNoDrawOffer}
+	}
+
+	now := time.Now()
+	event := newGameEvent(models.GameEventDrawDeclined, &playerID, nil)
+	if err := a.service.record(&game, event, nil, now); err != nil {
+		return commandResult{err: err}
+	}
+
+	a.commit(&game)
+	a.service.clearDrawOffer(game.ID)
+	a.service.publishGameUpdate(game.ID, "draw_declined", map[string]interface{}{
+		"player_id": playerID,
+	})
+
+	return commandResult{game: a.snapshot()}
+}
+
+func (a *gameActor) resign(playerID uuid.UUID) commandResult {
+	game := *a.game
+	if err := checkParticipant(&game, playerID); err != nil {
+		return commandResult{err: err}
+	}
+
+	now := time.Now()
+	finishGame(&game, winnerResult(getOpponentColor(playerColor(&game, playerID))), now)
+	event := newGameEvent(models.GameEventResigned, &playerID, nil)
+	if err := a.service.record(&game, event, nil, now); err != nil {
+		return commandResult{err: err}
+	}
+
+	a.commit(&game)
+	a.service.clearDrawOffer(game.ID)
+	a.service.publishGameUpdate(game.ID, "game_over", &game)
+
+	return commandResult{game: a.snapshot()}
+}
+
+// checkClock runs when the side to move may have run out of time.
+func (a *gameActor) checkClock() commandResult {
+	game := *a.game
+	if game.Status != models.GameStatusActive {
+		return commandResult{game: a.snapshot()}
+	}
+
+	now := time.Now()
+	if timeLeft, running := clockRemaining(&game, now); !running || timeLeft > 0 {
+		a.scheduleFlag()
+		return commandResult{game: a.snapshot()}
+	}
+
+	return a.flag(&game, now)
+}
+
+// flag ends game as lost on time by the side to move.
+func (a *gameActor) flag(game *models.Game, now time.Time) commandResult {
+	setClock(game, game.CurrentTurn, 0)
+	finishGame(game, winnerResult(getOpponentColor(game.CurrentTurn)), now)
+	event := newGameEvent(models.GameEventClockExpired, nil, nil)
+	if err := a.service.record(game, event, nil, now); err != nil {
+		return commandResult{err: err}
+	}
+
+	a.commit(game)
+	a.service.clearDrawOffer(game.ID)
+	a.service.publishGameUpdate(game.ID, "game_over", game)
+
+	return commandResult{game: a.snapshot()}
+}
+
+// commit installs a successfully written game as the actor's state.
+func (a *gameActor) commit(game *models.Game) {
+	finished := isGameOver(game) && (a.game == nil || !isGameOver(a.game))
+
+	a.game = game
+	a.service.cacheGameState(game)
+	a.scheduleFlag()
+	a.scheduleVote()
+
+	if finished {
+		a.service.notifyGameFinished(game)
+	}
+}
+
+// snapshot returns a copy of the actor's game that callers may keep.
+func (a *gameActor) snapshot() *models.Game {
+	game := *a.game
+	return &game
+}
+
+// scheduleFlag arms a timer that sends a clock check when the side to move
+// runs out of time. The timer outlives an idle actor on purpose: the check is
+// dispatched like any other command and starts a new actor if needed.
+func (a *gameActor) scheduleFlag() {
+	if a.flagTimer != nil {
+		a.flagTimer.Stop()
+		a.flagTimer = nil
+	}
+
+	// Correspondence deadlines are enforced by the scheduler
+	timeLeft, running := clockRemaining(a.game, time.Now())
+	if !running || a.game.DaysPerMove > 0 {
+		return
+	}
+
+	gs, gameID := a.service, a.gameID
+	a.flagTimer = time.AfterFunc(time.Duration(timeLeft)*time.Second, func() {
+		gs.dispatch(gameID, gameCommand{kind: commandClockCheck})
+	})
+}
+
+// record writes the updated game, the move if there is one and the event that
+// describes the change in one transaction.
+func (gs *GameService) record(game *models.Game, event *models.GameEvent, gameMove *models.GameMove, now time.Time) error {
+	tx := gs.db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	// Update the game first: the row lock makes a concurrent writer wait and
+	// then miss on the version check instead of racing on the move insert
+	if err := updateGameVersioned(tx, game, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if gameMove != nil {
+		if err := tx.Create(gameMove).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to save move: %w", err)
+		}
+	}
+	if err := appendEvent(tx, game, event, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit game update: %w", err)
+	}
+
+	return nil
+}
+
+// updateGameVersioned writes the mutable game fields only if the stored row is
+// still at game.Version, and advances the version on success.
+func updateGameVersioned(db *gorm.DB, game *models.Game, now time.Time) error {
+	result := db.Model(&models.Game{}).
+		Where("id = ? AND version = ?", game.ID, game.Version).
+		Updates(map[string]interface{}{
+			"white_player_id": game.WhitePlayerID,
+			"black_player_id": game.BlackPlayerID,
+			"status":          game.Status,
+			"result":          game.Result,
+			"current_turn":    game.CurrentTurn,
+			"board_state":     game.BoardState,
+			"move_count":      game.MoveCount,
+			"move_deadline":   game.MoveDeadline,
+			"eco":             game.ECO,
+			"opening_name":    game.OpeningName,
+			"white_time":      game.WhiteTime,
+			"black_time":      game.BlackTime,
+			"started_at":      game.StartedAt,
+			"last_move_at":    game.LastMoveAt,
+			"finished_at":     game.FinishedAt,
+			"version":         game.Version + 1,
+			"updated_at":      now,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update game: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errVersionConflict
+	}
+
+	game.Version++
+	game.UpdatedAt = now
+	return nil
+}
+
+func checkParticipant(game *models.Game, playerID uuid.UUID) error {
+	if game.Status != models.GameStatusActive {
+		return ErrGameNotActive
+	}
+	if playerColor(game, playerID) == "" {
+		return ErrNotGamePlayer
+	}
+	return nil
+}
+
+// clockRemaining returns the seconds left for the side to move and whether
+// its clock is running at all.
+func clockRemaining(game *models.Game, now time.Time) (int, bool) {
+	timeLeft := game.WhiteTime
+	if game.CurrentTurn == "black" {
+		timeLeft = game.BlackTime
+	}
+
+	if game.DaysPerMove > 0 {
+		if game.Status != models.GameStatusActive || game.MoveDeadline == nil {
+			return timeLeft, false
+		}
+		return int(game.MoveDeadline.Sub(now) / time.Second), true
+	}
+
+	if game.Status != models.GameStatusActive || game.LastMoveAt == nil || game.CurrentTurn == game.UntimedSide {
+		return timeLeft, false
+	}
+
+	return timeLeft - int(now.Sub(*game.LastMoveAt)/time.Second), true
+}
+
+func setClock(game *models.Game, color string, seconds int) {
+	if seconds < 0 {
+		seconds = 0
+	}
+	if color == "white" {
+		game.WhiteTime = seconds
+	} else {
+		game.BlackTime = seconds
+	}
+}
+
+func finishGame(game *models.Game, result models.GameResult, now time.Time) {
+	game.Status = models.GameStatusFinished
+	game.Result = &result
+	game.FinishedAt = &now
+}
+
+func winnerResult(color string) models.GameResult {
+	if color == "white" {
+		return models.GameResultWhiteWins
+	}
+	return models.GameResultBlackWins
+}
+
+// adjudicate returns the result the tablebase gives a standard game that is
+// still going, or nil when there is no tablebase or it doesn't cover the
+// position. Wins the fifty-move rule spoils are draws.
+func (gs *GameService) adjudicate(game *models.Game) *models.GameResult {
+	if gs.tablebase == nil || isGameOver(game) || game.Variant != models.GameVariantStandard {
+		return nil
+	}
+	wdl, err := gs.tablebase.ProbeWDL(game.BoardState)
+	if err != nil {
+		if !errors.Is(err, chess.ErrNotInTablebase) {
+			log.Printf("Failed to probe tablebase for game %s: %v", game.ID, err)
+		}
+		return nil
+	}
+
+	result := models.GameResultDraw
+	switch wdl {
+	case chess.WDLWin:
+		result = winnerResult(game.CurrentTurn)
+	case chess.WDLLoss:
+		result = winnerResult(getOpponentColor(game.CurrentTurn))
+	}
+	return &result
+}
+
+func isGameOver(game *models.Game) bool {
+	return game.Status == models.GameStatusFinished || game.Status == models.GameStatusAbandoned
+}
+
+func drawOfferKey(gameID uuid.UUID) string {
+	return fmt.Sprintf("game:%s:draw_offer", gameID)
+}
+
+// Draw offers live in Redis rather than in the actor so that an offer made
+// through one instance can be answered through another.
+func (gs *GameService) getDrawOffer(gameID uuid.UUID) (uuid.UUID, bool) {
+	value, err := gs.redis.Get(context.Background(), drawOfferKey(gameID)).Result()
+	if err != nil {
+		return uuid.Nil, false
+	}
+	playerID, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return playerID, true
+}
+
+func (gs *GameService) setDrawOffer(gameID, playerID uuid.UUID) error {
+	if err := gs.redis.Set(context.Background(), drawOfferKey(gameID), playerID.String(), time.Hour).Err(); err != nil {
+		return fmt.Errorf("failed to store draw offer: %w", err)
+	}
+	return nil
+}
+
+func (gs *GameService) clearDrawOffer(gameID uuid.UUID) {
+	gs.redis.Del(context.Background(), drawOfferKey(gameID))
+}
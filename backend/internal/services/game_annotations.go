@@ -0,0 +1,295 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Once a game is over its players can annotate its moves: a comment, NAGs,
+// and arrows and highlighted squares as in PGN's [%cal] and [%csl]. Each
+// player's annotations are their own; neither player can change or remove
+// the other's. The annotations of both go out with the game's PGN, and a PGN
+// of the game annotated elsewhere can be brought back in as the importing
+// player's.
+
+const maxAnnotationShapes = 64
+
+var (
+	ErrGameNotOver  = errors.New("game is still being played")
+	ErrMoveNotFound = errors.New("move not found")
+)
+
+// AnnotateMove replaces userID's annotation on the move played at ply. An
+// empty annotation removes it, and nil is returned.
+func (gs *GameService) AnnotateMove(gameID, userID uuid.UUID, ply int, annotation models.MoveAnnotation) (*models.MoveAnnotation, error) {
+	annotation.Comment = strings.TrimSpace(annotation.Comment)
+	if err := checkAnnotation(annotation.Comment, annotation.NAGs, annotation.Arrows, annotation.Highlights); err != nil {
+		return nil, err
+	}
+	if _, err := gs.annotatableGame(gameID, userID); err != nil {
+		return nil, err
+	}
+
+	var move models.GameMove
+	if err := gs.db.Take(&move, "game_id = ? AND move_number = ?", gameID, ply).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMoveNotFound
+		}
+		return nil, fmt.Errorf("failed to load move: %w", err)
+	}
+
+	annotation.MoveID, annotation.GameID, annotation.AuthorID = move.ID, gameID, userID
+	if annotation.Empty() {
+		if err := gs.db.Delete(&models.MoveAnnotation{}, "move_id = ? AND author_id = ?", move.ID, userID).Error; err != nil {
+			return nil, fmt.Errorf("failed to remove annotation: %w", err)
+		}
+		return nil, nil
+	}
+	if err := gs.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&annotation).Error; err != nil {
+		return nil, fmt.Errorf("failed to save annotation: %w", err)
+	}
+	return &annotation, nil
+}
+
+// ExportPGN writes a game and its annotations as PGN.
+func (gs *GameService) ExportPGN(gameID uuid.UUID) (string, error) {
+	game, err := gs.GetGame(gameID)
+	if err != nil {
+		return "", err
+	}
+
+	line := make([]*chess.PGNMove, len(game.Moves))
+	for i := len(game.Moves) - 1; i >= 0; i-- {
+		move := &game.Moves[i]
+		line[i] = &chess.PGNMove{SAN: move.Notation}
+		switch {
+		case move.IsCheckmate:
+			line[i].SAN += "#"
+		case move.IsCheck:
+			line[i].SAN += "+"
+		}
+		mergeAnnotations(line[i], game, move.Annotations)
+		if i+1 < len(line) {
+			line[i].Next = []*chess.PGNMove{line[i+1]}
+		}
+	}
+	var first []*chess.PGNMove
+	if len(line) > 0 {
+		first = line[:1]
+	}
+
+	tags := []chess.PGNTag{
+		{Name: "Event", Value: "Arcane Chess game"},
+		{Name: "Site", Value: "Arcane Chess"},
+		{Name: "Date", Value: game.CreatedAt.Format("2006.01.02")},
+		{Name: "White", Value: playerName(game.WhitePlayer, "?")},
+		{Name: "Black", Value: playerName(game.BlackPlayer, "?")},
+	}
+	white := pgnTimeControl(game.TimeControl, game.WhiteIncrement)
+	black := pgnTimeControl(startingTime(game, "black"), game.BlackIncrement)
+	if white == black {
+		tags = append(tags, chess.PGNTag{Name: "TimeControl", Value: white})
+	} else {
+		tags = append(tags, chess.PGNTag{Name: "WhiteTimeControl", Value: white}, chess.PGNTag{Name: "BlackTimeControl", Value: black})
+	}
+	if game.Variant == models.GameVariantCrazyhouse {
+		tags = append(tags, chess.PGNTag{Name: "Variant", Value: "Crazyhouse"})
+	}
+	if game.Handicap != "" {
+		tags = append(tags, chess.PGNTag{Name: "Handicap", Value: fmt.Sprintf("%s gives %s", game.HandicapSide, game.Handicap)})
+	}
+	// Odds games start from their own position, which FormatPGN writes out
+	// in SetUp and FEN tags
+	fen, err := gameStartPosition(game)
+	if err != nil {
+		return "", err
+	}
+	if game.ECO != "" {
+		tags = append(tags, chess.PGNTag{Name: "ECO", Value: game.ECO}, chess.PGNTag{Name: "Opening", Value: game.OpeningName})
+	}
+	return chess.FormatPGN(tags, fen, first, pgnResult(game)), nil
+}
+
+// mergeAnnotations writes the annotations of a move into its PGN move, white's
+// before black's. When both players commented, each comment is headed with
+// its author's name.
+func mergeAnnotations(move *chess.PGNMove, game *models.Game, annotations []models.MoveAnnotation) {
+	sort.SliceStable(annotations, func(i, j int) bool {
+		return playerColor(game, annotations[i].AuthorID) == "white" && playerColor(game, annotations[j].AuthorID) != "white"
+	})
+
+	var comments, signed []string
+	for _, annotation := range annotations {
+		move.NAGs = appendMissing(move.NAGs, annotation.NAGs...)
+		move.Arrows = appendMissing(move.Arrows, annotation.Arrows...)
+		move.Highlights = appendMissing(move.Highlights, annotation.Highlights...)
+		if annotation.Comment == "" {
+			continue
+		}
+		author := game.WhitePlayer
+		if playerColor(game, annotation.AuthorID) == "black" {
+			author = game.BlackPlayer
+		}
+		comments = append(comments, annotation.Comment)
+		signed = append(signed, fmt.Sprintf("%s: %s", playerName(author, "?"), annotation.Comment))
+	}
+	if len(comments) > 1 {
+		comments = signed
+	}
+	move.Comment = strings.Join(comments, " ")
+}
+
+// appendMissing appends the values not already in list.
+func appendMissing[T comparable](list []T, values ...T) []T {
+	for _, value := range values {
+		if !slices.Contains(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// pgnTimeControl formats a clock as a PGN TimeControl value: the seconds on
+// it, followed by the increment if there is one.
+func pgnTimeControl(seconds, increment int) string {
+	if increment == 0 {
+		return fmt.Sprint(seconds)
+	}
+	return fmt.Sprintf("%d+%d", seconds, increment)
+}
+
+// ImportAnnotations replaces userID's annotations on a game's moves with
+// those in the PGN in r, whose main line must be the game's moves or the
+// first of them. Variations are ignored, and so are the other player's
+// annotations.
+func (gs *GameService) ImportAnnotations(gameID, userID uuid.UUID, r io.Reader) (*models.Game, error) {
+	games, err := chess.ParsePGN(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(games) != 1 {
+		return nil, fmt.Errorf("%w: expected one game, found %d", ErrInvalidPGN, len(games))
+	}
+
+	if _, err := gs.annotatableGame(gameID, userID); err != nil {
+		return nil, err
+	}
+	var moves []models.GameMove
+	if err := gs.db.Where("game_id = ?", gameID).Order("move_number ASC").Find(&moves).Error; err != nil {
+		return nil, fmt.Errorf("failed to load game moves: %w", err)
+	}
+
+	var annotations []models.MoveAnnotation
+	line := games[0].Tree
+	for i := 0; len(line) > 0; i++ {
+		pgnMove := line[0]
+		if i >= len(moves) || strings.TrimRight(pgnMove.SAN, "+#") != strings.TrimRight(moves[i].Notation, "+#") {
+			return nil, fmt.Errorf("%w: move %d is %s, not the game's", ErrInvalidPGN, i+1, pgnMove.SAN)
+		}
+		annotation := models.MoveAnnotation{
+			MoveID:     moves[i].ID,
+			GameID:     gameID,
+			Comment:    strings.TrimSpace(pgnMove.Comment),
+			NAGs:       pgnMove.NAGs,
+			Arrows:     pgnMove.Arrows,
+			Highlights: pgnMove.Highlights,
+			AuthorID:   userID,
+		}
+		if err := checkAnnotation(annotation.Comment, annotation.NAGs, annotation.Arrows, annotation.Highlights); err != nil {
+			return nil, fmt.Errorf("move %d: %w", i+1, err)
+		}
+		if !annotation.Empty() {
+			annotations = append(annotations, annotation)
+		}
+		line = pgnMove.Next
+	}
+
+	err = gs.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.MoveAnnotation{}, "game_id = ? AND author_id = ?", gameID, userID).Error; err != nil {
+			return fmt.Errorf("failed to remove annotations: %w", err)
+		}
+		if len(annotations) == 0 {
+			return nil
+		}
+		if err := tx.Create(&annotations).Error; err != nil {
+			return fmt.Errorf("failed to save annotations: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gs.GetGame(gameID)
+}
+
+// annotatableGame loads a game userID may annotate: one of theirs that's over.
+func (gs *GameService) annotatableGame(gameID, userID uuid.UUID) (*models.Game, error) {
+	var game models.Game
+	if err := gs.db.Take(&game, "id = ?", gameID).Error; err != nil {
+		return nil, lookupError(err)
+	}
+	if playerColor(&game, userID) == "" {
+		return nil, ErrNotGamePlayer
+	}
+	if !isGameOver(&game) {
+		return nil, ErrGameNotOver
+	}
+	return &game, nil
+}
+
+// checkAnnotation checks a move's comment, NAGs and shapes against what PGN
+// can carry and what we're willing to store.
+func checkAnnotation(comment string, nags []int, arrows, highlights []string) error {
+	if len(comment) > maxAnalysisComment {
+		return fmt.Errorf("%w: comments are limited to %d characters", ErrInvalidAnnotation, maxAnalysisComment)
+	}
+	if len(nags) > maxAnalysisNAGs {
+		return fmt.Errorf("%w: at most %d NAGs", ErrInvalidAnnotation, maxAnalysisNAGs)
+	}
+	for _, nag := range nags {
+		if nag < 1 || nag > 255 {
+			return fmt.Errorf("%w: NAG %d out of range", ErrInvalidAnnotation, nag)
+		}
+	}
+	if len(arrows)+len(highlights) > maxAnnotationShapes {
+		return fmt.Errorf("%w: at most %d arrows and highlights", ErrInvalidAnnotation, maxAnnotationShapes)
+	}
+	for _, arrow := range arrows {
+		if !chess.ValidArrow(arrow) {
+			return fmt.Errorf("%w: invalid arrow %q", ErrInvalidAnnotation, arrow)
+		}
+	}
+	for _, highlight := range highlights {
+		if !chess.ValidHighlight(highlight) {
+			return fmt.Errorf("%w: invalid highlight %q", ErrInvalidAnnotation, highlight)
+		}
+	}
+	return nil
+}
+
+// pgnResult is a game's result as PGN writes it.
+func pgnResult(game *models.Game) string {
+	if game.Result == nil {
+		return "*"
+	}
+	switch *game.Result {
+	case models.GameResultWhiteWins:
+		return "1-0"
+	case models.GameResultBlackWins:
+		return "0-1"
+	case models.GameResultDraw:
+		return "1/2-1/2"
+	}
+	return "*"
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func expectFinishedGame(mock sqlmock.Sqlmock, gameID, white, black uuid.UUID, status models.GameStatus) {
+	mock.ExpectQuery(`SELECT \* FROM "games" WHERE id = \$1 LIMIT 1`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "white_player_id", "black_player_id", "status"}).
+			AddRow(gameID, white, black, status))
+}
+
+func TestGameService_AnnotateMove(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameID, white, black, moveID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	expectFinishedGame(mock, gameID, white, black, models.GameStatusFinished)
+	mock.ExpectQuery(`SELECT \* FROM "game_moves" WHERE game_id = \$1 AND move_number = \$2 LIMIT 1`).
+		WithArgs(gameID, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_id", "move_number"}).AddRow(moveID, gameID, 3))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "move_annotations" .* ON CONFLICT \("move_id","author_id"\) DO UPDATE SET`).
+		WithArgs(moveID, black, gameID, "Too slow", "6", "Rd8h4", "Rf2", testutil.AnyTime{}, testutil.AnyTime{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	annotation, err := NewGameService(db, redisClient).AnnotateMove(gameID, black, 3, models.MoveAnnotation{
+		Comment:    "  Too slow ",
+		NAGs:       models.NAGs{6},
+		Arrows:     models.Shapes{"Rd8h4"},
+		Highlights: models.Shapes{"Rf2"},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "Too slow", annotation.Comment)
+	assert.Equal(t, black, annotation.AuthorID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_AnnotateMove_Rejected(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	service := NewGameService(db, redisClient)
+	gameID, white, black := uuid.New(), uuid.New(), uuid.New()
+
+	_, err := service.AnnotateMove(gameID, white, 1, models.MoveAnnotation{Highlights: models.Shapes{"d4"}})
+	assert.ErrorIs(t, err, ErrInvalidAnnotation)
+
+	// Not while the game is on
+	expectFinishedGame(mock, gameID, white, black, models.GameStatusActive)
+	_, err = service.AnnotateMove(gameID, white, 1, models.MoveAnnotation{Comment: "Hmm"})
+	assert.ErrorIs(t, err, ErrGameNotOver)
+
+	// Nor by spectators
+	expectFinishedGame(mock, gameID, white, black, models.GameStatusFinished)
+	_, err = service.AnnotateMove(gameID, uuid.New(), 1, models.MoveAnnotation{Comment: "Hmm"})
+	assert.ErrorIs(t, err, ErrNotGamePlayer)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_ImportAnnotations_OtherGame(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameID, white, black := uuid.New(), uuid.New(), uuid.New()
+	expectFinishedGame(mock, gameID, white, black, models.GameStatusFinished)
+	mock.ExpectQuery(`SELECT \* FROM "game_moves" WHERE game_id = \$1 ORDER BY move_number ASC`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "move_number", "notation"}).
+			AddRow(uuid.New(), 1, "e4").
+			AddRow(uuid.New(), 2, "e5"))
+
+	pgn := "1. e4 {Best by test} 1... c5 *"
+	_, err := NewGameService(db, redisClient).ImportAnnotations(gameID, white, strings.NewReader(pgn))
+
+	assert.ErrorIs(t, err, ErrInvalidPGN)
+	assert.EqualError(t, err, "invalid PGN: move 2 is c5, not the game's")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_Annotations_BothPlayers(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	service := NewGameService(db, redisClient)
+	gameID, white, black, e4 := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	// White annotates 1.e4 and black writes their own note on it, which
+	// leaves white's alone
+	for _, author := range []uuid.UUID{white, black} {
+		expectFinishedGame(mock, gameID, white, black, models.GameStatusFinished)
+		mock.ExpectQuery(`SELECT \* FROM "game_moves" WHERE game_id = \$1 AND move_number = \$2 LIMIT 1`).
+			WithArgs(gameID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "game_id", "move_number"}).AddRow(e4, gameID, 1))
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO "move_annotations" .* ON CONFLICT \("move_id","author_id"\) DO UPDATE SET`).
+			WithArgs(e4, author, gameID, sqlmock.AnyArg(), "", "", "", testutil.AnyTime{}, testutil.AnyTime{}).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		_, err := service.AnnotateMove(gameID, author, 1, models.MoveAnnotation{Comment: "Played by " + author.String()})
+		require.NoError(t, err)
+	}
+
+	// Clearing black's note removes only black's
+	expectFinishedGame(mock, gameID, white, black, models.GameStatusFinished)
+	mock.ExpectQuery(`SELECT \* FROM "game_moves" WHERE game_id = \$1 AND move_number = \$2 LIMIT 1`).
+		WithArgs(gameID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_id", "move_number"}).AddRow(e4, gameID, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "move_annotations" WHERE move_id = \$1 AND author_id = \$2`).
+		WithArgs(e4, black).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	annotation, err := service.AnnotateMove(gameID, black, 1, models.MoveAnnotation{})
+	require.NoError(t, err)
+	assert.Nil(t, annotation)
+
+	// And black importing a PGN replaces black's annotations only
+	expectFinishedGame(mock, gameID, white, black, models.GameStatusFinished)
+	mock.ExpectQuery(`SELECT \* FROM "game_moves" WHERE game_id = \$1 ORDER BY move_number ASC`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "move_number", "notation"}).AddRow(e4, 1, "e4"))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "move_annotations" WHERE game_id = \$1 AND author_id = \$2`).
+		WithArgs(gameID, black).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO "move_annotations"`).
+		WithArgs(e4, black, gameID, "Best by test", "", "", "", testutil.AnyTime{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT \* FROM "games" WHERE id = \$1`).
+		WithArgs(gameID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(gameID))
+	mock.ExpectQuery(`SELECT \* FROM "game_moves"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_id", "move_number"}))
+
+	_, err = service.ImportAnnotations(gameID, black, strings.NewReader("1. e4 {Best by test} *"))
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMergeAnnotations(t *testing.T) {
+	white, black := uuid.New(), uuid.New()
+	game := &models.Game{
+		WhitePlayerID: &white,
+		BlackPlayerID: &black,
+		WhitePlayer:   &models.User{Username: "alice"},
+		BlackPlayer:   &models.User{Username: "bob"},
+	}
+
+	move := &chess.PGNMove{SAN: "e4"}
+	mergeAnnotations(move, game, []models.MoveAnnotation{
+		{AuthorID: black, Comment: "Predictable", NAGs: models.NAGs{6}},
+		{AuthorID: white, Comment: "Best by test", NAGs: models.NAGs{1, 6}, Arrows: models.Shapes{"Gd2d4"}},
+	})
+	assert.Equal(t, "alice: Best by test bob: Predictable", move.Comment)
+	assert.Equal(t, []int{1, 6}, move.NAGs)
+	assert.Equal(t, []string{"Gd2d4"}, move.Arrows)
+
+	// A lone comment needs no name
+	move = &chess.PGNMove{SAN: "e4"}
+	mergeAnnotations(move, game, []models.MoveAnnotation{
+		{AuthorID: black, Comment: "Predictable"},
+		{AuthorID: white, Highlights: models.Shapes{"Re4"}},
+	})
+	assert.Equal(t, "Predictable", move.Comment)
+	assert.Equal(t, []string{"Re4"}, move.Highlights)
+}
@@ -3,40 +3,128 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"arcane-chess/internal/chess"
 	"arcane-chess/internal/models"
+	"arcane-chess/internal/openings"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrGameNotFound    = errors.New("game not found")
+	ErrGameNotJoinable = errors.New("game is not available to join")
+	ErrAlreadyInGame   = errors.New("player already in game")
+	ErrGameNotActive   = errors.New("game is not active")
+	ErrNotGamePlayer   = errors.New("player is not in this game")
+	ErrNotPlayerTurn   = errors.New("not player's turn")
+	ErrInvalidMove     = errors.New("invalid move")
+	ErrTimeExpired     = errors.New("time expired")
+	ErrNoDrawOffer     = errors.New("no draw offer to respond to")
+	ErrUnknownVariant  = errors.New("unknown game variant")
+
+	// ErrConcurrentUpdate is returned when a command kept losing the version
+	// race against writers on other instances.
+	ErrConcurrentUpdate = errors.New("game was updated concurrently")
+)
+
 type GameService struct {
-	db    *gorm.DB
-	redis *redis.Client
+	db     *gorm.DB
+	redis  *redis.Client
+	events *EventStore
+	hub    *Hub // for messages to a single player, such as dropped premoves
+
+	tablebase *chess.Tablebase // adjudicates the endgames it covers, once set
+
+	actorsMu sync.Mutex
+	actors   map[uuid.UUID]*gameActor
+
+	finishHooks []func(models.Game)
+	moveHooks   []func(models.Game, models.GameMove)
 }
 
 func NewGameService(db *gorm.DB, redis *redis.Client) *GameService {
 	return &GameService{
-		db:    db,
-		redis: redis,
+		db:     db,
+		redis:  redis,
+		events: NewEventStore(db),
+		actors: make(map[uuid.UUID]*gameActor),
 	}
 }
 
-func (gs *GameService) CreateGame(arenaID uuid.UUID, playerID uuid.UUID) (*models.Game, error) {
-	game := &models.Game{
-		ArenaID:     arenaID,
-		WhitePlayerID: &playerID,
-		Status:      models.GameStatusWaiting,
-		TimeControl: 600, // 10 minutes
-		WhiteTime:   600,
-		BlackTime:   600,
+// PublishTo sends the messages meant for a single player through hub. Until
+// it's called they go nowhere; game updates for the whole room go through
+// Redis regardless.
+func (gs *GameService) PublishTo(hub *Hub) {
+	gs.hub = hub
+}
+
+// AdjudicateWith ends standard games as soon as a move reaches a position tb
+// covers, with the result it gives. Until it's called games are played out.
+func (gs *GameService) AdjudicateWith(tb *chess.Tablebase) {
+	gs.tablebase = tb
+}
+
+// OnGameFinished registers hook to run, in its own goroutine, whenever a game
+// finishes on this instance. Register hooks before the service handles games.
+func (gs *GameService) OnGameFinished(hook func(models.Game)) {
+	gs.finishHooks = append(gs.finishHooks, hook)
+}
+
+func (gs *GameService) notifyGameFinished(game *models.Game) {
+	for _, hook := range gs.finishHooks {
+		go hook(*game)
+	}
+}
+
+// OnMove registers hook to run, in its own goroutine, after every move played
+// on this instance. Register hooks before the service handles games.
+func (gs *GameService) OnMove(hook func(models.Game, models.GameMove)) {
+	gs.moveHooks = append(gs.moveHooks, hook)
+}
+
+func (gs *GameService) notifyMoved(game *models.Game, move *models.GameMove) {
+	for _, hook := range gs.moveHooks {
+		go hook(*game, *move)
 	}
+}
+
+// GameOptions are the settings a new game is created with. The zero value is
+// a standard game.
+type GameOptions struct {
+	Variant     models.GameVariant
+	TimeControl int        // seconds for each side, 10 minutes when zero
+	DaysPerMove int        // makes a correspondence game, which ignores TimeControl
+	VoteWindow  int        // makes a consultation game, with teams voting on moves for this many seconds
+	UntimedSide string     // "white" or "black" to play without a clock
+	SimulID     *uuid.UUID // the simul the game is a board of
+
+	// Odds, which make the game casual
+	BlackTimeControl int             // seconds black starts with, TimeControl when zero
+	WhiteIncrement   int             // seconds added after each of white's moves
+	BlackIncrement   int             // seconds added after each of black's moves
+	Handicap         models.Handicap // material HandicapSide starts without
+	HandicapSide     string
+	Casual           bool // keeps a game without odds out of ratings too
+}
 
-	if err := gs.db.Create(game).Error; err != nil {
+func (gs *GameService) CreateGame(arenaID uuid.UUID, playerID uuid.UUID, opts GameOptions) (*models.Game, error) {
+	game, err := newGame(arenaID, playerID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The game row and the first event of its log are written together
+	err = gs.db.Transaction(func(tx *gorm.DB) error {
+		return insertGame(tx, game)
+	})
+	if err != nil {
 		return nil, fmt.Errorf("failed to create game: %w", err)
 	}
 
@@ -46,116 +134,83 @@ func (gs *GameService) CreateGame(arenaID uuid.UUID, playerID uuid.UUID) (*model
 	return game, nil
 }
 
-func (gs *GameService) JoinGame(gameID uuid.UUID, playerID uuid.UUID) (*models.Game, error) {
+// GetGame loads a game with its players and moves, ordered by move number.
+func (gs *GameService) GetGame(gameID uuid.UUID) (*models.Game, error) {
 	var game models.Game
-	if err := gs.db.First(&game, "id = ?", gameID).Error; err != nil {
-		return nil, fmt.Errorf("game not found: %w", err)
-	}
-
-	if game.Status != models.GameStatusWaiting {
-		return nil, fmt.Errorf("game is not available to join")
-	}
-
-	if game.WhitePlayerID != nil && *game.WhitePlayerID == playerID {
-		return nil, fmt.Errorf("player already in game")
+	err := gs.db.Preload("WhitePlayer").Preload("BlackPlayer").
+		Preload("Moves", func(db *gorm.DB) *gorm.DB {
+			return db.Order("move_number ASC")
+		}).
+		Preload("Moves.Annotations").
+		First(&game, "id = ?", gameID).Error
+	if err != nil {
+		return nil, lookupError(err)
 	}
 
-	// Assign as black player
-	game.BlackPlayerID = &playerID
-	game.Status = models.GameStatusActive
-	now := time.Now()
-	game.StartedAt = &now
+	return &game, nil
+}
 
-	if err := gs.db.Save(&game).Error; err != nil {
-		return nil, fmt.Errorf("failed to join game: %w", err)
+// ReplayGame rebuilds a game from its event log as it stood after ply
+// half-moves, or as it stands now when ply is negative.
+func (gs *GameService) ReplayGame(gameID uuid.UUID, ply int) (*GameReplay, error) {
+	replay, err := gs.events.Rebuild(gameID, ply)
+	if errors.Is(err, ErrNoEventLog) {
+		return nil, ErrGameNotFound
 	}
+	return replay, err
+}
 
-	// Update cache
-	gs.cacheGameState(&game)
-
-	return &game, nil
+func (gs *GameService) JoinGame(gameID uuid.UUID, playerID uuid.UUID) (*models.Game, error) {
+	result := gs.dispatch(gameID, gameCommand{kind: commandJoin, playerID: playerID})
+	return result.game, result.err
 }
 
+// MakeMove validates and plays a move through the game's actor.
 func (gs *GameService) MakeMove(gameID uuid.UUID, playerID uuid.UUID, from, to string) (*models.GameMove, error) {
-	// Get game from cache first
-	game, err := gs.getGameFromCache(gameID)
-	if err != nil {
-		// Fallback to database
-		if err := gs.db.Preload("WhitePlayer").Preload("BlackPlayer").First(&game, "id = ?", gameID).Error; err != nil {
-			return nil, fmt.Errorf("game not found: %w", err)
-		}
-	}
-
-	// Validate player's turn
-	if !gs.isPlayerTurn(&game, playerID) {
-		return nil, fmt.Errorf("not player's turn")
-	}
+	result := gs.dispatch(gameID, gameCommand{kind: commandMove, playerID: playerID, from: from, to: to})
+	return result.move, result.err
+}
 
-	// Validate and execute move using chess engine
-	chessEngine := chess.NewEngine(game.BoardState)
-	move, err := chessEngine.ValidateMove(from, to)
-	if err != nil {
-		return nil, fmt.Errorf("invalid move: %w", err)
-	}
-
-	// Create move record
-	gameMove := &models.GameMove{
-		GameID:        gameID,
-		PlayerID:      playerID,
-		MoveNumber:    game.MoveCount + 1,
-		FromSquare:    from,
-		ToSquare:      to,
-		Piece:         move.Piece,
-		CapturedPiece: move.CapturedPiece,
-		Promotion:     move.Promotion,
-		IsCheck:       move.IsCheck,
-		IsCheckmate:   move.IsCheckmate,
-		IsStalemate:   move.IsStalemate,
-		Notation:      move.Notation,
-		FENAfter:      move.FENAfter,
-	}
-
-	// Update game state
-	game.BoardState = move.FENAfter
-	game.MoveCount++
-	game.CurrentTurn = gs.getOpponentColor(game.CurrentTurn)
-
-	// Handle game end conditions
-	if move.IsCheckmate || move.IsStalemate {
-		game.Status = models.GameStatusFinished
-		now := time.Now()
-		game.FinishedAt = &now
-
-		if move.IsCheckmate {
-			if game.CurrentTurn == "white" {
-				game.Result = &[]models.GameResult{models.GameResultWhiteWins}[0]
-			} else {
-				game.Result = &[]models.GameResult{models.GameResultBlackWins}[0]
-			}
-		} else {
-			game.Result = &[]models.GameResult{models.GameResultDraw}[0]
-		}
-	}
+// MakeDrop puts a piece from the player's hand on to, in crazyhouse games.
+func (gs *GameService) MakeDrop(gameID uuid.UUID, playerID uuid.UUID, piece, to string) (*models.GameMove, error) {
+	result := gs.dispatch(gameID, gameCommand{kind: commandMove, playerID: playerID, drop: piece, to: to})
+	return result.move, result.err
+}
 
-	// Save to database
-	tx := gs.db.Begin()
-	if err := tx.Create(gameMove).Error; err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("failed to save move: %w", err)
+// PlayMove is MakeMove, or MakeDrop when drop names a piece, that also
+// returns the game as the move left it.
+func (gs *GameService) PlayMove(gameID uuid.UUID, playerID uuid.UUID, from, to, drop string) (*models.GameMove, *models.Game, error) {
+	cmd := gameCommand{kind: commandMove, playerID: playerID, from: from, to: to, drop: drop}
+	if drop != "" {
+		cmd.from = ""
 	}
-	if err := tx.Save(&game).Error; err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("failed to update game: %w", err)
+	result := gs.dispatch(gameID, cmd)
+	if result.err != nil {
+		return nil, nil, result.err
 	}
-	tx.Commit()
+	return result.move, result.game, nil
+}
 
-	// Update cache
-	gs.cacheGameState(&game)
+// OfferDraw records a draw offer from playerID. If the opponent already
+// offered a draw, the game is drawn immediately.
+func (gs *GameService) OfferDraw(gameID uuid.UUID, playerID uuid.UUID) (*models.Game, error) {
+	result := gs.dispatch(gameID, gameCommand{kind: commandOfferDraw, playerID: playerID})
+	return result.game, result.err
+}
+
+func (gs *GameService) AcceptDraw(gameID uuid.UUID, playerID uuid.UUID) (*models.Game, error) {
+	result := gs.dispatch(gameID, gameCommand{kind: commandAcceptDraw, playerID: playerID})
+	return result.game, result.err
+}
 
-	// Publish move to Redis for real-time updates
-	gs.publishGameUpdate(gameID, "move", gameMove)
+func (gs *GameService) DeclineDraw(gameID uuid.UUID, playerID uuid.UUID) (*models.Game, error) {
+	result := gs.dispatch(gameID, gameCommand{kind: commandDeclineDraw, playerID: playerID})
+	return result.game, result.err
+}
 
-	return gameMove, nil
+func (gs *GameService) Resign(gameID uuid.UUID, playerID uuid.UUID) (*models.Game, error) {
+	result := gs.dispatch(gameID, gameCommand{kind: commandResign, playerID: playerID})
+	return result.game, result.err
 }
 
 func (gs *GameService) GetActiveGames(arenaID uuid.UUID) ([]models.Game, error) {
@@ -187,17 +242,143 @@ func (gs *GameService) getGameFromCache(gameID uuid.UUID) (models.Game, error) {
 	return game, err
 }
 
-func (gs *GameService) isPlayerTurn(game *models.Game, playerID uuid.UUID) bool {
-	if game.CurrentTurn == "white" && game.WhitePlayerID != nil && *game.WhitePlayerID == playerID {
-		return true
+// currentGame returns the game as cached, or from the database when the
+// cache misses.
+func (gs *GameService) currentGame(gameID uuid.UUID) (models.Game, error) {
+	game, err := gs.getGameFromCache(gameID)
+	if err != nil {
+		if err := gs.db.Take(&game, "id = ?", gameID).Error; err != nil {
+			return game, lookupError(err)
+		}
+	}
+	return game, nil
+}
+
+// newGame builds a game in which white waits for an opponent.
+func newGame(arenaID, white uuid.UUID, opts GameOptions) (*models.Game, error) {
+	variant := opts.Variant
+	if variant == "" {
+		variant = models.GameVariantStandard
+	}
+	startFEN, err := startingPosition(variant)
+	if err != nil {
+		return nil, err
+	}
+	timeControl := opts.TimeControl
+	if timeControl <= 0 {
+		timeControl = 600 // 10 minutes
+	}
+	if opts.DaysPerMove != 0 {
+		if opts.DaysPerMove < minDaysPerMove || opts.DaysPerMove > maxDaysPerMove {
+			return nil, ErrInvalidDaysPerMove
+		}
+		timeControl = opts.DaysPerMove * secondsPerDay
+	}
+	if opts.VoteWindow != 0 {
+		if opts.VoteWindow < minVoteWindow || opts.VoteWindow > maxVoteWindow || opts.DaysPerMove != 0 {
+			return nil, ErrInvalidVoteWindow
+		}
+	}
+
+	game := &models.Game{
+		ArenaID:       arenaID,
+		Variant:       variant,
+		WhitePlayerID: &white,
+		Status:        models.GameStatusWaiting,
+		BoardState:    startFEN,
+		TimeControl:   timeControl,
+		DaysPerMove:   opts.DaysPerMove,
+		VoteWindow:    opts.VoteWindow,
+		UntimedSide:   opts.UntimedSide,
+		SimulID:       opts.SimulID,
+		WhiteTime:     timeControl,
+		BlackTime:     timeControl,
+	}
+	game.BlackTimeControl = timeControl
+	if err := applyOdds(game, opts); err != nil {
+		return nil, err
+	}
+	return game, nil
+}
+
+// insertGame writes a new game together with the first event of its log.
+func insertGame(tx *gorm.DB, game *models.Game) error {
+	if err := tx.Create(game).Error; err != nil {
+		return err
 	}
-	if game.CurrentTurn == "black" && game.BlackPlayerID != nil && *game.BlackPlayerID == playerID {
-		return true
+	event := newGameEvent(models.GameEventCreated, game.WhitePlayerID, createdPayload{
+		ArenaID:       game.ArenaID,
+		Variant:       game.Variant,
+		WhitePlayerID: game.WhitePlayerID,
+		TimeControl:   game.TimeControl,
+		DaysPerMove:   game.DaysPerMove,
+		VoteWindow:    game.VoteWindow,
+		UntimedSide:   game.UntimedSide,
+		SimulID:       game.SimulID,
+		BoardState:    game.BoardState,
+
+		BlackTimeControl: game.BlackTimeControl,
+		WhiteIncrement:   game.WhiteIncrement,
+		BlackIncrement:   game.BlackIncrement,
+		Handicap:         game.Handicap,
+		HandicapSide:     game.HandicapSide,
+		Casual:           game.Casual,
+	})
+	return appendEvent(tx, game, event, game.CreatedAt)
+}
+
+// seatBlack starts a game that was just inserted with black as white's
+// opponent, as if black had joined it, for games that are arranged rather
+// than joined.
+func seatBlack(tx *gorm.DB, game *models.Game, black uuid.UUID, now time.Time) error {
+	game.BlackPlayerID = &black
+	game.Status = models.GameStatusActive
+	game.StartedAt = &now
+	game.LastMoveAt = &now
+	if err := updateGameVersioned(tx, game, now); err != nil {
+		return err
 	}
-	return false
+	return appendEvent(tx, game, newGameEvent(models.GameEventJoined, &black, nil), now)
 }
 
-func (gs *GameService) getOpponentColor(currentTurn string) string {
+// startingPosition returns the FEN a game of variant starts from.
+func startingPosition(variant models.GameVariant) (string, error) {
+	switch variant {
+	case models.GameVariantStandard:
+		return chess.StandardStartFEN, nil
+	case models.GameVariantCrazyhouse:
+		return chess.CrazyhouseStartFEN, nil
+	default:
+		return "", ErrUnknownVariant
+	}
+}
+
+// classifyOpening tags game with the opening its current position belongs
+// to. Positions outside the table leave the previous tag in place, so a game
+// keeps the name of the deepest known position it reached.
+func classifyOpening(game *models.Game) {
+	if opening, ok := openings.Lookup(game.BoardState); ok {
+		game.ECO = opening.ECO
+		game.OpeningName = opening.Name
+	}
+}
+
+// playerColor returns the color playerID plays in game, or "" if they don't.
+func playerColor(game *models.Game, playerID uuid.UUID) string {
+	if game.WhitePlayerID != nil && *game.WhitePlayerID == playerID {
+		return "white"
+	}
+	if game.BlackPlayerID != nil && *game.BlackPlayerID == playerID {
+		return "black"
+	}
+	return ""
+}
+
+func isPlayerTurn(game *models.Game, playerID uuid.UUID) bool {
+	return playerColor(game, playerID) == game.CurrentTurn
+}
+
+func getOpponentColor(currentTurn string) string {
 	if currentTurn == "white" {
 		return "black"
 	}
@@ -205,6 +386,12 @@ func (gs *GameService) getOpponentColor(currentTurn string) string {
 }
 
 func (gs *GameService) publishGameUpdate(gameID uuid.UUID, eventType string, data interface{}) {
+	publishGameUpdate(gs.redis, gameID, eventType, data)
+}
+
+// publishGameUpdate sends an update to the game's channel, which the hub
+// bridge relays to the players and spectators in the game room.
+func publishGameUpdate(client *redis.Client, gameID uuid.UUID, eventType string, data interface{}) {
 	ctx := context.Background()
 	update := map[string]interface{}{
 		"game_id":    gameID,
@@ -214,5 +401,14 @@ func (gs *GameService) publishGameUpdate(gameID uuid.UUID, eventType string, dat
 	}
 	
 	updateJSON, _ := json.Marshal(update)
-	gs.redis.Publish(ctx, fmt.Sprintf("game:%s", gameID), updateJSON)
-}
\ No newline at end of file
+	client.Publish(ctx, fmt.Sprintf("game:%s", gameID), updateJSON)
+}
+
+// lookupError maps a failed game lookup to ErrGameNotFound when the record
+// does not exist, and wraps anything else as a database failure.
+func lookupError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrGameNotFound
+	}
+	return fmt.Errorf("failed to load game: %w", err)
+}
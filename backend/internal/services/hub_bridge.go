@@ -0,0 +1,381 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// HubBridge connects a Hub to the hubs of other backend instances through
+// Redis pub/sub. Each instance subscribes only to the rooms and users it has
+// local clients for, delivers its own messages locally straight away and
+// ignores them when they come back from Redis.
+//
+// Presence is kept in Redis hashes keyed by "<instance>|<user>" so that the
+// entries of an instance that died without cleaning up can be recognised by
+// its expired heartbeat key and ignored.
+
+const (
+	roomChannelPrefix  = "ws:room:"
+	userChannelPrefix  = "ws:user:"
+	gameChannelPattern = "game:*"
+
+	roomPresencePrefix = "ws:presence:room:"
+	onlinePresenceKey  = "ws:presence:online"
+	instanceKeyPrefix  = "ws:instance:"
+
+	instanceHeartbeat = 10 * time.Second
+	instanceTTL       = 3 * instanceHeartbeat
+	dedupCacheSize    = 4096
+)
+
+type bridgeEnvelope struct {
+	ID      string  `json:"id"`
+	Origin  string  `json:"origin"`
+	Room    string  `json:"room,omitempty"`
+	UserID  string  `json:"user_id,omitempty"`
+	Message Message `json:"message"`
+	// Exclude lists users a room message must not be delivered to
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+type HubBridge struct {
+	hub        *Hub
+	redis      *redis.Client
+	instanceID string
+	seen       *dedupCache
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	pubsub *redis.PubSub
+	wg     sync.WaitGroup
+}
+
+// GameRoom is the Hub room that carries a game's live updates.
+func GameRoom(gameID string) string {
+	return "game:" + gameID
+}
+
+func NewHubBridge(hub *Hub, redisClient *redis.Client) *HubBridge {
+	return &HubBridge{
+		hub:        hub,
+		redis:      redisClient,
+		instanceID: uuid.New().String(),
+		seen:       newDedupCache(dedupCacheSize),
+	}
+}
+
+// Start subscribes to Redis and attaches the bridge to its hub. Rooms and
+// users that already have local clients are picked up as well.
+func (b *HubBridge) Start(ctx context.Context) error {
+	b.ctx, b.cancel = context.WithCancel(ctx)
+
+	b.pubsub = b.redis.PSubscribe(b.ctx, gameChannelPattern)
+	if _, err := b.pubsub.Receive(b.ctx); err != nil {
+		b.cancel()
+		return fmt.Errorf("failed to subscribe to game updates: %w", err)
+	}
+
+	if err := b.beat(); err != nil {
+		b.cancel()
+		return fmt.Errorf("failed to register instance: %w", err)
+	}
+
+	b.hub.bridge.Store(b)
+
+	b.hub.mutex.RLock()
+	var channels []string
+	users := make(map[string]bool)
+	for roomID, clients := range b.hub.Rooms {
+		channels = append(channels, roomChannelPrefix+roomID)
+		for client := range clients {
+			b.addPresence(roomPresencePrefix+roomID, client.UserID)
+		}
+	}
+	for client := range b.hub.Clients {
+		users[client.UserID] = true
+	}
+	b.hub.mutex.RUnlock()
+
+	for userID := range users {
+		b.userConnected(userID)
+	}
+	if len(channels) > 0 {
+		if err := b.pubsub.Subscribe(b.ctx, channels...); err != nil {
+			log.Printf("Error subscribing to rooms: %v", err)
+		}
+	}
+
+	b.wg.Add(2)
+	go b.listen()
+	go b.heartbeat()
+
+	return nil
+}
+
+// Close detaches the bridge and removes this instance's presence entries.
+func (b *HubBridge) Close() error {
+	b.hub.bridge.CompareAndSwap(b, nil)
+	b.cancel()
+	err := b.pubsub.Close()
+	b.wg.Wait()
+
+	ctx := context.Background()
+	b.hub.mutex.RLock()
+	for roomID, clients := range b.hub.Rooms {
+		for client := range clients {
+			b.redis.HDel(ctx, roomPresencePrefix+roomID, b.presenceField(client.UserID))
+		}
+	}
+	for client := range b.hub.Clients {
+		b.redis.HDel(ctx, onlinePresenceKey, b.presenceField(client.UserID))
+	}
+	b.hub.mutex.RUnlock()
+	b.redis.Del(ctx, instanceKeyPrefix+b.instanceID)
+
+	return err
+}
+
+func (b *HubBridge) listen() {
+	defer b.wg.Done()
+
+	for msg := range b.pubsub.Channel() {
+		if msg.Pattern == gameChannelPattern {
+			b.forwardGameUpdate(msg)
+			continue
+		}
+
+		var envelope bridgeEnvelope
+		if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil {
+			log.Printf("Error unmarshaling bridge message: %v", err)
+			continue
+		}
+
+		// Our own messages were delivered locally when they were sent
+		if envelope.Origin == b.instanceID || !b.seen.add(envelope.ID) {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(msg.Channel, roomChannelPrefix):
+			b.hub.deliverToRoom(envelope.Room, envelope.Message, envelope.Exclude)
+		case strings.HasPrefix(msg.Channel, userChannelPrefix):
+			b.hub.deliverToUser(envelope.UserID, envelope.Message)
+		}
+	}
+}
+
+// forwardGameUpdate hands an event published by GameService to the local
+// clients in the game's room. Every instance receives it once from Redis, so
+// it is never re-published.
+func (b *HubBridge) forwardGameUpdate(msg *redis.Message) {
+	gameID := strings.TrimPrefix(msg.Channel, "game:")
+	room := GameRoom(gameID)
+	b.hub.deliverToRoom(room, Message{
+		Type: "game_update",
+		Room: room,
+		Data: json.RawMessage(msg.Payload),
+	}, nil)
+}
+
+func (b *HubBridge) heartbeat() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(instanceHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.beat(); err != nil {
+				log.Printf("Error refreshing instance heartbeat: %v", err)
+			}
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *HubBridge) beat() error {
+	return b.redis.Set(b.ctx, instanceKeyPrefix+b.instanceID, time.Now().Unix(), instanceTTL).Err()
+}
+
+func (b *HubBridge) publishRoom(roomID string, message Message, exclude []string) {
+	b.publish(roomChannelPrefix+roomID, bridgeEnvelope{Room: roomID, Message: message, Exclude: exclude})
+}
+
+func (b *HubBridge) publishUser(userID string, message Message) {
+	b.publish(userChannelPrefix+userID, bridgeEnvelope{UserID: userID, Message: message})
+}
+
+func (b *HubBridge) publish(channel string, envelope bridgeEnvelope) {
+	envelope.ID = uuid.New().String()
+	envelope.Origin = b.instanceID
+
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		log.Printf("Error marshaling bridge message: %v", err)
+		return
+	}
+	if err := b.redis.Publish(b.ctx, channel, payload).Err(); err != nil {
+		log.Printf("Error publishing to %s: %v", channel, err)
+	}
+}
+
+func (b *HubBridge) roomJoined(roomID, userID string, firstLocal bool) {
+	if firstLocal {
+		if err := b.pubsub.Subscribe(b.ctx, roomChannelPrefix+roomID); err != nil {
+			log.Printf("Error subscribing to room %s: %v", roomID, err)
+		}
+	}
+	b.addPresence(roomPresencePrefix+roomID, userID)
+}
+
+func (b *HubBridge) roomLeft(roomID, userID string, lastLocal bool) {
+	if lastLocal {
+		if err := b.pubsub.Unsubscribe(b.ctx, roomChannelPrefix+roomID); err != nil {
+			log.Printf("Error unsubscribing from room %s: %v", roomID, err)
+		}
+	}
+	b.removePresence(roomPresencePrefix+roomID, userID)
+}
+
+func (b *HubBridge) userConnected(userID string) {
+	if b.addPresence(onlinePresenceKey, userID) == 1 {
+		if err := b.pubsub.Subscribe(b.ctx, userChannelPrefix+userID); err != nil {
+			log.Printf("Error subscribing to user %s: %v", userID, err)
+		}
+	}
+}
+
+func (b *HubBridge) userDisconnected(userID string) {
+	if b.removePresence(onlinePresenceKey, userID) == 0 {
+		if err := b.pubsub.Unsubscribe(b.ctx, userChannelPrefix+userID); err != nil {
+			log.Printf("Error unsubscribing from user %s: %v", userID, err)
+		}
+	}
+}
+
+func (b *HubBridge) presenceField(userID string) string {
+	return b.instanceID + "|" + userID
+}
+
+// addPresence counts one more local connection of userID under key and
+// returns the new count for this instance.
+func (b *HubBridge) addPresence(key, userID string) int64 {
+	count, err := b.redis.HIncrBy(b.ctx, key, b.presenceField(userID), 1).Result()
+	if err != nil {
+		log.Printf("Error updating presence %s: %v", key, err)
+	}
+	return count
+}
+
+func (b *HubBridge) removePresence(key, userID string) int64 {
+	field := b.presenceField(userID)
+	count, err := b.redis.HIncrBy(b.ctx, key, field, -1).Result()
+	if err != nil {
+		log.Printf("Error updating presence %s: %v", key, err)
+		return 0
+	}
+	if count <= 0 {
+		b.redis.HDel(b.ctx, key, field)
+		return 0
+	}
+	return count
+}
+
+// roomPresence lists the users in a room on any live instance.
+func (b *HubBridge) roomPresence(roomID string) ([]string, error) {
+	return b.presentUsers(roomPresencePrefix + roomID)
+}
+
+// IsUserOnline reports whether userID has a connection on any live instance.
+func (b *HubBridge) IsUserOnline(userID string) (bool, error) {
+	users, err := b.presentUsers(onlinePresenceKey)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range users {
+		if id == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (b *HubBridge) presentUsers(key string) ([]string, error) {
+	entries, err := b.redis.HGetAll(b.ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	alive := make(map[string]bool)
+	seen := make(map[string]bool)
+	users := []string{}
+	for field := range entries {
+		instanceID, userID, ok := strings.Cut(field, "|")
+		if !ok || seen[userID] {
+			continue
+		}
+
+		live, checked := alive[instanceID]
+		if !checked {
+			exists, err := b.redis.Exists(b.ctx, instanceKeyPrefix+instanceID).Result()
+			if err != nil {
+				return nil, err
+			}
+			live = exists == 1
+			alive[instanceID] = live
+		}
+
+		if live {
+			seen[userID] = true
+			users = append(users, userID)
+		}
+	}
+
+	sort.Strings(users)
+	return users, nil
+}
+
+// dedupCache remembers the most recent message IDs in a fixed-size ring.
+type dedupCache struct {
+	mutex sync.Mutex
+	ids   map[string]struct{}
+	ring  []string
+	next  int
+}
+
+func newDedupCache(size int) *dedupCache {
+	return &dedupCache{
+		ids:  make(map[string]struct{}, size),
+		ring: make([]string, size),
+	}
+}
+
+// add records id and reports whether it had not been seen before.
+func (d *dedupCache) add(id string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.ids[id]; ok {
+		return false
+	}
+
+	if old := d.ring[d.next]; old != "" {
+		delete(d.ids, old)
+	}
+	d.ring[d.next] = id
+	d.ids[id] = struct{}{}
+	d.next = (d.next + 1) % len(d.ring)
+
+	return true
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"arcane-chess/internal/testutil"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBridgedHub starts a hub attached to the shared miniredis, the way one
+// backend instance would run it.
+func newBridgedHub(t *testing.T, server *miniredis.Miniredis) (*Hub, *HubBridge) {
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	hub := NewHub()
+	go hub.Run()
+
+	bridge := NewHubBridge(hub, client)
+	require.NoError(t, bridge.Start(context.Background()))
+	t.Cleanup(func() {
+		bridge.Close()
+		client.Close()
+	})
+
+	return hub, bridge
+}
+
+// connectTestClient registers a signed-in client for userID.
+func connectTestClient(t *testing.T, hub *Hub, userID string) *Client {
+	client := &Client{
+		ID:            uuid.New().String(),
+		UserID:        userID,
+		Send:          make(chan []byte, 16),
+		Hub:           hub,
+		Authenticated: true,
+	}
+	hub.Register <- client
+	expectMessage(t, client, "connection_established")
+	return client
+}
+
+func expectMessage(t *testing.T, client *Client, messageType string) Message {
+	t.Helper()
+
+	select {
+	case raw := <-client.Send:
+		var message Message
+		require.NoError(t, json.Unmarshal(raw, &message))
+		require.Equal(t, messageType, message.Type)
+		return message
+	case <-time.After(2 * time.Second):
+		t.Fatalf("client %s did not receive a %s message", client.UserID, messageType)
+		return Message{}
+	}
+}
+
+func expectNoMessage(t *testing.T, client *Client) {
+	t.Helper()
+
+	select {
+	case raw := <-client.Send:
+		t.Fatalf("client %s received unexpected message %s", client.UserID, raw)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func waitForSubscribers(t *testing.T, server *miniredis.Miniredis, channel string, count int) {
+	require.Eventually(t, func() bool {
+		return server.PubSubNumSub(channel)[channel] == count
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestHubBridge_RoomBroadcastAcrossInstances(t *testing.T) {
+	_, server := testutil.MockRedis(t)
+	defer testutil.CleanupRedis(server)
+
+	hubA, _ := newBridgedHub(t, server)
+	hubB, _ := newBridgedHub(t, server)
+
+	alice := connectTestClient(t, hubA, "alice")
+	bob := connectTestClient(t, hubB, "bob")
+	hubA.JoinRoom(alice, "arena-1")
+	hubB.JoinRoom(bob, "arena-1")
+	waitForSubscribers(t, server, roomChannelPrefix+"arena-1", 2)
+
+	hubA.BroadcastToRoom("arena-1", Message{
+		Type: "chat_message",
+		Room: "arena-1",
+		Data: ChatMessage{UserID: "alice", Message: "hello from A"},
+	})
+
+	expectMessage(t, alice, "chat_message")
+	received := expectMessage(t, bob, "chat_message")
+	assert.Equal(t, "hello from A", received.Data.(map[string]interface{})["message"])
+
+	// Neither side sees the message a second time
+	expectNoMessage(t, alice)
+	expectNoMessage(t, bob)
+}
+
+func TestHubBridge_UnsubscribesWhenRoomEmpties(t *testing.T) {
+	_, server := testutil.MockRedis(t)
+	defer testutil.CleanupRedis(server)
+
+	hubA, _ := newBridgedHub(t, server)
+	alice := connectTestClient(t, hubA, "alice")
+
+	hubA.JoinRoom(alice, "arena-1")
+	waitForSubscribers(t, server, roomChannelPrefix+"arena-1", 1)
+
+	hubA.LeaveRoom(alice, "arena-1")
+	waitForSubscribers(t, server, roomChannelPrefix+"arena-1", 0)
+}
+
+func TestHubBridge_SendToUserOnOtherInstance(t *testing.T) {
+	_, server := testutil.MockRedis(t)
+	defer testutil.CleanupRedis(server)
+
+	hubA, _ := newBridgedHub(t, server)
+	hubB, _ := newBridgedHub(t, server)
+
+	alice := connectTestClient(t, hubA, "alice")
+	bob := connectTestClient(t, hubB, "bob")
+	waitForSubscribers(t, server, userChannelPrefix+"bob", 1)
+
+	hubA.SendToUser("bob", Message{Type: "challenge", Data: map[string]string{"from": "alice"}})
+
+	expectMessage(t, bob, "challenge")
+	expectNoMessage(t, alice)
+	expectNoMessage(t, bob)
+}
+
+func TestHubBridge_DropsDuplicateMessages(t *testing.T) {
+	redisClient, server := testutil.MockRedis(t)
+	defer testutil.CleanupRedis(server)
+
+	hubB, _ := newBridgedHub(t, server)
+	bob := connectTestClient(t, hubB, "bob")
+	hubB.JoinRoom(bob, "arena-1")
+	waitForSubscribers(t, server, roomChannelPrefix+"arena-1", 1)
+
+	payload, err := json.Marshal(bridgeEnvelope{
+		ID:      uuid.New().String(),
+		Origin:  "another-instance",
+		Room:    "arena-1",
+		Message: Message{Type: "chat_message", Room: "arena-1"},
+	})
+	require.NoError(t, err)
+
+	// The same envelope delivered twice, e.g. by a retrying publisher
+	redisClient.Publish(context.Background(), roomChannelPrefix+"arena-1", payload)
+	redisClient.Publish(context.Background(), roomChannelPrefix+"arena-1", payload)
+
+	expectMessage(t, bob, "chat_message")
+	expectNoMessage(t, bob)
+}
+
+func TestHubBridge_PresenceAcrossInstances(t *testing.T) {
+	_, server := testutil.MockRedis(t)
+	defer testutil.CleanupRedis(server)
+
+	hubA, _ := newBridgedHub(t, server)
+	hubB, bridgeB := newBridgedHub(t, server)
+
+	alice := connectTestClient(t, hubA, "alice")
+	bob := connectTestClient(t, hubB, "bob")
+	hubA.JoinRoom(alice, "arena-1")
+	hubB.JoinRoom(bob, "arena-1")
+
+	assert.Equal(t, []string{"alice", "bob"}, hubA.RoomMembers("arena-1"))
+	assert.Equal(t, []string{"alice", "bob"}, hubB.RoomMembers("arena-1"))
+	assert.True(t, hubA.IsUserOnline("bob"))
+
+	hubB.LeaveRoom(bob, "arena-1")
+	assert.Equal(t, []string{"alice"}, hubA.RoomMembers("arena-1"))
+
+	// An instance whose heartbeat expired no longer counts
+	hubB.JoinRoom(bob, "arena-1")
+	server.Del(instanceKeyPrefix + bridgeB.instanceID)
+	assert.Equal(t, []string{"alice"}, hubA.RoomMembers("arena-1"))
+	assert.False(t, hubA.IsUserOnline("bob"))
+}
+
+func TestHubBridge_ForwardsGameUpdatesToGameRoom(t *testing.T) {
+	redisClient, server := testutil.MockRedis(t)
+	defer testutil.CleanupRedis(server)
+
+	hubA, _ := newBridgedHub(t, server)
+	hubB, _ := newBridgedHub(t, server)
+
+	gameID := uuid.New()
+	alice := connectTestClient(t, hubA, "alice")
+	bob := connectTestClient(t, hubB, "bob")
+	hubA.JoinRoom(alice, GameRoom(gameID.String()))
+	hubB.JoinRoom(bob, GameRoom(gameID.String()))
+	require.Eventually(t, func() bool { return server.PubSubNumPat() == 2 }, 2*time.Second, 10*time.Millisecond)
+
+	gameService := NewGameService(nil, redisClient)
+	gameService.publishGameUpdate(gameID, "move", map[string]string{"notation": "e4"})
+
+	for _, client := range []*Client{alice, bob} {
+		update := expectMessage(t, client, "game_update")
+		assert.Equal(t, GameRoom(gameID.String()), update.Room)
+		assert.Equal(t, "move", update.Data.(map[string]interface{})["event_type"])
+		expectNoMessage(t, client)
+	}
+}
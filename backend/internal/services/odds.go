@@ -0,0 +1,122 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+)
+
+// Odds games let a stronger player give a weaker one a head start: material,
+// by starting without a piece, or time, with a shorter clock or a smaller
+// increment. Either makes the game casual. Pawn and move also gives the
+// receiver the first move, so an odds game may start with black to move.
+
+const maxIncrement = 180 // seconds
+
+var ErrInvalidOdds = errors.New("invalid odds")
+
+// handicapSquares is where each handicap's material starts, for white; black's
+// is on the mirrored square.
+var handicapSquares = map[models.Handicap]string{
+	models.HandicapKnight:      "b1",
+	models.HandicapRook:        "a1",
+	models.HandicapQueen:       "d1",
+	models.HandicapPawnAndMove: "f2",
+}
+
+// oddsPosition returns fen with giver ("white" or "black") starting without
+// the handicap's material.
+func oddsPosition(fen string, handicap models.Handicap, giver string) (string, error) {
+	square, ok := handicapSquares[handicap]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown handicap %q", ErrInvalidOdds, handicap)
+	}
+	if giver != "white" && giver != "black" {
+		return "", fmt.Errorf("%w: the handicap must be given by white or black", ErrInvalidOdds)
+	}
+	if giver == "black" {
+		square = square[:1] + string('9'-square[1]+'0')
+	}
+
+	fen, err := chess.RemovePieces(fen, square)
+	if err != nil {
+		return "", err
+	}
+	if handicap == models.HandicapPawnAndMove {
+		fen = chess.WithSideToMove(fen, getOpponentColor(giver))
+	}
+	return fen, nil
+}
+
+// gameStartPosition returns the position game started from, handicap
+// included.
+func gameStartPosition(game *models.Game) (string, error) {
+	variant := game.Variant
+	if variant == "" {
+		variant = models.GameVariantStandard
+	}
+	fen, err := startingPosition(variant)
+	if err != nil || game.Handicap == "" {
+		return fen, err
+	}
+	return oddsPosition(fen, game.Handicap, game.HandicapSide)
+}
+
+// applyOdds sets up game with the clocks and handicap of opts.
+func applyOdds(game *models.Game, opts GameOptions) error {
+	if opts.WhiteIncrement < 0 || opts.WhiteIncrement > maxIncrement ||
+		opts.BlackIncrement < 0 || opts.BlackIncrement > maxIncrement || opts.BlackTimeControl < 0 {
+		return fmt.Errorf("%w: increments must be between 0 and %d seconds", ErrInvalidOdds, maxIncrement)
+	}
+	if game.DaysPerMove > 0 && (opts.BlackTimeControl > 0 || opts.WhiteIncrement > 0 || opts.BlackIncrement > 0) {
+		return fmt.Errorf("%w: correspondence games have no clock to give odds on", ErrInvalidOdds)
+	}
+
+	if opts.BlackTimeControl > 0 {
+		game.BlackTimeControl = opts.BlackTimeControl
+		game.BlackTime = opts.BlackTimeControl
+	}
+	game.WhiteIncrement = opts.WhiteIncrement
+	game.BlackIncrement = opts.BlackIncrement
+
+	if opts.Handicap != "" {
+		fen, err := oddsPosition(game.BoardState, opts.Handicap, opts.HandicapSide)
+		if err != nil {
+			return err
+		}
+		game.BoardState = fen
+		game.CurrentTurn = sideToMove(fen)
+		game.Handicap = opts.Handicap
+		game.HandicapSide = opts.HandicapSide
+	}
+
+	game.Casual = opts.Casual || game.HasOdds()
+	return nil
+}
+
+// startingTime returns the seconds color's clock started with.
+func startingTime(game *models.Game, color string) int {
+	if color == "black" && game.BlackTimeControl > 0 {
+		return game.BlackTimeControl
+	}
+	return game.TimeControl
+}
+
+// increment returns the seconds color gains after each of its moves.
+func increment(game *models.Game, color string) int {
+	if color == "black" {
+		return game.BlackIncrement
+	}
+	return game.WhiteIncrement
+}
+
+// sideToMove returns the color to move in fen.
+func sideToMove(fen string) string {
+	if fields := strings.Fields(fen); len(fields) > 1 && fields[1] == "b" {
+		return "black"
+	}
+	return "white"
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOddsPosition(t *testing.T) {
+	tests := []struct {
+		handicap models.Handicap
+		giver    string
+		want     string
+	}{
+		{models.HandicapKnight, "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/R1BQKBNR w KQkq - 0 1"},
+		{models.HandicapRook, "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/1NBQKBNR w Kkq - 0 1"},
+		{models.HandicapQueen, "black", "rnb1kbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"},
+		{models.HandicapPawnAndMove, "white", "rnbqkbnr/pppppppp/8/8/8/8/PPPPP1PP/RNBQKBNR b KQkq - 0 1"},
+		{models.HandicapPawnAndMove, "black", "rnbqkbnr/ppppp1pp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s gives %s", tt.giver, tt.handicap), func(t *testing.T) {
+			fen, err := oddsPosition(chess.StandardStartFEN, tt.handicap, tt.giver)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, fen)
+		})
+	}
+
+	_, err := oddsPosition(chess.StandardStartFEN, "bishop", "white")
+	assert.ErrorIs(t, err, ErrInvalidOdds)
+	_, err = oddsPosition(chess.StandardStartFEN, models.HandicapKnight, "")
+	assert.ErrorIs(t, err, ErrInvalidOdds)
+}
+
+func TestNewGame_Odds(t *testing.T) {
+	game, err := newGame(uuid.New(), uuid.New(), GameOptions{
+		TimeControl:      300,
+		BlackTimeControl: 180,
+		WhiteIncrement:   2,
+		Handicap:         models.HandicapPawnAndMove,
+		HandicapSide:     "white",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "black", game.CurrentTurn)
+	assert.Equal(t, 300, game.WhiteTime)
+	assert.Equal(t, 180, game.BlackTime)
+	assert.True(t, game.Casual)
+
+	// Even clocks and material are rated as usual
+	game, err = newGame(uuid.New(), uuid.New(), GameOptions{WhiteIncrement: 3, BlackIncrement: 3})
+	require.NoError(t, err)
+	assert.Equal(t, 600, game.BlackTimeControl)
+	assert.False(t, game.HasOdds())
+	assert.False(t, game.Casual)
+}
+
+func TestGameService_CreateGame_InvalidOdds(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+
+	for _, opts := range []GameOptions{
+		{WhiteIncrement: -1},
+		{BlackIncrement: maxIncrement + 1},
+		{BlackTimeControl: -60},
+		{DaysPerMove: 3, WhiteIncrement: 5},
+		{Handicap: models.HandicapKnight},
+		{Handicap: "king", HandicapSide: "white"},
+	} {
+		game, err := gameService.CreateGame(uuid.New(), uuid.New(), opts)
+		assert.ErrorIs(t, err, ErrInvalidOdds)
+		assert.Nil(t, game)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_MakeMove_AddsIncrement(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	white, black := uuid.New(), uuid.New()
+	game := cacheActiveGame(t, redisClient, white, black)
+	lastMove := time.Now().Add(-5 * time.Second)
+	game.LastMoveAt = &lastMove
+	game.WhiteIncrement = 3
+	gameJSON, _ := json.Marshal(game)
+	redisClient.Set(context.Background(), fmt.Sprintf("game:%s", game.ID), string(gameJSON), time.Hour)
+
+	expectMove(mock, game.ID, "black", 1)
+	move, err := gameService.MakeMove(game.ID, white, "e2", "e4")
+	require.NoError(t, err)
+	assert.Equal(t, 598, move.TimeLeft)
+
+	cached, err := gameService.getGameFromCache(game.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 598, cached.WhiteTime)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
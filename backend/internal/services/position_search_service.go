@@ -0,0 +1,176 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Position search finds the archived games that reached a position, a
+// material balance or a pawn structure. Every position a finished standard
+// game passed through is indexed once, when the game ends, by its Zobrist
+// key, its material signature and its pawn bitboards; a search returns each
+// matching game once, with the first ply at which it matched.
+
+const (
+	defaultPositionSearchLimit = 50
+	maxPositionSearchLimit     = 200
+)
+
+var ErrInvalidPositionQuery = errors.New("invalid position query")
+
+type PositionSearchService struct {
+	db *gorm.DB
+}
+
+// PositionQuery selects the positions to search for. Every criterion given
+// must hold in the same position.
+type PositionQuery struct {
+	FEN        string     // the exact position
+	Material   string     // a material signature, "R+P vs R" or "KRPvKR"
+	Pawns      string     // a piece placement whose pawns form the structure to find
+	ExactPawns bool       // the structure must be all the pawns on the board, not a part of them
+	After      *uuid.UUID // the NextCursor of the previous page
+	Limit      int
+}
+
+type PositionMatch struct {
+	GameID uuid.UUID `json:"game_id"`
+	Ply    int       `json:"ply"`
+}
+
+// PositionSearchResult is one page of matches, ordered by game ID. NextCursor
+// is set when there are more.
+type PositionSearchResult struct {
+	Matches    []PositionMatch `json:"matches"`
+	NextCursor *uuid.UUID      `json:"next_cursor,omitempty"`
+}
+
+func NewPositionSearchService(db *gorm.DB) *PositionSearchService {
+	return &PositionSearchService{db: db}
+}
+
+// Search returns a page of the games that reached a position matching query.
+func (ps *PositionSearchService) Search(query PositionQuery) (*PositionSearchResult, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultPositionSearchLimit
+	}
+	limit = min(limit, maxPositionSearchLimit)
+
+	if query.FEN == "" && query.Material == "" && query.Pawns == "" {
+		return nil, fmt.Errorf("%w: give a position, material or pawn structure", ErrInvalidPositionQuery)
+	}
+
+	scope := ps.db.Model(&models.GamePosition{})
+	if query.FEN != "" {
+		if err := chess.ValidateFEN(query.FEN); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPositionQuery, err)
+		}
+		scope = scope.Where("zobrist_key = ?", int64(chess.PolyglotKey(query.FEN)))
+	}
+	if query.Material != "" {
+		signature, err := chess.ParseMaterialSignature(query.Material)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPositionQuery, err)
+		}
+		scope = scope.Where("material = ?", signature)
+	}
+	if query.Pawns != "" {
+		white, black, err := chess.PawnBitboards(query.Pawns)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPositionQuery, err)
+		}
+		switch {
+		case query.ExactPawns:
+			scope = scope.Where("white_pawns = ? AND black_pawns = ?", int64(white), int64(black))
+		case white|black == 0:
+			return nil, fmt.Errorf("%w: the pawn structure has no pawns", ErrInvalidPositionQuery)
+		default:
+			scope = scope.Where("(white_pawns & ?) = ? AND (black_pawns & ?) = ?",
+				int64(white), int64(white), int64(black), int64(black))
+		}
+	}
+	if query.After != nil {
+		scope = scope.Where("game_id > ?", *query.After)
+	}
+
+	// One more than a page tells whether there's a next one
+	var matches []PositionMatch
+	err := scope.Select("game_id, MIN(ply) AS ply").
+		Group("game_id").
+		Order("game_id ASC").
+		Limit(limit + 1).
+		Scan(&matches).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to search positions: %w", err)
+	}
+
+	result := &PositionSearchResult{Matches: matches}
+	if len(matches) > limit {
+		result.Matches = matches[:limit]
+		result.NextCursor = &matches[limit-1].GameID
+	}
+	if result.Matches == nil {
+		result.Matches = []PositionMatch{}
+	}
+	return result, nil
+}
+
+// IndexGame adds the positions of a finished game to the index. Indexing a
+// game again changes nothing.
+func (ps *PositionSearchService) IndexGame(game models.Game) error {
+	if game.Result == nil {
+		return nil
+	}
+	if game.Variant != models.GameVariantStandard && game.Variant != "" {
+		return nil
+	}
+
+	var moves []models.GameMove
+	err := ps.db.Where("game_id = ?", game.ID).
+		Order("move_number ASC").
+		Find(&moves).Error
+	if err != nil {
+		return fmt.Errorf("failed to load game moves: %w", err)
+	}
+	if len(moves) == 0 {
+		return nil
+	}
+
+	positions := make([]models.GamePosition, 0, len(moves))
+	for _, move := range moves {
+		white, black, err := chess.PawnBitboards(move.FENAfter)
+		if err != nil {
+			return fmt.Errorf("move %d: %w", move.MoveNumber, err)
+		}
+		positions = append(positions, models.GamePosition{
+			GameID:     game.ID,
+			Ply:        move.MoveNumber,
+			ZobristKey: int64(chess.PolyglotKey(move.FENAfter)),
+			Material:   chess.MaterialSignature(move.FENAfter),
+			WhitePawns: int64(white),
+			BlackPawns: int64(black),
+		})
+	}
+
+	err = ps.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&positions).Error
+	if err != nil {
+		return fmt.Errorf("failed to index game positions: %w", err)
+	}
+	return nil
+}
+
+// IndexFinishedGame is IndexGame as a GameService.OnGameFinished hook.
+func (ps *PositionSearchService) IndexFinishedGame(game models.Game) {
+	if err := ps.IndexGame(game); err != nil {
+		log.Printf("Error indexing the positions of game %s: %v", game.ID, err)
+	}
+}
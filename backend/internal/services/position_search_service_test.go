@@ -0,0 +1,134 @@
+package services
+
+import (
+	"testing"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPositionSearchService_Search(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	// d4 against d5 with the rest of the pawns anywhere
+	first, second, after := uuid.New(), uuid.New(), uuid.New()
+	mock.ExpectQuery(`SELECT game_id, MIN\(ply\) AS ply FROM "game_positions" WHERE material = \$1 `+
+		`AND \(\(white_pawns & \$2\) = \$3 AND \(black_pawns & \$4\) = \$5\) AND game_id > \$6 `+
+		`GROUP BY "game_id" ORDER BY game_id ASC LIMIT 3`).
+		WithArgs("KRPvKR", int64(1)<<27, int64(1)<<27, int64(1)<<35, int64(1)<<35, after).
+		WillReturnRows(sqlmock.NewRows([]string{"game_id", "ply"}).
+			AddRow(first, 61).
+			AddRow(second, 48).
+			AddRow(uuid.New(), 70))
+
+	result, err := NewPositionSearchService(db).Search(PositionQuery{
+		Material: "R+P vs R",
+		Pawns:    "8/8/8/3p4/3P4/8/8/8",
+		After:    &after,
+		Limit:    2,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []PositionMatch{{first, 61}, {second, 48}}, result.Matches)
+	assert.Equal(t, &second, result.NextCursor)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPositionSearchService_Search_InvalidQuery(t *testing.T) {
+	db, _ := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	service := NewPositionSearchService(db)
+	for _, query := range []PositionQuery{
+		{},
+		{FEN: "not a position"},
+		{Material: "R+P"},
+		{Pawns: "8/8/8/8/8/8/8/8"},
+	} {
+		_, err := service.Search(query)
+		assert.ErrorIs(t, err, ErrInvalidPositionQuery, "%+v", query)
+	}
+}
+
+func TestPositionSearchService_IndexGame(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	result := models.GameResultDraw
+	game := models.Game{ID: uuid.New(), Variant: models.GameVariantStandard, Result: &result}
+	afterE4 := "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"
+	afterE5 := "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2"
+	mock.ExpectQuery(`SELECT \* FROM "game_moves" WHERE game_id = \$1 ORDER BY move_number ASC`).
+		WithArgs(game.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"game_id", "move_number", "fen_after"}).
+			AddRow(game.ID, 1, afterE4).
+			AddRow(game.ID, 2, afterE5))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "game_positions" .* ON CONFLICT DO NOTHING`).
+		WithArgs(
+			game.ID, 1, int64(chess.PolyglotKey(afterE4)), "KQRRBBNNPPPPPPPPvKQRRBBNNPPPPPPPP", int64(0x1000ef00), int64(0xff000000000000),
+			game.ID, 2, int64(chess.PolyglotKey(afterE5)), "KQRRBBNNPPPPPPPPvKQRRBBNNPPPPPPPP", int64(0x1000ef00), int64(0xef001000000000),
+		).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	err := NewPositionSearchService(db).IndexGame(game)
+
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPositionSearchService_MaterialOfEitherColor(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	// White has the knight and black the bishop; the position is indexed,
+	// and found, under the name Syzygy gives it
+	result := models.GameResultDraw
+	game := models.Game{ID: uuid.New(), Variant: models.GameVariantStandard, Result: &result}
+	fen := "8/8/3k4/3b4/8/3NK3/8/8 b - - 0 1"
+	mock.ExpectQuery(`SELECT \* FROM "game_moves" WHERE game_id = \$1 ORDER BY move_number ASC`).
+		WithArgs(game.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"game_id", "move_number", "fen_after"}).
+			AddRow(game.ID, 81, fen))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "game_positions" .* ON CONFLICT DO NOTHING`).
+		WithArgs(game.ID, 81, int64(chess.PolyglotKey(fen)), "KBvKN", int64(0), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	service := NewPositionSearchService(db)
+	require.NoError(t, service.IndexGame(game))
+
+	for _, material := range []string{"N vs B", "B vs N"} {
+		mock.ExpectQuery(`SELECT game_id, MIN\(ply\) AS ply FROM "game_positions" WHERE material = \$1 ` +
+			`GROUP BY "game_id" ORDER BY game_id ASC LIMIT 51`).
+			WithArgs("KBvKN").
+			WillReturnRows(sqlmock.NewRows([]string{"game_id", "ply"}).AddRow(game.ID, 81))
+
+		found, err := service.Search(PositionQuery{Material: material})
+		require.NoError(t, err, material)
+		assert.Equal(t, []PositionMatch{{game.ID, 81}}, found.Matches, material)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
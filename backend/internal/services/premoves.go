@@ -0,0 +1,237 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// A player may queue premoves while their opponent is thinking. The queue
+// is kept in Redis under the game, so it survives the actor retiring, and as
+// soon as the opponent's move is written, by whichever instance, the actor
+// that wrote it plays the next premove with the engine's usual checks, so it
+// takes next to nothing off the clock. A premove that turns out illegal is
+// dropped along with the rest of the queue, and the player is told over the
+// WebSocket. Moving by hand replaces the queue too.
+
+const (
+	maxPremoves = 8
+	premovesTTL = 24 * time.Hour
+)
+
+var ErrInvalidPremove = errors.New("invalid premove")
+
+var squarePattern = regexp.MustCompile(`^[a-h][1-8]$`)
+
+// Premove is a move queued for the player's next turn. Drops name the piece
+// in Drop and leave From empty.
+type Premove struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to"`
+	Drop string `json:"drop,omitempty"`
+}
+
+// QueuePremove adds a premove to playerID's queue and returns the queue. It
+// can only be done on the opponent's turn.
+func (gs *GameService) QueuePremove(gameID, playerID uuid.UUID, from, to, drop string) ([]Premove, error) {
+	result := gs.dispatch(gameID, gameCommand{kind: commandPremove, playerID: playerID, from: from, to: to, drop: drop})
+	return result.premoves, result.err
+}
+
+// CancelPremoves empties playerID's premove queue.
+func (gs *GameService) CancelPremoves(gameID, playerID uuid.UUID) error {
+	return gs.dispatch(gameID, gameCommand{kind: commandCancelPremoves, playerID: playerID}).err
+}
+
+func (a *gameActor) queuePremove(playerID uuid.UUID, from, to, drop string) commandResult {
+	game := a.game
+	if game.Status != models.GameStatusActive {
+		return commandResult{err: ErrGameNotActive}
+	}
+	if game.VoteWindow > 0 {
+		return commandResult{err: ErrVoteRequired}
+	}
+	color := playerColor(game, playerID)
+	if color == "" {
+		return commandResult{err: ErrNotGamePlayer}
+	}
+	if color == game.CurrentTurn {
+		return commandResult{err: fmt.Errorf("%w: it's your move", ErrInvalidPremove)}
+	}
+
+	if drop != "" {
+		from = ""
+	} else if !squarePattern.MatchString(from) {
+		return commandResult{err: fmt.Errorf("%w: %q is not a square", ErrInvalidPremove, from)}
+	}
+	if !squarePattern.MatchString(to) {
+		return commandResult{err: fmt.Errorf("%w: %q is not a square", ErrInvalidPremove, to)}
+	}
+	queue, err := a.service.updatePremoves(a.gameID, func(queue *premoveQueue) error {
+		if queue.PlayerID != playerID {
+			*queue = premoveQueue{PlayerID: playerID}
+		}
+		if len(queue.Moves) >= maxPremoves {
+			return fmt.Errorf("%w: at most %d premoves", ErrInvalidPremove, maxPremoves)
+		}
+		queue.Moves = append(queue.Moves, Premove{From: from, To: to, Drop: drop})
+		return nil
+	})
+	if err != nil {
+		return commandResult{err: err}
+	}
+	return commandResult{game: a.snapshot(), premoves: queue.Moves}
+}
+
+func (a *gameActor) cancelPremoves(playerID uuid.UUID) commandResult {
+	_, err := a.service.updatePremoves(a.gameID, func(queue *premoveQueue) error {
+		if queue.PlayerID == playerID {
+			queue.Moves = nil
+		}
+		return nil
+	})
+	if err != nil {
+		return commandResult{err: err}
+	}
+	return commandResult{game: a.snapshot(), premoves: []Premove{}}
+}
+
+// playPremove plays the next premove if it's now the turn of the player who
+// queued it.
+func (a *gameActor) playPremove() {
+	if isGameOver(a.game) {
+		return
+	}
+	player := playerToMove(a.game)
+	if player == nil {
+		return
+	}
+
+	var next *Premove
+	_, err := a.service.updatePremoves(a.gameID, func(queue *premoveQueue) error {
+		next = nil
+		if queue.PlayerID == *player && len(queue.Moves) > 0 {
+			next = &queue.Moves[0]
+			queue.Moves = queue.Moves[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error loading premoves in game %s: %v", a.gameID, err)
+		return
+	}
+	if next == nil {
+		return
+	}
+
+	result := a.move(*player, next.From, next.To, next.Drop)
+	if result.err == nil {
+		return
+	}
+
+	if !errors.Is(result.err, ErrInvalidMove) {
+		log.Printf("Error playing premove in game %s: %v", a.gameID, result.err)
+	}
+	if dropped := a.cancelPremoves(*player); dropped.err != nil {
+		log.Printf("Error clearing premoves in game %s: %v", a.gameID, dropped.err)
+	}
+	a.service.notifyPremovesDropped(a.gameID, *player, *next, result.err)
+}
+
+// premoveQueue is a game's queued premoves, all of them one player's.
+type premoveQueue struct {
+	PlayerID uuid.UUID `json:"player_id"`
+	Moves    []Premove `json:"moves"`
+}
+
+func premovesKey(gameID uuid.UUID) string {
+	return fmt.Sprintf("game:%s:premoves", gameID)
+}
+
+// updatePremoves applies update to a game's premove queue and stores the
+// result, retrying when another instance changed the queue meanwhile. An
+// error from update is returned as is, and nothing is stored.
+func (gs *GameService) updatePremoves(gameID uuid.UUID, update func(*premoveQueue) error) (premoveQueue, error) {
+	ctx := context.Background()
+	key := premovesKey(gameID)
+
+	var queue premoveQueue
+	var updateErr error
+	apply := func(tx *redis.Tx) error {
+		queue = premoveQueue{}
+		stored, err := tx.Get(ctx, key).Bytes()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if len(stored) > 0 {
+			if err := json.Unmarshal(stored, &queue); err != nil {
+				return err
+			}
+		}
+
+		if updateErr = update(&queue); updateErr != nil {
+			return updateErr
+		}
+		if len(queue.Moves) == 0 {
+			queue.Moves = []Premove{}
+			if len(stored) == 0 {
+				return nil
+			}
+		}
+		updated, err := json.Marshal(queue)
+		if err != nil || bytes.Equal(updated, stored) {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if len(queue.Moves) == 0 {
+				pipe.Del(ctx, key)
+			} else {
+				pipe.Set(ctx, key, updated, premovesTTL)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt <= maxConflictRetries; attempt++ {
+		err := gs.redis.Watch(ctx, apply, key)
+		if updateErr != nil {
+			return queue, updateErr
+		}
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return queue, fmt.Errorf("failed to update premoves: %w", err)
+		}
+		return queue, nil
+	}
+	return queue, ErrConcurrentUpdate
+}
+
+// notifyPremovesDropped tells playerID that premove couldn't be played and
+// their queue was cleared.
+func (gs *GameService) notifyPremovesDropped(gameID, playerID uuid.UUID, premove Premove, err error) {
+	if gs.hub == nil {
+		return
+	}
+	gs.hub.SendToUser(playerID.String(), Message{
+		Type: "premoves_dropped",
+		Data: map[string]interface{}{
+			"game_id": gameID,
+			"premove": premove,
+			"reason":  gameErrorMessage(err),
+		},
+	})
+}
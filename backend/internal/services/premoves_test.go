@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectMove expects the writes of a move that leaves turn to move.
+func expectMove(mock sqlmock.Sqlmock, gameID uuid.UUID, turn string, moveCount int) {
+	mock.ExpectBegin()
+	expectMoveUpdate(mock, gameID, turn, moveCount, moveCount-1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`INSERT INTO "game_moves"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	expectEvent(mock, gameID, models.GameEventMoved, moveCount)
+	mock.ExpectCommit()
+}
+
+func TestGameService_QueuePremove_ChecksStoredVersion(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	white, black := uuid.New(), uuid.New()
+	game := cacheActiveGame(t, redisClient, white, black)
+	require.NoError(t, gameService.CancelPremoves(game.ID, white))
+
+	// White has moved on another instance: it's black's move, not a premove
+	expectStaleCopy(mock, game)
+
+	_, err := gameService.QueuePremove(game.ID, black, "d7", "d5", "")
+
+	assert.ErrorIs(t, err, ErrInvalidPremove)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_QueuePremove_Rejected(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	white, black := uuid.New(), uuid.New()
+	game := cacheActiveGame(t, redisClient, white, black)
+
+	_, err := gameService.QueuePremove(game.ID, white, "e2", "e4", "")
+	assert.ErrorIs(t, err, ErrInvalidPremove)
+
+	_, err = gameService.QueuePremove(game.ID, black, "e7", "e9", "")
+	assert.ErrorIs(t, err, ErrInvalidPremove)
+
+	_, err = gameService.QueuePremove(game.ID, uuid.New(), "e7", "e5", "")
+	assert.ErrorIs(t, err, ErrNotGamePlayer)
+
+	for i := 0; i < maxPremoves; i++ {
+		_, err = gameService.QueuePremove(game.ID, black, "g8", "f6", "")
+		require.NoError(t, err)
+	}
+	_, err = gameService.QueuePremove(game.ID, black, "g8", "f6", "")
+	assert.ErrorIs(t, err, ErrInvalidPremove)
+
+	require.NoError(t, gameService.CancelPremoves(game.ID, black))
+	premoves, err := gameService.QueuePremove(game.ID, black, "g8", "f6", "")
+	require.NoError(t, err)
+	assert.Len(t, premoves, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_PremovePlayedAfterOpponentMoves(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	gameService := NewGameService(db, redisClient)
+	white, black := uuid.New(), uuid.New()
+	game := cacheActiveGame(t, redisClient, white, black)
+	lastMove := time.Now().Add(-5 * time.Second)
+	game.LastMoveAt = &lastMove
+	gameJSON, _ := json.Marshal(game)
+	redisClient.Set(context.Background(), fmt.Sprintf("game:%s", game.ID), string(gameJSON), time.Hour)
+
+	premoves, err := gameService.QueuePremove(game.ID, black, "e7", "e5", "")
+	require.NoError(t, err)
+	require.Len(t, premoves, 1)
+	_, err = gameService.QueuePremove(game.ID, black, "b8", "c6", "")
+	require.NoError(t, err)
+
+	// 1.e4 e5 2.Nf3 Nc6, with black's moves played by the server
+	expectMove(mock, game.ID, "black", 1)
+	expectMove(mock, game.ID, "white", 2)
+	_, err = gameService.MakeMove(game.ID, white, "e2", "e4")
+	require.NoError(t, err)
+
+	expectMove(mock, game.ID, "black", 3)
+	expectMove(mock, game.ID, "white", 4)
+	_, err = gameService.MakeMove(game.ID, white, "g1", "f3")
+	require.NoError(t, err)
+
+	cached, err := gameService.getGameFromCache(game.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 4, cached.MoveCount)
+	assert.Equal(t, "r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 2 3", cached.BoardState)
+	// White took their time, the premoves cost black none
+	assert.Equal(t, 595, cached.WhiteTime)
+	assert.Equal(t, 600, cached.BlackTime)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_IllegalPremoveClearsQueue(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	hub := NewHub()
+	go hub.Run()
+	gameService := NewGameService(db, redisClient)
+	gameService.PublishTo(hub)
+	white, black := uuid.New(), uuid.New()
+	blackClient := connectTestClient(t, hub, black.String())
+	game := cacheActiveGame(t, redisClient, white, black)
+
+	// The queen can't get out while the e-pawn is in the way
+	_, err := gameService.QueuePremove(game.ID, black, "d8", "h4", "")
+	require.NoError(t, err)
+	_, err = gameService.QueuePremove(game.ID, black, "e7", "e5", "")
+	require.NoError(t, err)
+
+	expectMove(mock, game.ID, "black", 1)
+	_, err = gameService.MakeMove(game.ID, white, "e2", "e4")
+	require.NoError(t, err)
+
+	message := expectMessage(t, blackClient, "premoves_dropped")
+	data := message.Data.(map[string]interface{})
+	assert.Equal(t, game.ID.String(), data["game_id"])
+	assert.Equal(t, "h4", data["premove"].(map[string]interface{})["to"])
+
+	// The rest of the queue went with it, so black's turn waits for black
+	cached, err := gameService.getGameFromCache(game.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cached.MoveCount)
+	assert.Equal(t, "black", cached.CurrentTurn)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGameService_PremovesSharedBetweenInstances(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	instanceA := NewGameService(db, redisClient)
+	instanceB := NewGameService(db, redisClient)
+	white, black := uuid.New(), uuid.New()
+	game := cacheActiveGame(t, redisClient, white, black)
+
+	// Black premoves through one instance, white moves through the other
+	_, err := instanceA.QueuePremove(game.ID, black, "e7", "e5", "")
+	require.NoError(t, err)
+
+	expectMove(mock, game.ID, "black", 1)
+	expectMove(mock, game.ID, "white", 2)
+	_, err = instanceB.MakeMove(game.ID, white, "e2", "e4")
+	require.NoError(t, err)
+
+	cached, err := instanceB.getGameFromCache(game.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, cached.MoveCount)
+	assert.Equal(t, "white", cached.CurrentTurn)
+	assert.False(t, redisServer.Exists(premovesKey(game.ID)), "the played premove left the queue")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/glicko"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The miner looks for blunders in finished games after which the opponent
+// had a winning tactic, and turns each into a puzzle: the blunder is the
+// setup move and the tactic the solution. Every solving move must be the
+// only one that wins, except a final mate, since any mate solves a puzzle.
+
+const (
+	minerDepth          = 4
+	minerRankDepth      = 3 // every legal move is searched at each step, so shallower
+	minerMaxSolverMoves = 4
+
+	// minerWinningScore, in centipawns for the solver, counts as winning
+	// decisively. A move is the only move when it mates and nothing else
+	// does, or when the second-best move wins at least minerOnlyMoveGap
+	// percentage points less often.
+	minerWinningScore = 300
+	minerOnlyMoveGap  = 20.0
+)
+
+type PuzzleMiner struct {
+	db *gorm.DB
+}
+
+func NewPuzzleMiner(db *gorm.DB) *PuzzleMiner {
+	return &PuzzleMiner{db: db}
+}
+
+// MineGame adds the puzzles found in a finished standard game, played without
+// material odds, to the pool and returns how many were new.
+func (pm *PuzzleMiner) MineGame(game models.Game) (int, error) {
+	if game.Variant != models.GameVariantStandard && game.Variant != "" || game.Handicap != "" {
+		return 0, nil
+	}
+
+	var moves []models.GameMove
+	err := pm.db.Where("game_id = ?", game.ID).Order("move_number ASC").Find(&moves).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to load game moves: %w", err)
+	}
+
+	puzzles := FindPuzzles(game.ID, moves)
+	if len(puzzles) == 0 {
+		return 0, nil
+	}
+
+	result := pm.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "game_id"}, {Name: "game_ply"}},
+		DoNothing: true,
+	}).Create(&puzzles)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to save puzzles: %w", result.Error)
+	}
+	return int(result.RowsAffected), nil
+}
+
+// FindPuzzles returns a puzzle for every blunder in moves that handed the
+// opponent a decisive tactic.
+func FindPuzzles(gameID uuid.UUID, moves []models.GameMove) []models.Puzzle {
+	fens := make([]string, 0, len(moves)+1)
+	fens = append(fens, chess.StandardStartFEN)
+	for _, move := range moves {
+		fens = append(fens, move.FENAfter)
+	}
+
+	evals := make([]int, len(fens))
+	for i, fen := range fens {
+		evals[i] = chess.NewEngine(fen).Search(minerDepth).Score
+	}
+
+	var puzzles []models.Puzzle
+	for i, move := range moves {
+		blunderer := i%2 == 0 // white moves on even indexes
+		solver := !blunderer
+		loss := winChance(evals[i], blunderer) - winChance(evals[i+1], blunderer)
+		if loss < blunderThreshold {
+			continue
+		}
+		// The solver must go from not yet winning to winning
+		if solverScore(evals[i], solver) >= minerWinningScore || solverScore(evals[i+1], solver) < minerWinningScore {
+			continue
+		}
+
+		line, themes := solveTactic(fens[i+1], solver)
+		if len(line) == 0 {
+			continue
+		}
+
+		gameID := gameID
+		blunder := move.FromSquare + move.ToSquare
+		puzzles = append(puzzles, models.Puzzle{
+			GameID:           &gameID,
+			GamePly:          i + 1,
+			FEN:              fens[i],
+			Moves:            strings.Join(append([]string{blunder}, line...), " "),
+			Themes:           strings.Join(themes, " "),
+			Rating:           glicko.DefaultRating,
+			RatingDeviation:  glicko.DefaultDeviation,
+			RatingVolatility: glicko.DefaultVolatility,
+		})
+	}
+	return puzzles
+}
+
+// solveTactic follows the solver's only winning moves from fen, with the
+// opponent's best replies in between, and names the motifs on the way. The
+// line always ends with a solver move; it's empty when the first move
+// isn't unique.
+func solveTactic(fen string, solverWhite bool) ([]string, []string) {
+	var line []string
+	var themes []string
+	addTheme := func(theme string) {
+		for _, existing := range themes {
+			if existing == theme {
+				return
+			}
+		}
+		themes = append(themes, theme)
+	}
+
+	solverMoves := 0
+	ranked := chess.NewEngine(fen).RankMoves(minerRankDepth)
+	for solverMoves < minerMaxSolverMoves {
+		best, ok := onlyWinningMove(ranked, solverWhite)
+		if !ok {
+			break
+		}
+
+		played, err := chess.NewEngine(fen).ValidateMove(best.Move[:2], best.Move[2:])
+		if err != nil {
+			break
+		}
+		line = append(line, best.Move)
+		solverMoves++
+		fen = played.FENAfter
+
+		if played.IsCheckmate {
+			addTheme("mate")
+			addTheme(fmt.Sprintf("mateIn%d", solverMoves))
+			if chess.IsBackRankMate(fen) {
+				addTheme("backRankMate")
+			}
+			return line, themes
+		}
+		if chess.IsFork(fen, best.Move[2:]) {
+			addTheme("fork")
+		}
+		if chess.IsPin(fen, best.Move[2:]) {
+			addTheme("pin")
+		}
+		if solverMoves == minerMaxSolverMoves {
+			break
+		}
+
+		reply := chess.NewEngine(fen).Search(minerDepth)
+		if reply.BestMove == "" {
+			break
+		}
+		answered, err := chess.NewEngine(fen).ValidateMove(reply.BestMove[:2], reply.BestMove[2:])
+		if err != nil {
+			break
+		}
+
+		// Only keep going if the solver's next move is forced too
+		ranked = chess.NewEngine(answered.FENAfter).RankMoves(minerRankDepth)
+		if _, ok := onlyWinningMove(ranked, solverWhite); !ok {
+			break
+		}
+		line = append(line, reply.BestMove)
+		fen = answered.FENAfter
+	}
+
+	if solverMoves == 0 {
+		return nil, nil
+	}
+	if len(themes) == 0 {
+		themes = append(themes, "advantage")
+	}
+	return line, themes
+}
+
+// onlyWinningMove returns the best of ranked if it wins and is clearly better
+// than every other move. A mate in one needs no such check.
+func onlyWinningMove(ranked []chess.RankedMove, solverWhite bool) (chess.RankedMove, bool) {
+	if len(ranked) == 0 {
+		return chess.RankedMove{}, false
+	}
+	best := ranked[0]
+	if solverScore(best.Score, solverWhite) < minerWinningScore {
+		return best, false
+	}
+	if best.Mate == 1 || best.Mate == -1 || len(ranked) == 1 {
+		return best, true
+	}
+
+	second := ranked[1]
+	if best.Mate != 0 {
+		return best, second.Mate == 0
+	}
+	gap := winChance(best.Score, solverWhite) - winChance(second.Score, solverWhite)
+	return best, second.Mate == 0 && gap >= minerOnlyMoveGap
+}
+
+// solverScore turns a score from white's point of view into the solver's.
+func solverScore(score int, solverWhite bool) int {
+	if solverWhite {
+		return score
+	}
+	return -score
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"testing"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// playedMoves builds the stored moves of a game played from the start.
+func playedMoves(t *testing.T, gameID uuid.UUID, moves ...string) []models.GameMove {
+	t.Helper()
+
+	fen := chess.StandardStartFEN
+	played := make([]models.GameMove, 0, len(moves))
+	for i, uci := range moves {
+		move, err := chess.NewEngine(fen).ValidateMove(uci[:2], uci[2:])
+		require.NoError(t, err, uci)
+		fen = move.FENAfter
+		played = append(played, models.GameMove{
+			GameID:     gameID,
+			MoveNumber: i + 1,
+			FromSquare: uci[:2],
+			ToSquare:   uci[2:],
+			Notation:   move.Notation,
+			FENAfter:   fen,
+		})
+	}
+	return played
+}
+
+func TestFindPuzzles_FoolsMate(t *testing.T) {
+	gameID := uuid.New()
+	moves := playedMoves(t, gameID, "f2f3", "e7e5", "g2g4", "d8h4")
+
+	puzzles := FindPuzzles(gameID, moves)
+
+	require.Len(t, puzzles, 1)
+	assert.Equal(t, 3, puzzles[0].GamePly)
+	assert.Equal(t, moves[1].FENAfter, puzzles[0].FEN)
+	assert.Equal(t, "g2g4 d8h4", puzzles[0].Moves)
+	assert.Equal(t, "mate mateIn1", puzzles[0].Themes)
+	assert.Equal(t, &gameID, puzzles[0].GameID)
+}
+
+func TestSolveTactic_BackRankMate(t *testing.T) {
+	line, themes := solveTactic("r5k1/5ppp/p7/8/8/8/4RPPP/4R1K1 w - - 0 2", true)
+
+	assert.Equal(t, []string{"e2e8", "a8e8", "e1e8"}, line)
+	assert.Subset(t, themes, []string{"mate", "mateIn2", "backRankMate"})
+}
+
+func TestSolveTactic_Fork(t *testing.T) {
+	line, themes := solveTactic("r3k3/8/8/1N6/8/8/8/4K3 w - - 0 1", true)
+
+	require.NotEmpty(t, line)
+	assert.Equal(t, "b5c7", line[0])
+	assert.Contains(t, themes, "fork")
+	assert.Equal(t, 1, len(line)%2, "the line ends with a solver move")
+}
+
+func TestSolveTactic_NoUniqueMove(t *testing.T) {
+	// Either rook can be taken
+	line, _ := solveTactic("4k3/8/8/r2Q3r/8/8/8/4K3 w - - 0 1", true)
+
+	assert.Empty(t, line)
+}
+
+func TestPuzzleMiner_MineGame(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	result := models.GameResultBlackWins
+	game := models.Game{ID: uuid.New(), Variant: models.GameVariantStandard, Result: &result}
+	rows := sqlmock.NewRows([]string{"game_id", "move_number", "from_square", "to_square", "notation", "fen_after"})
+	for _, move := range playedMoves(t, game.ID, "f2f3", "e7e5", "g2g4", "d8h4") {
+		rows.AddRow(move.GameID, move.MoveNumber, move.FromSquare, move.ToSquare, move.Notation, move.FENAfter)
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM "game_moves" WHERE game_id = \$1 ORDER BY move_number ASC`).
+		WithArgs(game.ID).
+		WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "puzzles" .* ON CONFLICT \("game_id","game_ply"\) DO NOTHING RETURNING "id"`).
+		WithArgs(nil, game.ID, 3, sqlmock.AnyArg(), "g2g4 d8h4", "mate mateIn1", 1500.0, 350.0, 0.06, 0,
+			testutil.AnyTime{}, testutil.AnyTime{}, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectCommit()
+
+	mined, err := NewPuzzleMiner(db).MineGame(game)
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, mined)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
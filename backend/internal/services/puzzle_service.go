@@ -0,0 +1,462 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/glicko"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Puzzles are served close to the solver's puzzle rating. Each move of an
+// attempt is checked against the puzzle's line and the opponent's reply is
+// played back; when the attempt ends, the solver and the puzzle are rated
+// against each other as if they had played a game.
+
+const (
+	puzzleRatingWindow = 250.0
+	puzzleImportBatch  = 500
+)
+
+var (
+	ErrNoPuzzleAvailable     = errors.New("no puzzle available")
+	ErrPuzzleAttemptNotFound = errors.New("puzzle attempt not found")
+	ErrPuzzleAttemptFinished = errors.New("puzzle attempt is already finished")
+)
+
+type PuzzleService struct {
+	db *gorm.DB
+}
+
+// PuzzleChallenge is a puzzle as shown to the solver: the position after the
+// opponent's move, with that move to highlight.
+type PuzzleChallenge struct {
+	AttemptID uuid.UUID `json:"attempt_id"`
+	PuzzleID  uuid.UUID `json:"puzzle_id"`
+	FEN       string    `json:"fen"`
+	LastMove  string    `json:"last_move"`
+	Color     string    `json:"color"` // the side the solver plays
+	Rating    int       `json:"rating"`
+}
+
+// PuzzleMoveResult reports a solving move. While the attempt is active, Reply
+// is the opponent's answer and FEN the position after it.
+type PuzzleMoveResult struct {
+	Correct      bool                       `json:"correct"`
+	Status       models.PuzzleAttemptStatus `json:"status"`
+	Reply        string                     `json:"reply,omitempty"`
+	FEN          string                     `json:"fen"`
+	Solution     []string                   `json:"solution,omitempty"` // the rest of the line, after a wrong move
+	Rating       int                        `json:"rating,omitempty"`   // the solver's, once finished
+	RatingChange int                        `json:"rating_change"`
+}
+
+type PuzzleImportStats struct {
+	Imported    int `json:"imported"`
+	Skipped     int `json:"skipped"`     // malformed
+	Unsupported int `json:"unsupported"` // castling or promoting, which our engine doesn't play
+}
+
+func NewPuzzleService(db *gorm.DB) *PuzzleService {
+	return &PuzzleService{db: db}
+}
+
+// GetRating returns the user's puzzle rating, or a newcomer's rating if they
+// haven't tried a puzzle yet.
+func (ps *PuzzleService) GetRating(userID uuid.UUID) (*models.PuzzleRating, error) {
+	return loadPuzzleRating(ps.db, userID)
+}
+
+// NextPuzzle starts an attempt at a puzzle the user hasn't seen, close to
+// their rating. An attempt they left unfinished is resumed instead.
+func (ps *PuzzleService) NextPuzzle(userID uuid.UUID) (*PuzzleChallenge, error) {
+	var attempt models.PuzzleAttempt
+	err := ps.db.Preload("Puzzle").
+		Where("user_id = ? AND status = ?", userID, models.PuzzleAttemptActive).
+		First(&attempt).Error
+	if err == nil {
+		return challengeFor(attempt)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load puzzle attempt: %w", err)
+	}
+
+	rating, err := loadPuzzleRating(ps.db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	puzzle, err := ps.pickPuzzle(userID, rating.Rating)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt = models.PuzzleAttempt{
+		UserID:   userID,
+		PuzzleID: puzzle.ID,
+		Ply:      1,
+		Status:   models.PuzzleAttemptActive,
+		Puzzle:   *puzzle,
+	}
+	if err := ps.db.Omit("Puzzle").Create(&attempt).Error; err != nil {
+		return nil, fmt.Errorf("failed to start puzzle attempt: %w", err)
+	}
+
+	return challengeFor(attempt)
+}
+
+// pickPuzzle chooses at random among the unseen puzzles within
+// puzzleRatingWindow of rating, falling back to the closest unseen one.
+func (ps *PuzzleService) pickPuzzle(userID uuid.UUID, rating float64) (*models.Puzzle, error) {
+	seen := ps.db.Model(&models.PuzzleAttempt{}).Select("puzzle_id").Where("user_id = ?", userID)
+
+	var puzzle models.Puzzle
+	err := ps.db.Where("rating BETWEEN ? AND ?", rating-puzzleRatingWindow, rating+puzzleRatingWindow).
+		Where("id NOT IN (?)", seen).
+		Order("RANDOM()").
+		Take(&puzzle).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = ps.db.Where("id NOT IN (?)", seen).
+			Clauses(clause.OrderBy{Expression: clause.Expr{SQL: "ABS(rating - ?)", Vars: []interface{}{rating}}}).
+			Take(&puzzle).Error
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoPuzzleAvailable
+		}
+		return nil, fmt.Errorf("failed to find puzzle: %w", err)
+	}
+	return &puzzle, nil
+}
+
+// SubmitMove plays the solver's next move in an attempt. A legal move that
+// leaves the line fails the puzzle, unless it mates.
+func (ps *PuzzleService) SubmitMove(userID, attemptID uuid.UUID, from, to string) (*PuzzleMoveResult, error) {
+	var attempt models.PuzzleAttempt
+	err := ps.db.Preload("Puzzle").
+		Where("id = ? AND user_id = ?", attemptID, userID).
+		First(&attempt).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPuzzleAttemptNotFound
+		}
+		return nil, fmt.Errorf("failed to load puzzle attempt: %w", err)
+	}
+	if attempt.Status != models.PuzzleAttemptActive {
+		return nil, ErrPuzzleAttemptFinished
+	}
+
+	line := strings.Fields(attempt.Puzzle.Moves)
+	fen, err := playLine(attempt.Puzzle.FEN, line[:attempt.Ply])
+	if err != nil {
+		return nil, fmt.Errorf("puzzle %s: %w", attempt.Puzzle.ID, err)
+	}
+
+	move, err := chess.NewEngine(fen).ValidateMove(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMove, err)
+	}
+
+	if from+to != line[attempt.Ply] && !move.IsCheckmate {
+		result := &PuzzleMoveResult{Status: models.PuzzleAttemptFailed, FEN: move.FENAfter, Solution: line[attempt.Ply:]}
+		return result, ps.finish(&attempt, attempt.Ply, false, result)
+	}
+
+	ply := attempt.Ply + 1
+	result := &PuzzleMoveResult{Correct: true, FEN: move.FENAfter}
+	if ply >= len(line) || move.IsCheckmate {
+		result.Status = models.PuzzleAttemptSolved
+		return result, ps.finish(&attempt, ply, true, result)
+	}
+
+	result.Reply = line[ply]
+	result.FEN, err = playLine(move.FENAfter, line[ply:ply+1])
+	if err != nil {
+		return nil, fmt.Errorf("puzzle %s: %w", attempt.Puzzle.ID, err)
+	}
+	ply++
+
+	if ply >= len(line) {
+		result.Status = models.PuzzleAttemptSolved
+		return result, ps.finish(&attempt, ply, true, result)
+	}
+
+	update := ps.db.Model(&models.PuzzleAttempt{}).
+		Where("id = ? AND ply = ?", attempt.ID, attempt.Ply).
+		Update("ply", ply)
+	if update.Error != nil {
+		return nil, fmt.Errorf("failed to update puzzle attempt: %w", update.Error)
+	}
+	if update.RowsAffected == 0 {
+		return nil, ErrConcurrentUpdate
+	}
+
+	result.Status = models.PuzzleAttemptActive
+	return result, nil
+}
+
+// finish closes an attempt and rates the solver and the puzzle against each
+// other, filling in the solver's new rating on result. Both ratings are read
+// FOR UPDATE, so attempts finishing at once, at the same puzzle or by the same
+// solver, are rated one after the other instead of overwriting each other.
+func (ps *PuzzleService) finish(attempt *models.PuzzleAttempt, ply int, solved bool, result *PuzzleMoveResult) error {
+	return ps.db.Transaction(func(tx *gorm.DB) error {
+		// A newcomer has no rating row to lock yet
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}},
+			DoNothing: true,
+		}).Create(newPuzzleRating(attempt.UserID)).Error
+		if err != nil {
+			return fmt.Errorf("failed to create puzzle rating: %w", err)
+		}
+		rating, err := loadPuzzleRating(tx.Clauses(clause.Locking{Strength: "UPDATE"}), attempt.UserID)
+		if err != nil {
+			return err
+		}
+		var current models.Puzzle
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("rating", "rating_deviation", "rating_volatility").
+			Where("id = ?", attempt.Puzzle.ID).
+			Take(&current).Error
+		if err != nil {
+			return fmt.Errorf("failed to load puzzle: %w", err)
+		}
+
+		score := 0.0
+		if solved {
+			score = 1
+			rating.Solved++
+		}
+		solver := glicko.Rating{Rating: rating.Rating, Deviation: rating.Deviation, Volatility: rating.Volatility}
+		puzzle := glicko.Rating{
+			Rating:     current.Rating,
+			Deviation:  current.RatingDeviation,
+			Volatility: current.RatingVolatility,
+		}
+		newSolver := glicko.Update(solver, []glicko.Result{{Opponent: puzzle, Score: score}})
+		newPuzzle := glicko.Update(puzzle, []glicko.Result{{Opponent: solver, Score: 1 - score}})
+
+		status := models.PuzzleAttemptFailed
+		if solved {
+			status = models.PuzzleAttemptSolved
+		}
+		change := newSolver.Rating - solver.Rating
+		update := tx.Model(&models.PuzzleAttempt{}).
+			Where("id = ? AND status = ?", attempt.ID, models.PuzzleAttemptActive).
+			Updates(map[string]interface{}{
+				"ply":           ply,
+				"status":        status,
+				"rating_change": change,
+				"finished_at":   time.Now(),
+			})
+		if update.Error != nil {
+			return fmt.Errorf("failed to finish puzzle attempt: %w", update.Error)
+		}
+		if update.RowsAffected == 0 {
+			return ErrPuzzleAttemptFinished
+		}
+
+		rating.Rating, rating.Deviation, rating.Volatility = newSolver.Rating, newSolver.Deviation, newSolver.Volatility
+		rating.Attempts++
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}},
+			UpdateAll: true,
+		}).Create(rating).Error
+		if err != nil {
+			return fmt.Errorf("failed to save puzzle rating: %w", err)
+		}
+
+		err = tx.Model(&models.Puzzle{}).Where("id = ?", attempt.Puzzle.ID).Updates(map[string]interface{}{
+			"rating":            newPuzzle.Rating,
+			"rating_deviation":  newPuzzle.Deviation,
+			"rating_volatility": newPuzzle.Volatility,
+			"plays":             gorm.Expr("plays + 1"),
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update puzzle rating: %w", err)
+		}
+
+		result.Rating = int(math.Round(newSolver.Rating))
+		result.RatingChange = int(math.Round(change))
+		return nil
+	})
+}
+
+// ImportCSV loads puzzles in the common CSV dump format:
+//
+//	PuzzleId,FEN,Moves,Rating,RatingDeviation,Popularity,NbPlays,Themes,GameUrl,OpeningTags
+//
+// Puzzles already imported are left alone. Puzzles whose line our engine
+// can't play (castling and promotion) are counted apart from malformed ones.
+func (ps *PuzzleService) ImportCSV(r io.Reader) (PuzzleImportStats, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	var stats PuzzleImportStats
+	batch := make([]models.Puzzle, 0, puzzleImportBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result := ps.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "external_id"}},
+			DoNothing: true,
+		}).Create(&batch)
+		if result.Error != nil {
+			return fmt.Errorf("failed to save puzzles: %w", result.Error)
+		}
+		stats.Imported += int(result.RowsAffected)
+		batch = batch[:0]
+		return nil
+	}
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("line %d: %w", line, err)
+		}
+		if line == 1 && record[0] == "PuzzleId" {
+			continue
+		}
+
+		puzzle, err := parsePuzzleRecord(record)
+		if errors.Is(err, chess.ErrUnsupportedMove) {
+			stats.Unsupported++
+			continue
+		}
+		if err != nil {
+			stats.Skipped++
+			continue
+		}
+		batch = append(batch, puzzle)
+		if len(batch) == puzzleImportBatch {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return stats, err
+	}
+	if stats.Unsupported > 0 {
+		log.Printf("Skipped %d puzzles that castle or promote", stats.Unsupported)
+	}
+	return stats, nil
+}
+
+func parsePuzzleRecord(record []string) (models.Puzzle, error) {
+	if len(record) < 8 {
+		return models.Puzzle{}, fmt.Errorf("expected at least 8 fields, got %d", len(record))
+	}
+
+	id, fen, moves := record[0], record[1], record[2]
+	line := strings.Fields(moves)
+	if id == "" || len(line) < 2 {
+		return models.Puzzle{}, errors.New("missing id or moves")
+	}
+	if err := chess.ValidateFEN(fen); err != nil {
+		return models.Puzzle{}, err
+	}
+	if _, err := playLine(fen, line); err != nil {
+		return models.Puzzle{}, err
+	}
+
+	rating, err := strconv.ParseFloat(record[3], 64)
+	if err != nil {
+		return models.Puzzle{}, fmt.Errorf("invalid rating: %w", err)
+	}
+	deviation, err := strconv.ParseFloat(record[4], 64)
+	if err != nil {
+		deviation = glicko.DefaultDeviation
+	}
+	plays, _ := strconv.Atoi(record[6])
+
+	return models.Puzzle{
+		ExternalID:       &id,
+		FEN:              fen,
+		Moves:            strings.Join(line, " "),
+		Themes:           record[7],
+		Rating:           rating,
+		RatingDeviation:  deviation,
+		RatingVolatility: glicko.DefaultVolatility,
+		Plays:            plays,
+	}, nil
+}
+
+// playLine plays moves ("e2e4") from fen and returns the final position.
+func playLine(fen string, moves []string) (string, error) {
+	for _, uci := range moves {
+		if len(uci) == 5 {
+			return "", fmt.Errorf("move %s: promotion is %w", uci, chess.ErrUnsupportedMove)
+		}
+		if len(uci) != 4 {
+			return "", fmt.Errorf("invalid move %q", uci)
+		}
+		move, err := chess.NewEngine(fen).ValidateMove(uci[:2], uci[2:])
+		if err != nil {
+			return "", fmt.Errorf("move %s: %w", uci, err)
+		}
+		fen = move.FENAfter
+	}
+	return fen, nil
+}
+
+func challengeFor(attempt models.PuzzleAttempt) (*PuzzleChallenge, error) {
+	line := strings.Fields(attempt.Puzzle.Moves)
+	fen, err := playLine(attempt.Puzzle.FEN, line[:attempt.Ply])
+	if err != nil {
+		return nil, fmt.Errorf("puzzle %s: %w", attempt.Puzzle.ID, err)
+	}
+
+	color := "white"
+	if fields := strings.Fields(fen); len(fields) > 1 && fields[1] == "b" {
+		color = "black"
+	}
+	return &PuzzleChallenge{
+		AttemptID: attempt.ID,
+		PuzzleID:  attempt.PuzzleID,
+		FEN:       fen,
+		LastMove:  line[attempt.Ply-1],
+		Color:     color,
+		Rating:    int(math.Round(attempt.Puzzle.Rating)),
+	}, nil
+}
+
+func loadPuzzleRating(db *gorm.DB, userID uuid.UUID) (*models.PuzzleRating, error) {
+	var rating models.PuzzleRating
+	err := db.Where("user_id = ?", userID).First(&rating).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return newPuzzleRating(userID), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load puzzle rating: %w", err)
+	}
+	return &rating, nil
+}
+
+// newPuzzleRating is the rating of a user who hasn't tried a puzzle yet.
+func newPuzzleRating(userID uuid.UUID) *models.PuzzleRating {
+	return &models.PuzzleRating{
+		UserID:     userID,
+		Rating:     glicko.DefaultRating,
+		Deviation:  glicko.DefaultDeviation,
+		Volatility: glicko.DefaultVolatility,
+	}
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Black's a6 walks into a back-rank mate: Re8+ Rxe8 Rxe8#
+const (
+	backRankFEN   = "r5k1/p4ppp/8/8/8/8/4RPPP/4R1K1 b - - 0 1"
+	backRankMoves = "a7a6 e2e8 a8e8 e1e8"
+)
+
+var puzzleColumns = []string{"id", "fen", "moves", "themes", "rating", "rating_deviation", "rating_volatility", "plays"}
+
+// ratingBetween matches a rating written within [min, max].
+type ratingBetween struct{ min, max float64 }
+
+func (r ratingBetween) Match(v driver.Value) bool {
+	rating, ok := v.(float64)
+	return ok && rating >= r.min && rating <= r.max
+}
+
+func expectPuzzleAttempt(mock sqlmock.Sqlmock, attemptID, userID, puzzleID uuid.UUID, ply int) {
+	mock.ExpectQuery(`SELECT \* FROM "puzzle_attempts" WHERE id = \$1 AND user_id = \$2`).
+		WithArgs(attemptID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "puzzle_id", "ply", "status"}).
+			AddRow(attemptID, userID, puzzleID, ply, models.PuzzleAttemptActive))
+	mock.ExpectQuery(`SELECT \* FROM "puzzles" WHERE "puzzles"."id" = \$1`).
+		WithArgs(puzzleID).
+		WillReturnRows(sqlmock.NewRows(puzzleColumns).
+			AddRow(puzzleID, backRankFEN, backRankMoves, "backRankMate mateIn2", 1500, 80, 0.06, 10))
+}
+
+func TestPuzzleService_NextPuzzle(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	userID, puzzleID := uuid.New(), uuid.New()
+	mock.ExpectQuery(`SELECT \* FROM "puzzle_attempts" WHERE user_id = \$1 AND status = \$2`).
+		WithArgs(userID, models.PuzzleAttemptActive).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT \* FROM "puzzle_ratings" WHERE user_id = \$1`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "rating", "deviation", "volatility"}).
+			AddRow(userID, 1620, 90, 0.06))
+	mock.ExpectQuery(`SELECT \* FROM "puzzles" WHERE \(rating BETWEEN \$1 AND \$2\) AND id NOT IN \(SELECT "puzzle_id" FROM "puzzle_attempts" WHERE user_id = \$3\) ORDER BY RANDOM\(\) LIMIT 1`).
+		WithArgs(1370.0, 1870.0, userID).
+		WillReturnRows(sqlmock.NewRows(puzzleColumns).
+			AddRow(puzzleID, backRankFEN, backRankMoves, "backRankMate", 1580.4, 80, 0.06, 10))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "puzzle_attempts"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectCommit()
+
+	challenge, err := NewPuzzleService(db).NextPuzzle(userID)
+
+	require.NoError(t, err)
+	assert.Equal(t, puzzleID, challenge.PuzzleID)
+	assert.Equal(t, "r5k1/5ppp/p7/8/8/8/4RPPP/4R1K1 w - - 0 2", challenge.FEN)
+	assert.Equal(t, "a7a6", challenge.LastMove)
+	assert.Equal(t, "white", challenge.Color)
+	assert.Equal(t, 1580, challenge.Rating)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPuzzleService_SubmitMove_PlaysReply(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	attemptID, userID, puzzleID := uuid.New(), uuid.New(), uuid.New()
+	expectPuzzleAttempt(mock, attemptID, userID, puzzleID, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "puzzle_attempts" SET "ply"=\$1 WHERE id = \$2 AND ply = \$3`).
+		WithArgs(3, attemptID, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := NewPuzzleService(db).SubmitMove(userID, attemptID, "e2", "e8")
+
+	require.NoError(t, err)
+	assert.True(t, result.Correct)
+	assert.Equal(t, models.PuzzleAttemptActive, result.Status)
+	assert.Equal(t, "a8e8", result.Reply)
+	assert.Equal(t, "4r1k1/5ppp/p7/8/8/8/5PPP/4R1K1 w - - 0 3", result.FEN)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPuzzleService_SubmitMove_WrongMoveFails(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	attemptID, userID, puzzleID := uuid.New(), uuid.New(), uuid.New()
+	expectPuzzleAttempt(mock, attemptID, userID, puzzleID, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "puzzle_ratings" .* ON CONFLICT \("user_id"\) DO NOTHING`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT \* FROM "puzzle_ratings" WHERE user_id = \$1 ORDER BY .* FOR UPDATE`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "rating", "deviation", "volatility"}).
+			AddRow(userID, 1500, 350, 0.06))
+	// Another solver has rated the puzzle since the attempt loaded it
+	mock.ExpectQuery(`SELECT "rating","rating_deviation","rating_volatility" FROM "puzzles" WHERE id = \$1 LIMIT 1 FOR UPDATE`).
+		WithArgs(puzzleID).
+		WillReturnRows(sqlmock.NewRows([]string{"rating", "rating_deviation", "rating_volatility"}).
+			AddRow(1400, 80, 0.06))
+	mock.ExpectExec(`UPDATE "puzzle_attempts" SET "finished_at"=\$1,"ply"=\$2,"rating_change"=\$3,"status"=\$4 WHERE id = \$5 AND status = \$6`).
+		WithArgs(testutil.AnyTime{}, 1, sqlmock.AnyArg(), models.PuzzleAttemptFailed, attemptID, models.PuzzleAttemptActive).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO "puzzle_ratings" .* ON CONFLICT \("user_id"\) DO UPDATE SET`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "puzzles" SET "plays"=plays \+ 1,"rating"=\$1,"rating_deviation"=\$2,"rating_volatility"=\$3,"updated_at"=\$4 WHERE id = \$5`).
+		WithArgs(ratingBetween{1400, 1450}, sqlmock.AnyArg(), sqlmock.AnyArg(), testutil.AnyTime{}, puzzleID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := NewPuzzleService(db).SubmitMove(userID, attemptID, "e2", "e3")
+
+	require.NoError(t, err)
+	assert.False(t, result.Correct)
+	assert.Equal(t, models.PuzzleAttemptFailed, result.Status)
+	assert.Equal(t, []string{"e2e8", "a8e8", "e1e8"}, result.Solution)
+	assert.Less(t, result.RatingChange, 0)
+	assert.Equal(t, 1500+result.RatingChange, result.Rating)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPuzzleService_SubmitMove_IllegalMove(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	attemptID, userID, puzzleID := uuid.New(), uuid.New(), uuid.New()
+	expectPuzzleAttempt(mock, attemptID, userID, puzzleID, 1)
+
+	_, err := NewPuzzleService(db).SubmitMove(userID, attemptID, "e2", "d4")
+
+	assert.ErrorIs(t, err, ErrInvalidMove)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPuzzleService_ImportCSV(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	dump := `PuzzleId,FEN,Moves,Rating,RatingDeviation,Popularity,NbPlays,Themes,GameUrl,OpeningTags
+00001,` + backRankFEN + `,` + backRankMoves + `,1450,76,95,1200,backRankMate mateIn2 short,https://example.org/1,
+00002,r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1,e1g1 e8g8,1500,80,90,10,castling,,
+00003,not a fen,e2e4 e7e5,1500,80,90,10,opening,,
+00004,8/4P3/8/8/8/8/8/k3K3 w - - 0 1,e7e8q a1a2,1500,80,90,10,promotion,,
+`
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "puzzles" .* ON CONFLICT \("external_id"\) DO NOTHING RETURNING "id"`).
+		WithArgs("00001", nil, 0, backRankFEN, backRankMoves, "backRankMate mateIn2 short", 1450.0, 76.0, 0.06, 1200,
+			testutil.AnyTime{}, testutil.AnyTime{}, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectCommit()
+
+	stats, err := NewPuzzleService(db).ImportCSV(strings.NewReader(dump))
+
+	require.NoError(t, err)
+	assert.Equal(t, PuzzleImportStats{Imported: 1, Skipped: 1, Unsupported: 2}, stats)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/render"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Board diagrams and replays are cached in Redis under the hash of
+// everything that goes into them, so a shared position or game is only drawn
+// once however often it's fetched, and the hash doubles as the ETag.
+
+const (
+	renderCacheTTL = 24 * time.Hour
+	// Replays are far costlier to draw than diagrams, so only a few are drawn
+	// at once; a request waits up to gifRenderWait for its turn.
+	maxGIFRenders = 2
+	gifRenderWait = 10 * time.Second
+)
+
+var ErrRenderBusy = errors.New("too many replays being drawn, try again shortly")
+
+type RenderService struct {
+	db         *gorm.DB
+	redis      *redis.Client
+	gifRenders chan struct{}
+	gifWait    time.Duration
+}
+
+type RenderedBoard struct {
+	Data        []byte
+	ContentType string
+	Hash        string
+}
+
+// GameGIFOptions are the viewer's choices for a game replay; the rest comes
+// from the game.
+type GameGIFOptions struct {
+	Flipped bool
+	Theme   models.ArenaTheme
+	Size    int
+	Delay   time.Duration
+}
+
+func NewRenderService(db *gorm.DB, redis *redis.Client) *RenderService {
+	return &RenderService{db: db, redis: redis, gifRenders: make(chan struct{}, maxGIFRenders), gifWait: gifRenderWait}
+}
+
+// RenderBoard draws the diagram described by opts, or returns the cached copy.
+// Invalid options are reported as render.ErrInvalidOptions.
+func (rs *RenderService) RenderBoard(opts render.Options, format render.Format) (*RenderedBoard, error) {
+	opts, err := opts.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	return rs.cached(opts.Hash(format), render.ContentType(format), func() ([]byte, error) {
+		return render.Render(opts, format)
+	})
+}
+
+// RenderGameGIF draws an animated replay of a game, one frame per move with
+// the players' names and clocks, ending on the result once there is one.
+// ErrRenderBusy is returned when the replay isn't cached and too many others
+// are being drawn.
+func (rs *RenderService) RenderGameGIF(gameID uuid.UUID, opts GameGIFOptions) (*RenderedBoard, error) {
+	var game models.Game
+	err := rs.db.Preload("WhitePlayer").Preload("BlackPlayer").
+		Preload("Moves", func(db *gorm.DB) *gorm.DB {
+			return db.Order("move_number ASC")
+		}).
+		First(&game, "id = ?", gameID).Error
+	if err != nil {
+		return nil, lookupError(err)
+	}
+
+	gifOpts, err := replayOptions(&game, opts).Normalize()
+	if err != nil {
+		return nil, err
+	}
+	return rs.cached(gifOpts.Hash(), "image/gif", func() ([]byte, error) {
+		return rs.renderGIF(gifOpts)
+	})
+}
+
+// renderGIF draws a replay once one of the few drawing slots is free.
+func (rs *RenderService) renderGIF(opts render.GIFOptions) ([]byte, error) {
+	select {
+	case rs.gifRenders <- struct{}{}:
+	case <-time.After(rs.gifWait):
+		return nil, ErrRenderBusy
+	}
+	defer func() { <-rs.gifRenders }()
+	return render.RenderGIF(opts)
+}
+
+// cached returns the image cached under hash, or draws and caches it. A
+// failing cache only costs the redraw.
+func (rs *RenderService) cached(hash, contentType string, draw func() ([]byte, error)) (*RenderedBoard, error) {
+	board := &RenderedBoard{ContentType: contentType, Hash: hash}
+
+	ctx := context.Background()
+	key := renderCacheKey(hash)
+	data, err := rs.redis.Get(ctx, key).Bytes()
+	if err == nil {
+		board.Data = data
+		return board, nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		log.Printf("Error reading cached image %s: %v", hash, err)
+	}
+
+	data, err = draw()
+	if err != nil {
+		return nil, err
+	}
+	if err := rs.redis.Set(ctx, key, data, renderCacheTTL).Err(); err != nil {
+		log.Printf("Error caching image %s: %v", hash, err)
+	}
+
+	board.Data = data
+	return board, nil
+}
+
+func renderCacheKey(hash string) string {
+	return fmt.Sprintf("render:%s", hash)
+}
+
+// replayOptions turns a game with its players and moves into GIF frames.
+func replayOptions(game *models.Game, opts GameGIFOptions) render.GIFOptions {
+	start, err := gameStartPosition(game)
+	if err != nil {
+		start = chess.StandardStartFEN
+	}
+
+	frame := render.GIFFrame{
+		FEN:        start,
+		WhiteClock: time.Duration(game.TimeControl) * time.Second,
+		BlackClock: time.Duration(startingTime(game, "black")) * time.Second,
+	}
+	frames := []render.GIFFrame{frame}
+	for _, move := range game.Moves {
+		mover := sideToMove(frame.FEN)
+		frame.FEN = move.FENAfter
+		frame.LastMove = move.FromSquare + move.ToSquare
+		if mover == "white" {
+			frame.WhiteClock = time.Duration(move.TimeLeft) * time.Second
+		} else {
+			frame.BlackClock = time.Duration(move.TimeLeft) * time.Second
+		}
+		frames = append(frames, frame)
+	}
+
+	return render.GIFOptions{
+		White:   playerName(game.WhitePlayer, "White"),
+		Black:   playerName(game.BlackPlayer, "Black"),
+		Frames:  frames,
+		Result:  resultCaption(game),
+		Clocks:  game.TimeControl > 0,
+		Flipped: opts.Flipped,
+		Theme:   opts.Theme,
+		Size:    opts.Size,
+		Delay:   opts.Delay,
+	}
+}
+
+func playerName(player *models.User, fallback string) string {
+	if player == nil || player.Username == "" {
+		return fallback
+	}
+	return player.Username
+}
+
+// resultCaption describes how a finished game ended, e.g. "1-0 checkmate".
+func resultCaption(game *models.Game) string {
+	if game.Result == nil {
+		return ""
+	}
+
+	var caption string
+	switch *game.Result {
+	case models.GameResultWhiteWins:
+		caption = "1-0"
+	case models.GameResultBlackWins:
+		caption = "0-1"
+	case models.GameResultDraw:
+		caption = "1/2-1/2"
+	default:
+		return "Abandoned"
+	}
+
+	if n := len(game.Moves); n > 0 {
+		switch last := game.Moves[n-1]; {
+		case last.IsCheckmate:
+			caption += " checkmate"
+		case last.IsStalemate:
+			caption += " stalemate"
+		}
+	}
+	return caption
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/render"
+	"arcane-chess/internal/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderService_RenderBoard_Caches(t *testing.T) {
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer testutil.CleanupRedis(redisServer)
+	rs := NewRenderService(nil, redisClient)
+
+	opts := render.Options{FEN: chess.StandardStartFEN, LastMove: "e2e4"}
+	board, err := rs.RenderBoard(opts, render.FormatPNG)
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", board.ContentType)
+
+	cached, err := redisServer.Get(renderCacheKey(board.Hash))
+	require.NoError(t, err)
+	assert.Equal(t, string(board.Data), cached)
+
+	// A cached copy is served as is
+	redisServer.Set(renderCacheKey(board.Hash), "cached")
+	again, err := rs.RenderBoard(opts, render.FormatPNG)
+	require.NoError(t, err)
+	assert.Equal(t, "cached", string(again.Data))
+	assert.Equal(t, board.Hash, again.Hash)
+}
+
+func TestRenderService_RenderBoard_InvalidOptions(t *testing.T) {
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer testutil.CleanupRedis(redisServer)
+
+	_, err := NewRenderService(nil, redisClient).RenderBoard(render.Options{FEN: "not a fen"}, render.FormatSVG)
+	assert.ErrorIs(t, err, render.ErrInvalidOptions)
+}
+
+func TestReplayOptions(t *testing.T) {
+	result := models.GameResultBlackWins
+	game := &models.Game{
+		Variant:     models.GameVariantStandard,
+		TimeControl: 300,
+		Result:      &result,
+		WhitePlayer: &models.User{Username: "alice"},
+		Moves: []models.GameMove{
+			{FromSquare: "f2", ToSquare: "f3", FENAfter: "rnbqkbnr/pppppppp/8/8/8/5P2/PPPPP1PP/RNBQKBNR b KQkq - 0 1", TimeLeft: 298},
+			{FromSquare: "e7", ToSquare: "e5", FENAfter: "rnbqkbnr/pppp1ppp/8/4p3/8/5P2/PPPPP1PP/RNBQKBNR w KQkq - 0 2", TimeLeft: 295},
+		},
+	}
+
+	opts := replayOptions(game, GameGIFOptions{Flipped: true})
+
+	assert.Equal(t, "alice", opts.White)
+	assert.Equal(t, "Black", opts.Black)
+	assert.Equal(t, "0-1", opts.Result)
+	assert.True(t, opts.Clocks)
+	assert.True(t, opts.Flipped)
+	require.Len(t, opts.Frames, 3)
+	assert.Equal(t, chess.StandardStartFEN, opts.Frames[0].FEN)
+	assert.Equal(t, "e7e5", opts.Frames[2].LastMove)
+	assert.Equal(t, 298*time.Second, opts.Frames[2].WhiteClock)
+	assert.Equal(t, 295*time.Second, opts.Frames[2].BlackClock)
+
+	game.Moves[1].IsCheckmate = true
+	assert.Equal(t, "0-1 checkmate", resultCaption(game))
+}
+
+func TestRenderService_LimitsConcurrentReplays(t *testing.T) {
+	rs := NewRenderService(nil, nil)
+	rs.gifWait = 50 * time.Millisecond
+	opts := render.GIFOptions{Frames: []render.GIFFrame{{FEN: chess.StandardStartFEN}}, Size: 64}
+
+	for i := 0; i < maxGIFRenders; i++ {
+		rs.gifRenders <- struct{}{}
+	}
+	_, err := rs.renderGIF(opts)
+	assert.ErrorIs(t, err, ErrRenderBusy)
+
+	// Once a replay finishes the next one is drawn
+	<-rs.gifRenders
+	data, err := rs.renderGIF(opts)
+	require.NoError(t, err)
+	assert.NotEmpty(t, data)
+	assert.Len(t, rs.gifRenders, maxGIFRenders-1)
+}
@@ -0,0 +1,473 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// In a simul the host plays every participant at once. Users sign up while
+// it's open; starting it creates one game per participant, all with the host
+// on the same side, optionally without a clock for the host. The host is told
+// whenever a board is waiting for them and can fetch those boards in the
+// order they've been waiting, and the arena follows the score as results
+// come in. The simul finishes with its last game.
+
+const (
+	defaultSimulTimeControl  = 1800 // 30 minutes
+	defaultSimulParticipants = 20
+	maxSimulParticipants     = 50
+)
+
+var (
+	ErrSimulNotFound       = errors.New("simul not found")
+	ErrSimulNotOpen        = errors.New("simul is no longer taking participants")
+	ErrSimulFull           = errors.New("simul is full")
+	ErrNotSimulHost        = errors.New("only the host can do that")
+	ErrAlreadyInSimul      = errors.New("already in the simul")
+	ErrNotInSimul          = errors.New("not in the simul")
+	ErrNoSimulParticipants = errors.New("simul has no participants")
+	ErrInvalidSimul        = errors.New("invalid simul")
+)
+
+// ArenaRoom is the Hub room that carries an arena's announcements, such as
+// its simuls' results.
+func ArenaRoom(arenaID string) string {
+	return "arena:" + arenaID
+}
+
+// SimulOptions are the settings a simul is created with. The zero value is a
+// standard simul with the host playing white on their own clock.
+type SimulOptions struct {
+	Name            string
+	Variant         models.GameVariant
+	HostColor       string // "white" or "black"
+	TimeControl     int    // seconds for each side of every game, 30 minutes when zero
+	NoHostClock     bool   // the host plays without a clock
+	MaxParticipants int
+}
+
+// SimulScore counts a simul's games from the host's side.
+type SimulScore struct {
+	Wins    int `json:"wins"`
+	Draws   int `json:"draws"`
+	Losses  int `json:"losses"`
+	Playing int `json:"playing"`
+}
+
+// SimulDetail is a simul with its participants and its score so far.
+type SimulDetail struct {
+	models.Simul
+	Score SimulScore `json:"score"`
+}
+
+// SimulUpdate describes one change to a simul. Turn updates go to the host
+// only; everything else is announced to the simul's arena.
+type SimulUpdate struct {
+	SimulID uuid.UUID     `json:"simul_id"`
+	ArenaID uuid.UUID     `json:"arena_id"`
+	HostID  uuid.UUID     `json:"host_id"`
+	Op      string        `json:"op"` // created, joined, left, started, turn, result or finished
+	Simul   *models.Simul `json:"simul,omitempty"`
+	UserID  *uuid.UUID    `json:"user_id,omitempty"` // the participant who joined or left
+	Game    *models.Game  `json:"game,omitempty"`    // the board waiting for the host, or just finished
+	Score   *SimulScore   `json:"score,omitempty"`
+}
+
+type SimulService struct {
+	db    *gorm.DB
+	games *GameService
+	hub   *Hub
+}
+
+func NewSimulService(db *gorm.DB, games *GameService) *SimulService {
+	return &SimulService{db: db, games: games}
+}
+
+// PublishTo sends the simuls' updates through hub. Until it's called they
+// go nowhere.
+func (ss *SimulService) PublishTo(hub *Hub) {
+	ss.hub = hub
+}
+
+// CreateSimul opens a simul hosted by hostID in an arena.
+func (ss *SimulService) CreateSimul(arenaID, hostID uuid.UUID, opts SimulOptions) (*models.Simul, error) {
+	simul := models.Simul{
+		ArenaID:         arenaID,
+		HostID:          hostID,
+		Name:            strings.TrimSpace(opts.Name),
+		Variant:         opts.Variant,
+		HostColor:       opts.HostColor,
+		TimeControl:     opts.TimeControl,
+		HostClock:       !opts.NoHostClock,
+		MaxParticipants: opts.MaxParticipants,
+		Status:          models.SimulStatusOpen,
+	}
+	if simul.Variant == "" {
+		simul.Variant = models.GameVariantStandard
+	}
+	if _, err := startingPosition(simul.Variant); err != nil {
+		return nil, err
+	}
+	if simul.HostColor == "" {
+		simul.HostColor = "white"
+	}
+	if simul.HostColor != "white" && simul.HostColor != "black" {
+		return nil, fmt.Errorf("%w: the host plays white or black", ErrInvalidSimul)
+	}
+	if simul.TimeControl <= 0 {
+		simul.TimeControl = defaultSimulTimeControl
+	}
+	if simul.MaxParticipants <= 0 {
+		simul.MaxParticipants = defaultSimulParticipants
+	}
+	if simul.MaxParticipants > maxSimulParticipants {
+		return nil, fmt.Errorf("%w: at most %d participants", ErrInvalidSimul, maxSimulParticipants)
+	}
+	simul.Name = studyName(simul.Name, "Simul")
+
+	var arenas int64
+	if err := ss.db.Model(&models.Arena{}).Where("id = ?", arenaID).Count(&arenas).Error; err != nil {
+		return nil, fmt.Errorf("failed to load arena: %w", err)
+	}
+	if arenas == 0 {
+		return nil, ErrArenaNotFound
+	}
+
+	if err := ss.db.Create(&simul).Error; err != nil {
+		return nil, fmt.Errorf("failed to create simul: %w", err)
+	}
+	ss.publish(SimulUpdate{Op: "created", Simul: &simul}, &simul)
+	return &simul, nil
+}
+
+// GetSimul returns a simul with its host, its participants and their games,
+// and its score.
+func (ss *SimulService) GetSimul(simulID uuid.UUID) (*SimulDetail, error) {
+	var simul models.Simul
+	err := ss.db.Preload("Host").
+		Preload("Participants", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		}).
+		Preload("Participants.User").
+		Preload("Participants.Game").
+		First(&simul, "id = ?", simulID).Error
+	if err != nil {
+		return nil, simulLookupError(err)
+	}
+
+	var games []models.Game
+	for _, participant := range simul.Participants {
+		if participant.Game != nil {
+			games = append(games, *participant.Game)
+		}
+	}
+	return &SimulDetail{Simul: simul, Score: simulScore(&simul, games)}, nil
+}
+
+// ListSimuls returns an arena's simuls that haven't finished, newest first.
+func (ss *SimulService) ListSimuls(arenaID uuid.UUID) ([]models.Simul, error) {
+	var simuls []models.Simul
+	err := ss.db.Preload("Host").
+		Where("arena_id = ? AND status IN ?", arenaID, []models.SimulStatus{
+			models.SimulStatusOpen,
+			models.SimulStatusActive,
+		}).
+		Order("created_at DESC").
+		Find(&simuls).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list simuls: %w", err)
+	}
+	return simuls, nil
+}
+
+// Join signs userID up for an open simul.
+func (ss *SimulService) Join(simulID, userID uuid.UUID) (*models.SimulParticipant, error) {
+	var simul *models.Simul
+	var participant models.SimulParticipant
+	err := ss.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if simul, err = loadSimul(tx, simulID); err != nil {
+			return err
+		}
+		if simul.Status != models.SimulStatusOpen {
+			return ErrSimulNotOpen
+		}
+		if simul.HostID == userID {
+			return ErrAlreadyInSimul
+		}
+
+		err = tx.Take(&participant, "simul_id = ? AND user_id = ?", simulID, userID).Error
+		switch {
+		case err == nil:
+			return ErrAlreadyInSimul
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return fmt.Errorf("failed to load simul participant: %w", err)
+		}
+
+		var count int64
+		if err := tx.Model(&models.SimulParticipant{}).Where("simul_id = ?", simulID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count simul participants: %w", err)
+		}
+		if count >= int64(simul.MaxParticipants) {
+			return ErrSimulFull
+		}
+		participant = models.SimulParticipant{SimulID: simulID, UserID: userID}
+		if err := tx.Create(&participant).Error; err != nil {
+			return fmt.Errorf("failed to join simul: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ss.publish(SimulUpdate{Op: "joined", UserID: &userID}, simul)
+	return &participant, nil
+}
+
+// Leave withdraws userID from a simul that hasn't started.
+func (ss *SimulService) Leave(simulID, userID uuid.UUID) error {
+	simul, err := loadSimul(ss.db, simulID)
+	if err != nil {
+		return err
+	}
+	if simul.Status != models.SimulStatusOpen {
+		return ErrSimulNotOpen
+	}
+
+	result := ss.db.Delete(&models.SimulParticipant{}, "simul_id = ? AND user_id = ?", simulID, userID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to leave simul: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotInSimul
+	}
+
+	ss.publish(SimulUpdate{Op: "left", UserID: &userID}, simul)
+	return nil
+}
+
+// Start closes sign-ups and starts a game between the host and each
+// participant. Only the host can start a simul.
+func (ss *SimulService) Start(simulID, hostID uuid.UUID) (*models.Simul, []models.Game, error) {
+	var simul *models.Simul
+	var games []models.Game
+	err := ss.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if simul, err = loadSimul(tx, simulID); err != nil {
+			return err
+		}
+		if simul.HostID != hostID {
+			return ErrNotSimulHost
+		}
+		if simul.Status != models.SimulStatusOpen {
+			return ErrSimulNotOpen
+		}
+
+		var participants []models.SimulParticipant
+		if err := tx.Where("simul_id = ?", simulID).Order("created_at ASC").Find(&participants).Error; err != nil {
+			return fmt.Errorf("failed to load simul participants: %w", err)
+		}
+		if len(participants) == 0 {
+			return ErrNoSimulParticipants
+		}
+
+		// Only one request gets to start the simul
+		now := time.Now()
+		result := tx.Model(&models.Simul{}).
+			Where("id = ? AND status = ?", simulID, models.SimulStatusOpen).
+			Updates(map[string]interface{}{"status": models.SimulStatusActive, "started_at": now})
+		if result.Error != nil {
+			return fmt.Errorf("failed to start simul: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrSimulNotOpen
+		}
+		simul.Status = models.SimulStatusActive
+		simul.StartedAt = &now
+
+		opts := GameOptions{Variant: simul.Variant, TimeControl: simul.TimeControl, SimulID: &simul.ID}
+		if !simul.HostClock {
+			opts.UntimedSide = simul.HostColor
+		}
+		for _, participant := range participants {
+			white, black := simul.HostID, participant.UserID
+			if simul.HostColor == "black" {
+				white, black = black, white
+			}
+			game, err := newGame(simul.ArenaID, white, opts)
+			if err != nil {
+				return err
+			}
+			if err := insertGame(tx, game); err != nil {
+				return fmt.Errorf("failed to create simul game: %w", err)
+			}
+			if err := seatBlack(tx, game, black, now); err != nil {
+				return fmt.Errorf("failed to start simul game: %w", err)
+			}
+			err = tx.Model(&models.SimulParticipant{}).
+				Where("simul_id = ? AND user_id = ?", simulID, participant.UserID).
+				Update("game_id", game.ID).Error
+			if err != nil {
+				return fmt.Errorf("failed to save simul game: %w", err)
+			}
+			games = append(games, *game)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range games {
+		ss.games.cacheGameState(&games[i])
+		ss.games.publishGameUpdate(games[i].ID, "joined", &games[i])
+	}
+	ss.publish(SimulUpdate{Op: "started", Simul: simul, Score: &SimulScore{Playing: len(games)}}, simul)
+	return simul, games, nil
+}
+
+// HostQueue returns the host's boards where it's their turn, those that have
+// been waiting longest first.
+func (ss *SimulService) HostQueue(simulID, hostID uuid.UUID) ([]models.Game, error) {
+	simul, err := loadSimul(ss.db, simulID)
+	if err != nil {
+		return nil, err
+	}
+	if simul.HostID != hostID {
+		return nil, ErrNotSimulHost
+	}
+
+	var games []models.Game
+	err = ss.db.Preload("WhitePlayer").Preload("BlackPlayer").
+		Where("simul_id = ? AND status = ? AND current_turn = ?", simulID, models.GameStatusActive, simul.HostColor).
+		Order("last_move_at ASC").
+		Find(&games).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load simul games: %w", err)
+	}
+	return games, nil
+}
+
+// GameMoved is a GameService.OnMove hook telling a simul's host that one of
+// its boards is waiting for them.
+func (ss *SimulService) GameMoved(game models.Game, move models.GameMove) {
+	if game.SimulID == nil || isGameOver(&game) {
+		return
+	}
+	simul, err := loadSimul(ss.db, *game.SimulID)
+	if err != nil {
+		log.Printf("Error loading simul %s: %v", *game.SimulID, err)
+		return
+	}
+	if game.CurrentTurn != simul.HostColor {
+		return
+	}
+	ss.publish(SimulUpdate{Op: "turn", Game: &game}, simul)
+}
+
+// GameFinished is a GameService.OnGameFinished hook announcing a simul
+// game's result with the new score, and finishing the simul with its last
+// game.
+func (ss *SimulService) GameFinished(game models.Game) {
+	if game.SimulID == nil {
+		return
+	}
+	if err := ss.recordResult(game); err != nil {
+		log.Printf("Error recording the result of simul game %s: %v", game.ID, err)
+	}
+}
+
+func (ss *SimulService) recordResult(game models.Game) error {
+	simul, err := loadSimul(ss.db, *game.SimulID)
+	if err != nil {
+		return err
+	}
+
+	var games []models.Game
+	if err := ss.db.Select("id", "status", "result").Where("simul_id = ?", simul.ID).Find(&games).Error; err != nil {
+		return fmt.Errorf("failed to load simul games: %w", err)
+	}
+	score := simulScore(simul, games)
+	ss.publish(SimulUpdate{Op: "result", Game: &game, Score: &score}, simul)
+	if score.Playing > 0 {
+		return nil
+	}
+
+	// Every hook sees the last game finish; one of them finishes the simul
+	now := time.Now()
+	result := ss.db.Model(&models.Simul{}).
+		Where("id = ? AND status = ?", simul.ID, models.SimulStatusActive).
+		Updates(map[string]interface{}{"status": models.SimulStatusFinished, "finished_at": now})
+	if result.Error != nil {
+		return fmt.Errorf("failed to finish simul: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	simul.Status = models.SimulStatusFinished
+	simul.FinishedAt = &now
+	ss.publish(SimulUpdate{Op: "finished", Simul: simul, Score: &score}, simul)
+	return nil
+}
+
+// publish fills in the simul's IDs and sends update through the hub: turns
+// to the host, anything else to the simul's arena.
+func (ss *SimulService) publish(update SimulUpdate, simul *models.Simul) {
+	if ss.hub == nil {
+		return
+	}
+	update.SimulID, update.ArenaID, update.HostID = simul.ID, simul.ArenaID, simul.HostID
+	message := Message{Type: "simul_update", Data: update}
+	if update.Op == "turn" {
+		ss.hub.SendToUser(simul.HostID.String(), message)
+		return
+	}
+	message.Room = ArenaRoom(simul.ArenaID.String())
+	ss.hub.BroadcastToRoom(message.Room, message)
+}
+
+// simulScore counts games from the side of simul's host.
+func simulScore(simul *models.Simul, games []models.Game) SimulScore {
+	var score SimulScore
+	for _, game := range games {
+		if game.Result == nil {
+			if !isGameOver(&game) {
+				score.Playing++
+			}
+			continue
+		}
+		switch *game.Result {
+		case winnerResult(simul.HostColor):
+			score.Wins++
+		case winnerResult(getOpponentColor(simul.HostColor)):
+			score.Losses++
+		case models.GameResultDraw:
+			score.Draws++
+		}
+	}
+	return score
+}
+
+func loadSimul(db *gorm.DB, simulID uuid.UUID) (*models.Simul, error) {
+	var simul models.Simul
+	if err := db.Take(&simul, "id = ?", simulID).Error; err != nil {
+		return nil, simulLookupError(err)
+	}
+	return &simul, nil
+}
+
+func simulLookupError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSimulNotFound
+	}
+	return fmt.Errorf("failed to load simul: %w", err)
+}
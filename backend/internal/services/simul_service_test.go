@@ -0,0 +1,337 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func expectSimul(mock sqlmock.Sqlmock, simulID, arenaID, hostID uuid.UUID, hostColor string, status models.SimulStatus) {
+	mock.ExpectQuery(`SELECT \* FROM "simuls" WHERE id = \$1 LIMIT 1`).
+		WithArgs(simulID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "arena_id", "host_id", "variant", "host_color", "time_control", "host_clock", "max_participants", "status"}).
+			AddRow(simulID, arenaID, hostID, models.GameVariantStandard, hostColor, 1800, false, 2, status))
+}
+
+func TestSimulService_CreateSimul_Rejected(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	service := NewSimulService(db, nil)
+	arenaID, hostID := uuid.New(), uuid.New()
+
+	_, err := service.CreateSimul(arenaID, hostID, SimulOptions{HostColor: "red"})
+	assert.ErrorIs(t, err, ErrInvalidSimul)
+
+	_, err = service.CreateSimul(arenaID, hostID, SimulOptions{MaxParticipants: maxSimulParticipants + 1})
+	assert.ErrorIs(t, err, ErrInvalidSimul)
+
+	_, err = service.CreateSimul(arenaID, hostID, SimulOptions{Variant: "atomic"})
+	assert.ErrorIs(t, err, ErrUnknownVariant)
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "arenas" WHERE id = \$1`).
+		WithArgs(arenaID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	_, err = service.CreateSimul(arenaID, hostID, SimulOptions{})
+	assert.ErrorIs(t, err, ErrArenaNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSimulService_Join(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	service := NewSimulService(db, nil)
+	simulID, arenaID, hostID, userID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	mock.ExpectBegin()
+	expectSimul(mock, simulID, arenaID, hostID, "white", models.SimulStatusOpen)
+	mock.ExpectQuery(`SELECT \* FROM "simul_participants" WHERE simul_id = \$1 AND user_id = \$2 LIMIT 1`).
+		WithArgs(simulID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"simul_id", "user_id"}))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "simul_participants" WHERE simul_id = \$1`).
+		WithArgs(simulID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectExec(`INSERT INTO "simul_participants"`).
+		WithArgs(simulID, userID, nil, testutil.AnyTime{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	participant, err := service.Join(simulID, userID)
+	require.NoError(t, err)
+	assert.Equal(t, userID, participant.UserID)
+
+	// The simul takes two, and already has them
+	mock.ExpectBegin()
+	expectSimul(mock, simulID, arenaID, hostID, "white", models.SimulStatusOpen)
+	mock.ExpectQuery(`SELECT \* FROM "simul_participants" WHERE simul_id = \$1 AND user_id = \$2 LIMIT 1`).
+		WithArgs(simulID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"simul_id", "user_id"}))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "simul_participants" WHERE simul_id = \$1`).
+		WithArgs(simulID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectRollback()
+	_, err = service.Join(simulID, userID)
+	assert.ErrorIs(t, err, ErrSimulFull)
+
+	// The host doesn't play themself
+	mock.ExpectBegin()
+	expectSimul(mock, simulID, arenaID, hostID, "white", models.SimulStatusOpen)
+	mock.ExpectRollback()
+	_, err = service.Join(simulID, hostID)
+	assert.ErrorIs(t, err, ErrAlreadyInSimul)
+
+	mock.ExpectBegin()
+	expectSimul(mock, simulID, arenaID, hostID, "white", models.SimulStatusActive)
+	mock.ExpectRollback()
+	_, err = service.Join(simulID, userID)
+	assert.ErrorIs(t, err, ErrSimulNotOpen)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSimulService_Start(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	service := NewSimulService(db, NewGameService(db, redisClient))
+	simulID, arenaID, hostID, userID, gameID := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	mock.ExpectBegin()
+	expectSimul(mock, simulID, arenaID, hostID, "black", models.SimulStatusOpen)
+	mock.ExpectQuery(`SELECT \* FROM "simul_participants" WHERE simul_id = \$1 ORDER BY created_at ASC`).
+		WithArgs(simulID).
+		WillReturnRows(sqlmock.NewRows([]string{"simul_id", "user_id"}).AddRow(simulID, userID))
+	mock.ExpectExec(`UPDATE "simuls" SET .* WHERE id = \$4 AND status = \$5`).
+		WithArgs(testutil.AnyTime{}, models.SimulStatusActive, testutil.AnyTime{}, simulID, models.SimulStatusOpen).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The participant plays white against the host, whose clock never runs
+	mock.ExpectQuery(`INSERT INTO "games"`).
+		WithArgs(
+			arenaID,                    // arena_id
+			models.GameVariantStandard, // variant
+			userID,                     // white_player_id
+			nil,                        // black_player_id
+			models.GameStatusWaiting,   // status
+			nil,                        // result
+			"white",                    // current_turn
+			sqlmock.AnyArg(),           // board_state
+			0,                          // move_count
+			"",                         // eco
+			"",                         // opening_name
+			1800,                       // time_control
+			1800,                       // black_time_control
+			0,                          // white_increment
+			0,                          // black_increment
+			0,                          // days_per_move
+			0,                          // vote_window
+			"black",                    // untimed_side
+			"",                         // handicap
+			"",                         // handicap_side
+			false,                      // casual
+			simulID,                    // simul_id
+			1800,                       // white_time
+			1800,                       // black_time
+			nil,                        // started_at
+			nil,                        // finished_at
+			nil,                        // last_move_at
+			nil,                        // move_deadline
+			0,                          // version
+			testutil.AnyTime{},         // created_at
+			testutil.AnyTime{},         // updated_at
+			testutil.AnyUUID{},         // id
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(gameID))
+	expectEvent(mock, gameID, models.GameEventCreated, 0)
+	expectMoveUpdate(mock, gameID, "white", 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectEvent(mock, gameID, models.GameEventJoined, 1)
+	mock.ExpectExec(`UPDATE "simul_participants" SET "game_id"=\$1 WHERE simul_id = \$2 AND user_id = \$3`).
+		WithArgs(gameID, simulID, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	simul, games, err := service.Start(simulID, hostID)
+
+	require.NoError(t, err)
+	assert.Equal(t, models.SimulStatusActive, simul.Status)
+	require.Len(t, games, 1)
+	assert.Equal(t, &userID, games[0].WhitePlayerID)
+	assert.Equal(t, &hostID, games[0].BlackPlayerID)
+	assert.Equal(t, models.GameStatusActive, games[0].Status)
+	assert.Equal(t, "black", games[0].UntimedSide)
+	assert.True(t, redisServer.Exists("game:"+gameID.String()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSimulService_Start_Rejected(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	service := NewSimulService(db, nil)
+	simulID, arenaID, hostID := uuid.New(), uuid.New(), uuid.New()
+
+	mock.ExpectBegin()
+	expectSimul(mock, simulID, arenaID, hostID, "white", models.SimulStatusOpen)
+	mock.ExpectRollback()
+	_, _, err := service.Start(simulID, uuid.New())
+	assert.ErrorIs(t, err, ErrNotSimulHost)
+
+	mock.ExpectBegin()
+	expectSimul(mock, simulID, arenaID, hostID, "white", models.SimulStatusOpen)
+	mock.ExpectQuery(`SELECT \* FROM "simul_participants"`).
+		WillReturnRows(sqlmock.NewRows([]string{"simul_id", "user_id"}))
+	mock.ExpectRollback()
+	_, _, err = service.Start(simulID, hostID)
+	assert.ErrorIs(t, err, ErrNoSimulParticipants)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSimulService_HostQueue(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	service := NewSimulService(db, nil)
+	simulID, arenaID, hostID := uuid.New(), uuid.New(), uuid.New()
+	first, second := uuid.New(), uuid.New()
+
+	expectSimul(mock, simulID, arenaID, hostID, "black", models.SimulStatusActive)
+	mock.ExpectQuery(`SELECT \* FROM "games" WHERE simul_id = \$1 AND status = \$2 AND current_turn = \$3 ORDER BY last_move_at ASC`).
+		WithArgs(simulID, models.GameStatusActive, "black").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "current_turn"}).AddRow(first, "black").AddRow(second, "black"))
+
+	games, err := service.HostQueue(simulID, hostID)
+	require.NoError(t, err)
+	require.Len(t, games, 2)
+	assert.Equal(t, first, games[0].ID)
+
+	expectSimul(mock, simulID, arenaID, hostID, "black", models.SimulStatusActive)
+	_, err = service.HostQueue(simulID, uuid.New())
+	assert.ErrorIs(t, err, ErrNotSimulHost)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSimulService_GameMoved_TellsHost(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	hub := NewHub()
+	go hub.Run()
+	service := NewSimulService(db, nil)
+	service.PublishTo(hub)
+
+	simulID, arenaID, hostID, userID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	host := connectTestClient(t, hub, hostID.String())
+	participant := connectTestClient(t, hub, userID.String())
+
+	game := activeGame(hostID, userID)
+	game.SimulID = &simulID
+	game.CurrentTurn = "white"
+	expectSimul(mock, simulID, arenaID, hostID, "white", models.SimulStatusActive)
+	service.GameMoved(game, models.GameMove{MoveNumber: 2})
+
+	message := expectMessage(t, host, "simul_update")
+	data := message.Data.(map[string]interface{})
+	assert.Equal(t, "turn", data["op"])
+	assert.Equal(t, game.ID.String(), data["game"].(map[string]interface{})["id"])
+	expectNoMessage(t, participant)
+
+	// Nothing when the move hands the turn to the participant
+	game.CurrentTurn = "black"
+	expectSimul(mock, simulID, arenaID, hostID, "white", models.SimulStatusActive)
+	service.GameMoved(game, models.GameMove{MoveNumber: 3})
+	expectNoMessage(t, host)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSimulService_GameFinished_AnnouncesScore(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+	}()
+
+	hub := NewHub()
+	go hub.Run()
+	service := NewSimulService(db, nil)
+	service.PublishTo(hub)
+
+	simulID, arenaID, hostID := uuid.New(), uuid.New(), uuid.New()
+	watcher := connectTestClient(t, hub, uuid.New().String())
+	hub.JoinRoom(watcher, ArenaRoom(arenaID.String()))
+
+	game := activeGame(hostID, uuid.New())
+	game.SimulID = &simulID
+	game.Status = models.GameStatusFinished
+	draw := models.GameResultDraw
+	game.Result = &draw
+
+	expectSimul(mock, simulID, arenaID, hostID, "white", models.SimulStatusActive)
+	mock.ExpectQuery(`SELECT "id","status","result" FROM "games" WHERE simul_id = \$1`).
+		WithArgs(simulID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "result"}).
+			AddRow(uuid.New(), models.GameStatusFinished, models.GameResultWhiteWins).
+			AddRow(uuid.New(), models.GameStatusFinished, models.GameResultBlackWins).
+			AddRow(game.ID, models.GameStatusFinished, models.GameResultDraw))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "simuls" SET .* WHERE id = \$4 AND status = \$5`).
+		WithArgs(testutil.AnyTime{}, models.SimulStatusFinished, testutil.AnyTime{}, simulID, models.SimulStatusActive).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	service.GameFinished(game)
+
+	message := expectMessage(t, watcher, "simul_update")
+	data := message.Data.(map[string]interface{})
+	assert.Equal(t, "result", data["op"])
+	assert.Equal(t, map[string]interface{}{"wins": 1.0, "draws": 1.0, "losses": 1.0, "playing": 0.0}, data["score"])
+
+	// That was the last game
+	message = expectMessage(t, watcher, "simul_update")
+	data = message.Data.(map[string]interface{})
+	assert.Equal(t, "finished", data["op"])
+	assert.Equal(t, string(models.SimulStatusFinished), data["simul"].(map[string]interface{})["status"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClockRemaining_UntimedSide(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+	game := activeGame(uuid.New(), uuid.New())
+	game.CurrentTurn = "white"
+	game.WhiteTime, game.BlackTime = 300, 300
+	game.LastMoveAt = &start
+
+	timeLeft, running := clockRemaining(&game, time.Now())
+	assert.True(t, running)
+	assert.Equal(t, 240, timeLeft)
+
+	game.UntimedSide = "white"
+	timeLeft, running = clockRemaining(&game, time.Now())
+	assert.False(t, running)
+	assert.Equal(t, 300, timeLeft)
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Spectators get a live evaluation of the games they watch. Every game played
+// on this instance gets a worker that searches each new position once the
+// spectator delay has passed and broadcasts the best lines to the game's
+// spectator room, leaving out the game's players and, in consultation games,
+// the members of both teams. Positions that are
+// overtaken by a newer move before their turn comes are skipped, and the
+// searches of all games share a fixed number of slots so watchers can't
+// starve the server of CPU.
+
+const (
+	spectatorEvalDepth  = 4
+	spectatorEvalLines  = 3
+	spectatorWorkerIdle = 10 * time.Minute // a worker without moves for this long stops
+)
+
+const spectatorRoomSuffix = ":spectators"
+
+// GameSpectatorRoom is the Hub room that carries a game's live evaluation.
+// The game's players never receive its messages while the game is played.
+func GameSpectatorRoom(gameID string) string {
+	return GameRoom(gameID) + spectatorRoomSuffix
+}
+
+// spectatedGame returns the ID of the game whose spectator room roomID is.
+func spectatedGame(roomID string) (string, bool) {
+	gameID, ok := strings.CutPrefix(roomID, GameRoom(""))
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(gameID, spectatorRoomSuffix)
+}
+
+// maySpectate reports whether userID may join a game's spectator room: not
+// while they play in it, seated or on a consultation team. uuid.Nil stands
+// for an anonymous client, who may only once the game is over.
+func (gs *GameService) maySpectate(gameID, userID uuid.UUID) (bool, error) {
+	game, err := gs.currentGame(gameID)
+	if err != nil {
+		return false, err
+	}
+	if isGameOver(&game) {
+		return true, nil
+	}
+	if userID == uuid.Nil {
+		return false, nil
+	}
+	color, err := gs.teamColor(&game, userID)
+	if err != nil {
+		return false, err
+	}
+	return color == "", nil
+}
+
+// SpectatorEval is the evaluation of a game's position after ply half-moves.
+type SpectatorEval struct {
+	GameID uuid.UUID            `json:"game_id"`
+	Ply    int                  `json:"ply"`
+	FEN    string               `json:"fen"`
+	Depth  int                  `json:"depth"`
+	Lines  []chess.SearchResult `json:"lines"` // best first
+}
+
+type SpectatorEvalService struct {
+	hub   *Hub
+	games *GameService // finds consultation teams; nil leaves them out
+	delay time.Duration
+	depth int
+	lines int
+	slots chan struct{}
+
+	mu      sync.Mutex
+	workers map[uuid.UUID]*evalWorker
+}
+
+// NewSpectatorEvalService publishes evaluations through hub, delay after
+// each move, running at most maxSearches searches at once; zero or less
+// means half the CPUs. games tells who plays in consultation games.
+func NewSpectatorEvalService(hub *Hub, games *GameService, delay time.Duration, maxSearches int) *SpectatorEvalService {
+	if maxSearches <= 0 {
+		maxSearches = max(1, runtime.NumCPU()/2)
+	}
+	return &SpectatorEvalService{
+		hub:     hub,
+		games:   games,
+		delay:   delay,
+		depth:   spectatorEvalDepth,
+		lines:   spectatorEvalLines,
+		slots:   make(chan struct{}, maxSearches),
+		workers: make(map[uuid.UUID]*evalWorker),
+	}
+}
+
+// GameMoved is a GameService.OnMove hook queuing the new position for the
+// game's worker.
+func (ss *SpectatorEvalService) GameMoved(game models.Game, move models.GameMove) {
+	if game.Variant != models.GameVariantStandard && game.Variant != "" {
+		return
+	}
+	if isGameOver(&game) {
+		ss.stop(game.ID)
+		return
+	}
+
+	position := evalPosition{
+		ply:      move.MoveNumber,
+		fen:      move.FENAfter,
+		playedAt: move.CreatedAt,
+	}
+	if position.playedAt.IsZero() {
+		position.playedAt = time.Now()
+	}
+	players, err := ss.players(&game)
+	if err != nil {
+		// Better no evaluation than one a team member could read
+		log.Printf("Error loading the players of game %s: %v", game.ID, err)
+		return
+	}
+	position.players = players
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	worker, ok := ss.workers[game.ID]
+	if !ok {
+		worker = &evalWorker{wake: make(chan struct{}, 1), done: make(chan struct{})}
+		ss.workers[game.ID] = worker
+		go ss.run(game.ID, worker)
+	}
+	worker.offer(position)
+}
+
+// players returns everyone who plays game and must not see its evaluation.
+func (ss *SpectatorEvalService) players(game *models.Game) ([]string, error) {
+	var players []string
+	for _, player := range []*uuid.UUID{game.WhitePlayerID, game.BlackPlayerID} {
+		if player != nil {
+			players = append(players, player.String())
+		}
+	}
+	if ss.games == nil || game.VoteWindow == 0 {
+		return players, nil
+	}
+
+	members, err := ss.games.teamMembers(game.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		players = append(players, member.String())
+	}
+	return players, nil
+}
+
+// GameFinished is a GameService.OnGameFinished hook stopping the game's
+// worker.
+func (ss *SpectatorEvalService) GameFinished(game models.Game) {
+	ss.stop(game.ID)
+}
+
+func (ss *SpectatorEvalService) stop(gameID uuid.UUID) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if worker, ok := ss.workers[gameID]; ok {
+		close(worker.done)
+		delete(ss.workers, gameID)
+	}
+}
+
+func (ss *SpectatorEvalService) run(gameID uuid.UUID, worker *evalWorker) {
+	room := GameSpectatorRoom(gameID.String())
+	idle := time.NewTimer(spectatorWorkerIdle)
+	defer idle.Stop()
+
+	for {
+		select {
+		case <-worker.done:
+			return
+		case <-idle.C:
+			if ss.retire(gameID, worker) {
+				return
+			}
+			idle.Reset(spectatorWorkerIdle)
+			continue
+		case <-worker.wake:
+			idle.Reset(spectatorWorkerIdle)
+		}
+
+		position, ok := worker.take()
+		if !ok {
+			continue
+		}
+		if !worker.sleep(time.Until(position.playedAt.Add(ss.delay))) {
+			return
+		}
+		// A newer move has come in; its position is the one worth searching
+		if worker.overtaken(position.ply) || len(ss.hub.RoomMembers(room)) == 0 {
+			continue
+		}
+
+		eval, ok := ss.evaluate(gameID, position, worker)
+		if !ok {
+			return
+		}
+		if worker.overtaken(position.ply) {
+			continue
+		}
+		ss.hub.BroadcastToRoomExcept(room, Message{Type: "spectator_eval", Room: room, Data: eval}, position.players)
+	}
+}
+
+// evaluate searches a position once a slot is free. It gives up when the
+// worker is stopped while waiting.
+func (ss *SpectatorEvalService) evaluate(gameID uuid.UUID, position evalPosition, worker *evalWorker) (*SpectatorEval, bool) {
+	select {
+	case ss.slots <- struct{}{}:
+	case <-worker.done:
+		return nil, false
+	}
+	defer func() { <-ss.slots }()
+
+	return &SpectatorEval{
+		GameID: gameID,
+		Ply:    position.ply,
+		FEN:    position.fen,
+		Depth:  ss.depth,
+		Lines:  chess.NewEngine(position.fen).SearchLines(ss.depth, ss.lines),
+	}, true
+}
+
+// retire removes an idle worker, unless a position arrived in the meantime.
+func (ss *SpectatorEvalService) retire(gameID uuid.UUID, worker *evalWorker) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if worker.pending() {
+		return false
+	}
+	if ss.workers[gameID] == worker {
+		delete(ss.workers, gameID)
+	}
+	return true
+}
+
+type evalPosition struct {
+	ply      int
+	fen      string
+	playedAt time.Time
+	players  []string
+}
+
+// evalWorker holds the latest position of a game waiting to be searched.
+// Hooks run in their own goroutines, so moves may be offered out of order.
+type evalWorker struct {
+	mu     sync.Mutex
+	next   *evalPosition
+	latest int // the highest ply offered
+	wake   chan struct{}
+	done   chan struct{}
+}
+
+func (w *evalWorker) offer(position evalPosition) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if position.ply <= w.latest {
+		return
+	}
+	w.latest = position.ply
+	w.next = &position
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *evalWorker) take() (evalPosition, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.next == nil {
+		return evalPosition{}, false
+	}
+	position := *w.next
+	w.next = nil
+	return position, true
+}
+
+func (w *evalWorker) pending() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.next != nil
+}
+
+func (w *evalWorker) overtaken(ply int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.latest > ply
+}
+
+// sleep waits for d, returning false if the worker is stopped first.
+func (w *evalWorker) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-w.done:
+		return false
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backRankMate is a position where white mates with Re8.
+const backRankMate = "6k1/5ppp/8/8/8/8/5PPP/4R1K1 w - - 0 1"
+
+func activeGame(white, black uuid.UUID) models.Game {
+	return models.Game{
+		ID:            uuid.New(),
+		Variant:       models.GameVariantStandard,
+		Status:        models.GameStatusActive,
+		WhitePlayerID: &white,
+		BlackPlayerID: &black,
+	}
+}
+
+func TestSpectatorEval_ReachesSpectatorsOnly(t *testing.T) {
+	_, server := testutil.MockRedis(t)
+	defer testutil.CleanupRedis(server)
+
+	hubA, _ := newBridgedHub(t, server)
+	hubB, _ := newBridgedHub(t, server)
+
+	white, black := uuid.New(), uuid.New()
+	game := activeGame(white, black)
+	room := GameSpectatorRoom(game.ID.String())
+
+	// Players who join the spectator room, on either instance, get nothing
+	spectator := connectTestClient(t, hubB, uuid.New().String())
+	whiteClient := connectTestClient(t, hubA, white.String())
+	blackClient := connectTestClient(t, hubB, black.String())
+	hubB.JoinRoom(spectator, room)
+	hubA.JoinRoom(whiteClient, room)
+	hubB.JoinRoom(blackClient, room)
+
+	// Nor does an anonymous connection, whatever user ID it claims
+	anonymous := &Client{ID: uuid.New().String(), UserID: uuid.New().String(), Send: make(chan []byte, 16), Hub: hubB}
+	hubB.Register <- anonymous
+	expectMessage(t, anonymous, "connection_established")
+	hubB.JoinRoom(anonymous, room)
+	waitForSubscribers(t, server, roomChannelPrefix+room, 2)
+
+	evals := NewSpectatorEvalService(hubA, nil, 0, 1)
+	evals.GameMoved(game, models.GameMove{MoveNumber: 12, FENAfter: backRankMate, CreatedAt: time.Now()})
+
+	message := expectMessage(t, spectator, "spectator_eval")
+	assert.Equal(t, room, message.Room)
+	data := message.Data.(map[string]interface{})
+	assert.Equal(t, float64(12), data["ply"])
+	lines := data["lines"].([]interface{})
+	require.Len(t, lines, spectatorEvalLines)
+	assert.Equal(t, "e1e8", lines[0].(map[string]interface{})["best_move"])
+
+	expectNoMessage(t, whiteClient)
+	expectNoMessage(t, blackClient)
+	expectNoMessage(t, anonymous)
+}
+
+func TestSpectatorEval_LeavesOutConsultationTeams(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	redisClient, redisServer := testutil.MockRedis(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		testutil.CleanupDB(sqlDB)
+		testutil.CleanupRedis(redisServer)
+	}()
+
+	hub := NewHub()
+	go hub.Run()
+
+	game := activeGame(uuid.New(), uuid.New())
+	game.VoteWindow = 30
+	member := uuid.New()
+	room := GameSpectatorRoom(game.ID.String())
+	spectator := connectTestClient(t, hub, uuid.New().String())
+	memberClient := connectTestClient(t, hub, member.String())
+	hub.JoinRoom(spectator, room)
+	hub.JoinRoom(memberClient, room)
+
+	mock.ExpectQuery(`SELECT "user_id" FROM "consultation_members" WHERE game_id = \$1`).
+		WithArgs(game.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(member))
+
+	evals := NewSpectatorEvalService(hub, NewGameService(db, redisClient), 0, 1)
+	evals.GameMoved(game, models.GameMove{MoveNumber: 7, FENAfter: backRankMate, CreatedAt: time.Now()})
+
+	expectMessage(t, spectator, "spectator_eval")
+	expectNoMessage(t, memberClient)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSpectatorEval_WaitsForDelayAndSkipsOvertakenPositions(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	game := activeGame(uuid.New(), uuid.New())
+	room := GameSpectatorRoom(game.ID.String())
+	spectator := connectTestClient(t, hub, uuid.New().String())
+	hub.JoinRoom(spectator, room)
+
+	evals := NewSpectatorEvalService(hub, nil, 300*time.Millisecond, 1)
+	now := time.Now()
+	evals.GameMoved(game, models.GameMove{MoveNumber: 1, FENAfter: "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1", CreatedAt: now})
+	evals.GameMoved(game, models.GameMove{MoveNumber: 3, FENAfter: backRankMate, CreatedAt: now})
+	// Hooks run concurrently, so an older move may arrive last
+	evals.GameMoved(game, models.GameMove{MoveNumber: 2, FENAfter: backRankMate, CreatedAt: now})
+
+	expectNoMessage(t, spectator)
+	message := expectMessage(t, spectator, "spectator_eval")
+	assert.GreaterOrEqual(t, time.Since(now), 300*time.Millisecond)
+	assert.Equal(t, float64(3), message.Data.(map[string]interface{})["ply"])
+	expectNoMessage(t, spectator)
+}
+
+func TestSpectatorEval_StopsWhenGameFinishes(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	game := activeGame(uuid.New(), uuid.New())
+	spectator := connectTestClient(t, hub, uuid.New().String())
+	hub.JoinRoom(spectator, GameSpectatorRoom(game.ID.String()))
+
+	evals := NewSpectatorEvalService(hub, nil, time.Second, 1)
+	evals.GameMoved(game, models.GameMove{MoveNumber: 1, FENAfter: backRankMate, CreatedAt: time.Now()})
+	evals.GameFinished(game)
+
+	evals.mu.Lock()
+	assert.Empty(t, evals.workers)
+	evals.mu.Unlock()
+	expectNoMessage(t, spectator)
+
+	// Nor are variants the engine can't search evaluated
+	game.Variant = models.GameVariantCrazyhouse
+	evals.GameMoved(game, models.GameMove{MoveNumber: 2, FENAfter: backRankMate, CreatedAt: time.Now()})
+	evals.mu.Lock()
+	assert.Empty(t, evals.workers)
+	evals.mu.Unlock()
+}
@@ -0,0 +1,655 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Studies keep each chapter as normalised PGN movetext, with a revision
+// saved on every edit. An edit names the revision it was made on and fails
+// if the chapter has moved on since, so contributors never overwrite each
+// other's work. Changes reach members through the study's Hub room.
+
+const (
+	maxStudyChapters = 64
+	maxStudyMembers  = 100
+	maxStudyMoves    = 2000 // per chapter
+	maxStudyName     = 100
+)
+
+var (
+	ErrStudyNotFound        = errors.New("study not found")
+	ErrStudyChapterNotFound = errors.New("study chapter not found")
+	ErrStudyMemberNotFound  = errors.New("study member not found")
+	ErrStudyForbidden       = errors.New("not allowed to change the study")
+	ErrStudyConflict        = errors.New("study chapter was updated concurrently")
+	ErrStudyFull            = errors.New("study has too many chapters or members")
+	ErrInvalidStudyRole     = errors.New("invalid study role")
+	ErrInvalidPGN           = errors.New("invalid PGN")
+)
+
+// StudyChannel is the Hub room that carries a study's changes.
+func StudyChannel(studyID string) string {
+	return "study:" + studyID
+}
+
+// StudyUpdate describes one change to a study, as broadcast to its members.
+type StudyUpdate struct {
+	StudyID   uuid.UUID            `json:"study_id"`
+	Op        string               `json:"op"` // study, deleted, chapter, chapter_deleted, member or member_removed
+	Study     *models.Study        `json:"study,omitempty"`
+	Chapter   *models.StudyChapter `json:"chapter,omitempty"`
+	ChapterID *uuid.UUID           `json:"chapter_id,omitempty"`
+	Member    *models.StudyMember  `json:"member,omitempty"`
+	UserID    *uuid.UUID           `json:"user_id,omitempty"` // the member removed
+	Changes   []chess.PGNChange    `json:"changes,omitempty"` // what a chapter edit changed in its moves
+}
+
+// StudyRevisionDiff is a revision of a chapter with what changed from the
+// one before it.
+type StudyRevisionDiff struct {
+	models.StudyRevision
+	Renamed     bool              `json:"renamed,omitempty"`
+	NewPosition bool              `json:"new_position,omitempty"`
+	Changes     []chess.PGNChange `json:"changes"`
+}
+
+type StudyService struct {
+	db *gorm.DB
+}
+
+func NewStudyService(db *gorm.DB) *StudyService {
+	return &StudyService{db: db}
+}
+
+// CreateStudy starts an empty study owned by ownerID.
+func (ss *StudyService) CreateStudy(ownerID uuid.UUID, name, description string, public bool) (*models.Study, error) {
+	study := models.Study{
+		OwnerID:     ownerID,
+		Name:        studyName(name, "Study"),
+		Description: strings.TrimSpace(description),
+		Public:      public,
+	}
+	if err := ss.db.Create(&study).Error; err != nil {
+		return nil, fmt.Errorf("failed to create study: %w", err)
+	}
+	return &study, nil
+}
+
+// GetStudy returns a study with its chapters in order and its members, if
+// userID may view it.
+func (ss *StudyService) GetStudy(studyID, userID uuid.UUID) (*models.Study, error) {
+	var study models.Study
+	err := ss.db.
+		Preload("Chapters", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC, created_at ASC")
+		}).
+		Preload("Members", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		}).
+		First(&study, "id = ?", studyID).Error
+	if err != nil {
+		return nil, studyLookupError(err)
+	}
+
+	role := study.RoleOf(userID)
+	if role == "" && !study.Public {
+		return nil, ErrStudyNotFound
+	}
+	return &study, nil
+}
+
+// CanView reports whether userID may view the study and follow its changes.
+// Anyone may view a public study; userID is uuid.Nil for anonymous users.
+func (ss *StudyService) CanView(studyID, userID uuid.UUID) (bool, error) {
+	_, err := ss.authorize(ss.db, studyID, userID, models.StudyRoleViewer)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrStudyNotFound), errors.Is(err, ErrStudyForbidden):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// UpdateStudy replaces the study's name, description and visibility.
+func (ss *StudyService) UpdateStudy(studyID, userID uuid.UUID, name, description string, public bool) (*models.Study, error) {
+	study, err := ss.authorize(ss.db, studyID, userID, models.StudyRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	study.Name = studyName(name, study.Name)
+	study.Description = strings.TrimSpace(description)
+	study.Public = public
+	err = ss.db.Model(&models.Study{}).Where("id = ?", studyID).Updates(map[string]interface{}{
+		"name":        study.Name,
+		"description": study.Description,
+		"public":      study.Public,
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to update study: %w", err)
+	}
+	return study, nil
+}
+
+// DeleteStudy removes a study with everything in it. Only the owner can.
+func (ss *StudyService) DeleteStudy(studyID, userID uuid.UUID) error {
+	return ss.db.Transaction(func(tx *gorm.DB) error {
+		study, err := loadStudy(tx, studyID)
+		if err != nil {
+			return err
+		}
+		if study.OwnerID != userID {
+			return ErrStudyForbidden
+		}
+
+		chapters := tx.Model(&models.StudyChapter{}).Select("id").Where("study_id = ?", studyID)
+		if err := tx.Where("chapter_id IN (?)", chapters).Delete(&models.StudyRevision{}).Error; err != nil {
+			return fmt.Errorf("failed to delete study revisions: %w", err)
+		}
+		for _, model := range []interface{}{&models.StudyChapter{}, &models.StudyMember{}} {
+			if err := tx.Where("study_id = ?", studyID).Delete(model).Error; err != nil {
+				return fmt.Errorf("failed to delete study: %w", err)
+			}
+		}
+		if err := tx.Delete(study).Error; err != nil {
+			return fmt.Errorf("failed to delete study: %w", err)
+		}
+		return nil
+	})
+}
+
+// SetMember gives memberID role in the study, adding them if need be.
+func (ss *StudyService) SetMember(studyID, userID, memberID uuid.UUID, role models.StudyRole) (*models.StudyMember, error) {
+	if !role.Valid() {
+		return nil, ErrInvalidStudyRole
+	}
+
+	var member models.StudyMember
+	err := ss.db.Transaction(func(tx *gorm.DB) error {
+		study, err := ss.authorize(tx, studyID, userID, models.StudyRoleAdmin)
+		if err != nil {
+			return err
+		}
+		if memberID == study.OwnerID {
+			return ErrStudyForbidden
+		}
+
+		err = tx.Take(&member, "study_id = ? AND user_id = ?", studyID, memberID).Error
+		switch {
+		case err == nil:
+			member.Role = role
+			if err := tx.Model(&member).Update("role", role).Error; err != nil {
+				return fmt.Errorf("failed to update study member: %w", err)
+			}
+			return nil
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return fmt.Errorf("failed to load study member: %w", err)
+		}
+
+		var count int64
+		if err := tx.Model(&models.StudyMember{}).Where("study_id = ?", studyID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count study members: %w", err)
+		}
+		if count >= maxStudyMembers {
+			return ErrStudyFull
+		}
+		member = models.StudyMember{StudyID: studyID, UserID: memberID, Role: role}
+		if err := tx.Create(&member).Error; err != nil {
+			return fmt.Errorf("failed to add study member: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// RemoveMember takes memberID out of the study. Admins can remove anyone
+// and members can leave.
+func (ss *StudyService) RemoveMember(studyID, userID, memberID uuid.UUID) error {
+	needed := models.StudyRoleAdmin
+	if memberID == userID {
+		needed = models.StudyRoleViewer
+	}
+	if _, err := ss.authorize(ss.db, studyID, userID, needed); err != nil {
+		return err
+	}
+
+	result := ss.db.Where("study_id = ? AND user_id = ?", studyID, memberID).Delete(&models.StudyMember{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove study member: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrStudyMemberNotFound
+	}
+	return nil
+}
+
+// AddChapter appends a chapter read from the first game in pgn, which may be
+// empty for a chapter on the starting position. The game's FEN tag sets the
+// chapter's position and name defaults to its Event tag.
+func (ss *StudyService) AddChapter(studyID, userID uuid.UUID, name, pgn string) (*models.StudyChapter, error) {
+	game, err := parseStudyPGN(pgn)
+	if err != nil {
+		return nil, err
+	}
+	chapters, err := ss.addChapters(studyID, userID, []chess.PGNGame{game}, name)
+	if err != nil {
+		return nil, err
+	}
+	return &chapters[0], nil
+}
+
+// ImportPGN adds a chapter for every game in r.
+func (ss *StudyService) ImportPGN(studyID, userID uuid.UUID, r io.Reader) ([]models.StudyChapter, error) {
+	games, err := chess.ParsePGN(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(games) == 0 {
+		return nil, fmt.Errorf("%w: no games found", ErrInvalidPGN)
+	}
+	return ss.addChapters(studyID, userID, games, "")
+}
+
+// UpdateChapter renames a chapter when name isn't empty and replaces its
+// moves when pgn isn't nil. version is the revision the edit was made on.
+func (ss *StudyService) UpdateChapter(studyID, chapterID, userID uuid.UUID, version int, name string, pgn *string) (*models.StudyChapter, []chess.PGNChange, error) {
+	var chapter models.StudyChapter
+	var changes []chess.PGNChange
+	err := ss.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := ss.authorize(tx, studyID, userID, models.StudyRoleContributor); err != nil {
+			return err
+		}
+		current, err := findChapter(tx, studyID, chapterID)
+		if err != nil {
+			return err
+		}
+		if current.Version != version {
+			return ErrStudyConflict
+		}
+
+		chapter = *current
+		chapter.Name = studyName(name, current.Name)
+		if pgn != nil {
+			game, err := parseStudyPGN(*pgn)
+			if err != nil {
+				return err
+			}
+			if chapter.FEN, chapter.Movetext, err = chapterMoves(game); err != nil {
+				return err
+			}
+			changes = chess.DiffPGN(parseMovetext(current.Movetext), parseMovetext(chapter.Movetext))
+		}
+		chapter.Version++
+
+		result := tx.Model(&models.StudyChapter{}).
+			Where("id = ? AND version = ?", chapterID, version).
+			Updates(map[string]interface{}{
+				"name":     chapter.Name,
+				"fen":      chapter.FEN,
+				"movetext": chapter.Movetext,
+				"version":  chapter.Version,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to update study chapter: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrStudyConflict
+		}
+		return saveRevision(tx, &chapter, userID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &chapter, changes, nil
+}
+
+// AnnotateMove replaces the comment, NAGs and shapes of the move at the end
+// of path, the line of moves in SAN leading to it from the chapter's
+// position. Like UpdateChapter it is an edit of revision version.
+func (ss *StudyService) AnnotateMove(studyID, chapterID, userID uuid.UUID, version int, path []string, annotation models.MoveAnnotation) (*models.StudyChapter, []chess.PGNChange, error) {
+	annotation.Comment = strings.TrimSpace(annotation.Comment)
+	if err := checkAnnotation(annotation.Comment, annotation.NAGs, annotation.Arrows, annotation.Highlights); err != nil {
+		return nil, nil, err
+	}
+	if _, err := ss.authorize(ss.db, studyID, userID, models.StudyRoleContributor); err != nil {
+		return nil, nil, err
+	}
+	chapter, err := findChapter(ss.db, studyID, chapterID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if chapter.Version != version {
+		return nil, nil, ErrStudyConflict
+	}
+
+	tree := parseMovetext(chapter.Movetext)
+	var move *chess.PGNMove
+	line := tree
+	for _, san := range path {
+		move = nil
+		for _, next := range line {
+			if strings.TrimRight(next.SAN, "+#") == strings.TrimRight(san, "+#") {
+				move = next
+				break
+			}
+		}
+		if move == nil {
+			return nil, nil, ErrMoveNotFound
+		}
+		line = move.Next
+	}
+	if move == nil {
+		return nil, nil, ErrMoveNotFound
+	}
+	move.Comment, move.NAGs = annotation.Comment, annotation.NAGs
+	move.Arrows, move.Highlights = annotation.Arrows, annotation.Highlights
+
+	pgn := chess.FormatPGN(nil, chapter.FEN, tree, "*")
+	return ss.UpdateChapter(studyID, chapterID, userID, version, "", &pgn)
+}
+
+// DeleteChapter removes a chapter and its history.
+func (ss *StudyService) DeleteChapter(studyID, chapterID, userID uuid.UUID) error {
+	return ss.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := ss.authorize(tx, studyID, userID, models.StudyRoleContributor); err != nil {
+			return err
+		}
+		if _, err := findChapter(tx, studyID, chapterID); err != nil {
+			return err
+		}
+		if err := tx.Where("chapter_id = ?", chapterID).Delete(&models.StudyRevision{}).Error; err != nil {
+			return fmt.Errorf("failed to delete study chapter: %w", err)
+		}
+		if err := tx.Delete(&models.StudyChapter{}, "id = ?", chapterID).Error; err != nil {
+			return fmt.Errorf("failed to delete study chapter: %w", err)
+		}
+		return nil
+	})
+}
+
+// ChapterHistory returns every revision of a chapter, oldest first, each
+// with what changed from the one before.
+func (ss *StudyService) ChapterHistory(studyID, chapterID, userID uuid.UUID) ([]StudyRevisionDiff, error) {
+	if _, err := ss.authorize(ss.db, studyID, userID, models.StudyRoleViewer); err != nil {
+		return nil, err
+	}
+	if _, err := findChapter(ss.db, studyID, chapterID); err != nil {
+		return nil, err
+	}
+
+	var revisions []models.StudyRevision
+	if err := ss.db.Where("chapter_id = ?", chapterID).Order("version ASC").Find(&revisions).Error; err != nil {
+		return nil, fmt.Errorf("failed to load study revisions: %w", err)
+	}
+
+	history := make([]StudyRevisionDiff, len(revisions))
+	var previous *models.StudyRevision
+	for i := range revisions {
+		revision := &revisions[i]
+		diff := StudyRevisionDiff{StudyRevision: *revision, Changes: []chess.PGNChange{}}
+		before := ""
+		if previous != nil {
+			before = previous.Movetext
+			diff.Renamed = previous.Name != revision.Name
+			diff.NewPosition = previous.FEN != revision.FEN
+		}
+		if changes := chess.DiffPGN(parseMovetext(before), parseMovetext(revision.Movetext)); changes != nil {
+			diff.Changes = changes
+		}
+		history[i] = diff
+		previous = revision
+	}
+	return history, nil
+}
+
+// ExportPGN writes every chapter of the study as a game of its own.
+func (ss *StudyService) ExportPGN(studyID, userID uuid.UUID) (string, error) {
+	study, err := ss.GetStudy(studyID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	games := make([]string, len(study.Chapters))
+	for i, chapter := range study.Chapters {
+		tags := []chess.PGNTag{
+			{Name: "Event", Value: study.Name + ": " + chapter.Name},
+			{Name: "Site", Value: "Arcane Chess"},
+			{Name: "Date", Value: chapter.CreatedAt.Format("2006.01.02")},
+			{Name: "Round", Value: fmt.Sprint(i + 1)},
+			{Name: "White", Value: "?"},
+			{Name: "Black", Value: "?"},
+		}
+		games[i] = chess.FormatPGN(tags, chapter.FEN, parseMovetext(chapter.Movetext), "*")
+	}
+	return strings.Join(games, "\n"), nil
+}
+
+// addChapters appends a chapter for each game, named name or else after
+// the game.
+func (ss *StudyService) addChapters(studyID, userID uuid.UUID, games []chess.PGNGame, name string) ([]models.StudyChapter, error) {
+	chapters := make([]models.StudyChapter, len(games))
+	for i, game := range games {
+		fen, movetext, err := chapterMoves(game)
+		if err != nil {
+			return nil, fmt.Errorf("game %d: %w", i+1, err)
+		}
+		chapters[i] = models.StudyChapter{
+			StudyID:  studyID,
+			Name:     studyName(name, studyName(game.Tags["Event"], fmt.Sprintf("Chapter %d", i+1))),
+			FEN:      fen,
+			Movetext: movetext,
+			Version:  1,
+		}
+	}
+
+	err := ss.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := ss.authorize(tx, studyID, userID, models.StudyRoleContributor); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.StudyChapter{}).Where("study_id = ?", studyID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count study chapters: %w", err)
+		}
+		if int(count)+len(chapters) > maxStudyChapters {
+			return ErrStudyFull
+		}
+
+		for i := range chapters {
+			chapters[i].Position = int(count) + i
+			if err := tx.Create(&chapters[i]).Error; err != nil {
+				return fmt.Errorf("failed to create study chapter: %w", err)
+			}
+			if err := saveRevision(tx, &chapters[i], userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chapters, nil
+}
+
+// authorize loads a study if userID has at least role in it. Users who may
+// not see the study at all are told it doesn't exist.
+func (ss *StudyService) authorize(db *gorm.DB, studyID, userID uuid.UUID, role models.StudyRole) (*models.Study, error) {
+	study, err := loadStudy(db, studyID)
+	if err != nil {
+		return nil, err
+	}
+
+	has := study.RoleOf(userID)
+	if has == "" && userID != uuid.Nil {
+		var member models.StudyMember
+		err := db.Take(&member, "study_id = ? AND user_id = ?", studyID, userID).Error
+		switch {
+		case err == nil:
+			has = member.Role
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, fmt.Errorf("failed to load study member: %w", err)
+		}
+	}
+	if has == "" && study.Public {
+		has = models.StudyRoleViewer
+	}
+	switch {
+	case has == "":
+		return nil, ErrStudyNotFound
+	case !has.Allows(role):
+		return nil, ErrStudyForbidden
+	}
+	return study, nil
+}
+
+func saveRevision(tx *gorm.DB, chapter *models.StudyChapter, authorID uuid.UUID) error {
+	revision := models.StudyRevision{
+		ChapterID: chapter.ID,
+		Version:   chapter.Version,
+		AuthorID:  authorID,
+		Name:      chapter.Name,
+		FEN:       chapter.FEN,
+		Movetext:  chapter.Movetext,
+	}
+	if err := tx.Create(&revision).Error; err != nil {
+		return fmt.Errorf("failed to save study revision: %w", err)
+	}
+	return nil
+}
+
+// parseStudyPGN reads the one game a chapter is made from. Empty text is a
+// game without moves.
+func parseStudyPGN(pgn string) (chess.PGNGame, error) {
+	games, err := chess.ParsePGN(strings.NewReader(pgn))
+	if err != nil {
+		return chess.PGNGame{}, fmt.Errorf("%w: %v", ErrInvalidPGN, err)
+	}
+	if len(games) == 0 {
+		return chess.PGNGame{Tags: map[string]string{}}, nil
+	}
+	return games[0], nil
+}
+
+// chapterMoves checks a game's moves from its position and writes them out
+// again as normalised movetext. A line is only checked up to its first
+// castling or promotion.
+func chapterMoves(game chess.PGNGame) (fen, movetext string, err error) {
+	fen = chess.StandardStartFEN
+	if tag := game.Tags["FEN"]; tag != "" {
+		fen = tag
+	}
+	if err := chess.ValidateFEN(fen); err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidFEN, err)
+	}
+
+	count := 0
+	var replay func(moves []*chess.PGNMove, fen string) error
+	replay = func(moves []*chess.PGNMove, fen string) error {
+		for _, move := range moves {
+			if count++; count > maxStudyMoves {
+				return ErrStudyFull
+			}
+			engine := chess.NewEngine(fen)
+			squares, err := engine.ParseSAN(move.SAN)
+			var played *chess.Move
+			if err == nil {
+				played, err = engine.ValidateMove(squares[:2], squares[2:])
+			}
+			if errors.Is(err, chess.ErrUnsupportedMove) {
+				// Castling and promotion are kept as written, and so is the
+				// rest of the line, as the engine can't follow it from there
+				if count += countMoves(move.Next); count > maxStudyMoves {
+					return ErrStudyFull
+				}
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidPGN, err)
+			}
+			move.SAN = played.Notation
+			switch {
+			case played.IsCheckmate:
+				move.SAN += "#"
+			case played.IsCheck:
+				move.SAN += "+"
+			}
+			if err := replay(move.Next, played.FENAfter); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := replay(game.Tree, fen); err != nil {
+		return "", "", err
+	}
+	return fen, chess.FormatMovetext(fen, game.Tree), nil
+}
+
+// countMoves counts the moves in a tree, variations included.
+func countMoves(moves []*chess.PGNMove) int {
+	count := len(moves)
+	for _, move := range moves {
+		count += countMoves(move.Next)
+	}
+	return count
+}
+
+// parseMovetext reads a chapter's stored moves back into a tree.
+func parseMovetext(movetext string) []*chess.PGNMove {
+	games, err := chess.ParsePGN(strings.NewReader(movetext))
+	if err != nil || len(games) == 0 {
+		return nil
+	}
+	return games[0].Tree
+}
+
+func studyName(name, fallback string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fallback
+	}
+	if len(name) > maxStudyName {
+		name = name[:maxStudyName]
+	}
+	return name
+}
+
+func loadStudy(db *gorm.DB, studyID uuid.UUID) (*models.Study, error) {
+	var study models.Study
+	if err := db.Take(&study, "id = ?", studyID).Error; err != nil {
+		return nil, studyLookupError(err)
+	}
+	return &study, nil
+}
+
+func findChapter(db *gorm.DB, studyID, chapterID uuid.UUID) (*models.StudyChapter, error) {
+	var chapter models.StudyChapter
+	if err := db.Take(&chapter, "id = ? AND study_id = ?", chapterID, studyID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrStudyChapterNotFound
+		}
+		return nil, fmt.Errorf("failed to load study chapter: %w", err)
+	}
+	return &chapter, nil
+}
+
+func studyLookupError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrStudyNotFound
+	}
+	return fmt.Errorf("failed to load study: %w", err)
+}
@@ -0,0 +1,336 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"arcane-chess/internal/chess"
+	"arcane-chess/internal/models"
+	"arcane-chess/internal/testutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	studyColumns         = []string{"id", "owner_id", "name", "public", "created_at"}
+	studyChapterColumns  = []string{"id", "study_id", "name", "position", "fen", "movetext", "version", "created_at"}
+	studyRevisionColumns = []string{"id", "chapter_id", "version", "author_id", "name", "fen", "movetext", "created_at"}
+)
+
+func expectStudy(mock sqlmock.Sqlmock, studyID, ownerID uuid.UUID, public bool) {
+	mock.ExpectQuery(`SELECT \* FROM "studies" WHERE id = \$1 LIMIT 1`).
+		WithArgs(studyID).
+		WillReturnRows(sqlmock.NewRows(studyColumns).AddRow(studyID, ownerID, "Sicilian files", public, time.Now()))
+}
+
+func expectStudyMember(mock sqlmock.Sqlmock, studyID, userID uuid.UUID, role models.StudyRole) {
+	rows := sqlmock.NewRows([]string{"study_id", "user_id", "role"})
+	if role != "" {
+		rows.AddRow(studyID, userID, role)
+	}
+	mock.ExpectQuery(`SELECT \* FROM "study_members" WHERE study_id = \$1 AND user_id = \$2 LIMIT 1`).
+		WithArgs(studyID, userID).
+		WillReturnRows(rows)
+}
+
+func TestStudyService_UpdateChapter(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	studyID, chapterID, userID := uuid.New(), uuid.New(), uuid.New()
+	mock.ExpectBegin()
+	expectStudy(mock, studyID, uuid.New(), false)
+	expectStudyMember(mock, studyID, userID, models.StudyRoleContributor)
+	mock.ExpectQuery(`SELECT \* FROM "study_chapters" WHERE id = \$1 AND study_id = \$2 LIMIT 1`).
+		WithArgs(chapterID, studyID).
+		WillReturnRows(sqlmock.NewRows(studyChapterColumns).
+			AddRow(chapterID, studyID, "Najdorf", 0, chess.StandardStartFEN, "1. e4 c5", 2, time.Now()))
+	mock.ExpectExec(`UPDATE "study_chapters" SET .* WHERE id = \$\d+ AND version = \$\d+`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "study_revisions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectCommit()
+
+	pgn := "1. e4 c5 {Sicilian} (1... e5 2. Nf3) 2. Nf3"
+	chapter, changes, err := NewStudyService(db).UpdateChapter(studyID, chapterID, userID, 2, "", &pgn)
+
+	require.NoError(t, err)
+	assert.Equal(t, "Najdorf", chapter.Name)
+	assert.Equal(t, 3, chapter.Version)
+	assert.Equal(t, "1. e4 c5 {Sicilian} (1... e5 2. Nf3) 2. Nf3", chapter.Movetext)
+	assert.Equal(t, []chess.PGNChange{
+		{Kind: "annotated", Path: []string{"e4", "c5"}},
+		{Kind: "added", Path: []string{"e4", "c5", "Nf3"}},
+		{Kind: "added", Path: []string{"e4", "e5"}},
+	}, changes)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStudyService_UpdateChapter_Rejected(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	studyID, chapterID, userID := uuid.New(), uuid.New(), uuid.New()
+	service := NewStudyService(db)
+	pgn := "1. d4"
+
+	// An edit made on an old version
+	mock.ExpectBegin()
+	expectStudy(mock, studyID, userID, false)
+	mock.ExpectQuery(`SELECT \* FROM "study_chapters"`).
+		WillReturnRows(sqlmock.NewRows(studyChapterColumns).
+			AddRow(chapterID, studyID, "Najdorf", 0, chess.StandardStartFEN, "1. e4", 5, time.Now()))
+	mock.ExpectRollback()
+
+	_, _, err := service.UpdateChapter(studyID, chapterID, userID, 4, "", &pgn)
+	assert.ErrorIs(t, err, ErrStudyConflict)
+
+	// Viewers can't edit
+	viewer := uuid.New()
+	mock.ExpectBegin()
+	expectStudy(mock, studyID, userID, false)
+	expectStudyMember(mock, studyID, viewer, models.StudyRoleViewer)
+	mock.ExpectRollback()
+
+	_, _, err = service.UpdateChapter(studyID, chapterID, viewer, 5, "", &pgn)
+	assert.ErrorIs(t, err, ErrStudyForbidden)
+
+	// Outsiders aren't told a private study exists
+	mock.ExpectBegin()
+	expectStudy(mock, studyID, userID, false)
+	expectStudyMember(mock, studyID, viewer, "")
+	mock.ExpectRollback()
+
+	_, _, err = service.UpdateChapter(studyID, chapterID, viewer, 5, "", &pgn)
+	assert.ErrorIs(t, err, ErrStudyNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStudyService_AnnotateMove(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	studyID, chapterID, ownerID := uuid.New(), uuid.New(), uuid.New()
+	expectChapter := func() {
+		expectStudy(mock, studyID, ownerID, false)
+		mock.ExpectQuery(`SELECT \* FROM "study_chapters" WHERE id = \$1 AND study_id = \$2 LIMIT 1`).
+			WithArgs(chapterID, studyID).
+			WillReturnRows(sqlmock.NewRows(studyChapterColumns).
+				AddRow(chapterID, studyID, "Najdorf", 0, chess.StandardStartFEN, "1. e4 c5 (1... e5 2. Nf3) 2. Nf3", 2, time.Now()))
+	}
+	expectChapter()
+	mock.ExpectBegin()
+	expectChapter()
+	mock.ExpectExec(`UPDATE "study_chapters" SET .* WHERE id = \$\d+ AND version = \$\d+`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "study_revisions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectCommit()
+
+	chapter, changes, err := NewStudyService(db).AnnotateMove(studyID, chapterID, ownerID, 2, []string{"e4", "e5", "Nf3"},
+		models.MoveAnnotation{Comment: " Attacks e5 ", NAGs: models.NAGs{1}, Arrows: models.Shapes{"Gf3e5"}})
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, chapter.Version)
+	assert.Equal(t, "1. e4 c5 (1... e5 2. Nf3 $1 {[%cal Gf3e5] Attacks e5}) 2. Nf3", chapter.Movetext)
+	assert.Equal(t, []chess.PGNChange{{Kind: "annotated", Path: []string{"e4", "e5", "Nf3"}}}, changes)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStudyService_AnnotateMove_Rejected(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	studyID, chapterID, ownerID := uuid.New(), uuid.New(), uuid.New()
+	service := NewStudyService(db)
+
+	_, _, err := service.AnnotateMove(studyID, chapterID, ownerID, 2, []string{"e4"}, models.MoveAnnotation{Arrows: models.Shapes{"e2e4"}})
+	assert.ErrorIs(t, err, ErrInvalidAnnotation)
+
+	expectStudy(mock, studyID, ownerID, false)
+	mock.ExpectQuery(`SELECT \* FROM "study_chapters"`).
+		WillReturnRows(sqlmock.NewRows(studyChapterColumns).
+			AddRow(chapterID, studyID, "Najdorf", 0, chess.StandardStartFEN, "1. e4 c5", 2, time.Now()))
+
+	_, _, err = service.AnnotateMove(studyID, chapterID, ownerID, 2, []string{"e4", "e5"}, models.MoveAnnotation{Comment: "?"})
+	assert.ErrorIs(t, err, ErrMoveNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStudyService_ImportPGN(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	studyID, ownerID := uuid.New(), uuid.New()
+	mock.ExpectBegin()
+	expectStudy(mock, studyID, ownerID, false)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "study_chapters" WHERE study_id = \$1`).
+		WithArgs(studyID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`INSERT INTO "study_chapters"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+		mock.ExpectQuery(`INSERT INTO "study_revisions"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	}
+	mock.ExpectCommit()
+
+	pgn := `[Event "Fool's mate"]
+
+1. f3 e5 2. g4 $4 Qh4# 0-1
+
+[FEN "4k3/8/8/8/8/8/4P3/4K3 w - - 0 1"]
+
+1. e4! *
+`
+	chapters, err := NewStudyService(db).ImportPGN(studyID, ownerID, strings.NewReader(pgn))
+
+	require.NoError(t, err)
+	require.Len(t, chapters, 2)
+	assert.Equal(t, "Fool's mate", chapters[0].Name)
+	assert.Equal(t, 3, chapters[0].Position)
+	assert.Equal(t, "1. f3 e5 2. g4 $4 Qh4#", chapters[0].Movetext)
+	assert.Equal(t, "Chapter 2", chapters[1].Name)
+	assert.Equal(t, "4k3/8/8/8/8/8/4P3/4K3 w - - 0 1", chapters[1].FEN)
+	assert.Equal(t, "1. e4 $1", chapters[1].Movetext)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStudyService_ImportPGN_CastlingAndPromotion(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	studyID, ownerID := uuid.New(), uuid.New()
+	mock.ExpectBegin()
+	expectStudy(mock, studyID, ownerID, false)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "study_chapters" WHERE study_id = \$1`).
+		WithArgs(studyID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`INSERT INTO "study_chapters"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+		mock.ExpectQuery(`INSERT INTO "study_revisions"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	}
+	mock.ExpectCommit()
+
+	// The engine checks each line up to the castling or promotion and keeps
+	// the rest as written
+	pgn := `1. e4 e5 2. Nf3 Nc6 3. Bc4 (3. Bb5 a6) 3... Nf6 4. O-O Be7 5. Re1 *
+
+[FEN "8/4P3/8/8/8/8/8/k3K3 w - - 0 1"]
+
+1. Kd2 Kb2 2. e8=Q Kb3 3. Qb5+ *
+`
+	chapters, err := NewStudyService(db).ImportPGN(studyID, ownerID, strings.NewReader(pgn))
+
+	require.NoError(t, err)
+	require.Len(t, chapters, 2)
+	assert.Equal(t, "1. e4 e5 2. Nf3 Nc6 3. Bc4 (3. Bb5 a6) 3... Nf6 4. O-O Be7 5. Re1", chapters[0].Movetext)
+	assert.Equal(t, "1. Kd2 Kb2 2. e8=Q Kb3 3. Qb5+", chapters[1].Movetext)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStudyService_ImportPGN_Invalid(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	service := NewStudyService(db)
+
+	_, err := service.ImportPGN(uuid.New(), uuid.New(), strings.NewReader("1. e4 e5 2. Ke3"))
+	assert.ErrorIs(t, err, ErrInvalidPGN)
+
+	_, err = service.ImportPGN(uuid.New(), uuid.New(), strings.NewReader(""))
+	assert.ErrorIs(t, err, ErrInvalidPGN)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStudyService_ChapterHistory(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	studyID, chapterID, userID := uuid.New(), uuid.New(), uuid.New()
+	expectStudy(mock, studyID, uuid.New(), true)
+	expectStudyMember(mock, studyID, userID, "")
+	mock.ExpectQuery(`SELECT \* FROM "study_chapters"`).
+		WillReturnRows(sqlmock.NewRows(studyChapterColumns).
+			AddRow(chapterID, studyID, "Najdorf", 0, chess.StandardStartFEN, "", 3, time.Now()))
+	mock.ExpectQuery(`SELECT \* FROM "study_revisions" WHERE chapter_id = \$1 ORDER BY version ASC`).
+		WithArgs(chapterID).
+		WillReturnRows(sqlmock.NewRows(studyRevisionColumns).
+			AddRow(uuid.New(), chapterID, 1, userID, "Chapter 1", chess.StandardStartFEN, "", time.Now()).
+			AddRow(uuid.New(), chapterID, 2, userID, "Najdorf", chess.StandardStartFEN, "1. e4 c5", time.Now()).
+			AddRow(uuid.New(), chapterID, 3, userID, "Najdorf", chess.StandardStartFEN, "1. e4 c5 $1", time.Now()))
+
+	history, err := NewStudyService(db).ChapterHistory(studyID, chapterID, userID)
+
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Empty(t, history[0].Changes)
+	assert.True(t, history[1].Renamed)
+	assert.Equal(t, []chess.PGNChange{{Kind: "added", Path: []string{"e4"}}}, history[1].Changes)
+	assert.False(t, history[2].Renamed)
+	assert.Equal(t, []chess.PGNChange{{Kind: "annotated", Path: []string{"e4", "c5"}}}, history[2].Changes)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStudyService_ExportPGN(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	studyID, ownerID := uuid.New(), uuid.New()
+	created := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT \* FROM "studies" WHERE id = \$1 ORDER BY "studies"."id" LIMIT 1`).
+		WillReturnRows(sqlmock.NewRows(studyColumns).AddRow(studyID, ownerID, "Sicilian files", false, created))
+	mock.ExpectQuery(`SELECT \* FROM "study_chapters" WHERE "study_chapters"."study_id" = \$1 ORDER BY position ASC, created_at ASC`).
+		WillReturnRows(sqlmock.NewRows(studyChapterColumns).
+			AddRow(uuid.New(), studyID, "Najdorf", 0, chess.StandardStartFEN, "1. e4 c5", 1, created).
+			AddRow(uuid.New(), studyID, "Endgame", 1, "4k3/8/8/8/8/8/4P3/4K3 b - - 0 1", "1... Kd7", 1, created))
+	mock.ExpectQuery(`SELECT \* FROM "study_members"`).
+		WillReturnRows(sqlmock.NewRows([]string{"study_id", "user_id", "role"}))
+
+	pgn, err := NewStudyService(db).ExportPGN(studyID, ownerID)
+
+	require.NoError(t, err)
+	games, err := chess.ParsePGN(strings.NewReader(pgn))
+	require.NoError(t, err)
+	require.Len(t, games, 2)
+	assert.Equal(t, "Sicilian files: Najdorf", games[0].Tags["Event"])
+	assert.Equal(t, []string{"e4", "c5"}, games[0].Moves)
+	assert.Equal(t, "2", games[1].Tags["Round"])
+	assert.Equal(t, "4k3/8/8/8/8/8/4P3/4K3 b - - 0 1", games[1].Tags["FEN"])
+	assert.Equal(t, []string{"Kd7"}, games[1].Moves)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStudyService_GetStudy_Private(t *testing.T) {
+	db, mock := testutil.MockDB(t)
+	sqlDB, _ := db.DB()
+	defer testutil.CleanupDB(sqlDB)
+
+	studyID := uuid.New()
+	mock.ExpectQuery(`SELECT \* FROM "studies"`).
+		WillReturnRows(sqlmock.NewRows(studyColumns).AddRow(studyID, uuid.New(), "Sicilian files", false, time.Now()))
+	mock.ExpectQuery(`SELECT \* FROM "study_chapters"`).
+		WillReturnRows(sqlmock.NewRows(studyChapterColumns))
+	mock.ExpectQuery(`SELECT \* FROM "study_members"`).
+		WillReturnRows(sqlmock.NewRows([]string{"study_id", "user_id", "role"}))
+
+	_, err := NewStudyService(db).GetStudy(studyID, uuid.New())
+
+	assert.ErrorIs(t, err, ErrStudyNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -2,8 +2,14 @@ package services
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+
+	"arcane-chess/internal/models"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -15,6 +21,10 @@ type Client struct {
 	Conn   *websocket.Conn
 	Send   chan []byte
 	Hub    *Hub
+
+	// Set when UserID comes from a validated token rather than the query
+	// string; only authenticated clients may play moves
+	Authenticated bool
 }
 
 type Hub struct {
@@ -33,14 +43,27 @@ type Hub struct {
 	// Room-based messaging
 	Rooms map[string]map[*Client]bool
 	mutex sync.RWMutex
+
+	// Fans messages out to hubs on other instances; nil when running alone
+	bridge atomic.Pointer[HubBridge]
+
+	// Applies moves sent over the socket; nil disables game_move
+	gameService *GameService
+
+	// Applies edits to analysis rooms; nil disables the analysis_* messages
+	analysisRooms *AnalysisRoomService
+
+	// Decides who may join study rooms; nil leaves them open
+	studies *StudyService
 }
 
 type Message struct {
-	Type     string      `json:"type"`
-	Data     interface{} `json:"data"`
-	Room     string      `json:"room,omitempty"`
-	UserID   string      `json:"user_id,omitempty"`
-	Username string      `json:"username,omitempty"`
+	Type      string      `json:"type"`
+	Data      interface{} `json:"data"`
+	Room      string      `json:"room,omitempty"`
+	UserID    string      `json:"user_id,omitempty"`
+	Username  string      `json:"username,omitempty"`
+	RequestID string      `json:"request_id,omitempty"` // echoed in the reply to a request
 }
 
 // Game-specific message types
@@ -49,6 +72,46 @@ type GameMoveMessage struct {
 	From   string `json:"from"`
 	To     string `json:"to"`
 	Piece  string `json:"piece"`
+	Drop   string `json:"drop,omitempty"` // piece type dropped on To, in crazyhouse
+}
+
+// GameMoveResult is the validated move broadcast to a game's room and
+// returned to the player who made it.
+type GameMoveResult struct {
+	GameID      string             `json:"game_id"`
+	PlayerID    string             `json:"player_id"`
+	MoveNumber  int                `json:"move_number"`
+	From        string             `json:"from"`
+	To          string             `json:"to"`
+	Piece       string             `json:"piece"`
+	SAN         string             `json:"san"`
+	FEN         string             `json:"fen"`
+	WhiteTime   int                `json:"white_time"`
+	BlackTime   int                `json:"black_time"`
+	IsCheck     bool               `json:"is_check"`
+	IsCheckmate bool               `json:"is_checkmate"`
+	IsStalemate bool               `json:"is_stalemate"`
+	Status      models.GameStatus  `json:"status"`
+	Result      *models.GameResult `json:"result,omitempty"`
+}
+
+// AnalysisEditMessage is an edit to an analysis room's tree. Which fields
+// are used depends on the message type.
+type AnalysisEditMessage struct {
+	RoomID   string      `json:"room_id"`
+	NodeID   string      `json:"node_id,omitempty"`   // the node edited or selected
+	ParentID string      `json:"parent_id,omitempty"` // the node a move follows, empty for the room's position
+	From     string      `json:"from,omitempty"`
+	To       string      `json:"to,omitempty"`
+	Comment  string      `json:"comment,omitempty"`
+	NAGs     models.NAGs `json:"nags,omitempty"`
+	UserID   string      `json:"user_id,omitempty"` // who gets control
+}
+
+// RequestError is the data of an error reply to a client request.
+type RequestError struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
 }
 
 type AvatarPositionMessage struct {
@@ -86,6 +149,10 @@ func (h *Hub) Run() {
 			h.mutex.Unlock()
 			
 			log.Printf("Client %s connected", client.ID)
+
+			if bridge := h.bridge.Load(); bridge != nil {
+				bridge.userConnected(client.UserID)
+			}
 			
 			// Send connection confirmation
 			message := Message{
@@ -98,8 +165,12 @@ func (h *Hub) Run() {
 			h.SendToClient(client, message)
 
 		case client := <-h.Unregister:
+			var leftRooms, emptiedRooms []string
+			registered := false
+
 			h.mutex.Lock()
 			if _, ok := h.Clients[client]; ok {
+				registered = true
 				delete(h.Clients, client)
 				close(client.Send)
 				
@@ -107,8 +178,10 @@ func (h *Hub) Run() {
 				for roomID, clients := range h.Rooms {
 					if _, exists := clients[client]; exists {
 						delete(clients, client)
+						leftRooms = append(leftRooms, roomID)
 						if len(clients) == 0 {
 							delete(h.Rooms, roomID)
+							emptiedRooms = append(emptiedRooms, roomID)
 						}
 					}
 				}
@@ -117,6 +190,13 @@ func (h *Hub) Run() {
 			
 			log.Printf("Client %s disconnected", client.ID)
 
+			if bridge := h.bridge.Load(); bridge != nil && registered {
+				for _, roomID := range leftRooms {
+					bridge.roomLeft(roomID, client.UserID, contains(emptiedRooms, roomID))
+				}
+				bridge.userDisconnected(client.UserID)
+			}
+
 		case message := <-h.Broadcast:
 			h.mutex.RLock()
 			for client := range h.Clients {
@@ -134,31 +214,139 @@ func (h *Hub) Run() {
 
 func (h *Hub) JoinRoom(client *Client, roomID string) {
 	h.mutex.Lock()
-	defer h.mutex.Unlock()
-	
-	if h.Rooms[roomID] == nil {
+	created := h.Rooms[roomID] == nil
+	if created {
 		h.Rooms[roomID] = make(map[*Client]bool)
 	}
+	joined := !h.Rooms[roomID][client]
 	h.Rooms[roomID][client] = true
+	h.mutex.Unlock()
 	
 	log.Printf("Client %s joined room %s", client.ID, roomID)
+
+	if bridge := h.bridge.Load(); bridge != nil && joined {
+		bridge.roomJoined(roomID, client.UserID, created)
+	}
 }
 
 func (h *Hub) LeaveRoom(client *Client, roomID string) {
+	left, emptied := false, false
+
 	h.mutex.Lock()
-	defer h.mutex.Unlock()
-	
 	if room, exists := h.Rooms[roomID]; exists {
+		left = room[client]
 		delete(room, client)
 		if len(room) == 0 {
 			delete(h.Rooms, roomID)
+			emptied = true
 		}
 	}
+	h.mutex.Unlock()
 	
 	log.Printf("Client %s left room %s", client.ID, roomID)
+
+	if bridge := h.bridge.Load(); bridge != nil && left {
+		bridge.roomLeft(roomID, client.UserID, emptied)
+	}
 }
 
+// BroadcastToRoom sends message to every client in the room, on this
+// instance and, when a bridge is attached, on all other instances.
 func (h *Hub) BroadcastToRoom(roomID string, message Message) {
+	h.BroadcastToRoomExcept(roomID, message, nil)
+}
+
+// BroadcastToRoomExcept is BroadcastToRoom leaving out the connections of
+// the users in exclude, even if they are in the room. Anonymous connections
+// are left out too when exclude isn't empty: their user ID is only claimed,
+// so any of those users could be behind one.
+func (h *Hub) BroadcastToRoomExcept(roomID string, message Message, exclude []string) {
+	h.deliverToRoom(roomID, message, exclude)
+
+	if bridge := h.bridge.Load(); bridge != nil {
+		bridge.publishRoom(roomID, message, exclude)
+	}
+}
+
+// SendToUser sends message to every connection of userID, wherever it is.
+func (h *Hub) SendToUser(userID string, message Message) {
+	h.deliverToUser(userID, message)
+
+	if bridge := h.bridge.Load(); bridge != nil {
+		bridge.publishUser(userID, message)
+	}
+}
+
+// RoomMembers returns the IDs of the users currently in a room across all
+// instances, falling back to this instance's view without a bridge.
+func (h *Hub) RoomMembers(roomID string) []string {
+	if bridge := h.bridge.Load(); bridge != nil {
+		members, err := bridge.roomPresence(roomID)
+		if err == nil {
+			return members
+		}
+		log.Printf("Error reading presence for room %s: %v", roomID, err)
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	seen := make(map[string]bool)
+	members := []string{}
+	for client := range h.Rooms[roomID] {
+		if !seen[client.UserID] {
+			seen[client.UserID] = true
+			members = append(members, client.UserID)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// IsUserOnline reports whether userID has an open connection on any instance.
+func (h *Hub) IsUserOnline(userID string) bool {
+	if bridge := h.bridge.Load(); bridge != nil {
+		online, err := bridge.IsUserOnline(userID)
+		if err == nil {
+			return online
+		}
+		log.Printf("Error reading presence for user %s: %v", userID, err)
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for client := range h.Clients {
+		if client.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hub) deliverToUser(userID string, message Message) {
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for client := range h.Clients {
+		if client.UserID != userID {
+			continue
+		}
+		select {
+		case client.Send <- messageBytes:
+		default:
+			log.Printf("Dropping message for slow client %s", client.ID)
+		}
+	}
+}
+
+func (h *Hub) deliverToRoom(roomID string, message Message, exclude []string) {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 	
@@ -170,6 +358,9 @@ func (h *Hub) BroadcastToRoom(roomID string, message Message) {
 		}
 		
 		for client := range room {
+			if len(exclude) > 0 && (!client.Authenticated || contains(exclude, client.UserID)) {
+				continue
+			}
 			select {
 			case client.Send <- messageBytes:
 			default:
@@ -251,6 +442,10 @@ func (c *Client) HandleMessage(message Message) {
 	case "join_room":
 		if roomData, ok := message.Data.(map[string]interface{}); ok {
 			if roomID, ok := roomData["room_id"].(string); ok {
+				if !c.mayJoin(roomID) {
+					c.replyError(message, "forbidden", "not allowed to join the room")
+					return
+				}
 				c.Hub.JoinRoom(c, roomID)
 			}
 		}
@@ -261,12 +456,31 @@ func (c *Client) HandleMessage(message Message) {
 				c.Hub.LeaveRoom(c, roomID)
 			}
 		}
+
+	case "room_presence":
+		if roomData, ok := message.Data.(map[string]interface{}); ok {
+			if roomID, ok := roomData["room_id"].(string); ok {
+				c.Hub.SendToClient(c, Message{
+					Type: "room_presence",
+					Room: roomID,
+					Data: map[string]interface{}{
+						"room_id": roomID,
+						"users":   c.Hub.RoomMembers(roomID),
+					},
+				})
+			}
+		}
 		
 	case "game_move":
-		// Handle chess move
-		if message.Room != "" {
-			c.Hub.BroadcastToRoom(message.Room, message)
-		}
+		c.handleGameMove(message)
+
+	case "game_premove", "game_premove_cancel":
+		c.handleGamePremove(message)
+	case "coach_enable", "coach_disable", "coach_hint":
+		c.handleCoach(message)
+
+	case "analysis_move", "analysis_delete", "analysis_annotate", "analysis_promote", "analysis_control", "analysis_select":
+		c.handleAnalysisEdit(message)
 		
 	case "avatar_position":
 		// Handle avatar position update
@@ -291,13 +505,380 @@ func (c *Client) HandleMessage(message Message) {
 	}
 }
 
+// handleGameMove plays a move for the client's user and acknowledges it. The
+// game room learns of the move from the game's "move" update, published once
+// by whichever instance played it; the client's payload is never relayed.
+func (c *Client) handleGameMove(message Message) {
+	if c.Hub.gameService == nil {
+		c.replyError(message, "unavailable", "moves are not accepted on this connection")
+		return
+	}
+	if !c.Authenticated {
+		c.replyError(message, "unauthenticated", "authentication required")
+		return
+	}
+
+	var request GameMoveMessage
+	if err := decodeMessageData(message.Data, &request); err != nil {
+		c.replyError(message, "bad_request", "invalid move payload")
+		return
+	}
+	gameID, err := uuid.Parse(request.GameID)
+	if err != nil {
+		c.replyError(message, "bad_request", "invalid game ID")
+		return
+	}
+	playerID, err := uuid.Parse(c.UserID)
+	if err != nil {
+		c.replyError(message, "unauthenticated", "invalid user ID")
+		return
+	}
+
+	move, game, err := c.Hub.gameService.PlayMove(gameID, playerID, request.From, request.To, request.Drop)
+	if err != nil {
+		c.replyError(message, gameErrorCode(err), gameErrorMessage(err))
+		return
+	}
+
+	result := GameMoveResult{
+		GameID:      game.ID.String(),
+		PlayerID:    playerID.String(),
+		MoveNumber:  move.MoveNumber,
+		From:        move.FromSquare,
+		To:          move.ToSquare,
+		Piece:       move.Piece,
+		SAN:         move.Notation,
+		FEN:         move.FENAfter,
+		WhiteTime:   game.WhiteTime,
+		BlackTime:   game.BlackTime,
+		IsCheck:     move.IsCheck,
+		IsCheckmate: move.IsCheckmate,
+		IsStalemate: move.IsStalemate,
+		Status:      game.Status,
+		Result:      game.Result,
+	}
+
+	c.Hub.SendToClient(c, Message{Type: "game_move_ack", RequestID: message.RequestID, Data: result})
+}
+
+// handleGamePremove queues a premove for the client's user, or with
+// game_premove_cancel clears their queue, and acknowledges with the queue.
+func (c *Client) handleGamePremove(message Message) {
+	if c.Hub.gameService == nil {
+		c.replyError(message, "unavailable", "moves are not accepted on this connection")
+		return
+	}
+	if !c.Authenticated {
+		c.replyError(message, "unauthenticated", "authentication required")
+		return
+	}
+
+	var request GameMoveMessage
+	if err := decodeMessageData(message.Data, &request); err != nil {
+		c.replyError(message, "bad_request", "invalid premove payload")
+		return
+	}
+	gameID, err := uuid.Parse(request.GameID)
+	if err != nil {
+		c.replyError(message, "bad_request", "invalid game ID")
+		return
+	}
+	playerID, err := uuid.Parse(c.UserID)
+	if err != nil {
+		c.replyError(message, "unauthenticated", "invalid user ID")
+		return
+	}
+
+	premoves := []Premove{}
+	if message.Type == "game_premove_cancel" {
+		err = c.Hub.gameService.CancelPremoves(gameID, playerID)
+	} else {
+		premoves, err = c.Hub.gameService.QueuePremove(gameID, playerID, request.From, request.To, request.Drop)
+	}
+	if err != nil {
+		c.replyError(message, gameErrorCode(err), gameErrorMessage(err))
+		return
+	}
+
+	c.Hub.SendToClient(c, Message{
+		Type:      message.Type + "_ack",
+		RequestID: message.RequestID,
+		Data: map[string]interface{}{
+			"game_id":  gameID,
+			"premoves": premoves,
+		},
+	})
+}
+
+// handleCoach turns the coach on or off for the client's user in a game, or
+// gives them a hint, and acknowledges with the coach's advice or hint.
+func (c *Client) handleCoach(message Message) {
+	if c.Hub.gameService == nil {
+		c.replyError(message, "unavailable", "the coach is not available on this connection")
+		return
+	}
+	if !c.Authenticated {
+		c.replyError(message, "unauthenticated", "authentication required")
+		return
+	}
+
+	var request struct {
+		GameID string `json:"game_id"`
+	}
+	if err := decodeMessageData(message.Data, &request); err != nil {
+		c.replyError(message, "bad_request", "invalid coach payload")
+		return
+	}
+	gameID, err := uuid.Parse(request.GameID)
+	if err != nil {
+		c.replyError(message, "bad_request", "invalid game ID")
+		return
+	}
+	playerID, err := uuid.Parse(c.UserID)
+	if err != nil {
+		c.replyError(message, "unauthenticated", "invalid user ID")
+		return
+	}
+
+	var data interface{}
+	switch message.Type {
+	case "coach_enable":
+		data, err = c.Hub.gameService.EnableCoach(gameID, playerID)
+	case "coach_disable":
+		err = c.Hub.gameService.DisableCoach(gameID, playerID)
+		data = map[string]interface{}{"game_id": gameID}
+	case "coach_hint":
+		data, err = c.Hub.gameService.CoachHint(gameID, playerID)
+	}
+	if err != nil {
+		c.replyError(message, gameErrorCode(err), gameErrorMessage(err))
+		return
+	}
+
+	c.Hub.SendToClient(c, Message{Type: message.Type + "_ack", RequestID: message.RequestID, Data: data})
+}
+
+// handleAnalysisEdit applies an edit to an analysis room for the client's
+// user and broadcasts it to the room as an analysis_update.
+func (c *Client) handleAnalysisEdit(message Message) {
+	if c.Hub.analysisRooms == nil {
+		c.replyError(message, "unavailable", "analysis is not available on this connection")
+		return
+	}
+	if !c.Authenticated {
+		c.replyError(message, "unauthenticated", "authentication required")
+		return
+	}
+
+	var request AnalysisEditMessage
+	if err := decodeMessageData(message.Data, &request); err != nil {
+		c.replyError(message, "bad_request", "invalid analysis payload")
+		return
+	}
+	roomID, err := uuid.Parse(request.RoomID)
+	if err != nil {
+		c.replyError(message, "bad_request", "invalid room ID")
+		return
+	}
+	userID, err := uuid.Parse(c.UserID)
+	if err != nil {
+		c.replyError(message, "unauthenticated", "invalid user ID")
+		return
+	}
+	nodeID, err := optionalUUID(request.NodeID)
+	if err != nil {
+		c.replyError(message, "bad_request", "invalid node ID")
+		return
+	}
+	if nodeID == nil && message.Type != "analysis_move" && message.Type != "analysis_control" && message.Type != "analysis_select" {
+		c.replyError(message, "bad_request", "node ID required")
+		return
+	}
+
+	rooms := c.Hub.analysisRooms
+	var update *AnalysisRoomUpdate
+	changed := true
+	switch message.Type {
+	case "analysis_move":
+		parentID, parseErr := optionalUUID(request.ParentID)
+		if parseErr != nil {
+			c.replyError(message, "bad_request", "invalid parent ID")
+			return
+		}
+		update, changed, err = rooms.AddMove(roomID, userID, parentID, request.From, request.To)
+	case "analysis_delete":
+		update, err = rooms.DeleteNode(roomID, userID, *nodeID)
+	case "analysis_annotate":
+		update, err = rooms.AnnotateNode(roomID, userID, *nodeID, request.Comment, request.NAGs)
+	case "analysis_promote":
+		update, err = rooms.PromoteNode(roomID, userID, *nodeID)
+	case "analysis_control":
+		toUserID, parseErr := uuid.Parse(request.UserID)
+		if parseErr != nil {
+			c.replyError(message, "bad_request", "invalid user ID")
+			return
+		}
+		update, err = rooms.HandOverControl(roomID, userID, toUserID)
+	case "analysis_select":
+		update, err = rooms.SelectNode(roomID, userID, nodeID)
+	}
+	if err != nil {
+		c.replyError(message, analysisErrorCode(err), analysisErrorMessage(err))
+		return
+	}
+
+	c.Hub.SendToClient(c, Message{Type: message.Type + "_ack", RequestID: message.RequestID, Data: update})
+
+	if changed {
+		room := AnalysisRoomChannel(roomID.String())
+		c.Hub.BroadcastToRoom(room, Message{Type: "analysis_update", Room: room, UserID: c.UserID, Data: update})
+	}
+}
+
+// mayJoin reports whether the client may follow a room. Study rooms are
+// open only to those who can view the study, and a game's spectator room is
+// closed to those who play in it.
+func (c *Client) mayJoin(roomID string) bool {
+	if gameID, ok := spectatedGame(roomID); ok {
+		return c.maySpectate(gameID)
+	}
+	studyID, ok := strings.CutPrefix(roomID, StudyChannel(""))
+	if !ok || c.Hub.studies == nil {
+		return true
+	}
+	id, err := uuid.Parse(studyID)
+	if err != nil {
+		return false
+	}
+	userID := uuid.Nil
+	if c.Authenticated {
+		userID, _ = uuid.Parse(c.UserID)
+	}
+	allowed, err := c.Hub.studies.CanView(id, userID)
+	if err != nil {
+		log.Printf("Failed to check access to study %s: %v", studyID, err)
+		return false
+	}
+	return allowed
+}
+
+// maySpectate reports whether the client may join the spectator room of a
+// game. Anonymous clients can't be told apart from players, so they have to
+// wait for the game to finish.
+func (c *Client) maySpectate(gameID string) bool {
+	if c.Hub.gameService == nil {
+		return true
+	}
+	id, err := uuid.Parse(gameID)
+	if err != nil {
+		return false
+	}
+	userID := uuid.Nil
+	if c.Authenticated {
+		if userID, err = uuid.Parse(c.UserID); err != nil {
+			return false
+		}
+	}
+	allowed, err := c.Hub.gameService.maySpectate(id, userID)
+	if err != nil {
+		log.Printf("Failed to check whether %s plays game %s: %v", c.UserID, gameID, err)
+		return false
+	}
+	return allowed
+}
+
+func optionalUUID(value string) (*uuid.UUID, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func (c *Client) replyError(request Message, code, text string) {
+	c.Hub.SendToClient(c, Message{
+		Type:      request.Type + "_error",
+		RequestID: request.RequestID,
+		Data:      RequestError{Code: code, Error: text},
+	})
+}
+
+// decodeMessageData converts the generic data of an incoming message into v.
+func decodeMessageData(data interface{}, v interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func gameErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrGameNotFound):
+		return "not_found"
+	case errors.Is(err, ErrNotGamePlayer):
+		return "forbidden"
+	case errors.Is(err, ErrInvalidMove):
+		return "invalid_move"
+	case errors.Is(err, ErrInvalidPremove):
+		return "bad_request"
+	case errors.Is(err, ErrNotPlayerTurn),
+		errors.Is(err, ErrGameNotActive),
+		errors.Is(err, ErrTimeExpired),
+		errors.Is(err, ErrVoteRequired),
+		errors.Is(err, ErrCoachUnavailable),
+		errors.Is(err, ErrConcurrentUpdate):
+		return "conflict"
+	default:
+		return "internal"
+	}
+}
+
+// gameErrorMessage hides the details of unexpected errors from clients.
+func gameErrorMessage(err error) string {
+	if gameErrorCode(err) == "internal" {
+		return "internal server error"
+	}
+	return err.Error()
+}
+
+func analysisErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrAnalysisRoomNotFound), errors.Is(err, ErrAnalysisNodeNotFound):
+		return "not_found"
+	case errors.Is(err, ErrNotRoomController), errors.Is(err, ErrNotRoomHost):
+		return "forbidden"
+	case errors.Is(err, ErrInvalidMove):
+		return "invalid_move"
+	case errors.Is(err, ErrInvalidAnnotation):
+		return "bad_request"
+	case errors.Is(err, ErrAnalysisRoomConflict), errors.Is(err, ErrAnalysisRoomFull):
+		return "conflict"
+	default:
+		return "internal"
+	}
+}
+
+func analysisErrorMessage(err error) string {
+	if analysisErrorCode(err) == "internal" {
+		return "internal server error"
+	}
+	return err.Error()
+}
+
 // WebSocket manager service
 type WebSocketManager struct {
 	Hub *Hub
 }
 
-func NewWebSocketManager() *WebSocketManager {
+func NewWebSocketManager(gameService *GameService, analysisRooms *AnalysisRoomService, studies *StudyService) *WebSocketManager {
 	hub := NewHub()
+	hub.gameService = gameService
+	hub.analysisRooms = analysisRooms
+	hub.studies = studies
 	go hub.Run()
 	
 	return &WebSocketManager{
@@ -305,13 +886,14 @@ func NewWebSocketManager() *WebSocketManager {
 	}
 }
 
-func (wsm *WebSocketManager) HandleConnection(conn *websocket.Conn, userID, username string) {
+func (wsm *WebSocketManager) HandleConnection(conn *websocket.Conn, userID, username string, authenticated bool) {
 	client := &Client{
-		ID:     uuid.New().String(),
-		UserID: userID,
-		Conn:   conn,
-		Send:   make(chan []byte, 256),
-		Hub:    wsm.Hub,
+		ID:            uuid.New().String(),
+		UserID:        userID,
+		Conn:          conn,
+		Send:          make(chan []byte, 256),
+		Hub:           wsm.Hub,
+		Authenticated: authenticated,
 	}
 	
 	client.Hub.Register <- client
@@ -319,4 +901,13 @@ func (wsm *WebSocketManager) HandleConnection(conn *websocket.Conn, userID, user
 	// Start goroutines for reading and writing
 	go client.WritePump()
 	go client.ReadPump()
-}
\ No newline at end of file
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
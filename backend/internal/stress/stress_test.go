@@ -56,7 +56,7 @@ func (s *StressTestSuite) SetupSuite(t *testing.T) {
 	s.avatarService = services.NewAvatarService(db, redis)
 
 	// Initialize handlers
-	handler := handlers.NewHandler(s.gameService, s.userService, s.avatarService, cfg.JWT.Secret)
+	handler := handlers.NewHandler(s.gameService, s.userService, s.avatarService, services.NewArenaService(db), services.NewExplorerService(db), services.NewAnalysisService(db, redis), services.NewPuzzleService(db), services.NewRenderService(db, redis), services.NewAnalysisRoomService(db), services.NewStudyService(db), services.NewPositionSearchService(db), services.NewSimulService(db, s.gameService), cfg.JWT.Secret)
 
 	// Setup Gin
 	gin.SetMode(gin.TestMode)
@@ -39,7 +39,7 @@ func main() {
 	avatarService := services.NewAvatarService(mockDB, mockRedis)
 
 	// Create handler with test JWT secret
-	handler := handlers.NewHandler(gameService, userService, avatarService, "test-jwt-secret")
+	handler := handlers.NewHandler(gameService, userService, avatarService, services.NewArenaService(mockDB), "test-jwt-secret")
 
 	// Set Gin to release mode to reduce logs
 	gin.SetMode(gin.ReleaseMode)
@@ -31,9 +31,10 @@ func main() {
 	gameService := &services.GameService{}     // Empty service for WebSocket testing
 	userService := &services.UserService{}     // Empty service for WebSocket testing
 	avatarService := &services.AvatarService{} // Empty service for WebSocket testing
+	arenaService := &services.ArenaService{}   // Empty service for WebSocket testing
 
 	// Create handler with test JWT secret
-	handler := handlers.NewHandler(gameService, userService, avatarService, "test-jwt-secret")
+	handler := handlers.NewHandler(gameService, userService, avatarService, arenaService, "test-jwt-secret")
 
 	// Set Gin to release mode to reduce logs
 	gin.SetMode(gin.ReleaseMode)